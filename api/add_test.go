@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zeebo/bencode"
+)
+
+func TestAddExternalTorrent(t *testing.T) {
+	useTestRepository(t)
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser, DownloadPath: "/downloads/alice"})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	queue := make(chan common.TorrentData, 1)
+	handler := NewApiHandler(queue)
+	e := echo.New()
+	call := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		auth.SetUser(c, alice)
+		if err := handler.AddTorrentUrl(c); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("magnet link", func(t *testing.T) {
+		magnet := "magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=Movie&tr=http%3A%2F%2Ftracker.example%2Fannounce"
+		req := httptest.NewRequest(http.MethodPost, "/api/add", strings.NewReader(`{"url":"`+magnet+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := call(req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+
+		queued := <-queue
+		want := common.ExternalTorrentUrl("c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+		if queued.Url != want || queued.Magnet != magnet || queued.Title != "Movie" || queued.Torrent != nil {
+			t.Errorf("Unexpected queued torrent %+v", queued)
+		}
+		if queued.DownloadPath != "/downloads/alice" || queued.WatchEvery != 0 {
+			t.Errorf("Expected the caller's download path and no watch period, got %+v", queued)
+		}
+	})
+
+	t.Run("torrent file", func(t *testing.T) {
+		data, err := bencode.EncodeBytes(map[string]interface{}{
+			"announce": "http://tracker.example/announce",
+			"info": map[string]interface{}{
+				"name": "Album", "piece length": 16384, "pieces": "01234567890123456789", "length": 1000,
+			},
+		})
+		if err != nil {
+			t.Fatalf("EncodeBytes() failed: %v", err)
+		}
+		meta, _ := models.ParseTorrent(data)
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("torrent", "album.torrent")
+		_, _ = part.Write(data)
+		_ = writer.WriteField("downloadPath", "/downloads/music")
+		_ = writer.WriteField("tags", "music")
+		_ = writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/add", &body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := call(req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+
+		var response AddTorrentResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Url != common.ExternalTorrentUrl(meta.Hash()) {
+			t.Errorf("Unexpected response %s", rec.Body)
+		}
+		queued := <-queue
+		if queued.Title != "Album" || !bytes.Equal(queued.Torrent, data) || queued.DownloadPath != "/downloads/music" ||
+			len(queued.Tags) != 1 || queued.Tags[0] != "music" {
+			t.Errorf("Unexpected queued torrent %+v", queued)
+		}
+	})
+
+	t.Run("invalid torrent file", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("torrent", "broken.torrent")
+		_, _ = part.Write([]byte("<!DOCTYPE HTML>"))
+		_ = writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/add", &body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		if rec := call(req); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"torrent"`) {
+			t.Errorf("Expected 400 for the torrent field, got %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
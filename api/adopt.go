@@ -0,0 +1,83 @@
+package api
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/qbittorrent"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdoptRequest is the body of POST /api/adopt, the accepted proposals of GET /api/adopt
+type AdoptRequest struct {
+	Adoptions []qbittorrent.Adoption `json:"adoptions"`
+}
+
+// Validate checks the request fields
+func (r *AdoptRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if len(r.Adoptions) == 0 {
+		errs["adoptions"] = "is required"
+	}
+	if len(r.Adoptions) > maxPageSize {
+		errs["adoptions"] = "must not list more than " + strconv.Itoa(maxPageSize) + " torrents"
+	}
+	for i, adoption := range r.Adoptions {
+		field := "adoptions[" + strconv.Itoa(i) + "]"
+		if adoption.Hash == "" {
+			errs[field+".hash"] = "is required"
+		}
+		if !common.IsSupportedTrackerUrl(adoption.Url) {
+			errs[field+".url"] = "must be a topic url on a supported tracker"
+		}
+		if adoption.WatchEvery < 0 {
+			errs[field+".watch_every"] = "must not be negative"
+		}
+	}
+	return errs
+}
+
+// AdoptResponse lists the outcome for every adoption in the order of the request
+type AdoptResponse struct {
+	Adopted int                          `json:"adopted"`
+	Failed  int                          `json:"failed"`
+	Results []qbittorrent.AdoptionResult `json:"results"`
+}
+
+// GetAdoptable is a function for listing the qBittorrent torrents that are not monitored yet,
+// with the tracker topic found in the comment of each one
+func GetAdoptable(c echo.Context) error {
+	proposals, err := qbittorrent.ScanAdoptable()
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, proposals)
+}
+
+// AdoptTorrents is a function for monitoring existing qBittorrent torrents as tracker topics.
+// The torrents keep their hash and save path and are put on the caller's watch list.
+func AdoptTorrents(c echo.Context) error {
+	var request AdoptRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+
+	results, err := qbittorrent.Adopt(callerID(c), request.Adoptions)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	response := AdoptResponse{Results: results}
+	for _, result := range results {
+		if result.Status != qbittorrent.AdoptionAdopted {
+			response.Failed++
+			continue
+		}
+		response.Adopted++
+		audit(c, database.AuditTorrentAdopt, result.Url, nil, map[string]string{"hash": result.Hash})
+	}
+	return c.JSON(200, response)
+}
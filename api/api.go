@@ -1,23 +1,30 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
+	"io"
+	"kinozaltv_monitor/auth"
 	"kinozaltv_monitor/common"
 	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/jobs"
 	logger "kinozaltv_monitor/logging"
 	"kinozaltv_monitor/qbittorrent"
-	"net/http"
-	"strconv"
-	"sync"
+	"mime/multipart"
+	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
 
 type QbittorrentUser interface {
 	DeleteTorrent(hash string, deleteFiles bool) error
 	GetDownloadPaths() ([]string, error)
+	GetCategories() ([]qbittorrent.Category, error)
+	SetLocation(hash, location string) error
+	SetCategory(hash, category string) error
+	AddTags(hash string, tags []string) error
+	RemoveTags(hash string, tags []string) error
 }
 
 // getQbUser returns the qbittorrent user instance
@@ -30,15 +37,6 @@ func getQbUser() QbittorrentUser {
 
 var log = logger.New("api")
 
-var (
-	upgrader = websocket.Upgrader{
-		// Allow all origins
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
-)
-
 type ApiHandler struct {
 	torrentData chan common.TorrentData
 }
@@ -52,182 +50,257 @@ func NewApiHandler(torrentData chan common.TorrentData) *ApiHandler {
 	}
 }
 
-type MsgPool struct {
-	connections map[*websocket.Conn]bool
-	register    chan *websocket.Conn
-	unregister  chan *websocket.Conn
-	broadcast   chan string
-	connMux     sync.Mutex // Mutex to protect connections
-}
+// maxTorrentFileSize is the size limit of an uploaded .torrent file
+const maxTorrentFileSize = 10 << 20
 
-func NewMsgPool(msgChan chan string) *MsgPool {
-	return &MsgPool{
-		broadcast:   msgChan,
-		register:    make(chan *websocket.Conn),
-		unregister:  make(chan *websocket.Conn),
-		connections: make(map[*websocket.Conn]bool),
+// AddTorrentUrl is a function for adding a torrent by tracker url, magnet link or uploaded .torrent file
+func (h *ApiHandler) AddTorrentUrl(c echo.Context) error {
+	var request AddTorrentRequest
+	if fileHeader, err := c.FormFile("torrent"); err == nil {
+		if request.Torrent, err = readTorrentFile(fileHeader); err != nil {
+			// Return 400 Bad Request
+			return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"torrent": err.Error()}})
+		}
 	}
-}
-
-func (pool *MsgPool) HandleWsConnections(c echo.Context) error {
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
-	if err != nil {
+	if ok, err := bindValid(c, &request); !ok {
 		return err
 	}
-	defer func() {
-		pool.unregister <- ws
-		if closeErr := ws.Close(); closeErr != nil {
-			log.Error("Error closing websocket connection: ", closeErr.Error(), nil)
-		}
-	}()
 
-	pool.register <- ws
+	user, signedIn := auth.CurrentUser(c)
+	torrentData := common.TorrentData{
+		Url:          common.CanonicalTorrentUrl(request.Url),
+		DownloadPath: request.DownloadPath,
+		WatchEvery:   request.WatchEvery,
+		Tags:         request.Tags,
+		Category:     strings.TrimSpace(request.Category),
+		UserID:       user.ID,
+	}
+	if torrentData.DownloadPath == "" {
+		torrentData.DownloadPath = user.DownloadPath
+	}
+	if request.External() {
+		torrentData.Url = common.ExternalTorrentUrl(request.hash)
+		torrentData.Title = request.title
+		torrentData.Magnet = request.Url
+		torrentData.Torrent = request.Torrent
+	}
 
-	for {
-		_, _, err := ws.ReadMessage()
+	// Check if torrent is already watched
+	_, err := database.Repo.GetRecordByUrl(torrentData.Url)
+	if err == nil && signedIn {
+		watching, err := database.Repo.IsWatching(user.ID, torrentData.Url)
 		if err != nil {
-			log.Error("Error during reading from connection: ", err.Error(), nil)
-			break
+			// Return 500 Internal Server Error
+			return c.JSON(500, map[string]string{"error": err.Error()})
 		}
-	}
-	return nil
-}
-
-func (pool *MsgPool) Start() {
-	for {
-		select {
-		case connection := <-pool.register:
-			pool.connMux.Lock() // Lock when modifying the connections map
-			pool.connections[connection] = true
-			pool.connMux.Unlock() // Unlock after modifying the connections map
-
-			log.Info("websocket_client_connected", "New WebSocket client connected", map[string]string{
-				"total_connections": strconv.Itoa(len(pool.connections)),
-			})
-
-			// Send current state to the new connection
-			currentState := map[string]interface{}{
-				"type": "current_state",
-				"data": GetCheckInfos(),
-			}
-			jsonMsg, err := json.Marshal(currentState)
-			if err != nil {
-				log.Error("Error marshaling current state: ", err.Error(), nil)
-				pool.unregister <- connection
-				continue
+		if !watching {
+			// Another user already tracks the torrent, share it
+			if err := database.Repo.AddWatcher(user.ID, torrentData.Url); err != nil {
+				// Return 500 Internal Server Error
+				return c.JSON(500, map[string]string{"error": err.Error()})
 			}
+			audit(c, database.AuditTorrentAdd, torrentData.Url, nil, map[string]string{"status": "watching"})
+			return c.JSON(200, AddTorrentResponse{Status: "watching", Url: torrentData.Url})
+		}
+	}
+	if err == nil {
+		// Return 409 Conflict
+		return c.JSON(409, ErrorResponse{Error: database.ErrDuplicate.Error(), Url: torrentData.Url})
+	}
+	if err != database.ErrNotFound {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
 
-			log.Info("sending_current_state", "Sending current state to new client", map[string]string{
-				"message_size":   strconv.Itoa(len(jsonMsg)),
-				"torrents_count": strconv.Itoa(len(GetCheckInfos())),
-			})
+	// Create a job to report the outcome and send url to channel
+	job := h.queueTorrent(torrentData)
+	audit(c, database.AuditTorrentAdd, torrentData.Url, nil, torrentData)
 
-			if err := connection.WriteMessage(websocket.TextMessage, jsonMsg); err != nil {
-				log.Error("Error sending current state to new connection: ", err.Error(), nil)
-				pool.unregister <- connection
-			} else {
-				log.Info("current_state_sent", "Current state successfully sent to new client", nil)
-			}
-		case connection := <-pool.unregister:
-			pool.connMux.Lock() // Lock when modifying the connections map
-			if _, exists := pool.connections[connection]; exists {
-				delete(pool.connections, connection)
-				log.Info("websocket_client_disconnected", "WebSocket client disconnected", map[string]string{
-					"remaining_connections": strconv.Itoa(len(pool.connections)),
-				})
-			}
-			pool.connMux.Unlock() // Unlock after modifying the connections map
-		case message := <-pool.broadcast:
-			pool.connMux.Lock()
-			activeConnections := len(pool.connections)
-			pool.connMux.Unlock()
-
-			if activeConnections > 0 {
-				log.Info("broadcasting_message", "Broadcasting message to clients", map[string]string{
-					"active_connections": strconv.Itoa(activeConnections),
-					"message_preview": func() string {
-						if len(message) > 100 {
-							return message[:100] + "..."
-						}
-						return message
-					}(),
-				})
-			}
+	c.Response().Header().Set(echo.HeaderLocation, "/api/jobs/"+job.ID)
+	return c.JSON(202, AddTorrentResponse{Status: "queued", Url: torrentData.Url, JobID: job.ID, Job: &job})
+}
 
-			for connection := range pool.connections {
-				if err := connection.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-					log.Error("Error during sending message to connection: ", err.Error(), nil)
-					pool.unregister <- connection
-					if closeErr := connection.Close(); closeErr != nil {
-						log.Error("Error closing connection after write failure: ", closeErr.Error(), nil)
-					}
-					break
-				}
-			}
-		}
+// readTorrentFile reads an uploaded .torrent file
+func readTorrentFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	if fileHeader.Size > maxTorrentFileSize {
+		return nil, errors.New("must not be larger than 10 MiB")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = file.Close() }()
+	return io.ReadAll(io.LimitReader(file, maxTorrentFileSize))
 }
 
-// AddTorrentUrl is a function for adding a torrent by url
-func (h *ApiHandler) AddTorrentUrl(c echo.Context) error {
-	// Create new TorrentData instance
-	var torrentData common.TorrentData
+// queueTorrent creates an add job and passes the torrent to the add pipeline
+func (h *ApiHandler) queueTorrent(torrentData common.TorrentData) jobs.Job {
+	job := jobs.GlobalStore.Create(torrentData.UserID, torrentData.Url, torrentData.DownloadPath)
+	torrentData.JobID = job.ID
+	h.torrentData <- torrentData
+	return job
+}
 
-	// Bind request body to torrentData
-	if err := c.Bind(&torrentData); err != nil {
-		// If there's any error return 400 Bad Request
-		return c.JSON(400, map[string]string{"error": "Bad Request"})
+// GetJob is a function for getting the state of an add job
+func GetJob(c echo.Context) error {
+	job, ok := jobs.GlobalStore.Get(c.Param("id"))
+	if !ok || !canSeeJob(c, job) {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": "job not found"})
 	}
+	return c.JSON(200, job)
+}
 
-	// Check if url is empty
-	if torrentData.Url == "" {
-		// Return 400 Bad Request
-		return c.JSON(400, map[string]string{"error": "url is empty"})
+// GetJobs is a function for getting recent add jobs of the caller, newest first
+func GetJobs(c echo.Context) error {
+	result := make([]jobs.Job, 0)
+	for _, job := range jobs.GlobalStore.List() {
+		if showAll(c) || canSeeJob(c, job) {
+			result = append(result, job)
+		}
 	}
+	return c.JSON(200, result)
+}
 
-	// Send url to channel
-	h.torrentData <- common.TorrentData{Url: torrentData.Url, DownloadPath: torrentData.DownloadPath}
-
-	return c.JSON(200, map[string]string{"status": "ok"})
+// canSeeJob reports whether the caller started job or is an admin
+func canSeeJob(c echo.Context, job jobs.Job) bool {
+	user, ok := auth.CurrentUser(c)
+	return !ok || user.Role == database.RoleAdmin || job.UserID == user.ID
 }
 
-// RemoveTorrentUrl is a function for removing a torrent by ID
+// RemoveTorrentUrl is a function for removing a torrent by url and hash
 func RemoveTorrentUrl(c echo.Context) error {
-	// Read JSON from request body
-	var jsonTorrent map[string]string
-	err := c.Bind(&jsonTorrent)
-	if err != nil {
+	var request RemoveRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+	return removeTorrent(c, common.CanonicalTorrentUrl(request.Url), request.Hash, request.Mode, request.ConfirmToken)
+}
+
+// removeTorrent takes a torrent off the caller's watch list and, once nobody watches it,
+// stops monitoring it and removes it from qBittorrent as mode says. Deleting the files
+// needs a confirmation token for the torrent.
+func removeTorrent(c echo.Context, torrentUrl, hash, mode, confirmToken string) error {
+	if mode == "" {
+		mode = RemoveKeepFiles
+	}
+	user, _ := auth.CurrentUser(c)
+	if mode == RemoveDeleteFiles && !confirmations.consume(confirmToken, user.ID, urlScope(torrentUrl)) {
+		return confirmationRequired(c)
+	}
+
+	status, err := deleteTorrent(c, torrentUrl, hash, mode)
+	if err == errHashMismatch {
 		// Return 400 Bad Request
-		return c.JSON(400, map[string]string{"error": err.Error()})
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"hash": err.Error()}})
+	}
+	if err == database.ErrNotFound {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": err.Error()})
 	}
-	// Get torrent name from JSON
-	torrentUrl := jsonTorrent["url"]
-	torrentHash := jsonTorrent["hash"]
-	// Delete torrent from qbittorrent by name
-	err = getQbUser().DeleteTorrent(torrentHash, true)
 	if err != nil {
 		// Return 500 Internal Server Error
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
-	// Delete torrent from database
-	err = database.DeleteRecord(database.DB, torrentUrl)
+	return c.JSON(200, StatusResponse{Status: status})
+}
+
+// errHashMismatch is returned when the hash of a removal is not the one of its url
+var errHashMismatch = errors.New("does not match the torrent of the url")
+
+// deleteTorrent takes a torrent off the caller's watch list. Once nobody watches it, it is
+// deleted from the database and, unless mode is untrack, from qBittorrent. The status is
+// "unwatched" when other users still watch the torrent and "ok" when it was deleted.
+// The torrent is always removed by its recorded hash, hash is only checked against it.
+func deleteTorrent(c echo.Context, torrentUrl, hash, mode string) (string, error) {
+	record, err := database.Repo.GetRecordByUrl(torrentUrl)
 	if err != nil {
-		// Return 500 Internal Server Error
-		return c.JSON(500, map[string]string{"error": err.Error()})
+		return "", err
+	}
+	if hash != "" && !strings.EqualFold(hash, record.Hash) {
+		return "", errHashMismatch
+	}
+	hash = record.Hash
+	removed := events.Event{Type: events.TorrentRemoved, Url: torrentUrl, Title: record.Title, Hash: hash, Mode: mode, Actor: actorName(c)}
+
+	// Take the torrent off the caller's watch list, it is only deleted when nobody watches it anymore
+	if user, ok := auth.CurrentUser(c); ok {
+		remaining, err := database.Repo.RemoveWatcher(user.ID, torrentUrl)
+		if err != nil {
+			return "", err
+		}
+		if remaining > 0 {
+			removed.Mode = ""
+			events.Publish(removed)
+			audit(c, database.AuditTorrentRemove, torrentUrl, nil, map[string]string{"status": "unwatched"})
+			return "unwatched", nil
+		}
+	}
+
+	if mode != RemoveUntrack {
+		// Delete torrent from qbittorrent by hash
+		if err := getQbUser().DeleteTorrent(hash, mode == RemoveDeleteFiles); err != nil {
+			return "", err
+		}
 	}
+	// Delete torrent from database
+	if err := database.Repo.DeleteRecord(torrentUrl); err != nil {
+		return "", err
+	}
+	events.Publish(removed)
+	audit(c, database.AuditTorrentRemove, torrentUrl, map[string]string{"hash": hash, "title": record.Title}, map[string]string{"mode": mode})
+	return "ok", nil
+}
 
-	return c.JSON(200, map[string]string{"status": "ok"})
+// actorName names the caller in the history: the username, or the client address when auth is disabled
+func actorName(c echo.Context) string {
+	if user, ok := auth.CurrentUser(c); ok {
+		return user.Username
+	}
+	return c.RealIP()
 }
 
-// GetTorrentList is a function for getting a list of torrents
+// TorrentListItem is a watched torrent with its live status in qBittorrent. Live is
+// left out when qBittorrent is unavailable or does not have the torrent.
+type TorrentListItem struct {
+	database.Torrent
+	Live *common.TorrentStatus `json:"live,omitempty"`
+}
+
+// GetTorrentList is a function for getting the torrents on the caller's watch list
+// with their qBittorrent status. Admins get every torrent with ?all=true.
 func GetTorrentList(c echo.Context) error {
-	dbTorrents, err := database.GetAllRecords(database.DB)
+	dbTorrents, err := callerRecords(c)
 	if err != nil {
 		// Return 500 Internal Server Error
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
+	statuses := liveStatuses()
+	items := make([]TorrentListItem, 0, len(dbTorrents))
+	for _, t := range dbTorrents {
+		items = append(items, TorrentListItem{Torrent: t, Live: liveStatus(statuses, t.Hash)})
+	}
 	// Convert to JSON
-	return c.JSON(200, dbTorrents)
+	return c.JSON(200, items)
+}
+
+// liveStatuses returns the qBittorrent status of every torrent by lower case hash, nil
+// when qBittorrent is unavailable so that torrents are still listed without it
+func liveStatuses() map[string]common.TorrentStatus {
+	statuses, err := qbittorrent.TorrentStatuses()
+	if err != nil {
+		log.Error("torrent_status", "Listing torrents without their qBittorrent status", map[string]string{"error": err.Error()})
+	}
+	return statuses
+}
+
+// liveStatus returns the status of the torrent with hash, nil when there is none
+func liveStatus(statuses map[string]common.TorrentStatus, hash string) *common.TorrentStatus {
+	status, ok := statuses[strings.ToLower(hash)]
+	if !ok {
+		return nil
+	}
+	return &status
 }
 
 // GetDownloadPaths is a function for getting a list of download paths from qbittorrent
@@ -241,68 +314,92 @@ func GetDownloadPaths(c echo.Context) error {
 	return c.JSON(200, paths)
 }
 
-// WatchTorrents is a function for set a watch flag for torrents
-func (h *ApiHandler) WatchTorrent(c echo.Context) error {
-	// Read JSON from request body
-	var jsonTorrent map[string]string
-	err := c.Bind(&jsonTorrent)
+// GetCategories is a function for getting the qBittorrent categories a torrent can be assigned to
+func GetCategories(c echo.Context) error {
+	categories, err := getQbUser().GetCategories()
 	if err != nil {
-		// Return 400 Bad Request
-		return c.JSON(400, map[string]string{"error": err.Error()})
-	}
-	// Get torrent name from JSON
-	torrentUrl := jsonTorrent["url"]
-	// Get watch period from JSON in minutes
-	watchPeriod := jsonTorrent["watchPeriod"]
-	// Convert watch period to int
-	watchPeriodInt, err := strconv.Atoi(watchPeriod)
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, categories)
+}
+
+// WatchTorrent is a function for setting how often a torrent is checked
+func (h *ApiHandler) WatchTorrent(c echo.Context) error {
+	var request WatchRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+	torrentUrl := common.CanonicalTorrentUrl(request.Url)
+	watching, err := callerWatches(c, torrentUrl)
 	if err != nil {
-		// Return 400 Bad Request
-		return c.JSON(400, map[string]string{"error": err.Error()})
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	if !watching {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": database.ErrNotFound.Error()})
 	}
 
-	// Set watch flag for torrent
-	err = database.SetWatchFlag(database.DB, torrentUrl, watchPeriodInt)
+	// Set watch flag for torrent, the period is in minutes
+	record, err := database.Repo.GetRecordByUrl(torrentUrl)
+	if err == database.ErrNotFound {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": err.Error()})
+	}
+	if err == nil {
+		err = database.Repo.SetWatchFlag(torrentUrl, request.WatchPeriod)
+	}
+	if err == database.ErrExternalTorrent {
+		// Return 400 Bad Request
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"watchPeriod": "must be 0, " + err.Error()}})
+	}
 	if err != nil {
 		// Return 500 Internal Server Error
 		return c.JSON(500, map[string]string{"error": err.Error()})
 	}
+	auditWatch(c, torrentUrl, record.WatchEvery, request.WatchPeriod)
 
-	return c.JSON(200, map[string]string{"status": "ok"})
+	return c.JSON(200, StatusResponse{Status: "ok"})
+}
+
+// auditWatch records a change of the watch period of a torrent
+func auditWatch(c echo.Context, torrentUrl string, before, after int) {
+	audit(c, database.AuditTorrentWatch, torrentUrl, map[string]int{"watch_every": before}, map[string]int{"watch_every": after})
 }
 
 // GetCheckInfos returns the current check information for all torrents
 func GetCheckInfos() map[string]map[string]interface{} {
-	result := make(map[string]map[string]interface{})
-
 	// Get all torrents from database to ensure we have complete information
-	dbTorrents, err := database.GetAllRecords(database.DB)
+	dbTorrents, err := database.Repo.GetAllRecords()
 	if err != nil {
 		log.Error("get_db_records_for_check_infos", "Error getting database records", map[string]string{"error": err.Error()})
-		return result
+		return make(map[string]map[string]interface{})
 	}
+	return checkInfos(dbTorrents)
+}
 
-	// For each torrent in database, provide check info (either existing or default)
-	for _, dbTorrent := range dbTorrents {
-		if info, exists := qbittorrent.TorrentCheckInfos[dbTorrent.Url]; exists {
-			// Use existing check info
-			result[dbTorrent.Url] = map[string]interface{}{
-				"last_check_time":    info.LastCheckTime.Format(time.RFC3339),
-				"last_check_success": info.LastCheckSuccess,
-			}
-		} else {
-			// Provide default values for torrents without check info yet
-			defaultTime := time.Now()
-			result[dbTorrent.Url] = map[string]interface{}{
-				"last_check_time":    defaultTime.Format(time.RFC3339),
-				"last_check_success": true, // Assume success initially
-			}
+// GetCheckInfosForUser returns the current check information for the torrents a user watches
+func GetCheckInfosForUser(userID int) map[string]map[string]interface{} {
+	dbTorrents, err := database.Repo.GetRecordsForUser(userID)
+	if err != nil {
+		log.Error("get_db_records_for_check_infos", "Error getting database records", map[string]string{"error": err.Error()})
+		return make(map[string]map[string]interface{})
+	}
+	return checkInfos(dbTorrents)
+}
 
-			// Initialize the check info in the global map for future use
-			qbittorrent.TorrentCheckInfos[dbTorrent.Url] = &qbittorrent.TorrentCheckInfo{
-				LastCheckTime:    defaultTime,
-				LastCheckSuccess: true,
-			}
+// checkInfos returns the check information of dbTorrents, initializing missing entries
+func checkInfos(dbTorrents []database.Torrent) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+
+	// For each torrent in database, provide check info (either existing or default).
+	// Torrents without check info yet are assumed to be fine.
+	for _, dbTorrent := range dbTorrents {
+		info, _ := qbittorrent.InitCheckInfo(dbTorrent.Url)
+		result[dbTorrent.Url] = map[string]interface{}{
+			"last_check_time":    info.LastCheckTime.Format(time.RFC3339),
+			"last_check_success": info.LastCheckSuccess,
 		}
 	}
 
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Limits of the number of audit log entries returned at once
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+// audit records an action of the caller. before and after are the changed values,
+// nil when there was nothing before or after.
+func audit(c echo.Context, action, target string, before, after interface{}) {
+	entry := database.AuditEntry{
+		Action:   action,
+		Target:   target,
+		Before:   database.AuditValue(before),
+		After:    database.AuditValue(after),
+		SourceIP: c.RealIP(),
+	}
+	if user, ok := auth.CurrentUser(c); ok {
+		entry.Actor, entry.ActorID = user.Username, user.ID
+	} else {
+		entry.Actor = "anonymous"
+	}
+	database.Audit(entry)
+}
+
+// GetAudit is a function for querying the audit log with ?actor=, ?action=, ?target=,
+// ?since= and ?until= (RFC 3339) and ?limit=. ?format=csv downloads the entries as CSV.
+func GetAudit(c echo.Context) error {
+	filter := database.AuditFilter{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		Target: c.QueryParam("target"),
+		Limit:  defaultAuditLimit,
+	}
+	if strings.HasPrefix(filter.Target, "http") {
+		filter.Target = common.CanonicalTorrentUrl(filter.Target)
+	}
+
+	errs := FieldErrors{}
+	for name, field := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.QueryParam(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs[name] = "must be an RFC 3339 time"
+			}
+			*field = parsed
+		}
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(maxAuditLimit)
+		}
+		filter.Limit = limit
+	}
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "csv" {
+		errs["format"] = "must be json or csv"
+	}
+	if len(errs) > 0 {
+		// Return 400 Bad Request
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: errs})
+	}
+
+	entries, err := database.Repo.GetAudit(filter)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	if format != "csv" {
+		return c.JSON(200, entries)
+	}
+
+	var buf bytes.Buffer
+	if err := writeAuditCSV(&buf, entries); err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	fileName := "kinozal_monitor_audit_" + time.Now().Format("20060102_150405") + ".csv"
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+fileName+`"`)
+	return c.Blob(200, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// writeAuditCSV writes entries as CSV with a header row
+func writeAuditCSV(buf *bytes.Buffer, entries []database.AuditEntry) error {
+	w := csv.NewWriter(buf)
+	if err := w.Write([]string{"id", "created_at", "actor", "actor_id", "action", "target", "before", "after", "source_ip"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{
+			strconv.Itoa(e.ID),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			e.Actor,
+			strconv.Itoa(e.ActorID),
+			e.Action,
+			e.Target,
+			e.Before,
+			e.After,
+			e.SourceIP,
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
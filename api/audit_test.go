@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAudit(t *testing.T) {
+	useTestRepository(t)
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleAdmin})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	topicUrl := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Title", Name: "Name", Hash: "hash", Url: topicUrl}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+	if err := database.Repo.AddWatcher(alice.ID, topicUrl); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+	database.Audit(database.AuditEntry{Actor: database.ActorSystem, Action: database.AuditTorrentReplace, Target: topicUrl})
+
+	e := echo.New()
+	call := func(handler echo.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.7")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		auth.SetUser(c, alice)
+		if err := handler(c); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	handler := NewApiHandler(nil)
+	if rec := call(handler.WatchTorrent, http.MethodPost, "/api/watch", `{"url":"`+topicUrl+`","watchPeriod":15}`); rec.Code != http.StatusOK {
+		t.Fatalf("WatchTorrent() returned %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := call(GetAudit, http.MethodGet, "/api/audit?actor=alice&target="+topicUrl, "")
+	var entries []database.AuditEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Invalid response %s: %v", rec.Body, err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry of alice, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Action != database.AuditTorrentWatch || entry.ActorID != alice.ID || entry.SourceIP != "10.0.0.7" ||
+		entry.Before != `{"watch_every":0}` || entry.After != `{"watch_every":15}` {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+
+	rec = call(GetAudit, http.MethodGet, "/api/audit?format=csv", "")
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][2] != "actor" || records[1][2] != "alice" || records[2][2] != database.ActorSystem {
+		t.Errorf("Unexpected CSV export %v", records)
+	}
+
+	if rec := call(GetAudit, http.MethodGet, "/api/audit?since=yesterday&limit=0&format=xml", ""); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), "since") || !strings.Contains(rec.Body.String(), "format") {
+		t.Errorf("Expected 400 for invalid parameters, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AuthHandler serves sign in, sign out and API token management
+type AuthHandler struct {
+	sessionTTL   time.Duration
+	secureCookie bool
+}
+
+// NewAuthHandler creates a handler issuing sessions valid for sessionTTL.
+// secureCookie marks the session cookie as HTTPS only.
+func NewAuthHandler(sessionTTL time.Duration, secureCookie bool) *AuthHandler {
+	return &AuthHandler{
+		sessionTTL:   sessionTTL,
+		secureCookie: secureCookie,
+	}
+}
+
+// sessionCookie creates the session cookie, an empty value expires it
+func (h *AuthHandler) sessionCookie(value string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// Login is a function for signing in with username and password
+func (h *AuthHandler) Login(c echo.Context) error {
+	var credentials struct {
+		Username string `json:"username" form:"username"`
+		Password string `json:"password" form:"password"`
+	}
+	if err := c.Bind(&credentials); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+
+	user, token, err := auth.Login(database.Repo, credentials.Username, credentials.Password, h.sessionTTL)
+	if err == auth.ErrInvalidCredentials {
+		log.Info("login_failed", "Failed sign in attempt", map[string]string{"username": credentials.Username, "remote_ip": c.RealIP()})
+		// Return 401 Unauthorized
+		return c.JSON(401, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	c.SetCookie(h.sessionCookie(token, time.Now().Add(h.sessionTTL)))
+	return c.JSON(200, user)
+}
+
+// Logout is a function for ending the current session
+func (h *AuthHandler) Logout(c echo.Context) error {
+	if cookie, err := c.Cookie(auth.SessionCookie); err == nil && cookie.Value != "" {
+		if err := auth.Logout(database.Repo, cookie.Value); err != nil {
+			// Return 500 Internal Server Error
+			return c.JSON(500, map[string]string{"error": err.Error()})
+		}
+	}
+	c.SetCookie(h.sessionCookie("", time.Unix(0, 0)))
+	return c.JSON(200, map[string]string{"status": "ok"})
+}
+
+// Me is a function for getting the signed in user
+func (h *AuthHandler) Me(c echo.Context) error {
+	user, _ := auth.CurrentUser(c)
+	return c.JSON(200, user)
+}
+
+// UpdateMe is a function for changing the Telegram chat and default download path of the signed in user
+func (h *AuthHandler) UpdateMe(c echo.Context) error {
+	user, _ := auth.CurrentUser(c)
+	before := user
+	var request struct {
+		TelegramChatID *string `json:"telegram_chat_id"`
+		DownloadPath   *string `json:"download_path"`
+	}
+	if err := c.Bind(&request); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+	if request.TelegramChatID != nil {
+		user.TelegramChatID = *request.TelegramChatID
+	}
+	if request.DownloadPath != nil {
+		user.DownloadPath = *request.DownloadPath
+	}
+
+	if err := database.Repo.UpdateUser(user); err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditUserUpdate, userTarget(user), before, user)
+	return c.JSON(200, user)
+}
+
+// ChangePassword is a function for replacing the password of the signed in user
+func (h *AuthHandler) ChangePassword(c echo.Context) error {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.Bind(&request); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+
+	user, _ := auth.CurrentUser(c)
+	if !auth.CheckPassword(user.PasswordHash, request.CurrentPassword) {
+		// Return 403 Forbidden
+		return c.JSON(403, map[string]string{"error": "current password is wrong"})
+	}
+	hash, err := auth.HashPassword(request.NewPassword)
+	if err == auth.ErrPasswordTooShort {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err == nil {
+		err = database.Repo.SetPassword(user.ID, hash)
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditUserPassword, userTarget(user), nil, nil)
+	return c.JSON(200, map[string]string{"status": "ok"})
+}
+
+// GetAPITokens is a function for listing the API tokens of the signed in user
+func (h *AuthHandler) GetAPITokens(c echo.Context) error {
+	user, _ := auth.CurrentUser(c)
+	tokens, err := database.Repo.ListAPITokens(user.ID)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, tokens)
+}
+
+// CreateAPIToken is a function for issuing an API token. The token is only shown in this response.
+func (h *AuthHandler) CreateAPIToken(c echo.Context) error {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&request); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+	if request.Name == "" {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "name is empty"})
+	}
+
+	user, _ := auth.CurrentUser(c)
+	apiToken, token, err := auth.CreateAPIToken(database.Repo, user.ID, request.Name)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditTokenCreate, "token:"+strconv.Itoa(apiToken.ID), nil, apiToken)
+	return c.JSON(201, map[string]interface{}{"token": token, "api_token": apiToken})
+}
+
+// DeleteAPIToken is a function for revoking an API token of the signed in user
+func (h *AuthHandler) DeleteAPIToken(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "invalid token id"})
+	}
+
+	user, _ := auth.CurrentUser(c)
+	err = database.Repo.DeleteAPIToken(user.ID, id)
+	if err == database.ErrTokenNotFound {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditTokenDelete, "token:"+strconv.Itoa(id), nil, nil)
+	return c.JSON(200, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"os"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateBackup is a function for taking a database snapshot on demand
+func CreateBackup(c echo.Context) error {
+	keep, _ := strconv.Atoi(config.GlobalConfig.BackupKeep)
+
+	path, err := database.CreateBackup(database.Repo, config.GlobalConfig.BackupDir, keep)
+	if err == database.ErrBackupNotSupported {
+		// Return 501 Not Implemented
+		return c.JSON(501, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"status": "ok",
+		"path":   path,
+		"size":   info.Size(),
+	})
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/qbittorrent"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Bulk actions. unwatch stops the periodic checks, delete removes the torrent like DELETE /api/remove
+// and needs a confirmation token for the ids when the files are deleted too.
+const (
+	BulkSetWatch = "set_watch"
+	BulkUnwatch  = "unwatch"
+	BulkDelete   = "delete"
+	BulkMove     = "move"
+	BulkRecheck  = "recheck"
+	BulkTag      = "tag"
+)
+
+// Outcomes of a single torrent of a bulk operation
+const (
+	BulkItemOK        = "ok"
+	BulkItemUnwatched = "unwatched"
+	BulkItemNotFound  = "not_found"
+	BulkItemFailed    = "failed"
+)
+
+// bulkActions are the supported bulk actions
+var bulkActions = map[string]bool{
+	BulkSetWatch: true, BulkUnwatch: true, BulkDelete: true, BulkMove: true, BulkRecheck: true, BulkTag: true,
+}
+
+// BulkFilter selects torrents like the filters of GET /api/v1/torrents
+type BulkFilter struct {
+	Tracker string `json:"tracker"`
+	Watched *bool  `json:"watched"`
+	Status  string `json:"status"`
+	Q       string `json:"q"`
+}
+
+// BulkRequest is the body of POST /api/torrents/bulk. The torrents are given either
+// by ids or by a filter; the other fields are the arguments of the action.
+type BulkRequest struct {
+	Action       string      `json:"action"`
+	IDs          []int       `json:"ids"`
+	Filter       *BulkFilter `json:"filter"`
+	WatchEvery   int         `json:"watch_every"`
+	DeleteFiles  bool        `json:"delete_files"`
+	DownloadPath string      `json:"download_path"`
+	Tags         []string    `json:"tags"`
+	ConfirmToken string      `json:"confirm_token"`
+}
+
+// Validate checks the request fields
+func (r *BulkRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if !bulkActions[r.Action] {
+		errs["action"] = "must be set_watch, unwatch, delete, move, recheck or tag"
+	}
+	switch {
+	case len(r.IDs) == 0 && r.Filter == nil:
+		errs["ids"] = "ids or filter is required"
+	case len(r.IDs) > 0 && r.Filter != nil:
+		errs["ids"] = "must not be combined with filter"
+	case len(r.IDs) > maxPageSize:
+		errs["ids"] = "must not list more than " + strconv.Itoa(maxPageSize) + " torrents"
+	}
+	if r.Filter != nil && r.Filter.Status != "" && r.Filter.Status != CheckStatusOK && r.Filter.Status != CheckStatusFailed {
+		errs["filter.status"] = "must be ok or failed"
+	}
+	switch r.Action {
+	case BulkSetWatch:
+		if r.WatchEvery <= 0 {
+			errs["watch_every"] = "must be positive"
+		}
+	case BulkMove:
+		if strings.TrimSpace(r.DownloadPath) == "" {
+			errs["download_path"] = "is required"
+		}
+	case BulkTag:
+		if len(common.NormalizeTags(r.Tags)) == 0 {
+			errs["tags"] = "is required"
+		}
+	case BulkDelete:
+		// Files are only deleted for torrents that were confirmed one by one
+		if r.DeleteFiles && r.Filter != nil {
+			errs["filter"] = "can not be used to delete files, list the ids"
+		}
+	}
+	return errs
+}
+
+// BulkItemResult is the outcome of a bulk action for one torrent
+type BulkItemResult struct {
+	ID     int    `json:"id"`
+	Url    string `json:"url,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResponse lists the outcome for every selected torrent in the order they were processed
+type BulkResponse struct {
+	ID        string           `json:"id"`
+	Action    string           `json:"action"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+// bulkItem is a selected torrent, Torrent is empty when the id is not on the caller's watch list
+type bulkItem struct {
+	ID      int
+	Torrent *database.Torrent
+}
+
+// BulkTorrents is a function for applying an action to many torrents at once. Every
+// processed torrent is reported as a bulk_progress message on the live feed.
+func BulkTorrents(c echo.Context) error {
+	var request BulkRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+
+	user, _ := auth.CurrentUser(c)
+	if request.Action == BulkDelete && request.DeleteFiles && !confirmations.consume(request.ConfirmToken, user.ID, idsScope(request.IDs)) {
+		return confirmationRequired(c)
+	}
+
+	items, err := selectBulkItems(c, request)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	response := BulkResponse{ID: newBulkID(), Action: request.Action, Total: len(items), Results: make([]BulkItemResult, 0, len(items))}
+	for i, item := range items {
+		result := BulkItemResult{ID: item.ID, Status: BulkItemNotFound}
+		if item.Torrent != nil {
+			result.Url = item.Torrent.Url
+			status, err := applyBulkAction(c, request, *item.Torrent)
+			result.Status = status
+			if err != nil {
+				result.Status, result.Error = BulkItemFailed, err.Error()
+			}
+		}
+		if result.Status == BulkItemOK || result.Status == BulkItemUnwatched {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+
+		events.Publish(events.Event{Type: events.BulkProgress, Bulk: &events.BulkStep{
+			ID:        response.ID,
+			Action:    request.Action,
+			Done:      i + 1,
+			Total:     len(items),
+			TorrentID: result.ID,
+			Status:    result.Status,
+			Error:     result.Error,
+			UserID:    user.ID,
+		}})
+	}
+
+	log.Info("bulk_operation", "Bulk operation finished", map[string]string{
+		"id":        response.ID,
+		"action":    response.Action,
+		"succeeded": strconv.Itoa(response.Succeeded),
+		"failed":    strconv.Itoa(response.Failed),
+	})
+	return c.JSON(200, response)
+}
+
+// selectBulkItems returns the torrents of the request's ids or filter that are on the caller's watch list
+func selectBulkItems(c echo.Context, request BulkRequest) ([]bulkItem, error) {
+	var items []bulkItem
+	if request.Filter != nil {
+		query := torrentQuery{
+			tracker: strings.ToLower(strings.TrimSpace(request.Filter.Tracker)),
+			watched: request.Filter.Watched,
+			status:  request.Filter.Status,
+			search:  strings.ToLower(strings.TrimSpace(request.Filter.Q)),
+		}
+		dbTorrents, err := callerRecords(c)
+		if err != nil {
+			return nil, err
+		}
+		for i := range dbTorrents {
+			if query.matches(newTorrentResource(dbTorrents[i])) {
+				items = append(items, bulkItem{ID: dbTorrents[i].ID, Torrent: &dbTorrents[i]})
+			}
+		}
+		return items, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, id := range request.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		item := bulkItem{ID: id}
+		t, err := database.Repo.GetRecordByID(id)
+		if err == nil {
+			var watching bool
+			watching, err = callerWatches(c, t.Url)
+			if watching {
+				item.Torrent = &t
+			}
+		}
+		if err != nil && err != database.ErrNotFound {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// applyBulkAction applies the request's action to a single torrent
+func applyBulkAction(c echo.Context, request BulkRequest, t database.Torrent) (string, error) {
+	var err error
+	watchEvery := request.WatchEvery
+	switch request.Action {
+	case BulkSetWatch:
+		err = database.Repo.SetWatchFlag(t.Url, watchEvery)
+	case BulkUnwatch:
+		watchEvery = 0
+		err = database.Repo.SetWatchFlag(t.Url, watchEvery)
+	case BulkDelete:
+		mode := RemoveKeepFiles
+		if request.DeleteFiles {
+			mode = RemoveDeleteFiles
+		}
+		return deleteTorrent(c, t.Url, t.Hash, mode)
+	case BulkMove:
+		if t.Hash != "" {
+			err = getQbUser().SetLocation(t.Hash, request.DownloadPath)
+		}
+		if err == nil {
+			err = database.Repo.SetDownloadPath(t.Url, request.DownloadPath)
+		}
+	case BulkRecheck:
+		err = qbittorrent.CheckNow(t)
+	case BulkTag:
+		err = setTags(t, append(append([]string{}, t.Tags...), request.Tags...))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch request.Action {
+	case BulkSetWatch, BulkUnwatch:
+		auditWatch(c, t.Url, t.WatchEvery, watchEvery)
+	case BulkMove, BulkTag:
+		if updated, err := database.Repo.GetRecordByID(t.ID); err == nil {
+			audit(c, database.AuditTorrentUpdate, t.Url, torrentSettings(t), torrentSettings(updated))
+		}
+	}
+	return BulkItemOK, nil
+}
+
+// newBulkID returns a random bulk operation ID
+func newBulkID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("150405.000000")))
+	}
+	return hex.EncodeToString(b)
+}
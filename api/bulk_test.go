@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestBulkTorrents(t *testing.T) {
+	useTestRepository(t)
+	qb := useFakeQbittorrent(t)
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	bob, err := database.Repo.CreateUser(database.User{Username: "bob", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	ids := make(map[string]int)
+	for i, torrent := range []struct{ title, owner string }{{"First", "alice"}, {"Second", "alice"}, {"Third", "bob"}} {
+		topicUrl := "https://kinozal.tv/details.php?id=" + strconv.Itoa(i+1)
+		if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: torrent.title, Name: torrent.title, Hash: "hash" + strconv.Itoa(i), Url: topicUrl}); err != nil {
+			t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+		}
+		owner := alice
+		if torrent.owner == "bob" {
+			owner = bob
+		}
+		if err := database.Repo.AddWatcher(owner.ID, topicUrl); err != nil {
+			t.Fatalf("AddWatcher() failed: %v", err)
+		}
+		record, err := database.Repo.GetRecordByUrl(topicUrl)
+		if err != nil {
+			t.Fatalf("GetRecordByUrl() failed: %v", err)
+		}
+		ids[torrent.title] = record.ID
+	}
+
+	progress := events.GlobalBus.Subscribe("bulk_test", 10, events.BulkProgress)
+	t.Cleanup(func() { events.GlobalBus.Unsubscribe(progress) })
+
+	e := echo.New()
+	bulk := func(body string) BulkResponse {
+		req := httptest.NewRequest(http.MethodPost, "/api/torrents/bulk", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		auth.SetUser(c, alice)
+		if err := BulkTorrents(c); err != nil {
+			t.Fatalf("BulkTorrents() failed: %v", err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var response BulkResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("Ids", func(t *testing.T) {
+		body := `{"action":"set_watch","watch_every":30,"ids":[` + strconv.Itoa(ids["First"]) + `,` + strconv.Itoa(ids["Second"]) + `,` +
+			strconv.Itoa(ids["Third"]) + `,999,` + strconv.Itoa(ids["First"]) + `]}`
+		response := bulk(body)
+
+		var statuses []string
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+		}
+		want := []string{BulkItemOK, BulkItemOK, BulkItemNotFound, BulkItemNotFound}
+		if !reflect.DeepEqual(statuses, want) || response.Succeeded != 2 || response.Failed != 2 {
+			t.Errorf("Expected %v, got %+v", want, response)
+		}
+		if third, _ := database.Repo.GetRecordByID(ids["Third"]); third.WatchEvery != 0 {
+			t.Errorf("Expected another user's torrent to stay unchanged, got %+v", third)
+		}
+
+		for done := 1; done <= len(want); done++ {
+			e := <-progress.C
+			if e.Bulk == nil || e.Bulk.ID != response.ID || e.Bulk.Done != done || e.Bulk.Total != len(want) || e.Bulk.UserID != alice.ID {
+				t.Errorf("Unexpected progress event %+v", e.Bulk)
+			}
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		response := bulk(`{"action":"tag","tags":["finished"],"filter":{"q":"first"}}`)
+		if response.Total != 1 || response.Results[0].ID != ids["First"] || response.Results[0].Status != BulkItemOK {
+			t.Errorf("Expected only the first torrent to be tagged, got %+v", response)
+		}
+		if first, _ := database.Repo.GetRecordByID(ids["First"]); !reflect.DeepEqual(first.Tags, []string{"finished"}) {
+			t.Errorf("Expected the tag to be added, got %v", first.Tags)
+		}
+		if forms := qb.forms("torrents/addTags"); len(forms) != 1 || forms[0].Get("hashes") != "hash0" || forms[0].Get("tags") != "finished" {
+			t.Errorf("Expected the tag to be added in qBittorrent, got %v", forms)
+		}
+
+		response = bulk(`{"action":"unwatch","filter":{"watched":true}}`)
+		if response.Total != 2 || response.Succeeded != 2 {
+			t.Errorf("Expected both watched torrents to be unwatched, got %+v", response)
+		}
+		for _, title := range []string{"First", "Second"} {
+			if record, _ := database.Repo.GetRecordByID(ids[title]); record.WatchEvery != 0 {
+				t.Errorf("Expected %s to be unwatched, got %+v", title, record)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// confirmationTTL is how long a confirmation token can be used
+const confirmationTTL = 5 * time.Minute
+
+// confirmation is a pending permission to delete the files of the torrents in scope
+type confirmation struct {
+	userID  int
+	scope   string
+	expires time.Time
+}
+
+// confirmationStore hands out single-use tokens that confirm the deletion of downloaded files
+type confirmationStore struct {
+	mu      sync.Mutex
+	pending map[string]confirmation
+	now     func() time.Time
+}
+
+// confirmations are the pending file deletion confirmations of all users
+var confirmations = newConfirmationStore()
+
+func newConfirmationStore() *confirmationStore {
+	return &confirmationStore{pending: make(map[string]confirmation), now: time.Now}
+}
+
+// issue returns a token allowing userID to delete the files of scope until it expires
+func (s *confirmationStore) issue(userID int, scope string) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// Forget expired tokens so unused ones do not pile up
+	for t, pending := range s.pending {
+		if now.After(pending.expires) {
+			delete(s.pending, t)
+		}
+	}
+	expires := now.Add(confirmationTTL)
+	s.pending[token] = confirmation{userID: userID, scope: scope, expires: expires}
+	return token, expires, nil
+}
+
+// consume reports whether token was issued to userID for scope and has not expired.
+// A token can only be used once.
+func (s *confirmationStore) consume(token string, userID int, scope string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, ok := s.pending[token]
+	if !ok || pending.userID != userID || pending.scope != scope {
+		return false
+	}
+	delete(s.pending, token)
+	return !s.now().After(pending.expires)
+}
+
+// urlScope is the confirmation scope of a single torrent
+func urlScope(torrentUrl string) string {
+	return "url:" + common.CanonicalTorrentUrl(torrentUrl)
+}
+
+// idsScope is the confirmation scope of a set of torrents given by id
+func idsScope(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+	parts := make([]string, 0, len(sorted))
+	for i, id := range sorted {
+		if i > 0 && id == sorted[i-1] {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return "ids:" + strings.Join(parts, ",")
+}
+
+// ConfirmRemoveRequest is the body of POST /api/remove/confirm. It names the torrents
+// whose files are going to be deleted, either by url or by ids for a bulk delete.
+type ConfirmRemoveRequest struct {
+	Url string `json:"url"`
+	IDs []int  `json:"ids"`
+}
+
+// Validate checks the request fields
+func (r *ConfirmRemoveRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if (r.Url == "") == (len(r.IDs) == 0) {
+		errs["url"] = "either url or ids is required"
+	}
+	return errs
+}
+
+// ConfirmRemoveResponse carries the token that confirms a file deletion
+type ConfirmRemoveResponse struct {
+	ConfirmToken string    `json:"confirm_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ConfirmRemove is a function for getting a token that allows deleting downloaded files.
+// The token is passed as confirm_token with the remove request within five minutes.
+func ConfirmRemove(c echo.Context) error {
+	var request ConfirmRemoveRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+
+	scope := idsScope(request.IDs)
+	if request.Url != "" {
+		scope = urlScope(request.Url)
+	}
+	user, _ := auth.CurrentUser(c)
+	token, expires, err := confirmations.issue(user.ID, scope)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, ConfirmRemoveResponse{ConfirmToken: token, ExpiresAt: expires})
+}
+
+// confirmationRequired writes the reply to a file deletion without a valid confirmation token
+func confirmationRequired(c echo.Context) error {
+	// Return 428 Precondition Required
+	return c.JSON(428, ErrorResponse{
+		Error:  "confirmation required",
+		Fields: FieldErrors{"confirm_token": "must be a valid token from POST /api/remove/confirm to delete files"},
+	})
+}
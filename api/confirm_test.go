@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestConfirmationStore(t *testing.T) {
+	now := time.Now()
+	store := newConfirmationStore()
+	store.now = func() time.Time { return now }
+
+	token, _, err := store.issue(1, urlScope("https://kinozal.tv/details.php?id=1"))
+	if err != nil {
+		t.Fatalf("issue() failed: %v", err)
+	}
+	if store.consume(token, 2, urlScope("https://kinozal.tv/details.php?id=1")) {
+		t.Error("Expected a token of another user to be rejected")
+	}
+	if store.consume(token, 1, urlScope("https://kinozal.tv/details.php?id=2")) {
+		t.Error("Expected a token of another torrent to be rejected")
+	}
+	if !store.consume(token, 1, urlScope("https://www.kinozal.tv/details.php?id=1")) {
+		t.Error("Expected the token to be accepted for the same topic")
+	}
+	if store.consume(token, 1, urlScope("https://kinozal.tv/details.php?id=1")) {
+		t.Error("Expected the token to be accepted only once")
+	}
+
+	token, _, _ = store.issue(1, idsScope([]int{3, 1, 3}))
+	now = now.Add(confirmationTTL + time.Second)
+	if store.consume(token, 1, idsScope([]int{1, 3})) {
+		t.Error("Expected an expired token to be rejected")
+	}
+}
+
+func TestRemoveTorrent_Modes(t *testing.T) {
+	useTestRepository(t)
+	alice, _ := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	bob, _ := database.Repo.CreateUser(database.User{Username: "bob", PasswordHash: "x", Role: database.RoleUser})
+	shared, own := "https://kinozal.tv/details.php?id=1", "https://kinozal.tv/details.php?id=2"
+	for i, topicUrl := range []string{shared, own} {
+		if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Title", Name: "Name", Hash: "hash" + strconv.Itoa(i), Url: topicUrl}); err != nil {
+			t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+		}
+		if err := database.Repo.AddWatcher(alice.ID, topicUrl); err != nil {
+			t.Fatalf("AddWatcher() failed: %v", err)
+		}
+	}
+	if err := database.Repo.AddWatcher(bob.ID, shared); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+
+	e := echo.New()
+	call := func(handler echo.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		auth.SetUser(c, alice)
+		if err := handler(c); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	// Deleting files needs a confirmation for the torrent
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, `{"url":"`+shared+`","hash":"hash0","mode":"delete_files"}`); rec.Code != 428 {
+		t.Errorf("Expected 428 without a confirmation token, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := call(ConfirmRemove, http.MethodPost, `{"url":"`+shared+`"}`)
+	var confirm ConfirmRemoveResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &confirm); err != nil || confirm.ConfirmToken == "" {
+		t.Fatalf("Expected a confirmation token, got %d: %s", rec.Code, rec.Body)
+	}
+	body := `{"url":"` + shared + `","hash":"hash0","mode":"delete_files","confirm_token":"` + confirm.ConfirmToken + `"}`
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, body); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "unwatched") {
+		t.Errorf("Expected the shared torrent to be unwatched, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Untracking needs neither qBittorrent nor a hash
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, `{"url":"`+own+`","mode":"untrack"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := database.Repo.GetRecordByUrl(own); err != database.ErrNotFound {
+		t.Errorf("Expected the untracked torrent to be deleted from the database, got %v", err)
+	}
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, `{"url":"`+own+`","hash":"hash","mode":"wipe"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown mode, got %d", rec.Code)
+	}
+
+	// qBittorrent is never asked to remove a hash that is not the one of the url
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, `{"url":"`+own+`","hash":"hash0","mode":"keep_files"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown url, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := call(RemoveTorrentUrl, http.MethodDelete, `{"url":"`+shared+`","hash":"hash1","mode":"keep_files"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for the hash of another torrent, got %d: %s", rec.Code, rec.Body)
+	}
+}
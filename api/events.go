@@ -0,0 +1,83 @@
+package api
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/metrics"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultHistoryLimit is the number of history entries returned when no limit is given
+const defaultHistoryLimit = 100
+
+// CheckUpdate is the result of a torrent check as sent to live feed clients
+type CheckUpdate struct {
+	Url              string `json:"url"`
+	LastCheckTime    string `json:"last_check_time"`
+	LastCheckSuccess bool   `json:"last_check_success"`
+}
+
+// TorrentProgress is the changed qBittorrent status of a torrent as sent to live feed clients
+type TorrentProgress struct {
+	Url    string                `json:"url"`
+	Hash   string                `json:"hash"`
+	Status *common.TorrentStatus `json:"status"`
+}
+
+// GetHistory returns recorded events of the caller's torrents, optionally limited to a single torrent url
+func GetHistory(c echo.Context) error {
+	url := c.QueryParam("url")
+	if url != "" {
+		url = common.CanonicalTorrentUrl(url)
+	}
+
+	limit := defaultHistoryLimit
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			// Return 400 Bad Request
+			return c.JSON(400, map[string]string{"error": "limit must be a positive number"})
+		}
+		limit = parsed
+	}
+
+	var history []database.HistoryEntry
+	var err error
+	switch {
+	case showAll(c):
+		history, err = database.Repo.GetHistory(url, limit)
+	case url != "":
+		var watching bool
+		if watching, err = callerWatches(c, url); err == nil {
+			history = make([]database.HistoryEntry, 0)
+			if watching {
+				history, err = database.Repo.GetHistory(url, limit)
+			}
+		}
+	default:
+		history, err = database.Repo.GetHistoryForUser(callerID(c), limit)
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, history)
+}
+
+// GetEventStats returns event counters and the buffer usage of every event subscriber
+func GetEventStats(c echo.Context) error {
+	return c.JSON(200, map[string]interface{}{
+		"events":      events.GlobalStats.Counts(),
+		"subscribers": events.GlobalBus.Subscribers(),
+	})
+}
+
+// GetMetrics serves the metrics of the monitor in the Prometheus text format
+func GetMetrics(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	c.Response().WriteHeader(200)
+	return metrics.Default.WriteText(c.Response())
+}
@@ -0,0 +1,458 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProtocolVersion is the version of the live feed envelope
+const ProtocolVersion = 2
+
+// Envelope wraps every live feed message. Seq grows by one for every broadcast
+// message, so a client can tell that it missed something and resume from the last
+// sequence number it has seen. Replies to a single client carry seq 0.
+type Envelope struct {
+	Version int             `json:"v"`
+	Seq     uint64          `json:"seq"`
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	Url     string          `json:"url,omitempty"`
+	Data    json.RawMessage `json:"data"`
+	// UserID limits the message to a single user, 0 means any user watching Url
+	UserID int `json:"-"`
+}
+
+// DropPolicy decides what happens when a client's send queue is full
+type DropPolicy string
+
+// Supported drop policies
+const (
+	DropOldest DropPolicy = "drop_oldest"
+	DropNewest DropPolicy = "drop_newest"
+	Disconnect DropPolicy = "disconnect"
+)
+
+// ParseDropPolicy validates a drop policy name
+func ParseDropPolicy(name string) (DropPolicy, error) {
+	switch policy := DropPolicy(name); policy {
+	case DropOldest, DropNewest, Disconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported drop policy %q", name)
+}
+
+// feedClient is a live feed consumer with its own bounded queue and subscriptions
+type feedClient struct {
+	queue     chan Envelope
+	policy    DropPolicy
+	dropped   atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+	// goingAway is set when the client is closed because the server shuts down
+	goingAway atomic.Bool
+
+	mu    sync.Mutex
+	types map[string]bool
+	urls  map[string]bool
+
+	// scope limits the client to the messages its user may see, nil allows everything
+	scope messageScope
+}
+
+// messageScope decides which messages a client may see
+type messageScope interface {
+	allows(env Envelope) bool
+}
+
+// newFeedClient creates a client whose queue holds up to size messages
+func newFeedClient(size int, policy DropPolicy) *feedClient {
+	if size <= 0 {
+		size = 1
+	}
+	return &feedClient{
+		queue:  make(chan Envelope, size),
+		policy: policy,
+		done:   make(chan struct{}),
+		types:  make(map[string]bool),
+		urls:   make(map[string]bool),
+	}
+}
+
+// subscribe restricts the client to the given message types and torrent urls
+func (c *feedClient) subscribe(types, urls []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range types {
+		c.types[t] = true
+	}
+	for _, url := range urls {
+		c.urls[url] = true
+	}
+}
+
+// unsubscribe removes message types and torrent urls from the client's subscriptions
+func (c *feedClient) unsubscribe(types, urls []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range types {
+		delete(c.types, t)
+	}
+	for _, url := range urls {
+		delete(c.urls, url)
+	}
+}
+
+// subscriptions returns the current subscriptions of the client
+func (c *feedClient) subscriptions() (types, urls []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	types, urls = make([]string, 0, len(c.types)), make([]string, 0, len(c.urls))
+	for t := range c.types {
+		types = append(types, t)
+	}
+	for url := range c.urls {
+		urls = append(urls, url)
+	}
+	return types, urls
+}
+
+// wants reports whether env matches the subscriptions and the scope. A client without
+// subscriptions receives everything, and messages without a url pass the url filter.
+func (c *feedClient) wants(env Envelope) bool {
+	c.mu.Lock()
+	if len(c.types) > 0 && !c.types[env.Type] {
+		c.mu.Unlock()
+		return false
+	}
+	if len(c.urls) > 0 && env.Url != "" && !c.urls[env.Url] {
+		c.mu.Unlock()
+		return false
+	}
+	c.mu.Unlock()
+	return c.scope == nil || c.scope.allows(env)
+}
+
+// watchScope lets a user see messages addressed to them, messages about the torrents
+// they watch and messages that concern no torrent, such as tracker login failures.
+// The watched urls are kept, Feed.Run reloads them when the watch list changes.
+type watchScope struct {
+	userID int
+
+	mu   sync.RWMutex
+	urls map[string]bool
+}
+
+// userScope returns the scope of userID with the torrents the user watches now
+func userScope(userID int) *watchScope {
+	s := &watchScope{userID: userID, urls: make(map[string]bool)}
+	s.reload()
+	return s
+}
+
+// reload reads the watched urls, keeping the previous ones when that fails
+func (s *watchScope) reload() {
+	records, err := database.Repo.GetRecordsForUser(s.userID)
+	if err != nil {
+		log.Error("feed_scope", err.Error(), map[string]string{"user_id": strconv.Itoa(s.userID)})
+		return
+	}
+	urls := make(map[string]bool, len(records))
+	for _, record := range records {
+		urls[record.Url] = true
+	}
+	s.mu.Lock()
+	s.urls = urls
+	s.mu.Unlock()
+}
+
+// forget drops url, e.g. after its torrent was removed
+func (s *watchScope) forget(url string) {
+	s.mu.Lock()
+	delete(s.urls, url)
+	s.mu.Unlock()
+}
+
+// allows implements messageScope without querying the database
+func (s *watchScope) allows(env Envelope) bool {
+	if env.UserID != 0 {
+		return env.UserID == s.userID
+	}
+	if env.Url == "" {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.urls[common.CanonicalTorrentUrl(env.Url)]
+}
+
+// offer queues env if it matches the subscriptions
+func (c *feedClient) offer(env Envelope) {
+	if c.wants(env) {
+		c.enqueue(env)
+	}
+}
+
+// enqueue queues env without blocking, applying the drop policy when the queue is full
+func (c *feedClient) enqueue(env Envelope) {
+	select {
+	case c.queue <- env:
+		return
+	default:
+	}
+
+	c.dropped.Add(1)
+	switch c.policy {
+	case DropNewest:
+	case Disconnect:
+		c.close()
+	default:
+		// Make room by discarding the oldest queued message
+		select {
+		case <-c.queue:
+		default:
+		}
+		select {
+		case c.queue <- env:
+		default:
+		}
+	}
+}
+
+// close tells the client's writer to stop
+func (c *feedClient) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Feed turns bus events into sequenced envelopes, keeps the latest of them for
+// resuming clients and fans them out to every attached client
+type Feed struct {
+	mu      sync.Mutex
+	seq     uint64
+	ring    []Envelope
+	next    int
+	full    bool
+	clients map[*feedClient]struct{}
+	closed  bool
+
+	// fanout keeps broadcasts in sequence order while clients are offered a message without mu
+	fanout sync.Mutex
+}
+
+// NewFeed creates a feed that remembers the last size messages
+func NewFeed(size int) *Feed {
+	if size <= 0 {
+		size = 1
+	}
+	return &Feed{
+		ring:    make([]Envelope, size),
+		clients: make(map[*feedClient]struct{}),
+	}
+}
+
+// Run broadcasts events from sub until it is unsubscribed. A changed watch list is not
+// broadcast, it updates the scopes of the clients it concerns instead.
+func (f *Feed) Run(sub *events.Subscription) {
+	for e := range sub.C {
+		if e.Type == events.WatchersChanged {
+			f.updateScopes(e.UserID, e.Url)
+			continue
+		}
+		env, err := newEnvelope(e)
+		if err != nil {
+			log.Error("feed_envelope", "Error marshaling event", map[string]string{"error": err.Error(), "event_type": string(e.Type)})
+			continue
+		}
+		f.Broadcast(env)
+	}
+}
+
+// Broadcast assigns the next sequence number to env, remembers it and queues it for every
+// client attached by then. Clients attaching meanwhile get env in their backlog.
+func (f *Feed) Broadcast(env Envelope) {
+	f.mu.Lock()
+	f.seq++
+	env.Version = ProtocolVersion
+	env.Seq = f.seq
+	f.ring[f.next] = env
+	f.next = (f.next + 1) % len(f.ring)
+	if f.next == 0 {
+		f.full = true
+	}
+	clients := make([]*feedClient, 0, len(f.clients))
+	for client := range f.clients {
+		clients = append(clients, client)
+	}
+	f.fanout.Lock()
+	defer f.fanout.Unlock()
+	f.mu.Unlock()
+
+	for _, client := range clients {
+		client.offer(env)
+	}
+}
+
+// updateScopes reloads the watched urls of the clients of userID. A userID of 0 means
+// that the torrent of url was removed with all of its watchers.
+func (f *Feed) updateScopes(userID int, url string) {
+	f.mu.Lock()
+	var scopes []*watchScope
+	for client := range f.clients {
+		if scope, ok := client.scope.(*watchScope); ok && (userID == 0 || scope.userID == userID) {
+			scopes = append(scopes, scope)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, scope := range scopes {
+		if userID == 0 {
+			scope.forget(common.CanonicalTorrentUrl(url))
+		} else {
+			scope.reload()
+		}
+	}
+}
+
+// Seq returns the sequence number of the latest message
+func (f *Feed) Seq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq
+}
+
+// attach registers client. When since is positive the messages after since are
+// returned as backlog; complete is false if some of them are no longer buffered.
+// seq is the sequence number the client is up to date with after the backlog.
+func (f *Feed) attach(client *feedClient, since uint64) (backlog []Envelope, complete bool, seq uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		client.goingAway.Store(true)
+		client.close()
+	}
+	f.clients[client] = struct{}{}
+	if since == 0 || since > f.seq {
+		return nil, false, f.seq
+	}
+
+	buffered := f.buffered()
+	if len(buffered) > 0 && buffered[0].Seq > since+1 {
+		return nil, false, f.seq
+	}
+	for _, env := range buffered {
+		if env.Seq > since {
+			backlog = append(backlog, env)
+		}
+	}
+	return backlog, true, f.seq
+}
+
+// Clients returns the number of attached clients
+func (f *Feed) Clients() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clients)
+}
+
+// Close disconnects every client telling it that the server is going away.
+// Clients attaching later are disconnected right away.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for client := range f.clients {
+		client.goingAway.Store(true)
+		client.close()
+	}
+}
+
+// detach removes client from the feed
+func (f *Feed) detach(client *feedClient) {
+	f.mu.Lock()
+	delete(f.clients, client)
+	f.mu.Unlock()
+	client.close()
+}
+
+// buffered returns the remembered messages from oldest to newest
+func (f *Feed) buffered() []Envelope {
+	if !f.full {
+		return append([]Envelope(nil), f.ring[:f.next]...)
+	}
+	return append(append([]Envelope(nil), f.ring[f.next:]...), f.ring[:f.next]...)
+}
+
+// newEnvelope converts an event to an envelope carrying the message understood by the frontend
+func newEnvelope(e events.Event) (Envelope, error) {
+	var msgType string
+	var msg interface{}
+	switch e.Type {
+	case events.CheckCompleted:
+		msgType = "check_update"
+		msg = CheckUpdate{
+			Url:              e.Url,
+			LastCheckTime:    e.Time.Format(time.RFC3339),
+			LastCheckSuccess: e.Success,
+		}
+	case events.JobUpdated:
+		msgType = "job_update"
+		msg = e.Job
+	case events.BulkProgress:
+		msgType = "bulk_progress"
+		msg = e.Bulk
+	case events.TorrentProgress:
+		msgType = "torrent_progress"
+		msg = TorrentProgress{Url: e.Url, Hash: e.Hash, Status: e.Status}
+	default:
+		msgType = string(e.Type)
+		msg = e
+	}
+	env, err := newReply(msgType, e.Url, msg, e.Time)
+	if e.Job != nil {
+		env.UserID = e.Job.UserID
+	}
+	if e.Bulk != nil {
+		env.UserID = e.Bulk.UserID
+	}
+	return env, err
+}
+
+// newReply creates an unsequenced envelope for a single client
+func newReply(msgType, url string, msg interface{}, at time.Time) (Envelope, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Version: ProtocolVersion,
+		Type:    msgType,
+		Time:    at,
+		Url:     url,
+		Data:    data,
+	}, nil
+}
+
+// legacyMessage converts env to the unversioned message format of the first protocol version
+func legacyMessage(env Envelope) ([]byte, error) {
+	switch env.Type {
+	case "check_update":
+		var msg map[string]interface{}
+		if err := json.Unmarshal(env.Data, &msg); err != nil {
+			return nil, err
+		}
+		msg["type"] = env.Type
+		return json.Marshal(msg)
+	case "job_update":
+		return json.Marshal(map[string]interface{}{"type": env.Type, "job": env.Data})
+	case "current_state":
+		return json.Marshal(map[string]interface{}{"type": env.Type, "data": env.Data})
+	}
+	return env.Data, nil
+}
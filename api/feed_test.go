@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scopeFunc is a messageScope deciding with a function
+type scopeFunc func(Envelope) bool
+
+func (f scopeFunc) allows(env Envelope) bool {
+	return f(env)
+}
+
+func broadcastN(feed *Feed, n int) {
+	for i := 0; i < n; i++ {
+		feed.Broadcast(Envelope{Type: "check_update", Data: json.RawMessage(`{}`)})
+	}
+}
+
+func TestFeed_Attach(t *testing.T) {
+	feed := NewFeed(3)
+	broadcastN(feed, 5)
+
+	testCases := []struct {
+		name         string
+		since        uint64
+		wantComplete bool
+		wantBacklog  []uint64
+	}{
+		{name: "Fresh client", since: 0, wantComplete: false},
+		{name: "Up to date", since: 5, wantComplete: true},
+		{name: "Missed buffered messages", since: 3, wantComplete: true, wantBacklog: []uint64{4, 5}},
+		{name: "Oldest buffered message is next", since: 2, wantComplete: true, wantBacklog: []uint64{3, 4, 5}},
+		{name: "Missed more than buffered", since: 1, wantComplete: false},
+		{name: "Sequence from another server run", since: 10, wantComplete: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFeedClient(10, DropOldest)
+			backlog, complete, seq := feed.attach(client, tc.since)
+			defer feed.detach(client)
+
+			if complete != tc.wantComplete {
+				t.Errorf("Expected complete=%v, got %v", tc.wantComplete, complete)
+			}
+			if seq != 5 {
+				t.Errorf("Expected seq 5, got %d", seq)
+			}
+			if len(backlog) != len(tc.wantBacklog) {
+				t.Fatalf("Expected backlog %v, got %d messages", tc.wantBacklog, len(backlog))
+			}
+			for i, env := range backlog {
+				if env.Seq != tc.wantBacklog[i] || env.Version != ProtocolVersion {
+					t.Errorf("Unexpected backlog message %d: %+v", i, env)
+				}
+			}
+		})
+	}
+}
+
+func TestFeed_BroadcastDoesNotBlockAttach(t *testing.T) {
+	feed := NewFeed(3)
+	slow := newFeedClient(10, DropOldest)
+	release := make(chan struct{})
+	slow.scope = scopeFunc(func(Envelope) bool {
+		<-release
+		return true
+	})
+	feed.attach(slow, 0)
+
+	done := make(chan struct{})
+	go func() {
+		broadcastN(feed, 1)
+		close(done)
+	}()
+
+	// A slow scope does not hold up other clients attaching
+	attached := make(chan struct{})
+	go func() {
+		backlog, complete, _ := feed.attach(newFeedClient(10, DropOldest), 0)
+		if len(backlog) != 0 || complete {
+			t.Errorf("Unexpected backlog %v, complete %v", backlog, complete)
+		}
+		close(attached)
+	}()
+	select {
+	case <-attached:
+	case <-time.After(time.Second):
+		t.Fatal("Expected attach to return while a broadcast waits for a scope")
+	}
+	close(release)
+	<-done
+	if len(slow.queue) != 1 {
+		t.Errorf("Expected the slow client to get the message, got %d", len(slow.queue))
+	}
+}
+
+func TestFeedClient_DropPolicies(t *testing.T) {
+	testCases := []struct {
+		policy     DropPolicy
+		wantQueued []uint64
+		wantClosed bool
+	}{
+		{policy: DropOldest, wantQueued: []uint64{2, 3}},
+		{policy: DropNewest, wantQueued: []uint64{1, 2}},
+		{policy: Disconnect, wantQueued: []uint64{1, 2}, wantClosed: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			feed := NewFeed(10)
+			client := newFeedClient(2, tc.policy)
+			feed.attach(client, 0)
+			broadcastN(feed, 3)
+
+			if client.dropped.Load() != 1 {
+				t.Errorf("Expected 1 dropped message, got %d", client.dropped.Load())
+			}
+			for _, want := range tc.wantQueued {
+				if env := <-client.queue; env.Seq != want {
+					t.Errorf("Expected seq %d, got %d", want, env.Seq)
+				}
+			}
+			select {
+			case <-client.done:
+				if !tc.wantClosed {
+					t.Error("Expected client to stay open")
+				}
+			default:
+				if tc.wantClosed {
+					t.Error("Expected client to be closed")
+				}
+			}
+		})
+	}
+}
+
+func TestFeedClient_Subscriptions(t *testing.T) {
+	url := "https://kinozal.tv/details.php?id=1"
+	client := newFeedClient(10, DropOldest)
+
+	check := Envelope{Type: "check_update", Url: url}
+	otherCheck := Envelope{Type: "check_update", Url: "https://kinozal.tv/details.php?id=2"}
+	outage := Envelope{Type: string(events.ClientUnavailable)}
+
+	if !client.wants(check) || !client.wants(outage) {
+		t.Error("Expected a client without subscriptions to receive everything")
+	}
+
+	client.subscribe([]string{"check_update"}, []string{url})
+	if !client.wants(check) {
+		t.Error("Expected subscribed url and type to be delivered")
+	}
+	if client.wants(otherCheck) {
+		t.Error("Expected other urls to be filtered")
+	}
+	if client.wants(outage) {
+		t.Error("Expected other types to be filtered")
+	}
+
+	client.unsubscribe(nil, []string{url})
+	if !client.wants(otherCheck) {
+		t.Error("Expected every url after unsubscribing from the only url")
+	}
+}
+
+func TestFeedClient_UserScope(t *testing.T) {
+	useTestRepository(t)
+	user, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "hash", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	watched, other := "https://kinozal.tv/details.php?id=1", "https://kinozal.tv/details.php?id=2"
+	for i, url := range []string{watched, other} {
+		if err := database.Repo.AddRecord(models.Torrent{Title: url, Hash: strconv.Itoa(i), Url: url}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+	}
+	if err := database.Repo.AddWatcher(user.ID, watched); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+
+	client := newFeedClient(10, DropOldest)
+	client.scope = userScope(user.ID)
+
+	testCases := []struct {
+		name string
+		env  Envelope
+		want bool
+	}{
+		{name: "Watched torrent", env: Envelope{Type: "check_update", Url: watched}, want: true},
+		{name: "Torrent of another user", env: Envelope{Type: "check_update", Url: other}, want: false},
+		{name: "Message without torrent", env: Envelope{Type: "tracker_login_failed"}, want: true},
+		{name: "Own job", env: Envelope{Type: "job_update", Url: other, UserID: user.ID}, want: true},
+		{name: "Job of another user", env: Envelope{Type: "job_update", Url: watched, UserID: user.ID + 1}, want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := client.wants(tc.env); got != tc.want {
+				t.Errorf("Expected wants()=%v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFeed_WatchListChanges(t *testing.T) {
+	useTestRepository(t)
+	user, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "hash", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	url := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.AddRecord(models.Torrent{Title: "Title", Hash: "hash", Url: url}); err != nil {
+		t.Fatalf("AddRecord() failed: %v", err)
+	}
+
+	feed := NewFeed(3)
+	sub := events.GlobalBus.Subscribe("feed_test", 10)
+	go feed.Run(sub)
+	t.Cleanup(func() { events.GlobalBus.Unsubscribe(sub) })
+	client := newFeedClient(10, DropOldest)
+	client.scope = userScope(user.ID)
+	feed.attach(client, 0)
+
+	check := Envelope{Type: "check_update", Url: url}
+	waitFor := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); client.wants(check) != want; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected wants()=%v after the watch list changed", want)
+			}
+		}
+	}
+	if err := database.Repo.AddWatcher(user.ID, url); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+	waitFor(true)
+	if err := database.Repo.DeleteRecord(url); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	waitFor(false)
+	if feed.Seq() != 0 {
+		t.Errorf("Expected watch list changes not to be broadcast, got seq %d", feed.Seq())
+	}
+}
+
+func TestLegacyMessage(t *testing.T) {
+	testCases := []struct {
+		name string
+		env  Envelope
+		want string
+	}{
+		{
+			name: "Check update",
+			env:  Envelope{Type: "check_update", Data: json.RawMessage(`{"url":"u","last_check_success":true}`)},
+			want: `{"last_check_success":true,"type":"check_update","url":"u"}`,
+		},
+		{
+			name: "Job update",
+			env:  Envelope{Type: "job_update", Data: json.RawMessage(`{"id":"1"}`)},
+			want: `{"job":{"id":"1"},"type":"job_update"}`,
+		},
+		{
+			name: "Current state",
+			env:  Envelope{Type: "current_state", Data: json.RawMessage(`{}`)},
+			want: `{"data":{},"type":"current_state"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := legacyMessage(tc.env)
+			if err != nil {
+				t.Fatalf("legacyMessage() failed: %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
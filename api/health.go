@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"kinozaltv_monitor/qbittorrent"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Version of the monitor, set at build time with -ldflags "-X kinozaltv_monitor/api.Version=..."
+var Version = "dev"
+
+// startedAt is when the process started, used for the uptime
+var startedAt = time.Now()
+
+// readinessTimeout limits how long a single readiness check may take
+const readinessTimeout = 5 * time.Second
+
+// Results of a dependency check
+const (
+	DependencyOk     = "ok"
+	DependencyFailed = "failed"
+)
+
+// ReadinessCheck is the result of checking a dependency
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the reply of /readyz. Status is ok only when every check is.
+type ReadinessResponse struct {
+	Status string           `json:"status"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// TrackerStatus describes the login state and the latest successful check of a tracker
+type TrackerStatus struct {
+	Name                string     `json:"name"`
+	LoggedIn            bool       `json:"logged_in"`
+	LoginError          string     `json:"login_error,omitempty"`
+	LastSuccessfulCheck *time.Time `json:"last_successful_check"`
+}
+
+// ServiceStatus is the reply of /api/status
+type ServiceStatus struct {
+	Version       string          `json:"version"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int             `json:"uptime_seconds"`
+	Watchers      int             `json:"watchers"`
+	QueueLength   int             `json:"queue_length"`
+	Trackers      []TrackerStatus `json:"trackers"`
+}
+
+// dependencyCheck is a named readiness check
+type dependencyCheck struct {
+	name  string
+	check func() error
+}
+
+// dependencyChecks lists the database, the qBittorrent session and every tracker that tried to log in
+func dependencyChecks() []dependencyCheck {
+	checks := []dependencyCheck{
+		{name: "database", check: func() error {
+			if database.Repo == nil {
+				return errors.New("database is not initialized")
+			}
+			return database.Repo.Ping()
+		}},
+		{name: "qbittorrent", check: func() error {
+			if qbittorrent.GlobalManager == nil || qbittorrent.GlobalManager.User == nil {
+				return errors.New("qBittorrent client is not initialized")
+			}
+			if !qbittorrent.GlobalManager.User.SessionValid() {
+				return errors.New("qBittorrent session is not valid")
+			}
+			return nil
+		}},
+	}
+	for _, state := range models.LoginStates() {
+		checks = append(checks, dependencyCheck{name: "tracker:" + state.Tracker, check: func() error {
+			if !state.LoggedIn {
+				return errors.New("not logged in: " + state.Error)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+// runCheck runs check, giving up after readinessTimeout
+func runCheck(check dependencyCheck) ReadinessCheck {
+	done := make(chan error, 1)
+	go func() { done <- check.check() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(readinessTimeout):
+		err = errors.New("timed out after " + readinessTimeout.String())
+	}
+	if err != nil {
+		return ReadinessCheck{Name: check.name, Status: DependencyFailed, Error: err.Error()}
+	}
+	return ReadinessCheck{Name: check.name, Status: DependencyOk}
+}
+
+// Healthz reports that the process is alive
+func Healthz(c echo.Context) error {
+	return c.JSON(200, StatusResponse{Status: DependencyOk})
+}
+
+// Readyz checks the database, the qBittorrent session and the tracker logins.
+// It answers 503 Service Unavailable when any of them fails.
+func Readyz(c echo.Context) error {
+	checks := dependencyChecks()
+	results := make([]ReadinessCheck, len(checks))
+	done := make(chan struct{})
+	for i, check := range checks {
+		go func() {
+			results[i] = runCheck(check)
+			done <- struct{}{}
+		}()
+	}
+	for range checks {
+		<-done
+	}
+
+	response := ReadinessResponse{Status: DependencyOk, Checks: results}
+	for _, result := range results {
+		if result.Status != DependencyOk {
+			response.Status = DependencyFailed
+		}
+	}
+	if response.Status != DependencyOk {
+		// Return 503 Service Unavailable
+		return c.JSON(503, response)
+	}
+	return c.JSON(200, response)
+}
+
+// GetStatus returns the version, uptime, number of watchers, the length of the add queue
+// and the login state and latest successful check of every tracker
+func (h *ApiHandler) GetStatus(c echo.Context) error {
+	trackers := make(map[string]*TrackerStatus)
+	tracker := func(name string) *TrackerStatus {
+		if trackers[name] == nil {
+			trackers[name] = &TrackerStatus{Name: name}
+		}
+		return trackers[name]
+	}
+	for _, state := range models.LoginStates() {
+		status := tracker(state.Tracker)
+		status.LoggedIn, status.LoginError = state.LoggedIn, state.Error
+	}
+	for name, checkTime := range qbittorrent.LastSuccessfulChecks() {
+		if name != "" {
+			tracker(name).LastSuccessfulCheck = &checkTime
+		}
+	}
+
+	status := ServiceStatus{
+		Version:       Version,
+		StartedAt:     startedAt,
+		UptimeSeconds: int(time.Since(startedAt).Seconds()),
+		Watchers:      qbittorrent.Watchers(),
+		QueueLength:   len(h.torrentData),
+		Trackers:      make([]TrackerStatus, 0, len(trackers)),
+	}
+	for _, t := range trackers {
+		status.Trackers = append(status.Trackers, *t)
+	}
+	sort.Slice(status.Trackers, func(i, j int) bool { return status.Trackers[i].Name < status.Trackers[j].Name })
+	return c.JSON(200, status)
+}
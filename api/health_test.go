@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/common"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	useTestRepository(t)
+	e := echo.New()
+	call := func(handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	if rec := call(Healthz); rec.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200, got %d", rec.Code)
+	}
+
+	// qBittorrent is not initialized in tests, so the service is not ready
+	rec := call(Readyz)
+	var readiness ReadinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &readiness); err != nil {
+		t.Fatalf("Invalid response %s: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || readiness.Status != DependencyFailed {
+		t.Errorf("Expected 503 without qBittorrent, got %d: %s", rec.Code, rec.Body)
+	}
+	results := make(map[string]string)
+	for _, check := range readiness.Checks {
+		results[check.Name] = check.Status
+	}
+	if results["database"] != DependencyOk || results["qbittorrent"] != DependencyFailed {
+		t.Errorf("Unexpected checks %+v", readiness.Checks)
+	}
+
+	queue := make(chan common.TorrentData, 5)
+	queue <- common.TorrentData{Url: "https://kinozal.tv/details.php?id=1"}
+	queue <- common.TorrentData{Url: "https://kinozal.tv/details.php?id=2"}
+	rec = call(NewApiHandler(queue).GetStatus)
+	var status ServiceStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid response %s: %v", rec.Body, err)
+	}
+	if status.Version != Version || status.QueueLength != 2 || status.StartedAt.IsZero() || status.Trackers == nil {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
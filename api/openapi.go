@@ -0,0 +1,17 @@
+package api
+
+import (
+	_ "embed"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPISpec is the OpenAPI 3 description of the REST API. The client package is generated from it.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// GetOpenAPISpec is a function for serving the OpenAPI document
+func GetOpenAPISpec(c echo.Context) error {
+	return c.Blob(200, echo.MIMEApplicationJSONCharsetUTF8, OpenAPISpec)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/jobs"
+	"kinozaltv_monitor/models"
+	"kinozaltv_monitor/qbittorrent"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestOpenAPISpec_MatchesTypes keeps the schemas of the document in sync with the JSON fields of the Go types
+func TestOpenAPISpec_MatchesTypes(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(OpenAPISpec, &doc); err != nil {
+		t.Fatalf("OpenAPI document is not valid JSON: %v", err)
+	}
+
+	types := map[string]interface{}{
+		"Torrent":               database.Torrent{},
+		"TorrentListItem":       TorrentListItem{},
+		"TorrentStatus":         common.TorrentStatus{},
+		"TorrentResource":       TorrentResource{},
+		"TorrentPage":           TorrentPage{},
+		"Replacement":           database.Replacement{},
+		"TorrentVersion":        database.TorrentVersion{},
+		"MetaFile":              models.MetaFile{},
+		"FileChange":            models.FileChange{},
+		"VersionDiff":           VersionDiff{},
+		"UpdateTorrentRequest":  UpdateTorrentRequest{},
+		"BulkFilter":            BulkFilter{},
+		"BulkRequest":           BulkRequest{},
+		"BulkItemResult":        BulkItemResult{},
+		"BulkResponse":          BulkResponse{},
+		"ConfirmRemoveRequest":  ConfirmRemoveRequest{},
+		"ConfirmRemoveResponse": ConfirmRemoveResponse{},
+		"AdoptionProposal":      qbittorrent.AdoptionProposal{},
+		"Adoption":              qbittorrent.Adoption{},
+		"AdoptionResult":        qbittorrent.AdoptionResult{},
+		"Category":              qbittorrent.Category{},
+		"AdoptRequest":          AdoptRequest{},
+		"AdoptResponse":         AdoptResponse{},
+		"AuditEntry":            database.AuditEntry{},
+		"TrackerStatus":         TrackerStatus{},
+		"ServiceStatus":         ServiceStatus{},
+		"Job":                   jobs.Job{},
+		"AddTorrentRequest":     AddTorrentRequest{},
+		"AddTorrentResponse":    AddTorrentResponse{},
+		"WatchRequest":          WatchRequest{},
+		"RemoveRequest":         RemoveRequest{},
+		"StatusResponse":        StatusResponse{},
+		"ErrorResponse":         ErrorResponse{},
+	}
+	for name, value := range types {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("Schema %s is missing", name)
+			continue
+		}
+		var properties []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+
+		fields := jsonFields(reflect.TypeOf(value))
+		sort.Strings(fields)
+
+		if !reflect.DeepEqual(properties, fields) {
+			t.Errorf("Schema %s has properties %v, the Go type has %v", name, properties, fields)
+		}
+	}
+}
+
+// jsonFields returns the JSON field names of a struct type, including those of embedded structs
+func jsonFields(valueType reflect.Type) []string {
+	var fields []string
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && tag == "" {
+			fields = append(fields, jsonFields(field.Type)...)
+		} else if tag != "" && tag != "-" {
+			fields = append(fields, tag)
+		}
+	}
+	return fields
+}
+
+func TestValidation(t *testing.T) {
+	handler := NewApiHandler(make(chan common.TorrentData, 1))
+
+	testCases := []struct {
+		name       string
+		handler    echo.HandlerFunc
+		body       string
+		wantFields []string
+	}{
+		{name: "Add without url", handler: handler.AddTorrentUrl, body: `{"watchEvery":-1}`, wantFields: []string{"url", "watchEvery"}},
+		{name: "Add unsupported url", handler: handler.AddTorrentUrl, body: `{"url":"https://example.com/"}`, wantFields: []string{"url"}},
+		{name: "Add invalid magnet link", handler: handler.AddTorrentUrl, body: `{"url":"magnet:?dn=no+hash"}`, wantFields: []string{"url"}},
+		{name: "Add watched magnet link", handler: handler.AddTorrentUrl, body: `{"url":"magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a","watchEvery":60}`, wantFields: []string{"watchEvery"}},
+		{name: "Adopt nothing", handler: AdoptTorrents, body: `{"adoptions":[]}`, wantFields: []string{"adoptions"}},
+		{name: "Adopt without topic", handler: AdoptTorrents, body: `{"adoptions":[{"hash":"abc","url":"https://example.com/","watch_every":-1}]}`, wantFields: []string{"adoptions[0].url", "adoptions[0].watch_every"}},
+		{name: "Watch with negative period", handler: handler.WatchTorrent, body: `{"url":"https://kinozal.tv/details.php?id=1","watchPeriod":-5}`, wantFields: []string{"watchPeriod"}},
+		{name: "Watch period as string", handler: handler.WatchTorrent, body: `{"url":"https://kinozal.tv/details.php?id=1","watchPeriod":"5"}`},
+		{name: "Remove without url", handler: RemoveTorrentUrl, body: `{"mode":"wipe"}`, wantFields: []string{"mode", "url"}},
+		{name: "Bulk without action and torrents", handler: BulkTorrents, body: `{"action":"explode"}`, wantFields: []string{"action", "ids"}},
+		{name: "Bulk set watch without period", handler: BulkTorrents, body: `{"action":"set_watch","ids":[1]}`, wantFields: []string{"watch_every"}},
+		{name: "Bulk file deletion by filter", handler: BulkTorrents, body: `{"action":"delete","delete_files":true,"filter":{}}`, wantFields: []string{"filter"}},
+		{name: "Bulk with ids and filter", handler: BulkTorrents, body: `{"action":"tag","ids":[1],"filter":{"status":"slow"}}`, wantFields: []string{"filter.status", "ids", "tags"}},
+	}
+
+	e := echo.New()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			if err := tc.handler(e.NewContext(req, rec)); err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body)
+			}
+
+			var response ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Invalid error response: %v", err)
+			}
+			var fields []string
+			for field := range response.Fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tc.wantFields) {
+				t.Errorf("Expected invalid fields %v, got %v (%s)", tc.wantFields, fields, rec.Body)
+			}
+		})
+	}
+}
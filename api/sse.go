@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// sseRetry is the reconnect delay suggested to EventSource clients in milliseconds
+const sseRetry = 3000
+
+// EventStream serves the live feed as Server-Sent Events for clients that cannot use WebSockets
+type EventStream struct {
+	feed      *Feed
+	queueSize int
+	policy    DropPolicy
+	heartbeat time.Duration
+}
+
+// NewEventStream creates a Server-Sent Events endpoint fed by the same feed as the WebSocket pool
+func NewEventStream(feed *Feed, queueSize int, policy DropPolicy, heartbeat time.Duration) *EventStream {
+	return &EventStream{
+		feed:      feed,
+		queueSize: queueSize,
+		policy:    policy,
+		heartbeat: heartbeat,
+	}
+}
+
+// HandleEvents streams protocol v2 envelopes. Clients resume with the Last-Event-ID
+// header (or ?since=) and may filter with ?types=a,b and repeated ?url= parameters.
+func (s *EventStream) HandleEvents(c echo.Context) error {
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("since")
+	}
+	var since uint64
+	if lastEventID != "" {
+		parsed, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			// Return 400 Bad Request
+			return c.JSON(400, map[string]string{"error": "Last-Event-ID must be a sequence number"})
+		}
+		since = parsed
+	}
+
+	flusher, ok := c.Response().Writer.(http.Flusher)
+	if !ok {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": "streaming is not supported"})
+	}
+
+	client := newFeedClient(s.queueSize, s.policy)
+	if user, ok := auth.CurrentUser(c); ok {
+		client.scope = userScope(user.ID)
+	}
+	var types, urls []string
+	if value := c.QueryParam("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+	for _, url := range c.QueryParams()["url"] {
+		urls = append(urls, common.CanonicalTorrentUrl(url))
+	}
+	client.subscribe(types, urls)
+
+	backlog, complete, seq := s.feed.attach(client, since)
+	defer s.feed.detach(client)
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set(echo.HeaderCacheControl, "no-cache")
+	header.Set(echo.HeaderConnection, "keep-alive")
+	// Disable response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+
+	log.Info("sse_client_connected", "New event stream client connected", map[string]string{
+		"since":             strconv.FormatUint(since, 10),
+		"total_connections": strconv.Itoa(s.feed.Clients()),
+	})
+
+	w := c.Response()
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry); err != nil {
+		return nil
+	}
+	if err := s.writeInitialState(w, since, seq, backlog, complete, client, currentState(c)); err != nil {
+		log.Error("Error sending initial state to event stream: ", err.Error(), nil)
+		return nil
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case env := <-client.queue:
+			if err := writeSSE(w, env); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			// Comments keep proxies from closing an idle stream
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case <-client.done:
+			return nil
+		case <-c.Request().Context().Done():
+			log.Info("sse_client_disconnected", "Event stream client disconnected", map[string]string{
+				"dropped_messages": strconv.FormatUint(client.dropped.Load(), 10),
+			})
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+// writeInitialState replays the missed messages or sends the current check state
+func (s *EventStream) writeInitialState(w *echo.Response, since, seq uint64, backlog []Envelope, complete bool, client *feedClient, state func() interface{}) error {
+	if complete {
+		replayed := 0
+		for _, env := range backlog {
+			if !client.wants(env) {
+				continue
+			}
+			if err := writeSSE(w, env); err != nil {
+				return err
+			}
+			replayed++
+		}
+		resumed, err := newReply("resumed", "", map[string]interface{}{"since": since, "replayed": replayed}, time.Now())
+		if err != nil {
+			return err
+		}
+		return writeSSE(w, resumed)
+	}
+
+	current, err := newReply("current_state", "", state(), time.Now())
+	if err != nil {
+		return err
+	}
+	current.Seq = seq
+	return writeSSE(w, current)
+}
+
+// writeSSE writes env as a single event. Only sequenced messages get an id, so
+// Last-Event-ID always points at a message that can be resumed from.
+func writeSSE(w *echo.Response, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if env.Seq > 0 {
+		fmt.Fprintf(&b, "id: %d\n", env.Seq)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", env.Type, data)
+	_, err = w.Write([]byte(b.String()))
+	return err
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readSSE reads the next event from r, skipping retry fields and comments
+func readSSE(t *testing.T, r *bufio.Reader) map[string]string {
+	event := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString() failed: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if _, ok := event["event"]; ok {
+				return event
+			}
+			event = make(map[string]string)
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			event["comment"] = line
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	feed := NewFeed(10)
+	useTestRepository(t)
+
+	e := echo.New()
+	e.GET("/api/events", NewEventStream(feed, 16, DropOldest, 50*time.Millisecond).HandleEvents)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	topic := "https://kinozal.tv/details.php?id=1"
+	feed.Broadcast(Envelope{Type: "check_update", Url: topic})
+	feed.Broadcast(Envelope{Type: "job_update"})
+	feed.Broadcast(Envelope{Type: "check_update", Url: "https://kinozal.tv/details.php?id=2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/events?types=check_update&url="+topic, nil)
+	req.Header.Set("Last-Event-ID", "0")
+
+	t.Run("Fresh client gets current state", func(t *testing.T) {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /api/events failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Expected text/event-stream, got %s", ct)
+		}
+
+		r := bufio.NewReader(resp.Body)
+		if event := readSSE(t, r); event["event"] != "current_state" || event["id"] != "3" {
+			t.Errorf("Expected current_state with id 3, got %v", event)
+		}
+
+		feed.Broadcast(Envelope{Type: "job_update"})
+		feed.Broadcast(Envelope{Type: "check_update", Url: topic})
+		if event := readSSE(t, r); event["event"] != "check_update" || event["id"] != "5" {
+			t.Errorf("Expected filtered check_update with id 5, got %v", event)
+		}
+	})
+
+	t.Run("Resume with Last-Event-ID", func(t *testing.T) {
+		resumeReq := req.Clone(ctx)
+		resumeReq.Header.Set("Last-Event-ID", "1")
+		resp, err := http.DefaultClient.Do(resumeReq)
+		if err != nil {
+			t.Fatalf("GET /api/events failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		r := bufio.NewReader(resp.Body)
+		if event := readSSE(t, r); event["id"] != "5" {
+			t.Errorf("Expected replay of id 5 only, got %v", event)
+		}
+		if event := readSSE(t, r); event["event"] != "resumed" || event["id"] != "" {
+			t.Errorf("Expected resumed event without id, got %v", event)
+		}
+
+		// Heartbeat comments arrive while the feed is idle
+		line, _ := r.ReadString('\n')
+		for line == "\n" {
+			line, _ = r.ReadString('\n')
+		}
+		if line != ": heartbeat\n" {
+			t.Errorf("Expected heartbeat comment, got %q", line)
+		}
+	})
+
+	t.Run("Invalid Last-Event-ID", func(t *testing.T) {
+		badReq := req.Clone(ctx)
+		badReq.Header.Set("Last-Event-ID", "abc")
+		resp, err := http.DefaultClient.Do(badReq)
+		if err != nil {
+			t.Fatalf("GET /api/events failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected 400, got %d", resp.StatusCode)
+		}
+	})
+}
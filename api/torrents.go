@@ -0,0 +1,531 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/qbittorrent"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Page sizes of the torrent list
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Check statuses of a torrent resource
+const (
+	CheckStatusOK     = "ok"
+	CheckStatusFailed = "failed"
+)
+
+// TorrentResource is a torrent as returned by the v1 API
+type TorrentResource struct {
+	ID            int        `json:"id"`
+	Title         string     `json:"title"`
+	Name          string     `json:"name"`
+	Hash          string     `json:"hash"`
+	Url           string     `json:"url"`
+	Tracker       string     `json:"tracker"`
+	WatchEvery    int        `json:"watch_every"`
+	DownloadPath  string     `json:"download_path"`
+	Tags          []string   `json:"tags"`
+	OwnerID       int        `json:"owner_id"`
+	LastCheckTime *time.Time `json:"last_check_time,omitempty"`
+	CheckStatus   string     `json:"check_status"`
+	// UpdateStrategy is how a new version replaces the torrent, one of database.UpdateStrategies
+	UpdateStrategy string `json:"update_strategy"`
+	// Category is the qBittorrent category set for the torrent, empty for the configured one
+	Category string `json:"category"`
+	// Replacement is the unfinished replacement of the torrent by its new version, if any
+	Replacement *database.Replacement `json:"replacement,omitempty"`
+	// External is set for torrents added by magnet link or .torrent file, they are never watched
+	External bool `json:"external"`
+	// Live is the status of the torrent in qBittorrent, left out when it is unavailable
+	Live *common.TorrentStatus `json:"live,omitempty"`
+}
+
+// TorrentPage is a page of the torrent list. NextCursor is empty on the last page.
+type TorrentPage struct {
+	Items      []TorrentResource `json:"items"`
+	Total      int               `json:"total"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// UpdateTorrentRequest is the body of PATCH /api/v1/torrents/{id}. Missing fields are left unchanged.
+type UpdateTorrentRequest struct {
+	WatchEvery   *int      `json:"watch_every"`
+	DownloadPath *string   `json:"download_path"`
+	Tags         *[]string `json:"tags"`
+	// UpdateStrategy is one of database.UpdateStrategies
+	UpdateStrategy *string `json:"update_strategy"`
+	// Category is the qBittorrent category, it is changed in qBittorrent as well
+	Category *string `json:"category"`
+}
+
+// Validate checks the request fields
+func (r *UpdateTorrentRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if r.WatchEvery != nil && *r.WatchEvery < 0 {
+		errs["watch_every"] = "must not be negative"
+	}
+	if r.UpdateStrategy != nil && !slices.Contains(database.UpdateStrategies, *r.UpdateStrategy) {
+		errs["update_strategy"] = "must be " + strings.Join(database.UpdateStrategies, ", ")
+	}
+	return errs
+}
+
+// newTorrentResource combines a torrent record with its latest check
+func newTorrentResource(t database.Torrent) TorrentResource {
+	resource := TorrentResource{
+		ID:             t.ID,
+		Title:          t.Title,
+		Name:           t.Name,
+		Hash:           t.Hash,
+		Url:            t.Url,
+		Tracker:        trackerName(t.Url),
+		WatchEvery:     t.WatchEvery,
+		DownloadPath:   t.DownloadPath,
+		Tags:           t.Tags,
+		OwnerID:        t.OwnerID,
+		CheckStatus:    CheckStatusOK,
+		UpdateStrategy: t.UpdateStrategy,
+		Category:       t.Category,
+		External:       common.IsExternalTorrentUrl(t.Url),
+	}
+	if resource.Tags == nil {
+		resource.Tags = []string{}
+	}
+	// Torrents that were not checked yet are assumed to be fine
+	if info, ok := qbittorrent.LastCheck(t.Url); ok {
+		checked := info.LastCheckTime
+		resource.LastCheckTime = &checked
+		if !info.LastCheckSuccess {
+			resource.CheckStatus = CheckStatusFailed
+		}
+	}
+	if replacement, ok := qbittorrent.PendingReplacement(t.Url); ok {
+		resource.Replacement = &replacement
+	}
+	return resource
+}
+
+// trackerName returns the tracker host of a topic url, e.g. kinozal.tv
+func trackerName(topicUrl string) string {
+	u, err := url.Parse(topicUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// torrentQuery is the parsed filter, order and page of a torrent list request
+type torrentQuery struct {
+	tracker string
+	watched *bool
+	status  string
+	search  string
+	sort    string
+	desc    bool
+	limit   int
+	after   *torrentCursor
+}
+
+// torrentCursor points at the last item of a page. It stores the sort key, so the
+// next page starts at the right place even when torrents were added or removed.
+type torrentCursor struct {
+	Sort string `json:"sort"`
+	Text string `json:"s,omitempty"`
+	Num  int64  `json:"n,omitempty"`
+	ID   int    `json:"id"`
+}
+
+// sortFields are the fields the torrent list can be ordered by
+var sortFields = map[string]bool{"id": true, "title": true, "watch_every": true, "last_check": true}
+
+// parseTorrentQuery reads ?tracker=, ?watched=, ?status=, ?q=, ?sort=, ?limit= and ?cursor=
+func parseTorrentQuery(c echo.Context) (torrentQuery, FieldErrors) {
+	errs := FieldErrors{}
+	query := torrentQuery{
+		tracker: strings.ToLower(strings.TrimSpace(c.QueryParam("tracker"))),
+		status:  c.QueryParam("status"),
+		search:  strings.ToLower(strings.TrimSpace(c.QueryParam("q"))),
+		sort:    "id",
+		limit:   defaultPageSize,
+	}
+
+	if value := c.QueryParam("watched"); value != "" {
+		watched, err := strconv.ParseBool(value)
+		if err != nil {
+			errs["watched"] = "must be true or false"
+		}
+		query.watched = &watched
+	}
+	if query.status != "" && query.status != CheckStatusOK && query.status != CheckStatusFailed {
+		errs["status"] = "must be ok or failed"
+	}
+	if value := c.QueryParam("sort"); value != "" {
+		query.sort, query.desc = strings.TrimPrefix(value, "-"), strings.HasPrefix(value, "-")
+		if !sortFields[query.sort] {
+			errs["sort"] = "must be id, title, watch_every or last_check, prefixed with - for descending order"
+		}
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(maxPageSize)
+		}
+		query.limit = limit
+	}
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil || cursor.Sort != query.sortParam() {
+			errs["cursor"] = "is invalid for this sort order"
+		}
+		query.after = &cursor
+	}
+	return query, errs
+}
+
+// sortParam returns the sort order as given in ?sort=
+func (q torrentQuery) sortParam() string {
+	if q.desc {
+		return "-" + q.sort
+	}
+	return q.sort
+}
+
+// matches reports whether t passes the filters
+func (q torrentQuery) matches(t TorrentResource) bool {
+	if q.tracker != "" && t.Tracker != q.tracker && !strings.HasPrefix(t.Tracker, q.tracker+".") {
+		return false
+	}
+	if q.watched != nil && (t.WatchEvery > 0) != *q.watched {
+		return false
+	}
+	if q.status != "" && t.CheckStatus != q.status {
+		return false
+	}
+	if q.search != "" && !strings.Contains(strings.ToLower(t.Title+" "+t.Name), q.search) {
+		return false
+	}
+	return true
+}
+
+// cursorFor returns the position of t in the sort order
+func (q torrentQuery) cursorFor(t TorrentResource) torrentCursor {
+	cursor := torrentCursor{Sort: q.sortParam(), ID: t.ID}
+	switch q.sort {
+	case "title":
+		cursor.Text = strings.ToLower(t.Title)
+	case "watch_every":
+		cursor.Num = int64(t.WatchEvery)
+	case "last_check":
+		if t.LastCheckTime != nil {
+			cursor.Num = t.LastCheckTime.UnixNano()
+		}
+	}
+	return cursor
+}
+
+// compare orders two positions by the sort key and then by id
+func (q torrentQuery) compare(a, b torrentCursor) int {
+	result := strings.Compare(a.Text, b.Text)
+	if result == 0 {
+		result = compareInts(a.Num, b.Num)
+	}
+	if result == 0 {
+		result = compareInts(int64(a.ID), int64(b.ID))
+	}
+	if q.desc {
+		return -result
+	}
+	return result
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// page filters and orders torrents and returns the page after the cursor
+func (q torrentQuery) page(torrents []TorrentResource) TorrentPage {
+	items := make([]TorrentResource, 0, len(torrents))
+	for _, t := range torrents {
+		if q.matches(t) {
+			items = append(items, t)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return q.compare(q.cursorFor(items[i]), q.cursorFor(items[j])) < 0
+	})
+
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			return q.compare(q.cursorFor(items[i]), *q.after) > 0
+		})
+	}
+	end := start + q.limit
+	if end > len(items) {
+		end = len(items)
+	}
+
+	page := TorrentPage{Items: items[start:end], Total: len(items)}
+	if end < len(items) {
+		page.NextCursor = encodeCursor(q.cursorFor(items[end-1]))
+	}
+	return page
+}
+
+// encodeCursor returns the opaque ?cursor= value of a position
+func encodeCursor(cursor torrentCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a ?cursor= value
+func decodeCursor(value string) (torrentCursor, error) {
+	var cursor torrentCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// ListTorrents is a function for getting a filtered, ordered page of the caller's torrents
+func ListTorrents(c echo.Context) error {
+	query, errs := parseTorrentQuery(c)
+	if len(errs) > 0 {
+		// Return 400 Bad Request
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: errs})
+	}
+
+	dbTorrents, err := callerRecords(c)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	torrents := make([]TorrentResource, 0, len(dbTorrents))
+	for _, t := range dbTorrents {
+		torrents = append(torrents, newTorrentResource(t))
+	}
+	page := query.page(torrents)
+	statuses := liveStatuses()
+	for i := range page.Items {
+		page.Items[i].Live = liveStatus(statuses, page.Items[i].Hash)
+	}
+	return c.JSON(200, page)
+}
+
+// callerTorrent returns the torrent of the :id parameter if it is on the caller's watch list.
+// When it is not, the error response is already written and ok is false.
+func callerTorrent(c echo.Context) (t database.Torrent, ok bool, err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		// Return 400 Bad Request
+		return t, false, c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"id": "must be a number"}})
+	}
+
+	t, err = database.Repo.GetRecordByID(id)
+	watching := err == nil
+	if err == nil {
+		watching, err = callerWatches(c, t.Url)
+	}
+	if err != nil && err != database.ErrNotFound {
+		// Return 500 Internal Server Error
+		return t, false, c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	if !watching {
+		// Return 404 Not Found
+		return t, false, c.JSON(404, ErrorResponse{Error: database.ErrNotFound.Error()})
+	}
+	return t, true, nil
+}
+
+// GetTorrent is a function for getting a single torrent by id
+func GetTorrent(c echo.Context) error {
+	t, ok, err := callerTorrent(c)
+	if !ok {
+		return err
+	}
+	resource := newTorrentResource(t)
+	resource.Live = liveStatus(liveStatuses(), t.Hash)
+	return c.JSON(200, resource)
+}
+
+// UpdateTorrent is a function for changing the watch period, download path, tags, update strategy or category of a torrent
+func UpdateTorrent(c echo.Context) error {
+	t, ok, err := callerTorrent(c)
+	if !ok {
+		return err
+	}
+	var request UpdateTorrentRequest
+	if ok, err := bindValid(c, &request); !ok {
+		return err
+	}
+	if request.WatchEvery != nil && *request.WatchEvery > 0 && common.IsExternalTorrentUrl(t.Url) {
+		// Return 400 Bad Request
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"watch_every": "must be 0, " + database.ErrExternalTorrent.Error()}})
+	}
+
+	// qBittorrent is changed first, so a failing call leaves the record as it was
+	if err := updateQbittorrentTorrent(t, request); err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	err = updateTorrentRecord(t, request)
+
+	// Audit the changes that were made, also when a later one failed
+	updated, readErr := database.Repo.GetRecordByID(t.ID)
+	before, after := torrentSettings(t), torrentSettings(updated)
+	if readErr == nil && (err == nil || !reflect.DeepEqual(before, after)) {
+		audit(c, database.AuditTorrentUpdate, t.Url, before, after)
+	}
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, newTorrentResource(updated))
+}
+
+// updateQbittorrentTorrent applies the download path, category and tags of request to
+// the torrent in qBittorrent. When a call fails the calls before it are undone.
+func updateQbittorrentTorrent(t database.Torrent, request UpdateTorrentRequest) error {
+	if t.Hash == "" {
+		return nil
+	}
+	var undo []func() error
+	var err error
+	// A change is undone also when its own call failed, it may have been applied in part
+	if category, ok := changedCategory(t, request); ok {
+		undo = append(undo, func() error { return getQbUser().SetCategory(t.Hash, t.Category) })
+		err = getQbUser().SetCategory(t.Hash, category)
+	}
+	if err == nil && request.Tags != nil {
+		tags := common.NormalizeTags(*request.Tags)
+		undo = append(undo, func() error { return pushTags(t.Hash, tags, t.Tags) })
+		err = pushTags(t.Hash, t.Tags, tags)
+	}
+	// Moving is the last call, it is the only one that can not be undone when no path was recorded
+	if err == nil && request.DownloadPath != nil && *request.DownloadPath != t.DownloadPath {
+		err = getQbUser().SetLocation(t.Hash, *request.DownloadPath)
+	}
+	if err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undoErr := undo[i](); undoErr != nil {
+				log.Error("update_torrent", "Error undoing qBittorrent change", map[string]string{"error": undoErr.Error(), "torrent_url": t.Url})
+			}
+		}
+	}
+	return err
+}
+
+// updateTorrentRecord stores the changes of request in the record of t, stopping at the first error
+func updateTorrentRecord(t database.Torrent, request UpdateTorrentRequest) error {
+	var err error
+	if request.WatchEvery != nil {
+		err = database.Repo.SetWatchFlag(t.Url, *request.WatchEvery)
+	}
+	if err == nil && request.DownloadPath != nil && *request.DownloadPath != t.DownloadPath {
+		err = database.Repo.SetDownloadPath(t.Url, *request.DownloadPath)
+	}
+	if err == nil && request.Tags != nil {
+		err = database.Repo.SetTags(t.Url, common.NormalizeTags(*request.Tags))
+	}
+	if err == nil && request.UpdateStrategy != nil {
+		err = database.Repo.SetUpdateStrategy(t.Url, *request.UpdateStrategy)
+	}
+	if category, ok := changedCategory(t, request); err == nil && ok {
+		err = database.Repo.SetCategory(t.Url, category)
+	}
+	return err
+}
+
+// changedCategory returns the category request sets, ok is false when it keeps the category of t
+func changedCategory(t database.Torrent, request UpdateTorrentRequest) (category string, ok bool) {
+	if request.Category == nil {
+		return "", false
+	}
+	category = strings.TrimSpace(*request.Category)
+	return category, category != t.Category
+}
+
+// setTags changes the tags of a torrent in qBittorrent and then in the database
+func setTags(t database.Torrent, tags []string) error {
+	tags = common.NormalizeTags(tags)
+	if t.Hash != "" {
+		if err := pushTags(t.Hash, t.Tags, tags); err != nil {
+			return err
+		}
+	}
+	return database.Repo.SetTags(t.Url, tags)
+}
+
+// pushTags changes the tags of the torrent with hash in qBittorrent from oldTags to newTags
+func pushTags(hash string, oldTags, newTags []string) error {
+	if added := missingTags(newTags, oldTags); len(added) > 0 {
+		if err := getQbUser().AddTags(hash, added); err != nil {
+			return err
+		}
+	}
+	if removed := missingTags(oldTags, newTags); len(removed) > 0 {
+		return getQbUser().RemoveTags(hash, removed)
+	}
+	return nil
+}
+
+// missingTags returns the tags of a that b does not have
+func missingTags(a, b []string) []string {
+	has := make(map[string]bool, len(b))
+	for _, tag := range b {
+		has[tag] = true
+	}
+	var missing []string
+	for _, tag := range a {
+		if !has[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// torrentSettings are the user editable fields of a torrent as recorded in the audit log
+func torrentSettings(t database.Torrent) map[string]interface{} {
+	return map[string]interface{}{"watch_every": t.WatchEvery, "download_path": t.DownloadPath, "tags": t.Tags, "update_strategy": t.UpdateStrategy,
+		"category": t.Category}
+}
+
+// DeleteTorrent is a function for removing a torrent by id with ?mode= and ?confirm_token=, see RemoveTorrentUrl
+func DeleteTorrent(c echo.Context) error {
+	mode := c.QueryParam("mode")
+	if !validRemoveMode(mode) {
+		// Return 400 Bad Request
+		return c.JSON(400, ErrorResponse{Error: "validation failed", Fields: FieldErrors{"mode": "must be untrack, keep_files or delete_files"}})
+	}
+	t, ok, err := callerTorrent(c)
+	if !ok {
+		return err
+	}
+	return removeTorrent(c, t.Url, t.Hash, mode, c.QueryParam("confirm_token"))
+}
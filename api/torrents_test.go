@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"kinozaltv_monitor/qbittorrent"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeQbittorrent is a qBittorrent server that records the forms posted to it
+type fakeQbittorrent struct {
+	mu    sync.Mutex
+	posts map[string][]url.Values
+	// failing lists the endpoints that answer with an error
+	failing map[string]bool
+}
+
+// useFakeQbittorrent points the qBittorrent client at a fake server
+func useFakeQbittorrent(t *testing.T) *fakeQbittorrent {
+	fake := &fakeQbittorrent{posts: make(map[string][]url.Values), failing: make(map[string]bool)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+		if r.Method == http.MethodPost {
+			_ = r.ParseForm()
+			fake.mu.Lock()
+			fake.posts[endpoint] = append(fake.posts[endpoint], r.PostForm)
+			fake.mu.Unlock()
+		}
+		if fake.failing[endpoint] {
+			http.Error(w, "Fails", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("Ok."))
+	}))
+	savedUrl, savedManager := config.GlobalConfig.QBUrl, qbittorrent.GlobalManager
+	config.GlobalConfig.QBUrl = server.URL
+	jar, _ := cookiejar.New(nil)
+	qbittorrent.GlobalManager = &qbittorrent.Manager{User: &qbittorrent.QbittorrentUser{Client: &http.Client{Jar: jar}}}
+	t.Cleanup(func() {
+		server.Close()
+		config.GlobalConfig.QBUrl, qbittorrent.GlobalManager = savedUrl, savedManager
+	})
+	return fake
+}
+
+// forms returns the forms posted to endpoint, e.g. "torrents/addTags"
+func (f *fakeQbittorrent) forms(endpoint string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.posts[endpoint]
+}
+
+func TestTorrentQuery_Page(t *testing.T) {
+	torrents := []TorrentResource{
+		{ID: 1, Title: "Бэтмен", Tracker: "kinozal.tv", WatchEvery: 60, CheckStatus: CheckStatusOK},
+		{ID: 2, Title: "Alien", Tracker: "rutracker.org", WatchEvery: 0, CheckStatus: CheckStatusFailed},
+		{ID: 3, Title: "avatar", Tracker: "kinozal.tv", WatchEvery: 30, CheckStatus: CheckStatusOK},
+		{ID: 4, Title: "Бэтмен возвращается", Tracker: "kinozal.me", WatchEvery: 30, CheckStatus: CheckStatusOK},
+	}
+
+	testCases := []struct {
+		name  string
+		query string
+		want  []int
+		total int
+	}{
+		{name: "Default order", query: "", want: []int{1, 2, 3, 4}, total: 4},
+		{name: "Tracker", query: "tracker=kinozal", want: []int{1, 3, 4}, total: 3},
+		{name: "Exact tracker", query: "tracker=kinozal.tv", want: []int{1, 3}, total: 2},
+		{name: "Not watched", query: "watched=false", want: []int{2}, total: 1},
+		{name: "Failed checks", query: "status=failed", want: []int{2}, total: 1},
+		{name: "Case-insensitive search", query: "q=БЭТМЕН", want: []int{1, 4}, total: 2},
+		{name: "By title", query: "sort=title", want: []int{2, 3, 1, 4}, total: 4},
+		{name: "By watch period descending", query: "sort=-watch_every", want: []int{1, 4, 3, 2}, total: 4},
+		{name: "Limit", query: "sort=-id&limit=2", want: []int{4, 3}, total: 4},
+	}
+
+	e := echo.New()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+tc.query, nil)
+			query, errs := parseTorrentQuery(e.NewContext(req, httptest.NewRecorder()))
+			if len(errs) > 0 {
+				t.Fatalf("parseTorrentQuery() failed: %v", errs)
+			}
+			page := query.page(torrents)
+			if ids := torrentIDs(page.Items); !reflect.DeepEqual(ids, tc.want) || page.Total != tc.total {
+				t.Errorf("Expected %v of %d, got %v of %d", tc.want, tc.total, ids, page.Total)
+			}
+		})
+	}
+
+	t.Run("Cursor", func(t *testing.T) {
+		var ids []int
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			req := httptest.NewRequest(http.MethodGet, "/?sort=watch_every&limit=1&cursor="+url.QueryEscape(cursor), nil)
+			query, errs := parseTorrentQuery(e.NewContext(req, httptest.NewRecorder()))
+			if len(errs) > 0 {
+				t.Fatalf("parseTorrentQuery() failed: %v", errs)
+			}
+			page := query.page(torrents)
+			ids = append(ids, torrentIDs(page.Items)...)
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if want := []int{2, 3, 4, 1}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("Expected pages %v, got %v", want, ids)
+		}
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		cursor := encodeCursor(torrentCursor{Sort: "title", ID: 1})
+		req := httptest.NewRequest(http.MethodGet, "/?watched=maybe&status=unknown&sort=size&limit=0&cursor="+cursor, nil)
+		_, errs := parseTorrentQuery(e.NewContext(req, httptest.NewRecorder()))
+		for _, field := range []string{"watched", "status", "sort", "limit", "cursor"} {
+			if errs[field] == "" {
+				t.Errorf("Expected %s to be invalid, got %v", field, errs)
+			}
+		}
+	})
+}
+
+func TestTorrentResourceEndpoints(t *testing.T) {
+	useTestRepository(t)
+	qb := useFakeQbittorrent(t)
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	bob, err := database.Repo.CreateUser(database.User{Username: "bob", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	topicUrl := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Title", Name: "Name", Hash: "hash", Url: topicUrl}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+	if err := database.Repo.AddWatcher(alice.ID, topicUrl); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+	torrent, err := database.Repo.GetRecordByUrl(topicUrl)
+	if err != nil {
+		t.Fatalf("GetRecordByUrl() failed: %v", err)
+	}
+	id := strconv.Itoa(torrent.ID)
+
+	e := echo.New()
+	call := func(handler echo.HandlerFunc, user database.User, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		auth.SetUser(c, user)
+		if err := handler(c); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	if rec := call(GetTorrent, alice, http.MethodGet, id, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the watcher to get the torrent, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := call(GetTorrent, bob, http.MethodGet, id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's torrent, got %d", rec.Code)
+	}
+	if rec := call(GetTorrent, alice, http.MethodGet, "x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-numeric id, got %d", rec.Code)
+	}
+	if rec := call(DeleteTorrent, bob, http.MethodDelete, id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when deleting another user's torrent, got %d", rec.Code)
+	}
+
+	rec := call(UpdateTorrent, alice, http.MethodPatch, id, `{"watch_every":15,"tags":["serial"," serial "],"update_strategy":"new_files_only"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resource TorrentResource
+	if err := json.Unmarshal(rec.Body.Bytes(), &resource); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if resource.WatchEvery != 15 || len(resource.Tags) != 1 || resource.Tracker != "kinozal.tv" || resource.UpdateStrategy != database.UpdateNewFilesOnly {
+		t.Errorf("Unexpected torrent after update: %+v", resource)
+	}
+	if forms := qb.forms("torrents/addTags"); len(forms) != 1 || forms[0].Get("hashes") != "hash" || forms[0].Get("tags") != "serial" {
+		t.Errorf("Expected the tag to be added in qBittorrent, got %v", forms)
+	}
+	if rec := call(UpdateTorrent, alice, http.MethodPatch, id, `{"tags":["finished"]}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if forms := qb.forms("torrents/removeTags"); len(forms) != 1 || forms[0].Get("tags") != "serial" {
+		t.Errorf("Expected the old tag to be removed in qBittorrent, got %v", forms)
+	}
+	if rec := call(UpdateTorrent, alice, http.MethodPatch, id, `{"watch_every":-1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative watch period, got %d", rec.Code)
+	}
+	if rec := call(UpdateTorrent, alice, http.MethodPatch, id, `{"update_strategy":"overwrite"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown update strategy, got %d", rec.Code)
+	}
+}
+
+func TestUpdateTorrent_QbittorrentFails(t *testing.T) {
+	useTestRepository(t)
+	qb := useFakeQbittorrent(t)
+	qb.failing["torrents/setLocation"] = true
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	topicUrl := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Title", Name: "Name", Hash: "hash", Url: topicUrl}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+	if err := database.Repo.AddWatcher(alice.ID, topicUrl); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+	before, err := database.Repo.GetRecordByUrl(topicUrl)
+	if err != nil {
+		t.Fatalf("GetRecordByUrl() failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"watch_every":30,"category":"series","download_path":"/downloads/series"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(before.ID))
+	auth.SetUser(c, alice)
+	if err := UpdateTorrent(c); err != nil {
+		t.Fatalf("UpdateTorrent() failed: %v", err)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if forms := qb.forms("torrents/setCategory"); len(forms) != 2 || forms[1].Get("category") != "" {
+		t.Errorf("Expected the category to be changed back in qBittorrent, got %v", forms)
+	}
+	if after, _ := database.Repo.GetRecordByUrl(topicUrl); !reflect.DeepEqual(after, before) {
+		t.Errorf("Expected the record to stay unchanged, got %+v", after)
+	}
+	if entries, _ := database.Repo.GetAudit(database.AuditFilter{Action: database.AuditTorrentUpdate, Limit: 10}); len(entries) != 0 {
+		t.Errorf("Expected no audit entry for an update that was not made, got %+v", entries)
+	}
+}
+
+func torrentIDs(torrents []TorrentResource) []int {
+	ids := make([]int, 0, len(torrents))
+	for _, t := range torrents {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
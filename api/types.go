@@ -0,0 +1,167 @@
+package api
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/jobs"
+	"kinozaltv_monitor/models"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AddTorrentRequest is the body of POST /api/add, JSON or a multipart form. Url is a
+// tracker topic or a magnet link; a multipart form may carry a .torrent file in the
+// "torrent" field instead. Magnet links and .torrent files are added as external torrents.
+type AddTorrentRequest struct {
+	Url          string   `json:"url" form:"url"`
+	DownloadPath string   `json:"downloadPath" form:"downloadPath"`
+	WatchEvery   int      `json:"watchEvery" form:"watchEvery"`
+	Tags         []string `json:"tags" form:"tags"`
+	// Category is the qBittorrent category, empty to use the configured one
+	Category string `json:"category" form:"category"`
+	// Torrent is the uploaded .torrent file, read before binding
+	Torrent []byte `json:"-"`
+
+	// hash and title of an external torrent, set by Validate
+	hash, title string
+}
+
+// Validate checks the request fields
+func (r *AddTorrentRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	r.Url = strings.TrimSpace(r.Url)
+	switch {
+	case r.Torrent != nil && r.Url != "":
+		errs["url"] = "must not be combined with a .torrent file"
+	case r.Torrent != nil:
+		meta, err := models.ParseTorrent(r.Torrent)
+		if err != nil {
+			errs["torrent"] = "is not a valid .torrent file"
+			break
+		}
+		r.hash, r.title = meta.Hash(), meta.Name
+	case isMagnetLink(r.Url):
+		link, err := models.ParseMagnet(r.Url)
+		if err != nil {
+			errs["url"] = "is not a valid magnet link"
+		}
+		r.hash, r.title = link.Hash, link.Name
+	case r.Url == "":
+		errs["url"] = "is required"
+	case !common.IsSupportedTrackerUrl(r.Url):
+		errs["url"] = "is not a supported tracker url"
+	}
+	if r.WatchEvery < 0 {
+		errs["watchEvery"] = "must not be negative"
+	}
+	if r.WatchEvery > 0 && r.External() {
+		errs["watchEvery"] = "must be 0, " + database.ErrExternalTorrent.Error()
+	}
+	return errs
+}
+
+// External reports whether the request adds an external torrent rather than a tracker topic
+func (r *AddTorrentRequest) External() bool {
+	return r.Torrent != nil || isMagnetLink(r.Url)
+}
+
+// isMagnetLink reports whether s is a magnet URI
+func isMagnetLink(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), "magnet:")
+}
+
+// AddTorrentResponse is the reply of POST /api/add. A queued torrent comes with
+// its job, a torrent another user already tracks is only put on the watch list.
+type AddTorrentResponse struct {
+	Status string    `json:"status"`
+	Url    string    `json:"url,omitempty"`
+	JobID  string    `json:"job_id,omitempty"`
+	Job    *jobs.Job `json:"job,omitempty"`
+}
+
+// WatchRequest is the body of POST /api/watch
+type WatchRequest struct {
+	Url         string `json:"url"`
+	WatchPeriod int    `json:"watchPeriod"`
+}
+
+// Validate checks the request fields
+func (r *WatchRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if strings.TrimSpace(r.Url) == "" {
+		errs["url"] = "is required"
+	}
+	if r.WatchPeriod < 0 {
+		errs["watchPeriod"] = "must not be negative"
+	}
+	return errs
+}
+
+// Remove modes. untrack only stops monitoring, keep_files also removes the torrent from
+// qBittorrent and delete_files removes it together with the downloaded files.
+const (
+	RemoveUntrack     = "untrack"
+	RemoveKeepFiles   = "keep_files"
+	RemoveDeleteFiles = "delete_files"
+)
+
+// validRemoveMode reports whether mode is a supported remove mode, empty means keep_files
+func validRemoveMode(mode string) bool {
+	return mode == "" || mode == RemoveUntrack || mode == RemoveKeepFiles || mode == RemoveDeleteFiles
+}
+
+// RemoveRequest is the body of DELETE /api/remove. Deleting files needs a confirm_token
+// from POST /api/remove/confirm.
+type RemoveRequest struct {
+	Url          string `json:"url"`
+	Hash         string `json:"hash"`
+	Mode         string `json:"mode"`
+	ConfirmToken string `json:"confirm_token"`
+}
+
+// Validate checks the request fields
+func (r *RemoveRequest) Validate() FieldErrors {
+	errs := FieldErrors{}
+	if strings.TrimSpace(r.Url) == "" {
+		errs["url"] = "is required"
+	}
+	if !validRemoveMode(r.Mode) {
+		errs["mode"] = "must be untrack, keep_files or delete_files"
+	}
+	return errs
+}
+
+// StatusResponse is the reply of requests that only report success
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ErrorResponse is the reply of a failed request. Fields names every invalid request field.
+type ErrorResponse struct {
+	Error  string      `json:"error"`
+	Fields FieldErrors `json:"fields,omitempty"`
+	Url    string      `json:"url,omitempty"`
+}
+
+// FieldErrors maps request field names to what is wrong with them
+type FieldErrors map[string]string
+
+// validator is a request body that can check its own fields
+type validator interface {
+	Validate() FieldErrors
+}
+
+// bindValid binds the request body to req and validates it. When that fails the
+// 400 response is already written and ok is false.
+func bindValid(c echo.Context, req validator) (ok bool, err error) {
+	if err := c.Bind(req); err != nil {
+		// If there's any error return 400 Bad Request
+		return false, c.JSON(400, ErrorResponse{Error: "invalid request body"})
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		// Return 400 Bad Request
+		return false, c.JSON(400, ErrorResponse{Error: "validation failed", Fields: errs})
+	}
+	return true, nil
+}
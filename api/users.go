@@ -0,0 +1,187 @@
+package api
+
+import (
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// showAll reports whether the caller asked for every user's data and may see it.
+// Without auth there is no caller and everything is shown.
+func showAll(c echo.Context) bool {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		return true
+	}
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+	return all && user.Role == database.RoleAdmin
+}
+
+// callerRecords returns the torrent records on the caller's watch list
+func callerRecords(c echo.Context) ([]database.Torrent, error) {
+	if showAll(c) {
+		return database.Repo.GetAllRecords()
+	}
+	user, _ := auth.CurrentUser(c)
+	return database.Repo.GetRecordsForUser(user.ID)
+}
+
+// callerWatches reports whether url is on the caller's watch list
+func callerWatches(c echo.Context, url string) (bool, error) {
+	user, ok := auth.CurrentUser(c)
+	if !ok || user.Role == database.RoleAdmin {
+		return true, nil
+	}
+	return database.Repo.IsWatching(user.ID, url)
+}
+
+// callerID returns the ID of the signed in user, 0 when auth is disabled
+func callerID(c echo.Context) int {
+	user, _ := auth.CurrentUser(c)
+	return user.ID
+}
+
+// GetUsers is a function for listing all user accounts
+func GetUsers(c echo.Context) error {
+	users, err := database.Repo.ListUsers()
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, users)
+}
+
+// CreateUser is a function for creating a user account
+func CreateUser(c echo.Context) error {
+	var request struct {
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		Role           string `json:"role"`
+		TelegramChatID string `json:"telegram_chat_id"`
+		DownloadPath   string `json:"download_path"`
+	}
+	if err := c.Bind(&request); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+	if request.Username == "" {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "username is empty"})
+	}
+	if request.Role == "" {
+		request.Role = database.RoleUser
+	}
+	if !validRole(request.Role) {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "unsupported role " + strconv.Quote(request.Role)})
+	}
+
+	hash, err := auth.HashPassword(request.Password)
+	if err == auth.ErrPasswordTooShort {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	user, err := database.Repo.CreateUser(database.User{
+		Username:       request.Username,
+		PasswordHash:   hash,
+		Role:           request.Role,
+		TelegramChatID: request.TelegramChatID,
+		DownloadPath:   request.DownloadPath,
+	})
+	if err == database.ErrUserExists {
+		// Return 409 Conflict
+		return c.JSON(409, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditUserCreate, userTarget(user), nil, user)
+	return c.JSON(201, user)
+}
+
+// UpdateUser is a function for changing the role and notification settings of a user
+func UpdateUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "invalid user id"})
+	}
+	user, err := database.Repo.GetUserByID(id)
+	if err == database.ErrUserNotFound {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	// Fields missing from the request keep their values
+	before := user
+	if err := c.Bind(&user); err != nil {
+		// If there's any error return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "Bad Request"})
+	}
+	user.ID = id
+	if !validRole(user.Role) {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "unsupported role " + strconv.Quote(user.Role)})
+	}
+	if caller, ok := auth.CurrentUser(c); ok && caller.ID == id && user.Role != database.RoleAdmin {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "admins cannot demote themselves"})
+	}
+
+	if err := database.Repo.UpdateUser(user); err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditUserUpdate, userTarget(user), before, user)
+	return c.JSON(200, user)
+}
+
+// DeleteUser is a function for removing a user account with its watch list
+func DeleteUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "invalid user id"})
+	}
+	if caller, ok := auth.CurrentUser(c); ok && caller.ID == id {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": "admins cannot delete themselves"})
+	}
+
+	user, err := database.Repo.GetUserByID(id)
+	if err == nil {
+		err = database.Repo.DeleteUser(id)
+	}
+	if err == database.ErrUserNotFound {
+		// Return 404 Not Found
+		return c.JSON(404, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	audit(c, database.AuditUserDelete, userTarget(user), user, nil)
+	return c.JSON(200, map[string]string{"status": "ok"})
+}
+
+// userTarget names a user account in the audit log
+func userTarget(user database.User) string {
+	return "user:" + user.Username
+}
+
+// validRole reports whether role is a known user role
+func validRole(role string) bool {
+	return role == database.RoleAdmin || role == database.RoleUser
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// VersionDiff lists the files added, removed and changed from one version of a torrent to another
+type VersionDiff struct {
+	From    string              `json:"from"`
+	To      string              `json:"to"`
+	Added   []models.MetaFile   `json:"added"`
+	Removed []models.MetaFile   `json:"removed"`
+	Changed []models.FileChange `json:"changed"`
+}
+
+// GetTorrentVersions is a function for getting the recorded versions of a torrent, newest first
+func GetTorrentVersions(c echo.Context) error {
+	t, ok, err := callerTorrent(c)
+	if !ok {
+		return err
+	}
+	versions, err := database.Repo.GetTorrentVersions(t.Url)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(200, versions)
+}
+
+// DiffTorrentVersions is a function for comparing the files of two versions of a torrent
+// given by hash with ?from= and ?to=. By default the newest version is compared with the one before it.
+func DiffTorrentVersions(c echo.Context) error {
+	t, ok, err := callerTorrent(c)
+	if !ok {
+		return err
+	}
+	versions, err := database.Repo.GetTorrentVersions(t.Url)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	from, to := c.QueryParam("from"), c.QueryParam("to")
+	if to == "" && len(versions) > 0 {
+		to = versions[0].Hash
+	}
+	if from == "" && len(versions) > 1 {
+		from = versions[1].Hash
+	}
+	fromVersion, fromFound := findVersion(versions, from)
+	toVersion, toFound := findVersion(versions, to)
+	if !fromFound || !toFound {
+		// Return 404 Not Found
+		return c.JSON(404, ErrorResponse{Error: "torrent version not found"})
+	}
+
+	diff := models.DiffFiles(fromVersion.Files, toVersion.Files)
+	return c.JSON(200, VersionDiff{From: from, To: to, Added: diff.Added, Removed: diff.Removed, Changed: diff.Changed})
+}
+
+// findVersion returns the version with the given hash
+func findVersion(versions []database.TorrentVersion, hash string) (database.TorrentVersion, bool) {
+	for _, version := range versions {
+		if hash != "" && version.Hash == hash {
+			return version, true
+		}
+	}
+	return database.TorrentVersion{}, false
+}
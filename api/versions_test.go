@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestTorrentVersions(t *testing.T) {
+	useTestRepository(t)
+	alice, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	topicUrl := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Title", Hash: "v3", Url: topicUrl}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+	if err := database.Repo.AddWatcher(alice.ID, topicUrl); err != nil {
+		t.Fatalf("AddWatcher() failed: %v", err)
+	}
+	torrent, _ := database.Repo.GetRecordByUrl(topicUrl)
+
+	versions := []database.TorrentVersion{
+		{Url: topicUrl, Hash: "v1", Files: []models.MetaFile{{Path: "e01.mkv", Size: 100}}},
+		{Url: topicUrl, Hash: "v2", Files: []models.MetaFile{{Path: "e01.mkv", Size: 100}, {Path: "e02.mkv", Size: 200}}},
+		{Url: topicUrl, Hash: "v3", Files: []models.MetaFile{{Path: "e01.mkv", Size: 150}, {Path: "e02.mkv", Size: 200}, {Path: "e03.mkv", Size: 300}}},
+	}
+	for _, version := range versions {
+		if err := database.Repo.AddTorrentVersion(version); err != nil {
+			t.Fatalf("AddTorrentVersion() failed: %v", err)
+		}
+	}
+
+	e := echo.New()
+	call := func(handler echo.HandlerFunc, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?"+query, nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(torrent.ID))
+		auth.SetUser(c, alice)
+		if err := handler(c); err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return rec
+	}
+
+	var listed []database.TorrentVersion
+	rec := call(GetTorrentVersions, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed) != 3 || listed[0].Hash != "v3" {
+		t.Errorf("Expected 3 versions newest first, got %s", rec.Body)
+	}
+
+	// By default the newest version is compared with the one before it
+	var diff VersionDiff
+	rec = call(DiffTorrentVersions, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("Invalid response: %s", rec.Body)
+	}
+	want := VersionDiff{
+		From:    "v2",
+		To:      "v3",
+		Added:   []models.MetaFile{{Path: "e03.mkv", Size: 300}},
+		Removed: []models.MetaFile{},
+		Changed: []models.FileChange{{Path: "e01.mkv", OldSize: 100, NewSize: 150}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Expected %+v, got %+v", want, diff)
+	}
+
+	rec = call(DiffTorrentVersions, "from=v3&to=v1")
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil || len(diff.Removed) != 2 || len(diff.Added) != 0 {
+		t.Errorf("Expected 2 removed files from v3 to v1, got %s", rec.Body)
+	}
+	if rec := call(DiffTorrentVersions, "from=unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown version, got %d", rec.Code)
+	}
+}
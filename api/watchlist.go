@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/watchlist"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ExportWatchList is a function for downloading the watch list as JSON, CSV or OPML
+func ExportWatchList(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = watchlist.FormatJSON
+	}
+
+	dbTorrents, err := callerRecords(c)
+	if err != nil {
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	var buf bytes.Buffer
+	if err := watchlist.Encode(&buf, format, watchlist.FromRecords(dbTorrents)); err != nil {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	fileName := "kinozal_monitor_" + time.Now().Format("20060102_150405") + "." + format
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+fileName+`"`)
+	return c.Blob(200, watchlist.ContentType(format), buf.Bytes())
+}
+
+// ImportWatchList is a function for importing a watch list exported by ExportWatchList.
+// The file is sent as the request body or as the "file" field of a multipart form.
+func (h *ApiHandler) ImportWatchList(c echo.Context) error {
+	var body io.Reader = c.Request().Body
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		file, err := fileHeader.Open()
+		if err != nil {
+			// Return 400 Bad Request
+			return c.JSON(400, map[string]string{"error": err.Error()})
+		}
+		defer func() { _ = file.Close() }()
+		body = file
+		contentType = fileHeader.Header.Get(echo.HeaderContentType)
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = watchlist.FormatFromContentType(contentType)
+	}
+
+	entries, err := watchlist.Decode(body, format)
+	if err != nil {
+		// Return 400 Bad Request
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	opts := watchlist.Options{DryRun: dryRun, Conflict: c.QueryParam("conflict"), UserID: callerID(c)}
+
+	report, err := watchlist.Import(database.Repo, entries, opts, func(torrentData common.TorrentData) string {
+		return h.queueTorrent(torrentData).ID
+	})
+	if err != nil {
+		if errors.Is(err, watchlist.ErrUnsupportedConflict) {
+			// Return 400 Bad Request
+			return c.JSON(400, map[string]string{"error": err.Error()})
+		}
+		// Return 500 Internal Server Error
+		return c.JSON(500, map[string]string{"error": err.Error()})
+	}
+
+	if !report.DryRun {
+		audit(c, database.AuditWatchlistImport, "", nil, map[string]interface{}{"conflict": report.Conflict, "summary": report.Summary})
+	}
+	return c.JSON(200, report)
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/common"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// maxClientMessageSize limits messages sent by clients
+	maxClientMessageSize = 4096
+)
+
+// AllowedOrigins lists the browser origins besides the server itself that may open a WebSocket
+var AllowedOrigins []string
+
+var (
+	upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return auth.OriginAllowed(r, AllowedOrigins)
+		},
+	}
+)
+
+// ClientMessage is a request sent by a protocol v2 client
+type ClientMessage struct {
+	Type       string   `json:"type"`
+	EventTypes []string `json:"event_types"`
+	Urls       []string `json:"urls"`
+}
+
+// Subscriptions is the reply to subscribe and unsubscribe messages
+type Subscriptions struct {
+	EventTypes []string `json:"event_types"`
+	Urls       []string `json:"urls"`
+}
+
+// MsgPool serves the live feed over WebSocket. Every connection has its own
+// writer goroutine and bounded send queue, so a slow client only delays itself.
+type MsgPool struct {
+	feed         *Feed
+	queueSize    int
+	policy       DropPolicy
+	pingInterval time.Duration
+	connections  atomic.Int64
+	handlers     sync.WaitGroup
+}
+
+// NewMsgPool creates a pool serving feed with the given per-connection queue size,
+// drop policy and keepalive interval
+func NewMsgPool(feed *Feed, queueSize int, policy DropPolicy, pingInterval time.Duration) *MsgPool {
+	return &MsgPool{
+		feed:         feed,
+		queueSize:    queueSize,
+		policy:       policy,
+		pingInterval: pingInterval,
+	}
+}
+
+// HandleWsConnections serves a WebSocket client. Clients connecting with ?v=2 get
+// versioned envelopes, may manage subscriptions and may resume with ?since=<seq>.
+// Other clients get the unversioned messages of the first protocol version.
+func (pool *MsgPool) HandleWsConnections(c echo.Context) error {
+	pool.handlers.Add(1)
+	defer pool.handlers.Done()
+
+	version := 1
+	if c.QueryParam("v") == strconv.Itoa(ProtocolVersion) {
+		version = ProtocolVersion
+	}
+	var since uint64
+	if version == ProtocolVersion && c.QueryParam("since") != "" {
+		parsed, err := strconv.ParseUint(c.QueryParam("since"), 10, 64)
+		if err != nil {
+			// Return 400 Bad Request
+			return c.JSON(400, map[string]string{"error": "since must be a sequence number"})
+		}
+		since = parsed
+	}
+
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := newFeedClient(pool.queueSize, pool.policy)
+	user, signedIn := auth.CurrentUser(c)
+	if signedIn {
+		client.scope = userScope(user.ID)
+	}
+	backlog, complete, seq := pool.feed.attach(client, since)
+	pool.connections.Add(1)
+	defer pool.connections.Add(-1)
+
+	log.Info("websocket_client_connected", "New WebSocket client connected", map[string]string{
+		"protocol_version":  strconv.Itoa(version),
+		"since":             strconv.FormatUint(since, 10),
+		"total_connections": strconv.Itoa(pool.feed.Clients()),
+	})
+
+	// Bring the client up to date before live messages are written
+	if err := pool.sendInitialState(ws, client, version, since, seq, backlog, complete, currentState(c)); err != nil {
+		log.Error("Error sending initial state to new connection: ", err.Error(), nil)
+		pool.feed.detach(client)
+		_ = ws.Close()
+		return nil
+	}
+
+	writerDone := make(chan struct{})
+	go func() {
+		pool.writeLoop(ws, client, version)
+		close(writerDone)
+	}()
+
+	pool.readLoop(ws, client, version)
+	pool.feed.detach(client)
+	<-writerDone
+
+	log.Info("websocket_client_disconnected", "WebSocket client disconnected", map[string]string{
+		"dropped_messages":      strconv.FormatUint(client.dropped.Load(), 10),
+		"remaining_connections": strconv.Itoa(pool.feed.Clients()),
+	})
+	return nil
+}
+
+// Wait waits until every WebSocket connection is closed or ctx is done. Close the
+// feed first so that the connections end, and stop the HTTP server so that no new
+// connections start.
+func (pool *MsgPool) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pool.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Connections returns the number of connected WebSocket clients
+func (pool *MsgPool) Connections() int {
+	return int(pool.connections.Load())
+}
+
+// sendInitialState replays the missed messages of a resuming client or sends the
+// current check state when resuming is not possible
+func (pool *MsgPool) sendInitialState(ws *websocket.Conn, client *feedClient, version int, since, seq uint64, backlog []Envelope, complete bool, state func() interface{}) error {
+	if complete {
+		replayed := 0
+		for _, env := range backlog {
+			if !client.wants(env) {
+				continue
+			}
+			if err := writeEnvelope(ws, env, version); err != nil {
+				return err
+			}
+			replayed++
+		}
+		resumed, err := newReply("resumed", "", map[string]interface{}{"since": since, "replayed": replayed}, time.Now())
+		if err != nil {
+			return err
+		}
+		return writeEnvelope(ws, resumed, version)
+	}
+
+	current, err := newReply("current_state", "", state(), time.Now())
+	if err != nil {
+		return err
+	}
+	current.Seq = seq
+	return writeEnvelope(ws, current, version)
+}
+
+// writeLoop writes queued messages and keepalive pings until the client is closed
+func (pool *MsgPool) writeLoop(ws *websocket.Conn, client *feedClient, version int) {
+	ticker := time.NewTicker(pool.pingInterval)
+	defer func() {
+		ticker.Stop()
+		if closeErr := ws.Close(); closeErr != nil {
+			log.Error("Error closing websocket connection: ", closeErr.Error(), nil)
+		}
+	}()
+
+	for {
+		select {
+		case env := <-client.queue:
+			if err := writeEnvelope(ws, env, version); err != nil {
+				log.Error("Error during sending message to connection: ", err.Error(), nil)
+				return
+			}
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Error("Error sending ping to connection: ", err.Error(), nil)
+				return
+			}
+		case <-client.done:
+			closeCode, reason := websocket.CloseNormalClosure, ""
+			if client.goingAway.Load() {
+				closeCode, reason = websocket.CloseGoingAway, "server shutting down"
+			} else if client.policy == Disconnect && client.dropped.Load() > 0 {
+				closeCode, reason = websocket.ClosePolicyViolation, "send queue overflow"
+			}
+			_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// readLoop handles client messages until the connection fails or stops answering pings
+func (pool *MsgPool) readLoop(ws *websocket.Conn, client *feedClient, version int) {
+	pongWait := 2 * pool.pingInterval
+	ws.SetReadLimit(maxClientMessageSize)
+	_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Error("Error during reading from connection: ", err.Error(), nil)
+			}
+			return
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+
+		// Clients of the first protocol version cannot send requests
+		if version == ProtocolVersion {
+			client.enqueue(handleClientMessage(client, data))
+		}
+	}
+}
+
+// handleClientMessage applies a subscribe or unsubscribe request and returns the reply
+func handleClientMessage(client *feedClient, data []byte) Envelope {
+	var msg ClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return errorReply("invalid message: " + err.Error())
+	}
+
+	urls := make([]string, 0, len(msg.Urls))
+	for _, url := range msg.Urls {
+		urls = append(urls, common.CanonicalTorrentUrl(url))
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		client.subscribe(msg.EventTypes, urls)
+	case "unsubscribe":
+		client.unsubscribe(msg.EventTypes, urls)
+	default:
+		return errorReply("unsupported message type " + strconv.Quote(msg.Type))
+	}
+
+	types, subscribedUrls := client.subscriptions()
+	reply, err := newReply("subscriptions", "", Subscriptions{EventTypes: types, Urls: subscribedUrls}, time.Now())
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return reply
+}
+
+// currentState returns a function building the check state of the caller's torrents
+func currentState(c echo.Context) func() interface{} {
+	return func() interface{} {
+		if user, ok := auth.CurrentUser(c); ok {
+			return GetCheckInfosForUser(user.ID)
+		}
+		return GetCheckInfos()
+	}
+}
+
+// errorReply creates an error message for a single client
+func errorReply(message string) Envelope {
+	reply, _ := newReply("error", "", map[string]string{"error": message}, time.Now())
+	return reply
+}
+
+// writeEnvelope writes env in the format of the client's protocol version
+func writeEnvelope(ws *websocket.Conn, env Envelope, version int) error {
+	var msg []byte
+	var err error
+	if version == ProtocolVersion {
+		msg, err = json.Marshal(env)
+	} else {
+		msg, err = legacyMessage(env)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return ws.WriteMessage(websocket.TextMessage, msg)
+}
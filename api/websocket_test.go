@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"kinozaltv_monitor/database"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// useTestRepository points database.Repo at an empty SQLite database for the test
+func useTestRepository(t *testing.T) {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	previous := database.Repo
+	database.Repo = repo
+	t.Cleanup(func() {
+		database.Repo = previous
+		_ = repo.Close()
+	})
+}
+
+func newTestWsServer(t *testing.T, feed *Feed) string {
+	useTestRepository(t)
+
+	e := echo.New()
+	e.GET("/ws", NewMsgPool(feed, 16, DropOldest, time.Minute).HandleWsConnections)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func dialWs(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial(%s) failed: %v", url, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var env Envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("ReadJSON() failed: %v", err)
+	}
+	return env
+}
+
+func TestMsgPool_ProtocolV2(t *testing.T) {
+	feed := NewFeed(10)
+	url := newTestWsServer(t, feed)
+	topic := "https://kinozal.tv/details.php?id=1"
+
+	conn := dialWs(t, url+"?v=2")
+	if env := readEnvelope(t, conn); env.Type != "current_state" || env.Version != ProtocolVersion {
+		t.Fatalf("Expected current_state envelope first, got %+v", env)
+	}
+
+	// Only updates of the subscribed torrent are delivered
+	if err := conn.WriteJSON(ClientMessage{Type: "subscribe", Urls: []string{"http://www.kinozal.tv/details.php?id=1"}}); err != nil {
+		t.Fatalf("WriteJSON() failed: %v", err)
+	}
+	var subscriptions Subscriptions
+	env := readEnvelope(t, conn)
+	if err := json.Unmarshal(env.Data, &subscriptions); err != nil || env.Type != "subscriptions" {
+		t.Fatalf("Expected subscriptions reply, got %+v", env)
+	}
+	if len(subscriptions.Urls) != 1 || subscriptions.Urls[0] != topic {
+		t.Errorf("Expected canonical url subscription, got %v", subscriptions.Urls)
+	}
+
+	feed.Broadcast(Envelope{Type: "check_update", Url: "https://kinozal.tv/details.php?id=2"})
+	feed.Broadcast(Envelope{Type: "check_update", Url: topic})
+	if env := readEnvelope(t, conn); env.Seq != 2 || env.Url != topic {
+		t.Errorf("Expected seq 2 for %s, got %+v", topic, env)
+	}
+
+	// A reconnecting client gets what it missed instead of the full state
+	feed.Broadcast(Envelope{Type: "job_update"})
+	resumed := dialWs(t, url+"?v=2&since=1")
+	for _, want := range []uint64{2, 3} {
+		if env := readEnvelope(t, resumed); env.Seq != want {
+			t.Errorf("Expected replayed seq %d, got %+v", want, env)
+		}
+	}
+	if env := readEnvelope(t, resumed); env.Type != "resumed" {
+		t.Errorf("Expected resumed reply, got %+v", env)
+	}
+}
+
+func TestMsgPool_ProtocolV1(t *testing.T) {
+	feed := NewFeed(10)
+	url := newTestWsServer(t, feed)
+
+	conn := dialWs(t, url)
+	var state map[string]interface{}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&state); err != nil || state["type"] != "current_state" {
+		t.Fatalf("Expected unversioned current_state, got %v (%v)", state, err)
+	}
+	if _, ok := state["v"]; ok {
+		t.Errorf("Expected no envelope for protocol v1 clients, got %v", state)
+	}
+}
+
+func TestMsgPool_Shutdown(t *testing.T) {
+	useTestRepository(t)
+	feed := NewFeed(10)
+	pool := NewMsgPool(feed, 16, DropOldest, time.Minute)
+	e := echo.New()
+	e.GET("/ws", pool.HandleWsConnections)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?v=2"
+
+	conn := dialWs(t, url)
+	readEnvelope(t, conn)
+	feed.Close()
+
+	// The client is told that the server is going away
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a going away close frame, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pool.Wait(ctx); err != nil {
+		t.Errorf("Wait() failed: %v", err)
+	}
+
+	// Clients connecting after the feed was closed are disconnected right away
+	late := dialWs(t, url)
+	readEnvelope(t, late)
+	_ = late.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := late.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a late client to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"kinozaltv_monitor/database"
+	logger "kinozaltv_monitor/logging"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var log = logger.New("auth")
+
+// SessionCookie is the name of the cookie holding the session token
+const SessionCookie = "kinozal_session"
+
+// userContextKey is the echo context key of the authenticated user
+const userContextKey = "auth_user"
+
+// minPasswordLength is the shortest accepted password
+const minPasswordLength = 8
+
+// Authentication errors
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+)
+
+// dummyHash is compared against when a username does not exist, so unknown
+// users take as long to reject as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("kinozaltv_monitor"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken returns a random token for sessions and API tokens
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hash stored in place of a session or API token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login checks the credentials and starts a session valid for ttl.
+// The returned token is the cookie value; only its hash is stored.
+func Login(repo database.TorrentRepository, username, password string, ttl time.Duration) (database.User, string, error) {
+	user, err := repo.GetUserByName(username)
+	if err == database.ErrUserNotFound {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password)) // #nosec G104 - only spends time
+		return database.User{}, "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return database.User{}, "", err
+	}
+	if !CheckPassword(user.PasswordHash, password) {
+		return database.User{}, "", ErrInvalidCredentials
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		return database.User{}, "", err
+	}
+	err = repo.CreateSession(database.Session{
+		TokenHash: HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return database.User{}, "", err
+	}
+	return user, token, nil
+}
+
+// Logout ends the session of token
+func Logout(repo database.TorrentRepository, token string) error {
+	return repo.DeleteSession(HashToken(token))
+}
+
+// AuthenticateSession returns the user of an unexpired session token
+func AuthenticateSession(repo database.TorrentRepository, token string) (database.User, error) {
+	session, err := repo.GetSession(HashToken(token))
+	if err == database.ErrSessionNotFound {
+		return database.User{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return repo.GetUserByID(session.UserID)
+}
+
+// AuthenticateAPIToken returns the user owning an API token
+func AuthenticateAPIToken(repo database.TorrentRepository, token string) (database.User, error) {
+	apiToken, err := repo.GetAPIToken(HashToken(token))
+	if err == database.ErrTokenNotFound {
+		return database.User{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return repo.GetUserByID(apiToken.UserID)
+}
+
+// CreateAPIToken issues a named API token for a user. The token is returned
+// once and cannot be recovered later.
+func CreateAPIToken(repo database.TorrentRepository, userID int, name string) (database.APIToken, string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return database.APIToken{}, "", err
+	}
+	apiToken, err := repo.CreateAPIToken(database.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashToken(token),
+	})
+	if err != nil {
+		return database.APIToken{}, "", err
+	}
+	return apiToken, token, nil
+}
+
+// Authenticate resolves the user of a request from an "Authorization: Bearer" API
+// token or the session cookie
+func Authenticate(repo database.TorrentRepository, c echo.Context) (database.User, error) {
+	if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return database.User{}, ErrUnauthenticated
+		}
+		return AuthenticateAPIToken(repo, token)
+	}
+
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return database.User{}, ErrUnauthenticated
+	}
+	return AuthenticateSession(repo, cookie.Value)
+}
+
+// SetUser stores the authenticated user in the request context
+func SetUser(c echo.Context, user database.User) {
+	c.Set(userContextKey, user)
+}
+
+// CurrentUser returns the authenticated user of the request
+func CurrentUser(c echo.Context) (database.User, bool) {
+	user, ok := c.Get(userContextKey).(database.User)
+	return user, ok
+}
+
+// EnsureAdmin creates the admin account when there are no users yet. Without a
+// configured password a random one is generated and logged once. Torrents watched
+// before accounts existed go to the new admin's watch list.
+func EnsureAdmin(repo database.TorrentRepository, username, password string) error {
+	count, err := repo.CountUsers()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	generated := password == ""
+	if generated {
+		if password, err = NewToken(); err != nil {
+			return err
+		}
+		password = password[:16]
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	admin, err := repo.CreateUser(database.User{Username: username, PasswordHash: hash, Role: database.RoleAdmin})
+	if err != nil {
+		return err
+	}
+	if err := repo.AdoptUnwatchedTorrents(admin.ID); err != nil {
+		return err
+	}
+
+	fields := map[string]string{"username": username}
+	if generated {
+		fields["password"] = password
+	}
+	log.Info("admin_created", "Admin user created, change the password after signing in", fields)
+	return nil
+}
+
+// RunSessionCleanup removes expired sessions every interval until ctx is done
+func RunSessionCleanup(ctx context.Context, repo database.TorrentRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := repo.DeleteExpiredSessions(time.Now()); err != nil {
+				log.Error("session_cleanup", err.Error(), nil)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
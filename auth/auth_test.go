@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"kinozaltv_monitor/database"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestRepository(t *testing.T) database.TorrentRepository {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestHashPassword(t *testing.T) {
+	if _, err := HashPassword("short"); err != ErrPasswordTooShort {
+		t.Errorf("Expected ErrPasswordTooShort, got %v", err)
+	}
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() failed: %v", err)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("Expected the password to match its hash")
+	}
+	if CheckPassword(hash, "wrong horse") {
+		t.Error("Expected a different password not to match")
+	}
+}
+
+func TestLoginAndSessions(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := EnsureAdmin(repo, "admin", "correct horse"); err != nil {
+		t.Fatalf("EnsureAdmin() failed: %v", err)
+	}
+	// A second call must not touch the existing account
+	if err := EnsureAdmin(repo, "other", "another password"); err != nil {
+		t.Fatalf("EnsureAdmin() failed: %v", err)
+	}
+	if count, _ := repo.CountUsers(); count != 1 {
+		t.Errorf("Expected a single user, got %d", count)
+	}
+
+	if _, _, err := Login(repo, "admin", "wrong password", time.Hour); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, _, err := Login(repo, "nobody", "correct horse", time.Hour); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+
+	user, token, err := Login(repo, "admin", "correct horse", time.Hour)
+	if err != nil {
+		t.Fatalf("Login() failed: %v", err)
+	}
+	if user.Role != database.RoleAdmin {
+		t.Errorf("Expected admin role, got %s", user.Role)
+	}
+
+	if authenticated, err := AuthenticateSession(repo, token); err != nil || authenticated.ID != user.ID {
+		t.Errorf("Expected session of user %d, got %+v (%v)", user.ID, authenticated, err)
+	}
+	if err := Logout(repo, token); err != nil {
+		t.Fatalf("Logout() failed: %v", err)
+	}
+	if _, err := AuthenticateSession(repo, token); err != ErrUnauthenticated {
+		t.Errorf("Expected ErrUnauthenticated after sign out, got %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	repo := newTestRepository(t)
+	user, err := repo.CreateUser(database.User{Username: "admin", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	apiToken, token, err := CreateAPIToken(repo, user.ID, "export script")
+	if err != nil {
+		t.Fatalf("CreateAPIToken() failed: %v", err)
+	}
+	if apiToken.TokenHash == token {
+		t.Error("Expected only the token hash to be stored")
+	}
+
+	if authenticated, err := AuthenticateAPIToken(repo, token); err != nil || authenticated.ID != user.ID {
+		t.Errorf("Expected token of user %d, got %+v (%v)", user.ID, authenticated, err)
+	}
+	if _, err := AuthenticateAPIToken(repo, "guessed"); err != ErrUnauthenticated {
+		t.Errorf("Expected ErrUnauthenticated for an unknown token, got %v", err)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	testCases := []struct {
+		name    string
+		origin  string
+		allowed []string
+		want    bool
+	}{
+		{name: "No origin", origin: "", want: true},
+		{name: "Same origin", origin: "http://monitor.local:1323", want: true},
+		{name: "Foreign origin", origin: "http://evil.example", want: false},
+		{name: "Configured origin", origin: "https://dash.example/", allowed: []string{"https://dash.example"}, want: true},
+		{name: "Any origin", origin: "http://evil.example", allowed: []string{"*"}, want: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://monitor.local:1323/ws", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if got := OriginAllowed(r, tc.allowed); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseOrigins(t *testing.T) {
+	got := ParseOrigins(" https://a.example/, ,http://b.example:8080")
+	if len(got) != 2 || got[0] != "https://a.example" || got[1] != "http://b.example:8080" {
+		t.Errorf("Unexpected origins %v", got)
+	}
+}
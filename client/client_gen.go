@@ -0,0 +1,779 @@
+// Code generated by client/internal/gen from api/openapi.json; DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Torrent is a watched tracker topic
+type Torrent struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Url   string `json:"url"`
+	// Check period in minutes, 0 when the torrent is not watched
+	WatchEvery   int      `json:"watch_every"`
+	DownloadPath string   `json:"download_path"`
+	Tags         []string `json:"tags"`
+	// User who first watched the torrent
+	OwnerID int `json:"owner_id"`
+	// How a new version replaces the torrent: replace removes the old torrent first, in_place adds the new one over the old files before removing the old one, keep_both renames the old files and keeps the old torrent, new_files_only downloads only files the old torrent did not have
+	UpdateStrategy string `json:"update_strategy"`
+	// qBittorrent category, empty to use the one configured for the download path or tracker
+	Category string `json:"category"`
+}
+
+// Values of Torrent.UpdateStrategy
+const (
+	TorrentUpdateStrategyReplace      = "replace"
+	TorrentUpdateStrategyInPlace      = "in_place"
+	TorrentUpdateStrategyKeepBoth     = "keep_both"
+	TorrentUpdateStrategyNewFilesOnly = "new_files_only"
+)
+
+// TorrentListItem is a watched tracker topic with its live status in qBittorrent
+type TorrentListItem struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Url   string `json:"url"`
+	// Check period in minutes, 0 when the torrent is not watched
+	WatchEvery   int      `json:"watch_every"`
+	DownloadPath string   `json:"download_path"`
+	Tags         []string `json:"tags"`
+	// User who first watched the torrent
+	OwnerID        int    `json:"owner_id"`
+	UpdateStrategy string `json:"update_strategy"`
+	// qBittorrent category, empty to use the one configured for the download path or tracker
+	Category string         `json:"category"`
+	Live     *TorrentStatus `json:"live,omitempty"`
+}
+
+// Values of TorrentListItem.UpdateStrategy
+const (
+	TorrentListItemUpdateStrategyReplace      = "replace"
+	TorrentListItemUpdateStrategyInPlace      = "in_place"
+	TorrentListItemUpdateStrategyKeepBoth     = "keep_both"
+	TorrentListItemUpdateStrategyNewFilesOnly = "new_files_only"
+)
+
+// TorrentStatus is the live state of a torrent in qBittorrent, left out when qBittorrent is unavailable or does not have the torrent
+type TorrentStatus struct {
+	// From 0 to 1
+	Progress float64 `json:"progress"`
+	// qBittorrent state, e.g. downloading, stalledDL, uploading, stalledUP, pausedUP or error
+	State string `json:"state"`
+	// Size of the selected files in bytes
+	Size  int64   `json:"size"`
+	Ratio float64 `json:"ratio"`
+	// Bytes per second
+	Dlspeed int64 `json:"dlspeed"`
+	// Bytes per second
+	Upspeed int64 `json:"upspeed"`
+	// Seconds, 8640000 when unknown
+	Eta int64 `json:"eta"`
+	// Connected seeds
+	NumSeeds int    `json:"num_seeds"`
+	Category string `json:"category"`
+	// Comma separated
+	Tags string `json:"tags"`
+}
+
+// TorrentResource is a watched tracker topic with the result of its latest check
+type TorrentResource struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Url   string `json:"url"`
+	// Tracker host, e.g. kinozal.tv
+	Tracker string `json:"tracker"`
+	// Check period in minutes, 0 when the torrent is not watched
+	WatchEvery   int      `json:"watch_every"`
+	DownloadPath string   `json:"download_path"`
+	Tags         []string `json:"tags"`
+	// User who first watched the torrent
+	OwnerID        int        `json:"owner_id"`
+	LastCheckTime  *time.Time `json:"last_check_time,omitempty"`
+	CheckStatus    string     `json:"check_status"`
+	UpdateStrategy string     `json:"update_strategy"`
+	// qBittorrent category, empty to use the one configured for the download path or tracker
+	Category    string       `json:"category"`
+	Replacement *Replacement `json:"replacement,omitempty"`
+	// Added by magnet link or .torrent file, such torrents can not be watched
+	External bool           `json:"external"`
+	Live     *TorrentStatus `json:"live,omitempty"`
+}
+
+// Values of TorrentResource.CheckStatus
+const (
+	TorrentResourceCheckStatusOk     = "ok"
+	TorrentResourceCheckStatusFailed = "failed"
+)
+
+// Values of TorrentResource.UpdateStrategy
+const (
+	TorrentResourceUpdateStrategyReplace      = "replace"
+	TorrentResourceUpdateStrategyInPlace      = "in_place"
+	TorrentResourceUpdateStrategyKeepBoth     = "keep_both"
+	TorrentResourceUpdateStrategyNewFilesOnly = "new_files_only"
+)
+
+// Replacement is an unfinished replacement of a torrent by its new version. It is resumed by the next check of the torrent.
+type Replacement struct {
+	ID       int    `json:"id"`
+	Url      string `json:"url"`
+	OldHash  string `json:"old_hash"`
+	NewHash  string `json:"new_hash"`
+	NewTitle string `json:"new_title"`
+	SavePath string `json:"save_path"`
+	Strategy string `json:"strategy"`
+	// Category of the old torrent, the new one gets it as well
+	Category string `json:"category"`
+	// Tags of the old torrent, the new one gets them as well
+	Tags []string `json:"tags"`
+	// Whether qBittorrent manages the save path of the old torrent by its category
+	AutoTmm bool `json:"auto_tmm"`
+	// The last step carried out, the order of the steps depends on the strategy
+	State string `json:"state"`
+	// Reason of the latest failed step
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Values of Replacement.Strategy
+const (
+	ReplacementStrategyReplace      = "replace"
+	ReplacementStrategyInPlace      = "in_place"
+	ReplacementStrategyKeepBoth     = "keep_both"
+	ReplacementStrategyNewFilesOnly = "new_files_only"
+)
+
+// Values of Replacement.State
+const (
+	ReplacementStatePending       = "pending"
+	ReplacementStateOldRemoved    = "old_removed"
+	ReplacementStateOldRenamed    = "old_renamed"
+	ReplacementStateNewAdded      = "new_added"
+	ReplacementStateFilesSelected = "files_selected"
+	ReplacementStateDone          = "done"
+	ReplacementStateRolledBack    = "rolled_back"
+)
+
+// TorrentPage is a page of the torrent list
+type TorrentPage struct {
+	Items []TorrentResource `json:"items"`
+	// Number of torrents matching the filters
+	Total int `json:"total"`
+	// Cursor of the next page, missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// MetaFile is a file of a torrent, the path is relative to the torrent folder
+type MetaFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// FileChange is a file whose size differs between two versions of a torrent
+type FileChange struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+// TorrentVersion is the metadata of one version of a torrent, recorded when it was added to qBittorrent
+type TorrentVersion struct {
+	ID          int        `json:"id"`
+	Url         string     `json:"url"`
+	Hash        string     `json:"hash"`
+	Name        string     `json:"name"`
+	MetaVersion string     `json:"meta_version"`
+	TotalSize   int64      `json:"total_size"`
+	PieceLength int64      `json:"piece_length"`
+	Private     bool       `json:"private"`
+	Announce    []string   `json:"announce"`
+	Files       []MetaFile `json:"files"`
+	// Creation date of the .torrent file
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	RecordedAt time.Time  `json:"recorded_at"`
+}
+
+// Values of TorrentVersion.MetaVersion
+const (
+	TorrentVersionMetaVersionV1     = "v1"
+	TorrentVersionMetaVersionV2     = "v2"
+	TorrentVersionMetaVersionHybrid = "hybrid"
+)
+
+// VersionDiff is the files added, removed and changed from one version of a torrent to another
+type VersionDiff struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Added   []MetaFile   `json:"added"`
+	Removed []MetaFile   `json:"removed"`
+	Changed []FileChange `json:"changed"`
+}
+
+// UpdateTorrentRequest is the body of the updateTorrent operation. Missing or null fields are left unchanged.
+type UpdateTorrentRequest struct {
+	// Check period in minutes, 0 stops watching
+	WatchEvery *int `json:"watch_every,omitempty"`
+	// Save path, the torrent is moved there in qBittorrent as well
+	DownloadPath   *string   `json:"download_path,omitempty"`
+	Tags           *[]string `json:"tags,omitempty"`
+	UpdateStrategy *string   `json:"update_strategy,omitempty"`
+	// qBittorrent category, changed in qBittorrent as well
+	Category *string `json:"category,omitempty"`
+}
+
+// Values of UpdateTorrentRequest.UpdateStrategy
+const (
+	UpdateTorrentRequestUpdateStrategyReplace      = "replace"
+	UpdateTorrentRequestUpdateStrategyInPlace      = "in_place"
+	UpdateTorrentRequestUpdateStrategyKeepBoth     = "keep_both"
+	UpdateTorrentRequestUpdateStrategyNewFilesOnly = "new_files_only"
+)
+
+// Job is a single request to add a torrent
+type Job struct {
+	ID           string    `json:"id"`
+	UserID       int       `json:"user_id,omitempty"`
+	Url          string    `json:"url"`
+	DownloadPath string    `json:"download_path"`
+	State        string    `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Values of Job.State
+const (
+	JobStateQueued      = "queued"
+	JobStateResolving   = "resolving"
+	JobStateDownloading = "downloading"
+	JobStateAdded       = "added"
+	JobStateDuplicate   = "duplicate"
+	JobStateFailed      = "failed"
+)
+
+// AddTorrentRequest is the body of the addTorrent operation
+type AddTorrentRequest struct {
+	// Topic url on a supported tracker or a magnet link
+	Url string `json:"url"`
+	// Save path, defaults to the caller's download path
+	DownloadPath string `json:"downloadPath,omitempty"`
+	// Check period in minutes, must be 0 for magnet links
+	WatchEvery int      `json:"watchEvery,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// qBittorrent category, defaults to the one configured for the download path or tracker
+	Category string `json:"category,omitempty"`
+}
+
+// AddTorrentResponse is the reply of the addTorrent operation. A queued torrent comes with its job.
+type AddTorrentResponse struct {
+	Status string `json:"status"`
+	Url    string `json:"url,omitempty"`
+	JobID  string `json:"job_id,omitempty"`
+	Job    *Job   `json:"job,omitempty"`
+}
+
+// Values of AddTorrentResponse.Status
+const (
+	AddTorrentResponseStatusQueued   = "queued"
+	AddTorrentResponseStatusWatching = "watching"
+)
+
+// WatchRequest is the body of the watchTorrent operation
+type WatchRequest struct {
+	Url string `json:"url"`
+	// Check period in minutes, 0 stops watching
+	WatchPeriod int `json:"watchPeriod"`
+}
+
+// RemoveRequest is the body of the removeTorrent operation
+type RemoveRequest struct {
+	Url string `json:"url"`
+	// Info hash of the torrent in qBittorrent, must be the one of the url when given
+	Hash string `json:"hash,omitempty"`
+	// untrack only stops monitoring, keep_files (the default) also removes the torrent from qBittorrent, delete_files deletes the downloaded files too
+	Mode string `json:"mode,omitempty"`
+	// Token of the confirmRemove operation, required by delete_files
+	ConfirmToken string `json:"confirm_token,omitempty"`
+}
+
+// Values of RemoveRequest.Mode
+const (
+	RemoveRequestModeUntrack     = "untrack"
+	RemoveRequestModeKeepFiles   = "keep_files"
+	RemoveRequestModeDeleteFiles = "delete_files"
+)
+
+// ConfirmRemoveRequest is the body of the confirmRemove operation, either url or ids
+type ConfirmRemoveRequest struct {
+	Url string `json:"url,omitempty"`
+	// Torrents of a bulk delete
+	IDs []int `json:"ids,omitempty"`
+}
+
+// ConfirmRemoveResponse is the reply of the confirmRemove operation
+type ConfirmRemoveResponse struct {
+	ConfirmToken string    `json:"confirm_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// BulkFilter is a selection of torrents like the filters of the queryTorrents operation
+type BulkFilter struct {
+	Tracker string `json:"tracker,omitempty"`
+	Watched *bool  `json:"watched,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Q       string `json:"q,omitempty"`
+}
+
+// Values of BulkFilter.Status
+const (
+	BulkFilterStatusOk     = "ok"
+	BulkFilterStatusFailed = "failed"
+)
+
+// BulkRequest is the body of the bulkTorrents operation. The torrents are given by ids or by a filter.
+type BulkRequest struct {
+	Action string      `json:"action"`
+	IDs    []int       `json:"ids,omitempty"`
+	Filter *BulkFilter `json:"filter,omitempty"`
+	// Check period in minutes for set_watch
+	WatchEvery int `json:"watch_every,omitempty"`
+	// Delete the downloaded files too for delete
+	DeleteFiles bool `json:"delete_files,omitempty"`
+	// New save path for move
+	DownloadPath string `json:"download_path,omitempty"`
+	// Tags added by tag
+	Tags []string `json:"tags,omitempty"`
+	// Token of the confirmRemove operation for the ids, required by delete with delete_files
+	ConfirmToken string `json:"confirm_token,omitempty"`
+}
+
+// Values of BulkRequest.Action
+const (
+	BulkRequestActionSetWatch = "set_watch"
+	BulkRequestActionUnwatch  = "unwatch"
+	BulkRequestActionDelete   = "delete"
+	BulkRequestActionMove     = "move"
+	BulkRequestActionRecheck  = "recheck"
+	BulkRequestActionTag      = "tag"
+)
+
+// BulkItemResult is the outcome of a bulk action for one torrent
+type BulkItemResult struct {
+	ID     int    `json:"id"`
+	Url    string `json:"url,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Values of BulkItemResult.Status
+const (
+	BulkItemResultStatusOk        = "ok"
+	BulkItemResultStatusUnwatched = "unwatched"
+	BulkItemResultStatusNotFound  = "not_found"
+	BulkItemResultStatusFailed    = "failed"
+)
+
+// BulkResponse is the reply of the bulkTorrents operation
+type BulkResponse struct {
+	// Operation id, repeated in its bulk_progress messages
+	ID        string           `json:"id"`
+	Action    string           `json:"action"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+// AdoptionProposal is a qBittorrent torrent that is not monitored yet. matched torrents name a topic in their comment, taken ones a topic that is already monitored under another hash.
+type AdoptionProposal struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	SavePath string `json:"save_path"`
+	// Tracker of the topic or announce url, empty for other trackers
+	Tracker string `json:"tracker"`
+	// Topic url found in the comment, empty when there is none
+	Url    string `json:"url"`
+	Status string `json:"status"`
+	// Why the comment could not be read, the proposal is unmatched then
+	Error string `json:"error,omitempty"`
+}
+
+// Values of AdoptionProposal.Status
+const (
+	AdoptionProposalStatusMatched   = "matched"
+	AdoptionProposalStatusUnmatched = "unmatched"
+	AdoptionProposalStatusTaken     = "taken"
+)
+
+// Adoption is an accepted proposal: the torrent of hash is monitored as the topic url
+type Adoption struct {
+	Hash string `json:"hash"`
+	// Topic url on a supported tracker
+	Url string `json:"url"`
+	// Check period in minutes
+	WatchEvery int `json:"watch_every,omitempty"`
+}
+
+// AdoptionResult is the outcome of adopting one torrent
+type AdoptionResult struct {
+	Hash   string `json:"hash"`
+	Url    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Values of AdoptionResult.Status
+const (
+	AdoptionResultStatusAdopted   = "adopted"
+	AdoptionResultStatusDuplicate = "duplicate"
+	AdoptionResultStatusNotFound  = "not_found"
+	AdoptionResultStatusFailed    = "failed"
+)
+
+// Category is a qBittorrent category
+type Category struct {
+	Name string `json:"name"`
+	// Empty when torrents are saved to a subdirectory named after the category in the default save path
+	SavePath string `json:"save_path"`
+}
+
+// AdoptRequest is the body of the adoptTorrents operation
+type AdoptRequest struct {
+	Adoptions []Adoption `json:"adoptions"`
+}
+
+// AdoptResponse is the reply of the adoptTorrents operation
+type AdoptResponse struct {
+	Adopted int              `json:"adopted"`
+	Failed  int              `json:"failed"`
+	Results []AdoptionResult `json:"results"`
+}
+
+// AuditEntry is who did what. before and after hold the changed values as JSON.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`
+	ActorID   int       `json:"actor_id"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	SourceIp  string    `json:"source_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrackerStatus is the TrackerStatus schema of the API
+type TrackerStatus struct {
+	Name                string     `json:"name"`
+	LoggedIn            bool       `json:"logged_in"`
+	LoginError          string     `json:"login_error,omitempty"`
+	LastSuccessfulCheck *time.Time `json:"last_successful_check"`
+}
+
+// ServiceStatus is the ServiceStatus schema of the API
+type ServiceStatus struct {
+	Version       string    `json:"version"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int       `json:"uptime_seconds"`
+	// Torrents that are checked periodically
+	Watchers int `json:"watchers"`
+	// Torrent urls waiting to be added
+	QueueLength int             `json:"queue_length"`
+	Trackers    []TrackerStatus `json:"trackers"`
+}
+
+// StatusResponse is the reply of operations that only report success
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ErrorResponse is the reply of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Validation error of every invalid request field
+	Fields map[string]string `json:"fields,omitempty"`
+	Url    string            `json:"url,omitempty"`
+}
+
+// ListTorrentsParams are the query parameters of ListTorrents. Zero values are not sent.
+type ListTorrentsParams struct {
+	// List every torrent, admins only
+	All bool
+}
+
+// ListTorrents calls GET /api/torrents: Torrents on the caller's watch list with their live status in qBittorrent
+func (c *Client) ListTorrents(ctx context.Context, params ListTorrentsParams) ([]TorrentListItem, error) {
+	query := url.Values{}
+	if params.All {
+		query.Set("all", strconv.FormatBool(params.All))
+	}
+	var result []TorrentListItem
+	err := c.do(ctx, http.MethodGet, "/api/torrents", query, nil, &result)
+	return result, err
+}
+
+// QueryTorrentsParams are the query parameters of QueryTorrents. Zero values are not sent.
+type QueryTorrentsParams struct {
+	// Tracker host such as kinozal.tv, or its name such as kinozal
+	Tracker string
+	// true for torrents checked for updates, false for the others
+	Watched string
+	// Result of the latest check
+	Status string
+	// Case-insensitive text searched in title and name
+	Q string
+	// id, title, watch_every or last_check, prefixed with - for descending order
+	Sort string
+	// Page size, 50 by default and at most 500
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+	// List every torrent, admins only
+	All bool
+}
+
+// QueryTorrents calls GET /api/v1/torrents: A filtered, ordered page of the torrents on the caller's watch list
+func (c *Client) QueryTorrents(ctx context.Context, params QueryTorrentsParams) (TorrentPage, error) {
+	query := url.Values{}
+	if params.Tracker != "" {
+		query.Set("tracker", params.Tracker)
+	}
+	if params.Watched != "" {
+		query.Set("watched", params.Watched)
+	}
+	if params.Status != "" {
+		query.Set("status", params.Status)
+	}
+	if params.Q != "" {
+		query.Set("q", params.Q)
+	}
+	if params.Sort != "" {
+		query.Set("sort", params.Sort)
+	}
+	if params.Limit != 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Cursor != "" {
+		query.Set("cursor", params.Cursor)
+	}
+	if params.All {
+		query.Set("all", strconv.FormatBool(params.All))
+	}
+	var result TorrentPage
+	err := c.do(ctx, http.MethodGet, "/api/v1/torrents", query, nil, &result)
+	return result, err
+}
+
+// GetTorrent calls GET /api/v1/torrents/{id}: A torrent on the caller's watch list
+func (c *Client) GetTorrent(ctx context.Context, id int) (TorrentResource, error) {
+	var result TorrentResource
+	err := c.do(ctx, http.MethodGet, "/api/v1/torrents/"+strconv.Itoa(id), nil, nil, &result)
+	return result, err
+}
+
+// UpdateTorrent calls PATCH /api/v1/torrents/{id}: Change the watch period, download path, tags or update strategy of a torrent
+func (c *Client) UpdateTorrent(ctx context.Context, id int, body UpdateTorrentRequest) (TorrentResource, error) {
+	var result TorrentResource
+	err := c.do(ctx, http.MethodPatch, "/api/v1/torrents/"+strconv.Itoa(id), nil, body, &result)
+	return result, err
+}
+
+// DeleteTorrentParams are the query parameters of DeleteTorrent. Zero values are not sent.
+type DeleteTorrentParams struct {
+	// untrack only stops monitoring, keep_files (the default) also removes the torrent from qBittorrent, delete_files deletes the downloaded files too
+	Mode string
+	// Token of the confirmRemove operation, required by delete_files
+	ConfirmToken string
+}
+
+// DeleteTorrent calls DELETE /api/v1/torrents/{id}: Remove a torrent from the caller's watch list, deleting it when nobody else watches it
+func (c *Client) DeleteTorrent(ctx context.Context, id int, params DeleteTorrentParams) (StatusResponse, error) {
+	query := url.Values{}
+	if params.Mode != "" {
+		query.Set("mode", params.Mode)
+	}
+	if params.ConfirmToken != "" {
+		query.Set("confirm_token", params.ConfirmToken)
+	}
+	var result StatusResponse
+	err := c.do(ctx, http.MethodDelete, "/api/v1/torrents/"+strconv.Itoa(id), query, nil, &result)
+	return result, err
+}
+
+// GetTorrentVersions calls GET /api/v1/torrents/{id}/versions: The versions of a torrent recorded from its .torrent files, newest first
+func (c *Client) GetTorrentVersions(ctx context.Context, id int) ([]TorrentVersion, error) {
+	var result []TorrentVersion
+	err := c.do(ctx, http.MethodGet, "/api/v1/torrents/"+strconv.Itoa(id)+"/versions", nil, nil, &result)
+	return result, err
+}
+
+// DiffTorrentVersionsParams are the query parameters of DiffTorrentVersions. Zero values are not sent.
+type DiffTorrentVersionsParams struct {
+	// Hash of the older version, by default the version before the newest
+	From string
+	// Hash of the newer version, by default the newest
+	To string
+}
+
+// DiffTorrentVersions calls GET /api/v1/torrents/{id}/versions/diff: The files added, removed and changed between two versions of a torrent
+func (c *Client) DiffTorrentVersions(ctx context.Context, id int, params DiffTorrentVersionsParams) (VersionDiff, error) {
+	query := url.Values{}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	var result VersionDiff
+	err := c.do(ctx, http.MethodGet, "/api/v1/torrents/"+strconv.Itoa(id)+"/versions/diff", query, nil, &result)
+	return result, err
+}
+
+// AddTorrent calls POST /api/add: Queue a topic, magnet link or uploaded .torrent file for adding
+func (c *Client) AddTorrent(ctx context.Context, body AddTorrentRequest) (AddTorrentResponse, error) {
+	var result AddTorrentResponse
+	err := c.do(ctx, http.MethodPost, "/api/add", nil, body, &result)
+	return result, err
+}
+
+// WatchTorrent calls POST /api/watch: Set how often a torrent is checked for updates
+func (c *Client) WatchTorrent(ctx context.Context, body WatchRequest) (StatusResponse, error) {
+	var result StatusResponse
+	err := c.do(ctx, http.MethodPost, "/api/watch", nil, body, &result)
+	return result, err
+}
+
+// RemoveTorrent calls DELETE /api/remove: Remove a torrent from the caller's watch list, deleting it when nobody else watches it
+func (c *Client) RemoveTorrent(ctx context.Context, body RemoveRequest) (StatusResponse, error) {
+	var result StatusResponse
+	err := c.do(ctx, http.MethodDelete, "/api/remove", nil, body, &result)
+	return result, err
+}
+
+// ConfirmRemove calls POST /api/remove/confirm: Get a single-use token that allows deleting the downloaded files of a torrent, or of torrents given by id, for five minutes
+func (c *Client) ConfirmRemove(ctx context.Context, body ConfirmRemoveRequest) (ConfirmRemoveResponse, error) {
+	var result ConfirmRemoveResponse
+	err := c.do(ctx, http.MethodPost, "/api/remove/confirm", nil, body, &result)
+	return result, err
+}
+
+// BulkTorrents calls POST /api/torrents/bulk: Apply an action to many torrents, reporting every torrent as a bulk_progress message on the live feed
+func (c *Client) BulkTorrents(ctx context.Context, body BulkRequest) (BulkResponse, error) {
+	var result BulkResponse
+	err := c.do(ctx, http.MethodPost, "/api/torrents/bulk", nil, body, &result)
+	return result, err
+}
+
+// GetAdoptable calls GET /api/adopt: qBittorrent torrents that are not monitored yet, with the tracker topic found in the comment of each one (admins only)
+func (c *Client) GetAdoptable(ctx context.Context) ([]AdoptionProposal, error) {
+	var result []AdoptionProposal
+	err := c.do(ctx, http.MethodGet, "/api/adopt", nil, nil, &result)
+	return result, err
+}
+
+// AdoptTorrents calls POST /api/adopt: Monitor existing qBittorrent torrents as tracker topics without downloading them again (admins only)
+func (c *Client) AdoptTorrents(ctx context.Context, body AdoptRequest) (AdoptResponse, error) {
+	var result AdoptResponse
+	err := c.do(ctx, http.MethodPost, "/api/adopt", nil, body, &result)
+	return result, err
+}
+
+// GetAuditParams are the query parameters of GetAudit. Zero values are not sent.
+type GetAuditParams struct {
+	// Username, or system for changes made by the checker
+	Actor string
+	// e.g. torrent.add, torrent.watch, torrent.remove or torrent.replace
+	Action string
+	// Topic url, user:<name> or token:<id>
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// GetAudit calls GET /api/audit: Audit log of user and system actions, newest first (admins only). ?format=csv downloads the entries as CSV
+func (c *Client) GetAudit(ctx context.Context, params GetAuditParams) ([]AuditEntry, error) {
+	query := url.Values{}
+	if params.Actor != "" {
+		query.Set("actor", params.Actor)
+	}
+	if params.Action != "" {
+		query.Set("action", params.Action)
+	}
+	if params.Target != "" {
+		query.Set("target", params.Target)
+	}
+	if !params.Since.IsZero() {
+		query.Set("since", params.Since.Format(time.RFC3339))
+	}
+	if !params.Until.IsZero() {
+		query.Set("until", params.Until.Format(time.RFC3339))
+	}
+	if params.Limit != 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	var result []AuditEntry
+	err := c.do(ctx, http.MethodGet, "/api/audit", query, nil, &result)
+	return result, err
+}
+
+// GetStatus calls GET /api/status: Version, uptime, watchers, add queue length and the state of every tracker
+func (c *Client) GetStatus(ctx context.Context) (ServiceStatus, error) {
+	var result ServiceStatus
+	err := c.do(ctx, http.MethodGet, "/api/status", nil, nil, &result)
+	return result, err
+}
+
+// GetDownloadPaths calls GET /api/download-paths: Save paths known to qBittorrent
+func (c *Client) GetDownloadPaths(ctx context.Context) ([]string, error) {
+	var result []string
+	err := c.do(ctx, http.MethodGet, "/api/download-paths", nil, nil, &result)
+	return result, err
+}
+
+// GetCategories calls GET /api/categories: qBittorrent categories sorted by name
+func (c *Client) GetCategories(ctx context.Context) ([]Category, error) {
+	var result []Category
+	err := c.do(ctx, http.MethodGet, "/api/categories", nil, nil, &result)
+	return result, err
+}
+
+// ListJobsParams are the query parameters of ListJobs. Zero values are not sent.
+type ListJobsParams struct {
+	// List the jobs of every user, admins only
+	All bool
+}
+
+// ListJobs calls GET /api/jobs: Recent add jobs of the caller, newest first
+func (c *Client) ListJobs(ctx context.Context, params ListJobsParams) ([]Job, error) {
+	query := url.Values{}
+	if params.All {
+		query.Set("all", strconv.FormatBool(params.All))
+	}
+	var result []Job
+	err := c.do(ctx, http.MethodGet, "/api/jobs", query, nil, &result)
+	return result, err
+}
+
+// GetJob calls GET /api/jobs/{id}: State of an add job
+func (c *Client) GetJob(ctx context.Context, id string) (Job, error) {
+	var result Job
+	err := c.do(ctx, http.MethodGet, "/api/jobs/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
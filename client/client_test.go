@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"authentication required"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/torrents":
+			if r.URL.Query().Get("all") != "true" {
+				t.Errorf("Expected all=true, got %q", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`[{"id":1,"url":"https://kinozal.tv/details.php?id=1","watch_every":30,"tags":["series"]}]`))
+		case "POST /api/watch":
+			var body WatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.WatchPeriod != 15 {
+				t.Errorf("Unexpected body %+v (%v)", body, err)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"validation failed","fields":{"url":"is required"}}`))
+		case "PATCH /api/v1/torrents/7":
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != `{"watch_every":0}` {
+				t.Errorf("Expected a zero watch period to be sent, got %s", body)
+			}
+			_, _ = w.Write([]byte(`{"id":7,"watch_every":0,"check_status":"ok"}`))
+		case "GET /api/jobs/a b":
+			_, _ = w.Write([]byte(`{"id":"a b","state":"added"}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL+"/", WithToken("secret"))
+
+	torrents, err := c.ListTorrents(ctx, ListTorrentsParams{All: true})
+	if err != nil {
+		t.Fatalf("ListTorrents() failed: %v", err)
+	}
+	if len(torrents) != 1 || torrents[0].WatchEvery != 30 || torrents[0].Tags[0] != "series" {
+		t.Errorf("Unexpected torrents %+v", torrents)
+	}
+
+	zero := 0
+	torrent, err := c.UpdateTorrent(ctx, 7, UpdateTorrentRequest{WatchEvery: &zero})
+	if err != nil || torrent.ID != 7 || torrent.CheckStatus != TorrentResourceCheckStatusOk {
+		t.Errorf("Unexpected torrent %+v (%v)", torrent, err)
+	}
+
+	job, err := c.GetJob(ctx, "a b")
+	if err != nil || job.State != JobStateAdded {
+		t.Errorf("Unexpected job %+v (%v)", job, err)
+	}
+
+	_, err = c.WatchTorrent(ctx, WatchRequest{WatchPeriod: 15})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Fields["url"] != "is required" {
+		t.Errorf("Unexpected error %+v", apiErr)
+	}
+
+	_, err = New(server.URL).GetDownloadPaths(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %v", err)
+	}
+}
@@ -0,0 +1,505 @@
+// Command gen generates the types and methods of the client package from the
+// OpenAPI document of the REST API. It supports the subset of OpenAPI 3 the
+// document uses: object schemas, arrays, maps, references, path and query
+// parameters and JSON request and response bodies.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"sort"
+	"strings"
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+type schema struct {
+	Ref                  string          `json:"$ref"`
+	Type                 string          `json:"type"`
+	Format               string          `json:"format"`
+	Description          string          `json:"description"`
+	Items                *schema         `json:"items"`
+	Properties           json.RawMessage `json:"properties"`
+	AdditionalProperties *schema         `json:"additionalProperties"`
+	Required             []string        `json:"required"`
+	Enum                 []string        `json:"enum"`
+	Nullable             bool            `json:"nullable"`
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Schema      schema `json:"schema"`
+}
+
+type mediaTypes struct {
+	Content map[string]struct {
+		Schema schema `json:"schema"`
+	} `json:"content"`
+}
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Parameters  []parameter           `json:"parameters"`
+	RequestBody *mediaTypes           `json:"requestBody"`
+	Responses   map[string]mediaTypes `json:"responses"`
+}
+
+type document struct {
+	Paths      json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+// httpMethods are the operations of a path item in the order they are generated
+var httpMethods = []string{"get", "post", "put", "patch", "delete"}
+
+func main() {
+	specPath := flag.String("spec", "../api/openapi.json", "OpenAPI document")
+	outPath := flag.String("out", "client_gen.go", "generated Go file")
+	pkg := flag.String("package", "client", "package name of the generated file")
+	flag.Parse()
+
+	spec, err := os.ReadFile(*specPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code, err := Generate(spec, *pkg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*outPath, code, 0o644); err != nil { // #nosec G306 - generated source file
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Generate returns the formatted Go source for spec
+func Generate(spec []byte, pkg string) ([]byte, error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parse spec: %w", err)
+	}
+
+	g := &generator{imports: map[string]bool{"context": true, "net/http": true}}
+	if err := g.schemas(doc.Components.Schemas); err != nil {
+		return nil, err
+	}
+	if err := g.operations(doc.Paths); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by client/internal/gen from api/openapi.json; DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	out.WriteString(")\n")
+	out.Write(g.body.Bytes())
+
+	code, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, out.Bytes())
+	}
+	return code, nil
+}
+
+type generator struct {
+	body    bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+// schemas generates a struct for every object schema in document order
+func (g *generator) schemas(raw json.RawMessage) error {
+	names, err := orderedKeys(raw)
+	if err != nil {
+		return fmt.Errorf("read schemas: %w", err)
+	}
+	var all map[string]schema
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return fmt.Errorf("read schemas: %w", err)
+	}
+
+	for _, name := range names {
+		s := all[name]
+		if s.Type != "object" {
+			return fmt.Errorf("schema %s: only object schemas are supported", name)
+		}
+		if err := g.object(name, s); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// object generates the struct of an object schema and constants for its enums
+func (g *generator) object(name string, s schema) error {
+	props, err := orderedKeys(s.Properties)
+	if err != nil {
+		return err
+	}
+	var all map[string]schema
+	if err := json.Unmarshal(s.Properties, &all); err != nil {
+		return err
+	}
+	required := make(map[string]bool, len(s.Required))
+	for _, prop := range s.Required {
+		required[prop] = true
+	}
+
+	g.printf("\n")
+	g.comment(name, s.Description)
+	g.printf("type %s struct {\n", name)
+	for _, prop := range props {
+		p := all[prop]
+		goType, err := g.goType(p, !required[prop])
+		if err != nil {
+			return fmt.Errorf("property %s: %w", prop, err)
+		}
+		if p.Description != "" {
+			g.printf("\t// %s\n", p.Description)
+		}
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		g.printf("\t%s %s `json:%q`\n", goName(prop), goType, tag)
+	}
+	g.printf("}\n")
+
+	for _, prop := range props {
+		if enum := all[prop].Enum; len(enum) > 0 {
+			g.printf("\n// Values of %s.%s\nconst (\n", name, goName(prop))
+			for _, value := range enum {
+				g.printf("\t%s%s%s = %q\n", name, goName(prop), goName(value), value)
+			}
+			g.printf(")\n")
+		}
+	}
+	return nil
+}
+
+// goType returns the Go type of a schema. Optional objects and times are pointers,
+// as are nullable schemas, so that a zero value can be told from a missing one.
+func (g *generator) goType(s schema, optional bool) (string, error) {
+	if s.Nullable {
+		s.Nullable = false
+		goType, err := g.goType(s, false)
+		return "*" + goType, err
+	}
+	if s.Ref != "" {
+		name, err := refName(s.Ref)
+		if err != nil {
+			return "", err
+		}
+		if optional {
+			return "*" + name, nil
+		}
+		return name, nil
+	}
+
+	switch s.Type {
+	case "string":
+		if s.Format == "date-time" {
+			g.imports["time"] = true
+			if optional {
+				return "*time.Time", nil
+			}
+			return "time.Time", nil
+		}
+		return "string", nil
+	case "integer":
+		if s.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		item, err := g.goType(*s.Items, false)
+		return "[]" + item, err
+	case "object":
+		if s.AdditionalProperties == nil {
+			return "", fmt.Errorf("inline objects need additionalProperties")
+		}
+		value, err := g.goType(*s.AdditionalProperties, false)
+		return "map[string]" + value, err
+	}
+	return "", fmt.Errorf("unsupported type %q", s.Type)
+}
+
+// operations generates a client method for every operation in document order
+func (g *generator) operations(raw json.RawMessage) error {
+	paths, err := orderedKeys(raw)
+	if err != nil {
+		return fmt.Errorf("read paths: %w", err)
+	}
+	var all map[string]map[string]operation
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return fmt.Errorf("read paths: %w", err)
+	}
+
+	for _, path := range paths {
+		for _, method := range httpMethods {
+			op, ok := all[path][method]
+			if !ok {
+				continue
+			}
+			if err := g.operation(path, method, op); err != nil {
+				return fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// operation generates the client method of a single operation
+func (g *generator) operation(path, method string, op operation) error {
+	if op.OperationID == "" {
+		return fmt.Errorf("operationId is missing")
+	}
+	name := goName(op.OperationID)
+
+	args := []string{"ctx context.Context"}
+	var query []parameter
+	for _, p := range op.Parameters {
+		goType, err := g.goType(p.Schema, false)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		switch p.In {
+		case "path":
+			args = append(args, p.Name+" "+goType)
+		case "query":
+			query = append(query, p)
+		default:
+			return fmt.Errorf("parameter %s: unsupported location %q", p.Name, p.In)
+		}
+	}
+	if len(query) > 0 {
+		if err := g.paramsStruct(name, query); err != nil {
+			return err
+		}
+		args = append(args, "params "+name+"Params")
+	}
+	body := "nil"
+	if op.RequestBody != nil {
+		s, err := jsonSchema(*op.RequestBody)
+		if err != nil {
+			return fmt.Errorf("request body: %w", err)
+		}
+		goType, err := g.goType(s, false)
+		if err != nil {
+			return fmt.Errorf("request body: %w", err)
+		}
+		args = append(args, "body "+goType)
+		body = "body"
+	}
+
+	result, err := g.resultType(op)
+	if err != nil {
+		return err
+	}
+
+	g.printf("\n// %s calls %s %s: %s\n", name, strings.ToUpper(method), path, strings.TrimSuffix(op.Summary, "."))
+	g.printf("func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), result)
+	queryArg := "nil"
+	if len(query) > 0 {
+		g.imports["net/url"] = true
+		queryArg = "query"
+		g.printf("\tquery := url.Values{}\n")
+		for _, p := range query {
+			if err := g.queryParam(p); err != nil {
+				return err
+			}
+		}
+	}
+	g.printf("\tvar result %s\n", result)
+	g.printf("\terr := c.do(ctx, http.Method%s, %s, %s, %s, &result)\n", goName(method), g.pathExpr(path, op.Parameters), queryArg, body)
+	g.printf("\treturn result, err\n}\n")
+	return nil
+}
+
+// resultType returns the Go type of the lowest 2xx response
+func (g *generator) resultType(op operation) (string, error) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return "", fmt.Errorf("no success response")
+	}
+	sort.Strings(codes)
+
+	s, err := jsonSchema(op.Responses[codes[0]])
+	if err != nil {
+		return "", fmt.Errorf("response %s: %w", codes[0], err)
+	}
+	return g.goType(s, false)
+}
+
+// paramsStruct generates the struct holding the query parameters of an operation
+func (g *generator) paramsStruct(name string, query []parameter) error {
+	g.printf("\n// %sParams are the query parameters of %s. Zero values are not sent.\n", name, name)
+	g.printf("type %sParams struct {\n", name)
+	for _, p := range query {
+		goType, err := g.goType(p.Schema, false)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		if p.Description != "" {
+			g.printf("\t// %s\n", p.Description)
+		}
+		g.printf("\t%s %s\n", goName(p.Name), goType)
+	}
+	g.printf("}\n")
+	return nil
+}
+
+// queryParam adds a query parameter unless it has its zero value
+func (g *generator) queryParam(p parameter) error {
+	field := "params." + goName(p.Name)
+	switch p.Schema.Type {
+	case "string":
+		if p.Schema.Format == "date-time" {
+			g.printf("\tif !%s.IsZero() {\n\t\tquery.Set(%q, %s.Format(time.RFC3339))\n\t}\n", field, p.Name, field)
+			break
+		}
+		g.printf("\tif %s != \"\" {\n\t\tquery.Set(%q, %s)\n\t}\n", field, p.Name, field)
+	case "integer":
+		g.imports["strconv"] = true
+		g.printf("\tif %s != 0 {\n\t\tquery.Set(%q, strconv.Itoa(%s))\n\t}\n", field, p.Name, field)
+	case "boolean":
+		g.imports["strconv"] = true
+		g.printf("\tif %s {\n\t\tquery.Set(%q, strconv.FormatBool(%s))\n\t}\n", field, p.Name, field)
+	default:
+		return fmt.Errorf("query parameter %s: unsupported type %q", p.Name, p.Schema.Type)
+	}
+	return nil
+}
+
+// pathExpr returns a Go expression building path with escaped path parameters
+func (g *generator) pathExpr(path string, params []parameter) string {
+	types := make(map[string]string, len(params))
+	for _, p := range params {
+		types[p.Name] = p.Schema.Type
+	}
+
+	var parts []string
+	for {
+		start := strings.Index(path, "{")
+		end := strings.Index(path, "}")
+		if start < 0 || end < start {
+			break
+		}
+		name := path[start+1 : end]
+		value := "url.PathEscape(" + name + ")"
+		if types[name] == "integer" {
+			value = "strconv.Itoa(" + name + ")"
+			g.imports["strconv"] = true
+		} else {
+			g.imports["net/url"] = true
+		}
+		parts = append(parts, fmt.Sprintf("%q", path[:start]), value)
+		path = path[end+1:]
+	}
+	if path != "" || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%q", path))
+	}
+	return strings.Join(parts, "+")
+}
+
+// comment writes a doc comment for name
+func (g *generator) comment(name, description string) {
+	if description == "" {
+		g.printf("// %s is the %s schema of the API\n", name, name)
+		return
+	}
+	g.printf("// %s is %s\n", name, strings.ToLower(description[:1])+description[1:])
+}
+
+// jsonSchema returns the schema of the application/json content
+func jsonSchema(m mediaTypes) (schema, error) {
+	content, ok := m.Content["application/json"]
+	if !ok {
+		return schema{}, fmt.Errorf("no application/json content")
+	}
+	return content.Schema, nil
+}
+
+// refName returns the schema name of a local reference
+func refName(ref string) (string, error) {
+	name, ok := strings.CutPrefix(ref, schemaRefPrefix)
+	if !ok {
+		return "", fmt.Errorf("unsupported reference %q", ref)
+	}
+	return name, nil
+}
+
+// goName converts a JSON or operation name to an exported Go identifier
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' }) {
+		switch part {
+		case "id":
+			b.WriteString("ID")
+			continue
+		case "ids":
+			b.WriteString("IDs")
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// orderedKeys returns the keys of a JSON object in document order
+func orderedKeys(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object")
+	}
+
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedClientIsUpToDate(t *testing.T) {
+	spec, err := os.ReadFile("../../../api/openapi.json")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	generated, err := Generate(spec, "client")
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	committed, err := os.ReadFile("../../client_gen.go")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if !bytes.Equal(generated, committed) {
+		t.Error("client/client_gen.go is out of date, run go generate ./client")
+	}
+}
+
+func TestGoName(t *testing.T) {
+	testCases := map[string]string{
+		"id":           "ID",
+		"job_id":       "JobID",
+		"ids":          "IDs",
+		"downloadPath": "DownloadPath",
+		"listTorrents": "ListTorrents",
+		"get":          "Get",
+	}
+	for in, want := range testCases {
+		if got := goName(in); got != want {
+			t.Errorf("goName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/watchlist"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commands are CLI subcommands. export and import talk to a running instance over
+// its HTTP API, restore and passwd work on the database directly.
+var commands = map[string]func(args []string) error{
+	"export":  exportCommand,
+	"import":  importCommand,
+	"restore": restoreCommand,
+	"passwd":  passwdCommand,
+}
+
+// runCommand runs the subcommand named by args[0] and reports whether there was one
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+	if err := command(args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func defaultServer() string {
+	return "http://localhost:" + globalConfig.ListenPort
+}
+
+// apiRequest sends a request to a running instance, authenticated with token if set
+func apiRequest(method, target, token, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// exportCommand downloads the watch list: export [-server url] [-format json|csv|opml] [-o file]
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	server := flags.String("server", defaultServer(), "base url of a running instance")
+	format := flags.String("format", watchlist.FormatJSON, "export format: json, csv or opml")
+	output := flags.String("o", "", "output file (default stdout)")
+	token := flags.String("token", os.Getenv("KINOZAL_TOKEN"), "API token (default $KINOZAL_TOKEN)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	resp, err := apiRequest(http.MethodGet, *server+"/api/export?format="+url.QueryEscape(*format), *token, "", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("export failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		out = file
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// importCommand uploads a watch list: import [-server url] [-format f] [-dry-run] [-conflict skip|update] file
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	server := flags.String("server", defaultServer(), "base url of a running instance")
+	format := flags.String("format", "", "file format: json, csv or opml (default: guessed from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "only validate the file and report what would change")
+	conflict := flags.String("conflict", watchlist.ConflictSkip, "what to do with already watched urls: skip or update")
+	token := flags.String("token", os.Getenv("KINOZAL_TOKEN"), "API token (default $KINOZAL_TOKEN)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [flags] file")
+	}
+
+	fileName := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromFileName(fileName)
+	}
+
+	file, err := os.Open(fileName) // #nosec G304 - the file is given by the operator on the command line
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	query := url.Values{
+		"format":   {*format},
+		"dry_run":  {strconv.FormatBool(*dryRun)},
+		"conflict": {*conflict},
+	}
+	resp, err := apiRequest(http.MethodPost, *server+"/api/import?"+query.Encode(), *token, watchlist.ContentType(*format), file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import failed with status %d: %s", resp.StatusCode, body)
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+// formatFromFileName picks the watch list format from a file extension
+func formatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return watchlist.FormatCSV
+	case ".opml", ".xml":
+		return watchlist.FormatOPML
+	default:
+		return watchlist.FormatJSON
+	}
+}
+
+// restoreCommand replaces the SQLite database with a snapshot: restore [-db path] snapshot
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dbPath := flags.String("db", globalConfig.DBDsn, "SQLite database file to replace")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: restore [flags] snapshot")
+	}
+	if globalConfig.DBDriver != database.DriverSQLite {
+		return database.ErrBackupNotSupported
+	}
+
+	snapshot := flags.Arg(0)
+	if err := database.ValidateSnapshot(snapshot); err != nil {
+		return fmt.Errorf("snapshot %s is not usable: %w", snapshot, err)
+	}
+
+	previous, err := database.RestoreSnapshot(snapshot, *dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Restored", *dbPath, "from", snapshot)
+	if previous != "" {
+		fmt.Println("The replaced database was kept as", previous)
+	}
+	return nil
+}
+
+// passwdCommand sets a user's password, reading it from stdin: passwd [-user name]
+func passwdCommand(args []string) error {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	username := flags.String("user", globalConfig.AuthAdminUser, "user whose password is set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stderr, "New password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	repo, err := database.NewRepository(globalConfig.DBDriver, globalConfig.DBDsn)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = repo.Close() }()
+
+	user, err := repo.GetUserByName(*username)
+	if err == database.ErrUserNotFound {
+		_, err = repo.CreateUser(database.User{Username: *username, PasswordHash: hash, Role: database.RoleAdmin})
+	} else if err == nil {
+		err = repo.SetPassword(user.ID, hash)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Password of", *username, "updated")
+	return nil
+}
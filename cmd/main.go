@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
 	assets "kinozaltv_monitor"
 	"kinozaltv_monitor/api"
+	"kinozaltv_monitor/auth"
 	"kinozaltv_monitor/common"
 	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
 	logger "kinozaltv_monitor/logging"
+	"kinozaltv_monitor/metrics"
 	customMiddleware "kinozaltv_monitor/middleware"
 	"kinozaltv_monitor/models"
 	"kinozaltv_monitor/qbittorrent"
+	"kinozaltv_monitor/telegram"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -17,39 +29,125 @@ import (
 )
 
 var globalConfig = config.GlobalConfig
+var log = logger.New("http_server")
 
 func main() {
+	// Run a CLI subcommand instead of the server if one is given
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	// Stop on Ctrl+C and on the SIGTERM sent by Docker
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize the torrent repository (SQLite or PostgreSQL)
+	err := database.InitializeRepository(globalConfig)
+	if err != nil {
+		panic("Failed to initialize database: " + err.Error())
+	}
+
 	// Initialize the tracker manager with all available trackers
 	models.InitializeTrackers(globalConfig)
 
 	// Initialize qBittorrent manager
-	err := qbittorrent.InitializeManager(globalConfig.QBUsername, globalConfig.QBPassword)
+	err = qbittorrent.InitializeManager(globalConfig.QBUsername, globalConfig.QBPassword)
 	if err != nil {
 		panic("Failed to initialize qBittorrent manager: " + err.Error())
 	}
 
-	wsChan := make(chan string, 1000)
 	urlChan := make(chan common.TorrentData, 100)
 
 	e := echo.New()
 	e.HideBanner = true
 
-	// Set allowed origins for CORS to allow requests from any origin
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-	}))
+	// Cross-origin requests are only allowed from configured origins
+	allowedOrigins := auth.ParseOrigins(globalConfig.CorsOrigins)
+	if len(allowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     allowedOrigins,
+			AllowCredentials: !slices.Contains(allowedOrigins, "*"),
+		}))
+	}
+	api.AllowedOrigins = allowedOrigins
 
 	// Middleware
 	e.Use(customMiddleware.HTTPLogger())
 
 	e.Use(middleware.Recover())
 
+	// Require a session or an API token everywhere except the login page
+	authEnabled, _ := strconv.ParseBool(globalConfig.AuthEnabled)
+	if authEnabled {
+		err = auth.EnsureAdmin(database.Repo, globalConfig.AuthAdminUser, globalConfig.AuthAdminPass)
+		if err != nil {
+			panic("Failed to create admin user: " + err.Error())
+		}
+		e.Use(customMiddleware.Auth(database.Repo, allowedOrigins,
+			"/login.html", "/style.css", "/favicon.ico", "/api/auth/login", "/api/openapi.json", "/healthz", "/readyz"))
+		go auth.RunSessionCleanup(ctx, database.Repo, time.Hour)
+	} else {
+		log.Info("auth_disabled", "Authentication is disabled, every client has full access", nil)
+	}
+
 	// Set channel for adding torrent by url
 	handler := api.NewApiHandler(urlChan)
-	msgPool := api.NewMsgPool(wsChan)
 
-	go qbittorrent.TorrentChecker(wsChan)
-	go qbittorrent.WsMessageHandler(wsChan, urlChan)
+	// Live feed shared by WebSocket and Server-Sent Events clients
+	replayBuffer, _ := strconv.Atoi(globalConfig.WsReplayBuffer)
+	feed := api.NewFeed(replayBuffer)
+	go feed.Run(events.GlobalBus.Subscribe("websocket", 1000))
+
+	wsQueueSize, _ := strconv.Atoi(globalConfig.WsQueueSize)
+	wsDropPolicy, err := api.ParseDropPolicy(globalConfig.WsDropPolicy)
+	if err != nil {
+		panic("Invalid websocket configuration: " + err.Error())
+	}
+	wsPingInterval, _ := strconv.Atoi(globalConfig.WsPingInterval)
+	if wsPingInterval <= 0 {
+		panic("Invalid websocket configuration: ping_interval must be a positive number of seconds")
+	}
+	msgPool := api.NewMsgPool(feed, wsQueueSize, wsDropPolicy, time.Duration(wsPingInterval)*time.Second)
+	eventStream := api.NewEventStream(feed, wsQueueSize, wsDropPolicy, time.Duration(wsPingInterval)*time.Second)
+
+	// Gauges that are cheaper to compute when Prometheus scrapes them
+	metrics.Default.OnScrape(func() {
+		metrics.WebSocketClients.Set(float64(msgPool.Connections()))
+		metrics.AddQueueDepth.Set(float64(len(urlChan)))
+	})
+	metrics.Default.OnScrape(qbittorrent.CollectTorrentStates)
+
+	// Every subscriber gets its own buffer so a slow one cannot block the checker
+	// Shutdown waits for the notifier and the history recorder to handle the buffered events
+	var subscribers sync.WaitGroup
+	subscribers.Add(2)
+	telegramSub := events.GlobalBus.Subscribe("telegram", 100, events.TorrentAdded, events.TorrentUpdated)
+	go func() {
+		defer subscribers.Done()
+		telegram.RunNotifier(telegramSub)
+	}()
+	historySub := events.GlobalBus.Subscribe("history", 100,
+		events.TorrentAdded, events.TorrentUpdated, events.TrackerLoginFailed, events.ClientUnavailable, events.TorrentRemoved,
+		events.TorrentAdopted)
+	go func() {
+		defer subscribers.Done()
+		database.RunHistoryRecorder(database.Repo, historySub)
+	}()
+	go events.GlobalStats.Run(events.GlobalBus.Subscribe("metrics", 1000))
+
+	// Take database snapshots periodically if enabled
+	if backupInterval, _ := strconv.Atoi(globalConfig.BackupInterval); backupInterval > 0 {
+		backupKeep, _ := strconv.Atoi(globalConfig.BackupKeep)
+		go database.RunBackupScheduler(ctx, database.Repo, globalConfig.BackupDir, backupKeep, time.Duration(backupInterval)*time.Minute)
+	}
+
+	// Push the download progress of the torrents to live feed clients if enabled
+	if progressInterval, _ := strconv.Atoi(globalConfig.WsProgressEvery); progressInterval > 0 {
+		go qbittorrent.RunProgressFeed(ctx, time.Duration(progressInterval)*time.Second)
+	}
+
+	go qbittorrent.TorrentChecker(ctx)
+	go qbittorrent.WsMessageHandler(ctx, urlChan)
 
 	var contentHandler = echo.WrapHandler(http.FileServer(http.FS(assets.Assets)))
 	var contentRewrite = middleware.Rewrite(map[string]string{"/*": "/frontend/$1"})
@@ -59,18 +157,56 @@ func main() {
 	// API routes
 	e.GET("/api/torrents", api.GetTorrentList)
 	e.GET("/api/download-paths", api.GetDownloadPaths)
+	e.GET("/api/categories", api.GetCategories)
+	e.GET("/api/openapi.json", api.GetOpenAPISpec)
 	e.POST("/api/add", handler.AddTorrentUrl)
 	e.POST("/api/watch", handler.WatchTorrent)
+	e.GET("/api/export", api.ExportWatchList)
+	e.POST("/api/import", handler.ImportWatchList)
+	e.POST("/api/backup", api.CreateBackup, customMiddleware.RequireAdmin)
+	e.GET("/api/jobs", api.GetJobs)
+	e.GET("/api/jobs/:id", api.GetJob)
+	e.GET("/api/history", api.GetHistory)
+	e.GET("/api/stats", api.GetEventStats, customMiddleware.RequireAdmin)
+	e.GET("/api/audit", api.GetAudit, customMiddleware.RequireAdmin)
+	e.GET("/metrics", api.GetMetrics, customMiddleware.RequireAdmin)
+	e.GET("/api/status", handler.GetStatus)
+	e.GET("/healthz", api.Healthz)
+	e.GET("/readyz", api.Readyz)
+	e.GET("/api/events", eventStream.HandleEvents)
 
 	e.DELETE("/api/remove", api.RemoveTorrentUrl)
+	e.POST("/api/remove/confirm", api.ConfirmRemove)
+	e.POST("/api/torrents/bulk", api.BulkTorrents)
+	e.GET("/api/adopt", api.GetAdoptable, customMiddleware.RequireAdmin)
+	e.POST("/api/adopt", api.AdoptTorrents, customMiddleware.RequireAdmin)
+	e.GET("/api/v1/torrents", api.ListTorrents)
+	e.GET("/api/v1/torrents/:id", api.GetTorrent)
+	e.PATCH("/api/v1/torrents/:id", api.UpdateTorrent)
+	e.DELETE("/api/v1/torrents/:id", api.DeleteTorrent)
+	e.GET("/api/v1/torrents/:id/versions", api.GetTorrentVersions)
+	e.GET("/api/v1/torrents/:id/versions/diff", api.DiffTorrentVersions)
+
+	if authEnabled {
+		sessionTTL, _ := strconv.Atoi(globalConfig.AuthSessionTTL)
+		secureCookie, _ := strconv.ParseBool(globalConfig.AuthSecureCookie)
+		authHandler := api.NewAuthHandler(time.Duration(sessionTTL)*time.Hour, secureCookie)
+		e.POST("/api/auth/login", authHandler.Login)
+		e.POST("/api/auth/logout", authHandler.Logout)
+		e.GET("/api/auth/me", authHandler.Me)
+		e.PUT("/api/auth/me", authHandler.UpdateMe)
+		e.POST("/api/auth/password", authHandler.ChangePassword)
+		e.GET("/api/auth/tokens", authHandler.GetAPITokens)
+		e.POST("/api/auth/tokens", authHandler.CreateAPIToken)
+		e.DELETE("/api/auth/tokens/:id", authHandler.DeleteAPIToken)
+		e.GET("/api/users", api.GetUsers, customMiddleware.RequireAdmin)
+		e.POST("/api/users", api.CreateUser, customMiddleware.RequireAdmin)
+		e.PUT("/api/users/:id", api.UpdateUser, customMiddleware.RequireAdmin)
+		e.DELETE("/api/users/:id", api.DeleteUser, customMiddleware.RequireAdmin)
+	}
 
 	// Websocket route
 	e.GET("/ws", msgPool.HandleWsConnections)
-	// Run ws pool
-	go msgPool.Start()
-
-	// Initialize our custom logger
-	log := logger.New("http_server")
 
 	// Create HTTP server manually to avoid Echo's automatic logging
 	serverAddr := ":" + globalConfig.ListenPort
@@ -87,11 +223,23 @@ func main() {
 	})
 
 	// Start server and log fatal errors with our logger
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Error("server_error", "HTTP server failed to start", map[string]string{
 			"error":   err.Error(),
 			"address": serverAddr,
 		})
 		panic("HTTP server failed to start: " + err.Error())
+	case <-ctx.Done():
+		// A second signal kills the process right away
+		stop()
 	}
+
+	shutdownTimeout, _ := strconv.Atoi(globalConfig.ShutdownTimeout)
+	shutdown(time.Duration(shutdownTimeout)*time.Second, server, feed, msgPool, &subscribers)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"kinozaltv_monitor/api"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/qbittorrent"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdown stops the service after a signal: the live feed, the HTTP server, the
+// running checks and adds, the event subscribers and finally the database.
+// All steps together may take up to timeout.
+func shutdown(timeout time.Duration, server *http.Server, feed *api.Feed, msgPool *api.MsgPool, subscribers *sync.WaitGroup) {
+	log.Info("shutdown_start", "Shutting down", map[string]string{"timeout": timeout.String()})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Disconnect live feed clients first, the HTTP server waits for Server-Sent Events requests
+	feed.Close()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("shutdown", "Error stopping HTTP server", map[string]string{"error": err.Error()})
+	}
+	if err := msgPool.Wait(ctx); err != nil {
+		log.Error("shutdown", "WebSocket connections did not close in time", map[string]string{"error": err.Error()})
+	}
+
+	// A replacement deletes the old torrent before adding the new one, let it finish
+	if err := qbittorrent.Drain(ctx); err != nil {
+		log.Error("shutdown", "Checks and adds did not finish in time", map[string]string{"error": err.Error()})
+	}
+
+	// Record the remaining events in the history before the database is closed
+	events.GlobalBus.Close()
+	done := make(chan struct{})
+	go func() {
+		subscribers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Error("shutdown", "Event subscribers did not finish in time", nil)
+	}
+
+	if err := database.Repo.Close(); err != nil {
+		log.Error("shutdown", "Error closing database", map[string]string{"error": err.Error()})
+	}
+	log.Info("shutdown_complete", "Shutdown complete", nil)
+}
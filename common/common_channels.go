@@ -2,11 +2,39 @@ package common
 
 import (
 	"net/url"
+	"regexp"
+	"strings"
 )
 
 type TorrentData struct {
-	Url          string `json:"url"`
-	DownloadPath string `json:"downloadPath"`
+	Url          string   `json:"url"`
+	DownloadPath string   `json:"downloadPath"`
+	WatchEvery   int      `json:"watchEvery,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Category     string   `json:"category,omitempty"`
+	JobID        string   `json:"-"`
+	UserID       int      `json:"-"`
+	// Title, Magnet and Torrent describe an external torrent, see ExternalTorrentUrl.
+	// Torrent is an uploaded .torrent file, Magnet the magnet link it was added by otherwise.
+	Title   string `json:"-"`
+	Magnet  string `json:"-"`
+	Torrent []byte `json:"-"`
+}
+
+// TorrentStatus is the live state of a torrent in qBittorrent, in the fields and units
+// of the qBittorrent Web API: progress from 0 to 1, size in bytes, speeds in bytes per
+// second and ETA in seconds, 8640000 when unknown. Tags are comma separated.
+type TorrentStatus struct {
+	Progress float64 `json:"progress"`
+	State    string  `json:"state"`
+	Size     int64   `json:"size"`
+	Ratio    float64 `json:"ratio"`
+	DlSpeed  int64   `json:"dlspeed"`
+	UpSpeed  int64   `json:"upspeed"`
+	ETA      int64   `json:"eta"`
+	NumSeeds int     `json:"num_seeds"`
+	Category string  `json:"category"`
+	Tags     string  `json:"tags"`
 }
 
 func GetTrackerDomain(originalUrl string) string {
@@ -16,3 +44,85 @@ func GetTrackerDomain(originalUrl string) string {
 	}
 	return u.Host
 }
+
+// topicParams lists the query parameter that identifies a topic on each tracker
+var topicParams = map[string]string{
+	"kinozal.tv":    "id",
+	"rutracker.org": "t",
+}
+
+// IsSupportedTrackerUrl reports whether the url points to a tracker the monitor can watch
+func IsSupportedTrackerUrl(originalUrl string) bool {
+	u, err := url.Parse(strings.TrimSpace(originalUrl))
+	if err != nil {
+		return false
+	}
+	param, known := topicParams[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")]
+	return known && u.Query().Get(param) != ""
+}
+
+// urlPattern matches the urls in free text, e.g. the comment of a .torrent file
+var urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// FindTopicUrl returns the first supported tracker topic url in text in its canonical form,
+// or an empty string when there is none
+func FindTopicUrl(text string) string {
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		if IsSupportedTrackerUrl(match) {
+			return CanonicalTorrentUrl(match)
+		}
+	}
+	return ""
+}
+
+// externalUrlPrefix starts the url of torrents that were not added from a tracker topic
+const externalUrlPrefix = "magnet:?xt=urn:btih:"
+
+// ExternalTorrentUrl returns the url an external torrent, uploaded as a .torrent file or
+// added by magnet link, is recorded under. It is the magnet link of its hash.
+func ExternalTorrentUrl(hash string) string {
+	return externalUrlPrefix + strings.ToLower(hash)
+}
+
+// IsExternalTorrentUrl reports whether the url is the url of an external torrent
+func IsExternalTorrentUrl(originalUrl string) bool {
+	return strings.HasPrefix(originalUrl, externalUrlPrefix)
+}
+
+// NormalizeTags trims tags and drops empty and repeated ones, keeping the order
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// CanonicalTorrentUrl normalizes a tracker topic URL so that different spellings
+// of the same topic (scheme, www prefix, extra query parameters, fragments) are equal
+func CanonicalTorrentUrl(originalUrl string) string {
+	originalUrl = strings.TrimSpace(originalUrl)
+	u, err := url.Parse(originalUrl)
+	if err != nil || u.Host == "" {
+		return originalUrl
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	param, known := topicParams[host]
+	if !known {
+		return originalUrl
+	}
+
+	u.Scheme = "https"
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawQuery = url.Values{param: {u.Query().Get(param)}}.Encode()
+	return u.String()
+}
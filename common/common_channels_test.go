@@ -0,0 +1,86 @@
+package common
+
+import "testing"
+
+func TestCanonicalTorrentUrl(t *testing.T) {
+	testCases := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{
+			name:     "Kinozal canonical url",
+			url:      "https://kinozal.tv/details.php?id=1234567",
+			expected: "https://kinozal.tv/details.php?id=1234567",
+		},
+		{
+			name:     "Kinozal with www, http and extra params",
+			url:      "http://www.Kinozal.tv/details.php?sid=abc&id=1234567#comments",
+			expected: "https://kinozal.tv/details.php?id=1234567",
+		},
+		{
+			name:     "RuTracker with surrounding spaces",
+			url:      "  https://rutracker.org/forum/viewtopic.php?t=42&start=30 ",
+			expected: "https://rutracker.org/forum/viewtopic.php?t=42",
+		},
+		{
+			name:     "Unknown tracker is kept as is",
+			url:      "https://example.com/topic?id=1&x=2",
+			expected: "https://example.com/topic?id=1&x=2",
+		},
+		{
+			name:     "External torrent url is kept as is",
+			url:      ExternalTorrentUrl("ABC123"),
+			expected: "magnet:?xt=urn:btih:abc123",
+		},
+		{
+			name:     "Not a url",
+			url:      "not a url",
+			expected: "not a url",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CanonicalTorrentUrl(tc.url); got != tc.expected {
+				t.Errorf("CanonicalTorrentUrl(%q) = %q, expected %q", tc.url, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestIsSupportedTrackerUrl(t *testing.T) {
+	testCases := map[string]bool{
+		"https://kinozal.tv/details.php?id=1":               true,
+		"https://www.rutracker.org/forum/viewtopic.php?t=2": true,
+		"https://kinozal.tv/details.php":                    false,
+		"https://example.com/details.php?id=1":              false,
+		"":                                                  false,
+	}
+	for url, expected := range testCases {
+		if got := IsSupportedTrackerUrl(url); got != expected {
+			t.Errorf("IsSupportedTrackerUrl(%q) = %v, expected %v", url, got, expected)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := NormalizeTags([]string{" series ", "", "kinozal-monitor", "series"})
+	if len(got) != 2 || got[0] != "series" || got[1] != "kinozal-monitor" {
+		t.Errorf("Unexpected tags: %v", got)
+	}
+}
+
+func TestFindTopicUrl(t *testing.T) {
+	testCases := map[string]string{
+		"http://kinozal.tv/details.php?id=1234567":                                               "https://kinozal.tv/details.php?id=1234567",
+		"Torrent from https://example.com/x, see https://rutracker.org/forum/viewtopic.php?t=42": "https://rutracker.org/forum/viewtopic.php?t=42",
+		"Created with qBittorrent":                                                               "",
+		"":                                                                                       "",
+	}
+	for text, expected := range testCases {
+		if got := FindTopicUrl(text); got != expected {
+			t.Errorf("FindTopicUrl(%q) = %q, expected %q", text, got, expected)
+		}
+	}
+}
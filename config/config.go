@@ -9,17 +9,42 @@ import (
 )
 
 type AppConfig struct {
-	QBUsername      string
-	QBPassword      string
-	QBUrl           string
-	KinozalUsername string
-	KinozalPassword string
-	RtUsername      string
-	RtPassword      string
-	TelegramChatId  string
-	TelegramToken   string
-	ListenPort      string
-	UserAgent       string
+	QBUsername       string
+	QBPassword       string
+	QBUrl            string
+	QBCategoryMode   string
+	QBTags           string
+	QBPathCategories string
+	QBPathTags       string
+	KinozalUsername  string
+	KinozalPassword  string
+	KinozalCategory  string
+	KinozalTags      string
+	RtUsername       string
+	RtPassword       string
+	RtCategory       string
+	RtTags           string
+	TelegramChatId   string
+	TelegramToken    string
+	ListenPort       string
+	UserAgent        string
+	DBDriver         string
+	DBDsn            string
+	BackupDir        string
+	BackupInterval   string
+	BackupKeep       string
+	WsQueueSize      string
+	WsDropPolicy     string
+	WsPingInterval   string
+	WsReplayBuffer   string
+	WsProgressEvery  string
+	AuthEnabled      string
+	AuthAdminUser    string
+	AuthAdminPass    string
+	AuthSessionTTL   string
+	AuthSecureCookie string
+	CorsOrigins      string
+	ShutdownTimeout  string
 }
 
 // GlobalConfig is a global variable for storing user data
@@ -65,33 +90,86 @@ func loadConfig() error {
 			"USER_AGENT":  &GlobalConfig.UserAgent,
 		},
 		"qbittorrent": {
-			"QB_USERNAME": &GlobalConfig.QBUsername,
-			"QB_PASSWORD": &GlobalConfig.QBPassword,
-			"QB_URL":      &GlobalConfig.QBUrl,
+			"QB_USERNAME":        &GlobalConfig.QBUsername,
+			"QB_PASSWORD":        &GlobalConfig.QBPassword,
+			"QB_URL":             &GlobalConfig.QBUrl,
+			"QB_CATEGORY_MODE":   &GlobalConfig.QBCategoryMode,
+			"QB_TAGS":            &GlobalConfig.QBTags,
+			"QB_PATH_CATEGORIES": &GlobalConfig.QBPathCategories,
+			"QB_PATH_TAGS":       &GlobalConfig.QBPathTags,
 		},
 		"kinozal": {
 			"KZ_USERNAME": &GlobalConfig.KinozalUsername,
 			"KZ_PASSWORD": &GlobalConfig.KinozalPassword,
+			"KZ_CATEGORY": &GlobalConfig.KinozalCategory,
+			"KZ_TAGS":     &GlobalConfig.KinozalTags,
 		},
 		"rutracker": {
 			"RT_USERNAME": &GlobalConfig.RtUsername,
 			"RT_PASSWORD": &GlobalConfig.RtPassword,
+			"RT_CATEGORY": &GlobalConfig.RtCategory,
+			"RT_TAGS":     &GlobalConfig.RtTags,
 		},
 		"telegram": {
 			"TG_ID":    &GlobalConfig.TelegramChatId,
 			"TG_TOKEN": &GlobalConfig.TelegramToken,
 		},
+		"database": {
+			"DB_DRIVER": &GlobalConfig.DBDriver,
+			"DB_DSN":    &GlobalConfig.DBDsn,
+		},
+		"backup": {
+			"BACKUP_DIR":      &GlobalConfig.BackupDir,
+			"BACKUP_INTERVAL": &GlobalConfig.BackupInterval,
+			"BACKUP_KEEP":     &GlobalConfig.BackupKeep,
+		},
+		"websocket": {
+			"WS_QUEUE_SIZE":        &GlobalConfig.WsQueueSize,
+			"WS_DROP_POLICY":       &GlobalConfig.WsDropPolicy,
+			"WS_PING_INTERVAL":     &GlobalConfig.WsPingInterval,
+			"WS_REPLAY_BUFFER":     &GlobalConfig.WsReplayBuffer,
+			"WS_PROGRESS_INTERVAL": &GlobalConfig.WsProgressEvery,
+		},
+		"auth": {
+			"AUTH_ENABLED":        &GlobalConfig.AuthEnabled,
+			"AUTH_ADMIN_USERNAME": &GlobalConfig.AuthAdminUser,
+			"AUTH_ADMIN_PASSWORD": &GlobalConfig.AuthAdminPass,
+			"AUTH_SESSION_TTL":    &GlobalConfig.AuthSessionTTL,
+			"AUTH_SECURE_COOKIE":  &GlobalConfig.AuthSecureCookie,
+		},
+		"cors": {
+			"CORS_ALLOWED_ORIGINS": &GlobalConfig.CorsOrigins,
+		},
+		"shutdown": {
+			"SHUTDOWN_TIMEOUT": &GlobalConfig.ShutdownTimeout,
+		},
 	}
 
 	defaultValues := map[string]string{
-		"LISTEN_PORT": "1323",
-		"USER_AGENT":  "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/113.0",
+		"LISTEN_PORT":          "1323",
+		"USER_AGENT":           "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/113.0",
+		"DB_DRIVER":            "sqlite",
+		"DB_DSN":               "db/kinozaltv_monitor.db",
+		"BACKUP_DIR":           "db/backups",
+		"BACKUP_INTERVAL":      "1440",
+		"BACKUP_KEEP":          "7",
+		"WS_QUEUE_SIZE":        "256",
+		"WS_DROP_POLICY":       "drop_oldest",
+		"WS_PING_INTERVAL":     "30",
+		"WS_REPLAY_BUFFER":     "1000",
+		"WS_PROGRESS_INTERVAL": "5",
+		"QB_CATEGORY_MODE":     "false",
+		"AUTH_ENABLED":         "true",
+		"AUTH_ADMIN_USERNAME":  "admin",
+		"AUTH_SESSION_TTL":     "720",
+		"AUTH_SECURE_COOKIE":   "false",
+		"SHUTDOWN_TIMEOUT":     "30",
 	}
 
 	for section, fields := range configFieldMap {
 		for key, field := range fields {
 			if err == nil {
-				iniKey := strings.ToLower(strings.Split(key, "_")[1])
+				iniKey := strings.ToLower(strings.SplitN(key, "_", 2)[1])
 				iniValue := cfg.Section(section).Key(iniKey).String()
 				envValue := os.Getenv(key)
 
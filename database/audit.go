@@ -0,0 +1,132 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ActorSystem is the actor of changes made by the checker rather than by a user
+const ActorSystem = "system"
+
+// Audited actions
+const (
+	AuditTorrentAdd      = "torrent.add"
+	AuditTorrentWatch    = "torrent.watch"
+	AuditTorrentUpdate   = "torrent.update"
+	AuditTorrentRemove   = "torrent.remove"
+	AuditTorrentReplace  = "torrent.replace"
+	AuditTorrentAdopt    = "torrent.adopt"
+	AuditQbittorrentAdd  = "qbittorrent.add"
+	AuditWatchlistImport = "watchlist.import"
+	AuditUserCreate      = "user.create"
+	AuditUserUpdate      = "user.update"
+	AuditUserDelete      = "user.delete"
+	AuditUserPassword    = "user.password"
+	AuditTokenCreate     = "token.create"
+	AuditTokenDelete     = "token.delete"
+)
+
+// AuditEntry records who did what. Before and After hold the changed values as JSON,
+// empty when there was nothing before or after, e.g. for additions and removals.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`
+	ActorID   int       `json:"actor_id"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	SourceIP  string    `json:"source_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit log entries. Empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// AddAudit is a function for recording an audit log entry
+func (r *sqlRepository) AddAudit(entry AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	_, err := r.exec(`INSERT INTO audit_log (actor, actor_id, action, target, before_value, after_value, source_ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor, entry.ActorID, entry.Action, entry.Target, entry.Before, entry.After, entry.SourceIP, entry.CreatedAt.UTC())
+	return err
+}
+
+// GetAudit is a function for getting the newest audit log entries matching a filter
+func (r *sqlRepository) GetAudit(filter AuditFilter) (entries []AuditEntry, err error) {
+	query := "SELECT id, actor, actor_id, action, target, before_value, after_value, source_ip, created_at FROM audit_log WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		query += " AND action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.Target != "" {
+		query += " AND target = ?"
+		args = append(args, filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, filter.Until.UTC())
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	entries = make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Actor, &e.ActorID, &e.Action, &e.Target, &e.Before, &e.After, &e.SourceIP, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Audit records entry in Repo. Failures are only logged, they never undo the audited change.
+func Audit(entry AuditEntry) {
+	if Repo == nil {
+		return
+	}
+	if err := Repo.AddAudit(entry); err != nil {
+		log.Error("audit", "Error recording audit log entry", map[string]string{"error": err.Error(), "action": entry.Action, "target": entry.Target})
+	}
+}
+
+// AuditValue encodes a before or after value of an audit log entry, nil is stored as empty
+func AuditValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBackupNotSupported is returned for backends that have their own backup tooling
+var ErrBackupNotSupported = errors.New("online backups are only supported for SQLite, use pg_dump for PostgreSQL")
+
+// backupPrefix and backupSuffix frame the timestamp in snapshot file names
+const (
+	backupPrefix     = "kinozaltv_monitor_"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102_150405.000"
+)
+
+// Backuper is implemented by repositories that can write a consistent snapshot of themselves
+type Backuper interface {
+	Backup(path string) error
+}
+
+// Backup writes a consistent copy of the live database to path with VACUUM INTO
+func (r *SQLiteRepository) Backup(path string) error {
+	_, err := r.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// CreateBackup writes a timestamped snapshot of repo to dir and keeps only the newest keep snapshots
+func CreateBackup(repo TorrentRepository, dir string, keep int) (string, error) {
+	backuper, ok := repo.(Backuper)
+	if !ok {
+		return "", ErrBackupNotSupported
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupTimeLayout)+backupSuffix)
+	if err := backuper.Backup(path); err != nil {
+		return "", err
+	}
+	log.Info("db_backup", "Database snapshot created", map[string]string{"path": path})
+
+	if err := pruneBackups(dir, keep); err != nil {
+		log.Error("db_backup_prune", "Error removing old snapshots", map[string]string{"error": err.Error()})
+	}
+	return path, nil
+}
+
+// ListBackups returns snapshot paths in dir, oldest first
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	// Timestamps in names sort chronologically
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// pruneBackups deletes the oldest snapshots so that at most keep remain, keep <= 0 keeps all
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		log.Info("db_backup_prune", "Old database snapshot removed", map[string]string{"path": backups[0]})
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// ValidateSnapshot checks that path is an intact SQLite database with the torrents schema
+func ValidateSnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("snapshot is not a valid SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("snapshot failed integrity check: %s", result)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM torrents").Scan(&count); err != nil {
+		return fmt.Errorf("snapshot has no torrents table: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot validates snapshot and atomically replaces the database file at target.
+// The replaced file is kept next to target. The service must not be running.
+func RestoreSnapshot(snapshot, target string) (string, error) {
+	if err := ValidateSnapshot(snapshot); err != nil {
+		return "", err
+	}
+
+	tmp := target + ".restore"
+	if err := copyFile(snapshot, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+
+	var previous string
+	if _, err := os.Stat(target); err == nil {
+		previous = target + ".before-restore-" + time.Now().UTC().Format("20060102_150405")
+		if err := os.Rename(target, previous); err != nil {
+			_ = os.Remove(tmp)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
+// copyFile copies src to dst and syncs it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src) // #nosec G304 - snapshot path is chosen by the operator
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600) // #nosec G304 - derived from the configured database path
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// RunBackupScheduler creates a snapshot every interval until ctx is done
+func RunBackupScheduler(ctx context.Context, repo TorrentRepository, dir string, keep int, interval time.Duration) {
+	if _, ok := repo.(Backuper); !ok {
+		log.Info("db_backup_scheduler", "Scheduled backups are disabled for this database driver", nil)
+		return
+	}
+
+	log.Info("db_backup_scheduler", "Backup scheduler started", map[string]string{
+		"dir":      dir,
+		"interval": interval.String(),
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := CreateBackup(repo, dir, keep); err != nil {
+				log.Error("db_backup_scheduler", "Scheduled backup failed", map[string]string{"error": err.Error()})
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
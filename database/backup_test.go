@@ -0,0 +1,76 @@
+package database
+
+import (
+	"kinozaltv_monitor/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "live.db")
+	backupDir := filepath.Join(dir, "backups")
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	if err := repo.AddRecord(models.Torrent{Title: "Saved", Hash: "aaa", Url: "https://kinozal.tv/details.php?id=1"}); err != nil {
+		t.Fatalf("AddRecord() failed: %v", err)
+	}
+
+	var snapshot string
+	for i := 0; i < 3; i++ {
+		snapshot, err = CreateBackup(repo, backupDir, 2)
+		if err != nil {
+			t.Fatalf("CreateBackup() failed: %v", err)
+		}
+	}
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		t.Fatalf("ListBackups() failed: %v", err)
+	}
+	if len(backups) != 2 || backups[1] != snapshot {
+		t.Errorf("Expected the 2 newest snapshots to be kept, got %v", backups)
+	}
+	if err := ValidateSnapshot(snapshot); err != nil {
+		t.Fatalf("ValidateSnapshot() failed: %v", err)
+	}
+
+	// Lose the data, then restore it
+	if err := repo.DeleteRecord("https://kinozal.tv/details.php?id=1"); err != nil {
+		t.Fatalf("DeleteRecord() failed: %v", err)
+	}
+	_ = repo.Close()
+
+	previous, err := RestoreSnapshot(snapshot, dbPath)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() failed: %v", err)
+	}
+	if _, err := os.Stat(previous); err != nil {
+		t.Errorf("Expected replaced database to be kept at %s: %v", previous, err)
+	}
+
+	repo, err = NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() after restore failed: %v", err)
+	}
+	defer repo.Close()
+	if _, err := repo.GetRecordByUrl("https://kinozal.tv/details.php?id=1"); err != nil {
+		t.Errorf("Expected restored record, got %v", err)
+	}
+}
+
+func TestValidateSnapshot_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupted.db")
+	if err := os.WriteFile(path, []byte("definitely not a database"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := ValidateSnapshot(path); err == nil {
+		t.Error("Expected corrupted snapshot to be rejected")
+	}
+	if _, err := RestoreSnapshot(path, filepath.Join(t.TempDir(), "target.db")); err == nil {
+		t.Error("Expected restore of corrupted snapshot to fail")
+	}
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"kinozaltv_monitor/events"
+	"time"
+)
+
+// HistoryEntry is a notable event in the life of a watched torrent
+type HistoryEntry struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Url       string    `json:"url"`
+	Title     string    `json:"title"`
+	Hash      string    `json:"hash"`
+	OldHash   string    `json:"old_hash"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddHistory is a function for recording a history entry
+func (r *sqlRepository) AddHistory(entry HistoryEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	_, err := r.exec("INSERT INTO torrent_history (type, url, title, hash, old_hash, message, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.Type, entry.Url, entry.Title, entry.Hash, entry.OldHash, entry.Message, entry.CreatedAt.UTC())
+	return err
+}
+
+// historyColumns is the column list read by queryHistory
+const historyColumns = "id, type, url, title, hash, old_hash, message, created_at"
+
+// GetHistory is a function for getting the newest history entries, optionally for a single url
+func (r *sqlRepository) GetHistory(url string, limit int) ([]HistoryEntry, error) {
+	query := "SELECT " + historyColumns + " FROM torrent_history"
+	args := []interface{}{}
+	if url != "" {
+		query += " WHERE url = ?"
+		args = append(args, url)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+	return r.queryHistory(query, args...)
+}
+
+// GetHistoryForUser is a function for getting the newest history entries of the torrents a user watches
+func (r *sqlRepository) GetHistoryForUser(userID int, limit int) ([]HistoryEntry, error) {
+	return r.queryHistory(`SELECT `+historyColumns+` FROM torrent_history WHERE url IN (
+		SELECT t.url FROM torrents t JOIN torrent_watchers w ON w.torrent_id = t.id WHERE w.user_id = ?)
+		ORDER BY id DESC LIMIT ?`, userID, limit)
+}
+
+// queryHistory runs a query selecting historyColumns and reads all rows
+func (r *sqlRepository) queryHistory(query string, args ...interface{}) (entries []HistoryEntry, err error) {
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	entries = make([]HistoryEntry, 0)
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.Type, &e.Url, &e.Title, &e.Hash, &e.OldHash, &e.Message, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// RunHistoryRecorder stores events from sub in the torrent history until it is unsubscribed
+func RunHistoryRecorder(repo TorrentRepository, sub *events.Subscription) {
+	for e := range sub.C {
+		err := repo.AddHistory(HistoryEntry{
+			Type:      string(e.Type),
+			Url:       e.Url,
+			Title:     e.Title,
+			Hash:      e.Hash,
+			OldHash:   e.OldHash,
+			Message:   historyMessage(e),
+			CreatedAt: e.Time,
+		})
+		if err != nil {
+			log.Error("history_recorder", "Error recording history entry", map[string]string{"error": err.Error(), "type": string(e.Type)})
+		}
+	}
+}
+
+// historyMessage describes an event in a few words
+func historyMessage(e events.Event) string {
+	switch e.Type {
+	case events.TorrentAdded:
+		return "Torrent added to qBittorrent"
+	case events.TorrentAdopted:
+		return "Torrent already in qBittorrent adopted"
+	case events.TorrentUpdated:
+		return "Torrent replaced after the release was updated"
+	case events.TrackerLoginFailed:
+		return "Login to " + e.Tracker + " failed: " + e.Error
+	case events.ClientUnavailable:
+		return "qBittorrent is unavailable: " + e.Error
+	case events.TorrentRemoved:
+		return removalMessage(e)
+	default:
+		return e.Error
+	}
+}
+
+// removalMessage says who removed a torrent and what happened to it in qBittorrent
+func removalMessage(e events.Event) string {
+	switch e.Mode {
+	case "untrack":
+		return "No longer monitored, removed by " + e.Actor + ", torrent kept in qBittorrent"
+	case "keep_files":
+		return "Removed by " + e.Actor + ", downloaded files kept"
+	case "delete_files":
+		return "Removed with the downloaded files by " + e.Actor
+	default:
+		return "Removed from the watch list of " + e.Actor + ", other users still watch it"
+	}
+}
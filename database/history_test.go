@@ -0,0 +1,45 @@
+package database
+
+import (
+	"kinozaltv_monitor/events"
+	"testing"
+)
+
+func TestRunHistoryRecorder(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	bus := events.NewBus()
+	sub := bus.Subscribe("history", 10)
+
+	done := make(chan struct{})
+	go func() {
+		RunHistoryRecorder(repo, sub)
+		close(done)
+	}()
+
+	bus.Publish(events.Event{Type: events.TorrentAdopted, Url: "https://kinozal.tv/details.php?id=2", Hash: "adopted"})
+	bus.Publish(events.Event{Type: events.TorrentUpdated, Url: "https://kinozal.tv/details.php?id=1", Hash: "new", OldHash: "old"})
+	bus.Publish(events.Event{Type: events.TrackerLoginFailed, Tracker: "kinozal", Error: "bad password"})
+	bus.Publish(events.Event{Type: events.TorrentRemoved, Url: "https://kinozal.tv/details.php?id=1", Actor: "alice", Mode: "keep_files"})
+	bus.Unsubscribe(sub)
+	<-done
+
+	history, err := repo.GetHistory("", 10)
+	if err != nil {
+		t.Fatalf("GetHistory() failed: %v", err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("Expected 4 history entries, got %d", len(history))
+	}
+	if history[0].Message != "Removed by alice, downloaded files kept" {
+		t.Errorf("Unexpected message %q", history[0].Message)
+	}
+	if history[1].Message != "Login to kinozal failed: bad password" {
+		t.Errorf("Unexpected message %q", history[1].Message)
+	}
+	if history[2].OldHash != "old" || history[2].Hash != "new" {
+		t.Errorf("Expected hashes to be recorded, got %+v", history[2])
+	}
+	if history[3].Message != "Torrent already in qBittorrent adopted" {
+		t.Errorf("Unexpected message %q", history[3].Message)
+	}
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"kinozaltv_monitor/common"
+)
+
+// migration is a versioned schema change, applied once inside a transaction
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *sql.Tx, rebind func(string) string) error
+}
+
+// execStatements returns a migration step that runs plain SQL statements
+func execStatements(statements ...string) func(tx *sql.Tx, rebind func(string) string) error {
+	return func(tx *sql.Tx, rebind func(string) string) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// runMigrations applies all migrations that are not recorded in schema_migrations yet
+func (r *sqlRepository) runMigrations(migrations []migration) error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied int
+		if err := r.queryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", m.version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		log.Info("db_migration", "Applying database migration", map[string]string{"name": m.name})
+
+		tx, err := r.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.apply(tx, r.rebind); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(r.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.version, m.name); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalizeTorrents rewrites urls to their canonical form and removes rows that
+// point to the same topic or the same hash. The oldest row is kept and inherits the
+// largest watch period of its duplicates.
+func canonicalizeTorrents(tx *sql.Tx, rebind func(string) string) error {
+	_, err := tx.Exec(`UPDATE torrents SET
+		title = COALESCE(title, ''),
+		name = COALESCE(name, ''),
+		hash = COALESCE(hash, ''),
+		url = COALESCE(url, ''),
+		watch_every = COALESCE(watch_every, 0)`)
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		id         int
+		url        string
+		hash       string
+		watchEvery int
+	}
+
+	rows, err := tx.Query("SELECT id, url, hash, watch_every FROM torrents ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var all []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.url, &r.hash, &r.watchEvery); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		all = append(all, r)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	keepers := make(map[int]*row)
+	byUrl := make(map[string]*row)
+	byHash := make(map[string]*row)
+	for i := range all {
+		r := &all[i]
+		canonical := common.CanonicalTorrentUrl(r.url)
+
+		keeper := byUrl[canonical]
+		if keeper == nil && r.hash != "" {
+			keeper = byHash[r.hash]
+		}
+		if keeper != nil {
+			if r.watchEvery > keeper.watchEvery {
+				keeper.watchEvery = r.watchEvery
+			}
+			if _, err := tx.Exec(rebind("DELETE FROM torrents WHERE id = ?"), r.id); err != nil {
+				return err
+			}
+			log.Info("db_migration", "Removed duplicate torrent record", map[string]string{"url": r.url, "hash": r.hash})
+			continue
+		}
+
+		r.url = canonical
+		keepers[r.id] = r
+		byUrl[canonical] = r
+		if r.hash != "" {
+			byHash[r.hash] = r
+		}
+	}
+
+	for id, r := range keepers {
+		if _, err := tx.Exec(rebind("UPDATE torrents SET url = ?, watch_every = ? WHERE id = ?"), r.url, r.watchEvery, id); err != nil {
+			return err
+		}
+	}
+
+	return execStatements(
+		`CREATE UNIQUE INDEX IF NOT EXISTS torrents_url_key ON torrents (url)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS torrents_hash_key ON torrents (hash) WHERE hash <> ''`,
+	)(tx, rebind)
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// PostgresRepository is a TorrentRepository backed by a PostgreSQL server,
+// so several instances can share one watch list
+type PostgresRepository struct {
+	sqlRepository
+}
+
+// NewPostgresRepository connects to PostgreSQL using the given DSN
+func NewPostgresRepository(dsn string) (*PostgresRepository, error) {
+	if dsn == "" {
+		return nil, errors.New("postgres DSN is empty")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	repo := &PostgresRepository{sqlRepository{db: db, rebind: dollarNumbers, isUniqueViolation: postgresUniqueViolation}}
+	if err := repo.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// postgresMigrations is the schema history of the PostgreSQL backend
+var postgresMigrations = []migration{
+	{version: 1, name: "create_torrents", apply: execStatements(`CREATE TABLE IF NOT EXISTS torrents (
+		id SERIAL PRIMARY KEY,
+		title TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		hash TEXT NOT NULL DEFAULT '',
+		url  TEXT NOT NULL DEFAULT '',
+		watch_every INTEGER NOT NULL DEFAULT 0
+	)`)},
+	{version: 2, name: "unique_torrent_url_and_hash", apply: canonicalizeTorrents},
+	{version: 3, name: "torrent_download_path_and_tags", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN IF NOT EXISTS download_path TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE torrents ADD COLUMN IF NOT EXISTS tags TEXT NOT NULL DEFAULT ''`,
+	)},
+	{version: 4, name: "create_torrent_history", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS torrent_history (
+			id SERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			hash TEXT NOT NULL DEFAULT '',
+			old_hash TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS torrent_history_url_idx ON torrent_history (url)`,
+	)},
+	{version: 5, name: "create_users", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'user',
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL,
+			last_used_at TIMESTAMPTZ
+		)`,
+	)},
+	{version: 6, name: "add_user_settings_and_watchers", apply: execStatements(
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS telegram_chat_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS download_path TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE torrents ADD COLUMN IF NOT EXISTS owner_id INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS torrent_watchers (
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			torrent_id INTEGER NOT NULL REFERENCES torrents (id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (user_id, torrent_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS torrent_watchers_torrent_idx ON torrent_watchers (torrent_id)`,
+	)},
+	{version: 7, name: "create_audit_log", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id SERIAL PRIMARY KEY,
+			actor TEXT NOT NULL,
+			actor_id INTEGER NOT NULL DEFAULT 0,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			before_value TEXT NOT NULL DEFAULT '',
+			after_value TEXT NOT NULL DEFAULT '',
+			source_ip TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`,
+		`CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target)`,
+	)},
+	{version: 8, name: "create_replacements", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS replacements (
+			id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			old_hash TEXT NOT NULL,
+			new_hash TEXT NOT NULL,
+			new_title TEXT NOT NULL DEFAULT '',
+			save_path TEXT NOT NULL DEFAULT '',
+			state TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS replacements_state_idx ON replacements (state)`,
+	)},
+	{version: 9, name: "add_update_strategy", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN IF NOT EXISTS update_strategy TEXT NOT NULL DEFAULT 'replace'`,
+		`ALTER TABLE replacements ADD COLUMN IF NOT EXISTS strategy TEXT NOT NULL DEFAULT 'replace'`,
+	)},
+	{version: 10, name: "create_torrent_versions", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS torrent_versions (
+			id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			meta_version TEXT NOT NULL DEFAULT '',
+			total_size BIGINT NOT NULL DEFAULT 0,
+			piece_length BIGINT NOT NULL DEFAULT 0,
+			private BOOLEAN NOT NULL DEFAULT FALSE,
+			announce TEXT NOT NULL DEFAULT '',
+			files TEXT NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ,
+			recorded_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS torrent_versions_url_hash_idx ON torrent_versions (url, hash)`,
+	)},
+	{version: 11, name: "add_qbittorrent_category", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN IF NOT EXISTS tags TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN IF NOT EXISTS auto_tmm BOOLEAN NOT NULL DEFAULT FALSE`,
+	)},
+}
+
+// migrate creates the schema
+func (r *PostgresRepository) migrate() error {
+	return r.runMigrations(postgresMigrations)
+}
+
+// postgresUniqueViolation reports whether err is a unique_violation error
+func postgresUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// States of a torrent replacement. Each state names the last step carried out; the
+// order of the steps depends on the update strategy, see Torrent.UpdateStrategy. With
+// UpdateReplace a replacement moves from pending through old_removed and new_added to
+// done. It ends rolled_back when the old torrent was kept or put back.
+const (
+	ReplacementPending       = "pending"
+	ReplacementOldRemoved    = "old_removed"
+	ReplacementOldRenamed    = "old_renamed"
+	ReplacementNewAdded      = "new_added"
+	ReplacementFilesSelected = "files_selected"
+	ReplacementDone          = "done"
+	ReplacementRolledBack    = "rolled_back"
+)
+
+// Replacement is the intent to replace the torrent of a topic with its new version.
+// It is written before qBittorrent is touched so that an interrupted replacement can
+// be resumed or rolled back. Error holds the reason of the latest failed step.
+type Replacement struct {
+	ID       int    `json:"id"`
+	Url      string `json:"url"`
+	OldHash  string `json:"old_hash"`
+	NewHash  string `json:"new_hash"`
+	NewTitle string `json:"new_title"`
+	SavePath string `json:"save_path"`
+	Strategy string `json:"strategy"`
+	// Category, Tags and AutoTMM are taken over from the old torrent by the new one
+	Category  string    `json:"category"`
+	Tags      []string  `json:"tags"`
+	AutoTMM   bool      `json:"auto_tmm"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Finished reports whether the replacement needs no more steps
+func (r Replacement) Finished() bool {
+	return r.State == ReplacementDone || r.State == ReplacementRolledBack
+}
+
+const replacementColumns = "id, url, old_hash, new_hash, new_title, save_path, strategy, category, tags, auto_tmm, state, error, created_at, updated_at"
+
+// CreateReplacement is a function for recording a new replacement intent
+func (r *sqlRepository) CreateReplacement(replacement Replacement) (Replacement, error) {
+	if replacement.State == "" {
+		replacement.State = ReplacementPending
+	}
+	if replacement.Strategy == "" {
+		replacement.Strategy = UpdateReplace
+	}
+	replacement.CreatedAt = time.Now().UTC()
+	replacement.UpdatedAt = replacement.CreatedAt
+	err := r.queryRow(`INSERT INTO replacements (url, old_hash, new_hash, new_title, save_path, strategy, category, tags, auto_tmm, state, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		replacement.Url, replacement.OldHash, replacement.NewHash, replacement.NewTitle, replacement.SavePath, replacement.Strategy,
+		replacement.Category, joinTags(replacement.Tags), replacement.AutoTMM, replacement.State, replacement.Error, replacement.CreatedAt, replacement.UpdatedAt).Scan(&replacement.ID)
+	return replacement, err
+}
+
+// UpdateReplacement is a function for storing the state and error of a replacement
+func (r *sqlRepository) UpdateReplacement(replacement Replacement) error {
+	_, err := r.exec("UPDATE replacements SET state = ?, error = ?, updated_at = ? WHERE id = ?",
+		replacement.State, replacement.Error, time.Now().UTC(), replacement.ID)
+	return err
+}
+
+// GetUnfinishedReplacements is a function for getting the replacements that are
+// neither done nor rolled back, oldest first
+func (r *sqlRepository) GetUnfinishedReplacements() (replacements []Replacement, err error) {
+	rows, err := r.query("SELECT "+replacementColumns+" FROM replacements WHERE state NOT IN (?, ?) ORDER BY id",
+		ReplacementDone, ReplacementRolledBack)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	replacements = make([]Replacement, 0)
+	for rows.Next() {
+		replacement, err := scanReplacement(rows)
+		if err != nil {
+			return nil, err
+		}
+		replacements = append(replacements, replacement)
+	}
+	return replacements, rows.Err()
+}
+
+// scanReplacement reads a row selected with replacementColumns
+func scanReplacement(rows *sql.Rows) (Replacement, error) {
+	var r Replacement
+	var tags string
+	err := rows.Scan(&r.ID, &r.Url, &r.OldHash, &r.NewHash, &r.NewTitle, &r.SavePath, &r.Strategy, &r.Category, &tags, &r.AutoTMM,
+		&r.State, &r.Error, &r.CreatedAt, &r.UpdatedAt)
+	r.Tags = splitTags(tags)
+	return r, err
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"kinozaltv_monitor/config"
+	logger "kinozaltv_monitor/logging"
+	"kinozaltv_monitor/models"
+	"time"
+)
+
+var log = logger.New("database")
+
+// Common errors
+var (
+	ErrNotFound  = errors.New("torrent record not found")
+	ErrDuplicate = errors.New("torrent is already watched")
+	// ErrExternalTorrent is returned when watching a torrent that has no tracker topic to check
+	ErrExternalTorrent = errors.New("external torrents can not be watched")
+)
+
+// Torrent is a struct for storing torrent data from the database
+type Torrent struct {
+	ID           int      `json:"id"`
+	Title        string   `json:"title"`
+	Name         string   `json:"name"`
+	Hash         string   `json:"hash"`
+	Url          string   `json:"url"`
+	WatchEvery   int      `json:"watch_every"`
+	DownloadPath string   `json:"download_path"`
+	Tags         []string `json:"tags"`
+	OwnerID      int      `json:"owner_id"`
+	// UpdateStrategy is how a new version of the torrent replaces the old one, one of the Update* values
+	UpdateStrategy string `json:"update_strategy"`
+	// Category is the qBittorrent category of the torrent, empty to use the configured one
+	Category string `json:"category"`
+}
+
+// Update strategies of a torrent. UpdateReplace removes the old torrent before adding the
+// new one, UpdateInPlace adds the new one over the files of the old one before removing it,
+// UpdateKeepBoth renames the files of the old torrent and keeps it, and UpdateNewFilesOnly
+// downloads only the files the old torrent did not have.
+const (
+	UpdateReplace      = "replace"
+	UpdateInPlace      = "in_place"
+	UpdateKeepBoth     = "keep_both"
+	UpdateNewFilesOnly = "new_files_only"
+)
+
+// UpdateStrategies lists the valid update strategies
+var UpdateStrategies = []string{UpdateReplace, UpdateInPlace, UpdateKeepBoth, UpdateNewFilesOnly}
+
+// TorrentRepository is the storage contract for watched torrents and the
+// accounts that use them. Every supported database backend implements it.
+// Urls are stored in canonical form, see common.CanonicalTorrentUrl.
+type TorrentRepository interface {
+	// GetAllRecords returns all torrent records
+	GetAllRecords() ([]Torrent, error)
+
+	// GetRecordByUrl returns the torrent record for a topic url or ErrNotFound
+	GetRecordByUrl(url string) (Torrent, error)
+
+	// GetRecordByID returns the torrent record with the given id or ErrNotFound
+	GetRecordByID(id int) (Torrent, error)
+
+	// CreateOrUpdateRecord adds a torrent record or updates hash and title of an existing one
+	CreateOrUpdateRecord(torrentInfo models.Torrent) error
+
+	// AddRecord adds a new torrent record, returns ErrDuplicate if the url or hash is taken
+	AddRecord(torrentInfo models.Torrent) error
+
+	// UpdateRecord updates hash and title of a torrent record
+	UpdateRecord(torrentInfo models.Torrent) error
+
+	// DeleteRecord deletes a torrent record and its watchers by url
+	DeleteRecord(url string) error
+
+	// SetWatchFlag sets the watch period in minutes for a torrent record
+	SetWatchFlag(url string, watchPeriod int) error
+
+	// SetDownloadPath sets the qBittorrent save path for a torrent record
+	SetDownloadPath(url string, downloadPath string) error
+
+	// SetTags replaces the tags of a torrent record
+	SetTags(url string, tags []string) error
+
+	// SetUpdateStrategy sets how new versions replace a torrent record, one of UpdateStrategies
+	SetUpdateStrategy(url string, strategy string) error
+
+	// SetCategory sets the qBittorrent category of a torrent record
+	SetCategory(url string, category string) error
+
+	// AddHistory records an event in the torrent history
+	AddHistory(entry HistoryEntry) error
+
+	// GetHistory returns the newest history entries, for all torrents if url is empty
+	GetHistory(url string, limit int) ([]HistoryEntry, error)
+
+	// CreateUser adds a user account, returns ErrUserExists if the username is taken
+	CreateUser(user User) (User, error)
+
+	// GetUserByName returns a user by username or ErrUserNotFound
+	GetUserByName(username string) (User, error)
+
+	// GetUserByID returns a user by id or ErrUserNotFound
+	GetUserByID(id int) (User, error)
+
+	// CountUsers returns the number of user accounts
+	CountUsers() (int, error)
+
+	// SetPassword replaces the password hash of a user
+	SetPassword(userID int, passwordHash string) error
+
+	// CreateSession stores a signed in browser session
+	CreateSession(session Session) error
+
+	// GetSession returns an unexpired session by token hash or ErrSessionNotFound
+	GetSession(tokenHash string) (Session, error)
+
+	// DeleteSession removes a session
+	DeleteSession(tokenHash string) error
+
+	// DeleteExpiredSessions removes sessions that expired before now
+	DeleteExpiredSessions(now time.Time) error
+
+	// CreateAPIToken stores an API token
+	CreateAPIToken(token APIToken) (APIToken, error)
+
+	// GetAPIToken returns an API token by token hash or ErrTokenNotFound and records its use
+	GetAPIToken(tokenHash string) (APIToken, error)
+
+	// ListAPITokens returns the API tokens of a user
+	ListAPITokens(userID int) ([]APIToken, error)
+
+	// DeleteAPIToken revokes an API token of a user or returns ErrTokenNotFound
+	DeleteAPIToken(userID, id int) error
+
+	// ListUsers returns all user accounts
+	ListUsers() ([]User, error)
+
+	// UpdateUser saves role, Telegram chat and default download path of a user
+	UpdateUser(user User) error
+
+	// DeleteUser removes a user with its sessions, API tokens and watch list entries
+	DeleteUser(id int) error
+
+	// GetRecordsForUser returns the torrent records watched by a user
+	GetRecordsForUser(userID int) ([]Torrent, error)
+
+	// AddWatcher adds a torrent record to the watch list of a user. The first
+	// watcher becomes the owner of the record.
+	AddWatcher(userID int, url string) error
+
+	// RemoveWatcher removes a torrent record from the watch list of a user and
+	// returns how many users still watch it
+	RemoveWatcher(userID int, url string) (int, error)
+
+	// IsWatching reports whether a user watches a torrent record
+	IsWatching(userID int, url string) (bool, error)
+
+	// GetWatchers returns the users watching a torrent record
+	GetWatchers(url string) ([]User, error)
+
+	// AdoptUnwatchedTorrents adds every torrent record nobody watches to the watch list of a user
+	AdoptUnwatchedTorrents(userID int) error
+
+	// GetHistoryForUser returns the newest history entries of the torrents a user watches
+	GetHistoryForUser(userID int, limit int) ([]HistoryEntry, error)
+
+	// AddAudit records who did what in the audit log
+	AddAudit(entry AuditEntry) error
+
+	// GetAudit returns the newest audit log entries matching filter
+	GetAudit(filter AuditFilter) ([]AuditEntry, error)
+
+	// CreateReplacement records the intent to replace the torrent of a topic
+	CreateReplacement(replacement Replacement) (Replacement, error)
+
+	// UpdateReplacement stores the state and error of a replacement
+	UpdateReplacement(replacement Replacement) error
+
+	// GetUnfinishedReplacements returns the replacements that are neither done nor rolled back
+	GetUnfinishedReplacements() ([]Replacement, error)
+
+	// AddTorrentVersion records the metadata of a torrent version, known versions are left unchanged
+	AddTorrentVersion(version TorrentVersion) error
+
+	// GetTorrentVersions returns the recorded versions of a torrent, newest first
+	GetTorrentVersions(url string) ([]TorrentVersion, error)
+
+	// Ping checks that the database is reachable
+	Ping() error
+
+	// Close closes the underlying database connection
+	Close() error
+}
+
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Repo is the global torrent repository instance
+var Repo TorrentRepository
+
+// NewRepository creates a torrent repository for the given driver and DSN
+func NewRepository(driver, dsn string) (TorrentRepository, error) {
+	switch driver {
+	case DriverSQLite, "sqlite3", "":
+		return NewSQLiteRepository(dsn)
+	case DriverPostgres, "postgresql":
+		return NewPostgresRepository(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
+
+// InitializeRepository initializes the global torrent repository
+func InitializeRepository(globalConfig *config.AppConfig) error {
+	repo, err := NewRepository(globalConfig.DBDriver, globalConfig.DBDsn)
+	if err != nil {
+		return err
+	}
+	Repo = repo
+	return nil
+}
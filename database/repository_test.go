@@ -0,0 +1,583 @@
+package database
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTorrentRepository runs the TorrentRepository contract against a backend.
+// newRepo must return an empty repository.
+func testTorrentRepository(t *testing.T, newRepo func(t *testing.T) TorrentRepository) {
+	t.Run("Empty repository", func(t *testing.T) {
+		repo := newRepo(t)
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if records == nil || len(records) != 0 {
+			t.Errorf("Expected empty non-nil slice, got %v", records)
+		}
+		if err := repo.Ping(); err != nil {
+			t.Errorf("Ping() failed: %v", err)
+		}
+	})
+
+	t.Run("Add and list", func(t *testing.T) {
+		repo := newRepo(t)
+
+		torrent := models.Torrent{Title: "Title", Name: "Name", Hash: "hash1", Url: "https://kinozal.tv/details.php?id=1"}
+		if err := repo.AddRecord(torrent); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(records))
+		}
+		got := records[0]
+		if got.ID == 0 {
+			t.Error("Expected record ID to be assigned")
+		}
+		if got.Title != torrent.Title || got.Name != torrent.Name || got.Hash != torrent.Hash || got.Url != torrent.Url {
+			t.Errorf("Unexpected record: %+v", got)
+		}
+		if got.WatchEvery != 0 {
+			t.Errorf("Expected WatchEvery=0 by default, got %d", got.WatchEvery)
+		}
+
+		byID, err := repo.GetRecordByID(got.ID)
+		if err != nil || byID.Url != torrent.Url {
+			t.Errorf("Expected GetRecordByID() to return %s, got %+v (%v)", torrent.Url, byID, err)
+		}
+		if _, err := repo.GetRecordByID(got.ID + 100); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for an unknown id, got %v", err)
+		}
+	})
+
+	t.Run("CreateOrUpdateRecord", func(t *testing.T) {
+		repo := newRepo(t)
+
+		url := "https://rutracker.org/forum/viewtopic.php?t=2"
+		if err := repo.CreateOrUpdateRecord(models.Torrent{Title: "Old", Name: "Old", Hash: "old", Url: url}); err != nil {
+			t.Fatalf("CreateOrUpdateRecord() create failed: %v", err)
+		}
+		if err := repo.CreateOrUpdateRecord(models.Torrent{Title: "New", Name: "New", Hash: "new", Url: url}); err != nil {
+			t.Fatalf("CreateOrUpdateRecord() update failed: %v", err)
+		}
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record after update, got %d", len(records))
+		}
+		if records[0].Hash != "new" || records[0].Title != "New" {
+			t.Errorf("Expected hash and title to be updated, got %+v", records[0])
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		repo := newRepo(t)
+
+		torrent := models.Torrent{Title: "T", Name: "T", Hash: "dup", Url: "https://kinozal.tv/details.php?id=6"}
+		if err := repo.AddRecord(torrent); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+
+		sameTopic := models.Torrent{Title: "T", Name: "T", Hash: "other", Url: "http://www.kinozal.tv/details.php?id=6&sid=1"}
+		if err := repo.AddRecord(sameTopic); err != ErrDuplicate {
+			t.Errorf("Expected ErrDuplicate for the same topic, got %v", err)
+		}
+		sameHash := models.Torrent{Title: "T", Name: "T", Hash: "dup", Url: "https://kinozal.tv/details.php?id=7"}
+		if err := repo.AddRecord(sameHash); err != ErrDuplicate {
+			t.Errorf("Expected ErrDuplicate for the same hash, got %v", err)
+		}
+
+		found, err := repo.GetRecordByUrl(sameTopic.Url)
+		if err != nil {
+			t.Fatalf("GetRecordByUrl() failed: %v", err)
+		}
+		if found.Hash != "dup" {
+			t.Errorf("Expected the original record, got %+v", found)
+		}
+		if _, err := repo.GetRecordByUrl("https://kinozal.tv/details.php?id=999"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent upserts", func(t *testing.T) {
+		repo := newRepo(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- repo.CreateOrUpdateRecord(models.Torrent{Title: "T", Name: "T", Hash: "same", Url: "https://kinozal.tv/details.php?id=8"})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("CreateOrUpdateRecord() failed: %v", err)
+			}
+		}
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if len(records) != 1 {
+			t.Errorf("Expected exactly 1 record, got %d", len(records))
+		}
+	})
+
+	t.Run("SetWatchFlag", func(t *testing.T) {
+		repo := newRepo(t)
+
+		url := "https://kinozal.tv/details.php?id=3"
+		if err := repo.AddRecord(models.Torrent{Title: "T", Name: "T", Hash: "h3", Url: url}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+		if err := repo.SetWatchFlag(url, 60); err != nil {
+			t.Fatalf("SetWatchFlag() failed: %v", err)
+		}
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if records[0].WatchEvery != 60 {
+			t.Errorf("Expected WatchEvery=60, got %d", records[0].WatchEvery)
+		}
+
+		// External torrents have no topic to check
+		external := common.ExternalTorrentUrl("h4")
+		if err := repo.AddRecord(models.Torrent{Title: "E", Name: "E", Hash: "h4", Url: external}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+		if err := repo.SetWatchFlag(external, 60); err != ErrExternalTorrent {
+			t.Errorf("Expected ErrExternalTorrent, got %v", err)
+		}
+		if err := repo.SetWatchFlag(external, 0); err != nil {
+			t.Errorf("SetWatchFlag(0) failed: %v", err)
+		}
+	})
+
+	t.Run("SetDownloadPath, SetTags, SetUpdateStrategy and SetCategory", func(t *testing.T) {
+		repo := newRepo(t)
+
+		url := "https://kinozal.tv/details.php?id=9"
+		if err := repo.AddRecord(models.Torrent{Title: "T", Name: "T", Hash: "h9", Url: url}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+		if err := repo.SetDownloadPath(url, "/downloads/series"); err != nil {
+			t.Fatalf("SetDownloadPath() failed: %v", err)
+		}
+		if err := repo.SetTags(url, []string{"series", " kinozal-monitor ", "series"}); err != nil {
+			t.Fatalf("SetTags() failed: %v", err)
+		}
+		if record, _ := repo.GetRecordByUrl(url); record.UpdateStrategy != UpdateReplace {
+			t.Errorf("Expected update strategy %q by default, got %q", UpdateReplace, record.UpdateStrategy)
+		}
+		if err := repo.SetUpdateStrategy(url, UpdateKeepBoth); err != nil {
+			t.Fatalf("SetUpdateStrategy() failed: %v", err)
+		}
+		if err := repo.SetCategory(url, "series"); err != nil {
+			t.Fatalf("SetCategory() failed: %v", err)
+		}
+
+		record, err := repo.GetRecordByUrl(url)
+		if err != nil {
+			t.Fatalf("GetRecordByUrl() failed: %v", err)
+		}
+		if record.DownloadPath != "/downloads/series" {
+			t.Errorf("Expected download path to be stored, got %q", record.DownloadPath)
+		}
+		if len(record.Tags) != 2 || record.Tags[0] != "series" || record.Tags[1] != "kinozal-monitor" {
+			t.Errorf("Unexpected tags: %v", record.Tags)
+		}
+		if record.UpdateStrategy != UpdateKeepBoth {
+			t.Errorf("Expected update strategy to be stored, got %q", record.UpdateStrategy)
+		}
+		if record.Category != "series" {
+			t.Errorf("Expected category to be stored, got %q", record.Category)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		repo := newRepo(t)
+
+		url := "https://kinozal.tv/details.php?id=10"
+		entries := []HistoryEntry{
+			{Type: "torrent_added", Url: url, Hash: "old"},
+			{Type: "client_unavailable", Message: "connection refused"},
+			{Type: "torrent_updated", Url: url, Hash: "new", OldHash: "old"},
+		}
+		for _, entry := range entries {
+			if err := repo.AddHistory(entry); err != nil {
+				t.Fatalf("AddHistory() failed: %v", err)
+			}
+		}
+
+		all, err := repo.GetHistory("", 10)
+		if err != nil {
+			t.Fatalf("GetHistory() failed: %v", err)
+		}
+		if len(all) != 3 || all[0].Type != "torrent_updated" || all[0].CreatedAt.IsZero() {
+			t.Errorf("Expected newest entry first, got %+v", all)
+		}
+
+		forUrl, err := repo.GetHistory(url, 1)
+		if err != nil {
+			t.Fatalf("GetHistory() failed: %v", err)
+		}
+		if len(forUrl) != 1 || forUrl[0].OldHash != "old" {
+			t.Errorf("Expected the latest entry of %s, got %+v", url, forUrl)
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		repo := newRepo(t)
+
+		admin, err := repo.CreateUser(User{Username: "admin", PasswordHash: "hash", Role: RoleAdmin})
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		if admin.ID == 0 {
+			t.Error("Expected CreateUser() to assign an id")
+		}
+		if _, err := repo.CreateUser(User{Username: "admin", PasswordHash: "other"}); err != ErrUserExists {
+			t.Errorf("Expected ErrUserExists, got %v", err)
+		}
+		if count, err := repo.CountUsers(); err != nil || count != 1 {
+			t.Errorf("Expected 1 user, got %d (%v)", count, err)
+		}
+
+		if err := repo.SetPassword(admin.ID, "new-hash"); err != nil {
+			t.Fatalf("SetPassword() failed: %v", err)
+		}
+		user, err := repo.GetUserByName("admin")
+		if err != nil || user.PasswordHash != "new-hash" || user.Role != RoleAdmin {
+			t.Errorf("Unexpected user %+v (%v)", user, err)
+		}
+		if _, err := repo.GetUserByID(admin.ID + 100); err != ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		repo := newRepo(t)
+		user, err := repo.CreateUser(User{Username: "admin", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+
+		now := time.Now()
+		if err := repo.CreateSession(Session{TokenHash: "live", UserID: user.ID, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("CreateSession() failed: %v", err)
+		}
+		if err := repo.CreateSession(Session{TokenHash: "expired", UserID: user.ID, ExpiresAt: now.Add(-time.Hour)}); err != nil {
+			t.Fatalf("CreateSession() failed: %v", err)
+		}
+
+		if session, err := repo.GetSession("live"); err != nil || session.UserID != user.ID {
+			t.Errorf("Expected live session of user %d, got %+v (%v)", user.ID, session, err)
+		}
+		if _, err := repo.GetSession("expired"); err != ErrSessionNotFound {
+			t.Errorf("Expected ErrSessionNotFound for an expired session, got %v", err)
+		}
+
+		if err := repo.DeleteExpiredSessions(now); err != nil {
+			t.Fatalf("DeleteExpiredSessions() failed: %v", err)
+		}
+		if err := repo.DeleteSession("live"); err != nil {
+			t.Fatalf("DeleteSession() failed: %v", err)
+		}
+		if _, err := repo.GetSession("live"); err != ErrSessionNotFound {
+			t.Errorf("Expected ErrSessionNotFound after sign out, got %v", err)
+		}
+	})
+
+	t.Run("API tokens", func(t *testing.T) {
+		repo := newRepo(t)
+		user, err := repo.CreateUser(User{Username: "admin", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+
+		token, err := repo.CreateAPIToken(APIToken{UserID: user.ID, Name: "backup script", TokenHash: "abc"})
+		if err != nil {
+			t.Fatalf("CreateAPIToken() failed: %v", err)
+		}
+
+		used, err := repo.GetAPIToken("abc")
+		if err != nil || used.ID != token.ID || used.LastUsedAt == nil {
+			t.Errorf("Expected used token %d, got %+v (%v)", token.ID, used, err)
+		}
+		if _, err := repo.GetAPIToken("unknown"); err != ErrTokenNotFound {
+			t.Errorf("Expected ErrTokenNotFound, got %v", err)
+		}
+
+		tokens, err := repo.ListAPITokens(user.ID)
+		if err != nil || len(tokens) != 1 || tokens[0].Name != "backup script" {
+			t.Errorf("Unexpected tokens %+v (%v)", tokens, err)
+		}
+
+		if err := repo.DeleteAPIToken(user.ID+1, token.ID); err != ErrTokenNotFound {
+			t.Errorf("Expected ErrTokenNotFound when revoking another user's token, got %v", err)
+		}
+		if err := repo.DeleteAPIToken(user.ID, token.ID); err != nil {
+			t.Fatalf("DeleteAPIToken() failed: %v", err)
+		}
+		if tokens, _ := repo.ListAPITokens(user.ID); len(tokens) != 0 {
+			t.Errorf("Expected no tokens after revoking, got %+v", tokens)
+		}
+	})
+
+	t.Run("User settings", func(t *testing.T) {
+		repo := newRepo(t)
+		user, err := repo.CreateUser(User{Username: "alice", PasswordHash: "hash", Role: RoleUser})
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+
+		user.Role, user.TelegramChatID, user.DownloadPath = RoleAdmin, "42", "/downloads/alice"
+		if err := repo.UpdateUser(user); err != nil {
+			t.Fatalf("UpdateUser() failed: %v", err)
+		}
+		users, err := repo.ListUsers()
+		if err != nil || len(users) != 1 {
+			t.Fatalf("Expected 1 user, got %+v (%v)", users, err)
+		}
+		if got := users[0]; got.Role != RoleAdmin || got.TelegramChatID != "42" || got.DownloadPath != "/downloads/alice" {
+			t.Errorf("Unexpected user %+v", got)
+		}
+		if err := repo.UpdateUser(User{ID: user.ID + 100, Role: RoleUser}); err != ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+
+		if err := repo.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser() failed: %v", err)
+		}
+		if err := repo.DeleteUser(user.ID); err != ErrUserNotFound {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Watchers", func(t *testing.T) {
+		repo := newRepo(t)
+		alice, _ := repo.CreateUser(User{Username: "alice", PasswordHash: "hash", Role: RoleUser})
+		bob, _ := repo.CreateUser(User{Username: "bob", PasswordHash: "hash", Role: RoleUser})
+
+		shared := models.Torrent{Title: "Shared", Name: "Shared", Hash: "shared", Url: "https://kinozal.tv/details.php?id=6"}
+		own := models.Torrent{Title: "Own", Name: "Own", Hash: "own", Url: "https://kinozal.tv/details.php?id=7"}
+		for _, torrent := range []models.Torrent{shared, own} {
+			if err := repo.AddRecord(torrent); err != nil {
+				t.Fatalf("AddRecord() failed: %v", err)
+			}
+		}
+		if err := repo.AddWatcher(alice.ID, shared.Url); err != nil {
+			t.Fatalf("AddWatcher() failed: %v", err)
+		}
+		if err := repo.AddWatcher(bob.ID, shared.Url); err != nil {
+			t.Fatalf("AddWatcher() failed: %v", err)
+		}
+		// Watching twice is not an error
+		if err := repo.AddWatcher(alice.ID, shared.Url); err != nil {
+			t.Fatalf("AddWatcher() twice failed: %v", err)
+		}
+		if err := repo.AddWatcher(bob.ID, "https://kinozal.tv/details.php?id=99"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for an unknown url, got %v", err)
+		}
+
+		record, _ := repo.GetRecordByUrl(shared.Url)
+		if record.OwnerID != alice.ID {
+			t.Errorf("Expected the first watcher %d to own the record, got %d", alice.ID, record.OwnerID)
+		}
+		watchers, err := repo.GetWatchers(shared.Url)
+		if err != nil || len(watchers) != 2 {
+			t.Errorf("Expected 2 watchers, got %+v (%v)", watchers, err)
+		}
+		if watching, _ := repo.IsWatching(alice.ID, own.Url); watching {
+			t.Error("Expected alice not to watch the unshared torrent")
+		}
+
+		if err := repo.AdoptUnwatchedTorrents(bob.ID); err != nil {
+			t.Fatalf("AdoptUnwatchedTorrents() failed: %v", err)
+		}
+		records, err := repo.GetRecordsForUser(bob.ID)
+		if err != nil || len(records) != 2 {
+			t.Errorf("Expected bob to watch 2 torrents, got %+v (%v)", records, err)
+		}
+		if records, _ := repo.GetRecordsForUser(alice.ID); len(records) != 1 || records[0].Url != shared.Url {
+			t.Errorf("Expected alice to watch only %s, got %+v", shared.Url, records)
+		}
+
+		if err := repo.AddHistory(HistoryEntry{Type: "torrent_updated", Url: own.Url}); err != nil {
+			t.Fatalf("AddHistory() failed: %v", err)
+		}
+		if history, _ := repo.GetHistoryForUser(alice.ID, 10); len(history) != 0 {
+			t.Errorf("Expected no history for alice, got %+v", history)
+		}
+		if history, _ := repo.GetHistoryForUser(bob.ID, 10); len(history) != 1 {
+			t.Errorf("Expected 1 history entry for bob, got %+v", history)
+		}
+
+		remaining, err := repo.RemoveWatcher(alice.ID, shared.Url)
+		if err != nil || remaining != 1 {
+			t.Errorf("Expected 1 remaining watcher, got %d (%v)", remaining, err)
+		}
+		if err := repo.DeleteUser(bob.ID); err != nil {
+			t.Fatalf("DeleteUser() failed: %v", err)
+		}
+		if watchers, _ := repo.GetWatchers(shared.Url); len(watchers) != 0 {
+			t.Errorf("Expected deleting a user to remove their watch list, got %+v", watchers)
+		}
+		if err := repo.DeleteRecord(own.Url); err != nil {
+			t.Fatalf("DeleteRecord() failed: %v", err)
+		}
+	})
+
+	t.Run("DeleteRecord", func(t *testing.T) {
+		repo := newRepo(t)
+
+		keep := models.Torrent{Title: "Keep", Name: "Keep", Hash: "keep", Url: "https://kinozal.tv/details.php?id=4"}
+		drop := models.Torrent{Title: "Drop", Name: "Drop", Hash: "drop", Url: "https://kinozal.tv/details.php?id=5"}
+		for _, torrent := range []models.Torrent{keep, drop} {
+			if err := repo.AddRecord(torrent); err != nil {
+				t.Fatalf("AddRecord() failed: %v", err)
+			}
+		}
+		if err := repo.DeleteRecord(drop.Url); err != nil {
+			t.Fatalf("DeleteRecord() failed: %v", err)
+		}
+
+		records, err := repo.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords() failed: %v", err)
+		}
+		if len(records) != 1 || records[0].Url != keep.Url {
+			t.Errorf("Expected only %s to remain, got %+v", keep.Url, records)
+		}
+	})
+
+	t.Run("Audit log", func(t *testing.T) {
+		repo := newRepo(t)
+
+		start := time.Now().Add(-time.Hour)
+		entries := []AuditEntry{
+			{Actor: "alice", ActorID: 1, Action: "torrent.add", Target: "https://kinozal.tv/details.php?id=1", After: `{"watch_every":30}`, SourceIP: "10.0.0.1", CreatedAt: start},
+			{Actor: "bob", ActorID: 2, Action: "torrent.watch", Target: "https://kinozal.tv/details.php?id=1", Before: `{"watch_every":30}`, After: `{"watch_every":0}`},
+			{Actor: ActorSystem, Action: "torrent.replace", Target: "https://kinozal.tv/details.php?id=2"},
+		}
+		for _, entry := range entries {
+			if err := repo.AddAudit(entry); err != nil {
+				t.Fatalf("AddAudit() failed: %v", err)
+			}
+		}
+
+		all, err := repo.GetAudit(AuditFilter{Limit: 10})
+		if err != nil || len(all) != 3 || all[0].Actor != ActorSystem {
+			t.Fatalf("Expected 3 entries newest first, got %+v (%v)", all, err)
+		}
+		if all[1].Before != `{"watch_every":30}` || all[2].SourceIP != "10.0.0.1" {
+			t.Errorf("Expected values to be stored, got %+v", all)
+		}
+		if byTarget, _ := repo.GetAudit(AuditFilter{Target: entries[0].Target, Limit: 10}); len(byTarget) != 2 {
+			t.Errorf("Expected 2 entries for the target, got %+v", byTarget)
+		}
+		if byActor, _ := repo.GetAudit(AuditFilter{Actor: "bob", Action: "torrent.watch", Limit: 10}); len(byActor) != 1 {
+			t.Errorf("Expected 1 entry of bob, got %+v", byActor)
+		}
+		if recent, _ := repo.GetAudit(AuditFilter{Since: start.Add(time.Minute), Limit: 10}); len(recent) != 2 {
+			t.Errorf("Expected 2 recent entries, got %+v", recent)
+		}
+		if old, _ := repo.GetAudit(AuditFilter{Until: start.Add(time.Minute), Limit: 10}); len(old) != 1 {
+			t.Errorf("Expected 1 old entry, got %+v", old)
+		}
+	})
+
+	t.Run("Replacements", func(t *testing.T) {
+		repo := newRepo(t)
+
+		first, err := repo.CreateReplacement(Replacement{Url: "https://kinozal.tv/details.php?id=1", OldHash: "old1", NewHash: "new1", SavePath: "/downloads",
+			Category: "series", Tags: []string{"kinozal-monitor", "series"}, AutoTMM: true})
+		if err != nil || first.ID == 0 || first.State != ReplacementPending {
+			t.Fatalf("CreateReplacement() returned %+v, %v", first, err)
+		}
+		second, _ := repo.CreateReplacement(Replacement{Url: "https://kinozal.tv/details.php?id=2", OldHash: "old2", NewHash: "new2", Strategy: UpdateInPlace})
+
+		first.State, first.Error = ReplacementOldRemoved, "add failed"
+		if err := repo.UpdateReplacement(first); err != nil {
+			t.Fatalf("UpdateReplacement() failed: %v", err)
+		}
+		second.State = ReplacementDone
+		_ = repo.UpdateReplacement(second)
+
+		unfinished, err := repo.GetUnfinishedReplacements()
+		if err != nil || len(unfinished) != 1 {
+			t.Fatalf("Expected 1 unfinished replacement, got %+v (%v)", unfinished, err)
+		}
+		if r := unfinished[0]; r.ID != first.ID || r.State != ReplacementOldRemoved || r.Error != "add failed" || r.SavePath != "/downloads" || r.Strategy != UpdateReplace || r.Finished() {
+			t.Errorf("Unexpected replacement %+v", r)
+		}
+		if r := unfinished[0]; r.Category != "series" || len(r.Tags) != 2 || r.Tags[1] != "series" || !r.AutoTMM {
+			t.Errorf("Expected the placement of the old torrent to be stored, got %+v", r)
+		}
+	})
+
+	t.Run("TorrentVersions", func(t *testing.T) {
+		repo := newRepo(t)
+
+		url := "https://kinozal.tv/details.php?id=11"
+		created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		first := TorrentVersion{Url: url, Hash: "h1", Name: "Show", MetaVersion: models.MetaVersionV1, TotalSize: 100,
+			Announce: []string{"http://a/announce", "http://b/announce"}, Files: []models.MetaFile{{Path: "e01.mkv", Size: 100}}, CreatedAt: &created}
+		second := TorrentVersion{Url: url, Hash: "h2", Name: "Show", Private: true, Files: []models.MetaFile{}}
+		for _, version := range []TorrentVersion{first, second, first} {
+			if err := repo.AddTorrentVersion(version); err != nil {
+				t.Fatalf("AddTorrentVersion() failed: %v", err)
+			}
+		}
+
+		versions, err := repo.GetTorrentVersions(url)
+		if err != nil || len(versions) != 2 {
+			t.Fatalf("Expected 2 versions, got %+v (%v)", versions, err)
+		}
+		if versions[0].Hash != "h2" || !versions[0].Private || versions[0].CreatedAt != nil || len(versions[0].Announce) != 0 {
+			t.Errorf("Unexpected newest version %+v", versions[0])
+		}
+		got := versions[1]
+		if got.Hash != "h1" || got.TotalSize != 100 || len(got.Announce) != 2 || len(got.Files) != 1 || got.Files[0].Path != "e01.mkv" {
+			t.Errorf("Unexpected oldest version %+v", got)
+		}
+		if got.CreatedAt == nil || !got.CreatedAt.Equal(created) {
+			t.Errorf("Expected creation date %v, got %v", created, got.CreatedAt)
+		}
+	})
+}
+
+func TestDollarNumbers(t *testing.T) {
+	got := dollarNumbers("UPDATE torrents SET hash = ?, title = ? WHERE url = ?")
+	expected := "UPDATE torrents SET hash = $1, title = $2 WHERE url = $3"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestNewRepository_UnsupportedDriver(t *testing.T) {
+	if _, err := NewRepository("mysql", ""); err == nil {
+		t.Error("Expected error for unsupported driver")
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/models"
+	"strconv"
+	"strings"
+)
+
+// sqlRepository implements TorrentRepository on top of database/sql.
+// Queries are written with "?" placeholders and rebound for the backend.
+type sqlRepository struct {
+	db                *sql.DB
+	rebind            func(query string) string
+	isUniqueViolation func(err error) bool
+}
+
+// questionMarks keeps "?" placeholders as is
+func questionMarks(query string) string {
+	return query
+}
+
+// dollarNumbers rewrites "?" placeholders to "$1", "$2", ...
+func dollarNumbers(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (r *sqlRepository) exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.db.Exec(r.rebind(query), args...)
+}
+
+func (r *sqlRepository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.Query(r.rebind(query), args...)
+}
+
+func (r *sqlRepository) queryRow(query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRow(r.rebind(query), args...)
+}
+
+// torrentColumns is the column list read by scanTorrent
+const torrentColumns = "id, title, name, hash, url, watch_every, download_path, tags, owner_id, update_strategy, category"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTorrent reads a torrent selected with torrentColumns
+func scanTorrent(row rowScanner) (Torrent, error) {
+	var t Torrent
+	var tags string
+	if err := row.Scan(&t.ID, &t.Title, &t.Name, &t.Hash, &t.Url, &t.WatchEvery, &t.DownloadPath, &tags, &t.OwnerID, &t.UpdateStrategy, &t.Category); err != nil {
+		return Torrent{}, err
+	}
+	t.Tags = splitTags(tags)
+	return t, nil
+}
+
+// joinTags stores tags as a comma separated string
+func joinTags(tags []string) string {
+	return strings.Join(common.NormalizeTags(tags), ",")
+}
+
+// splitTags is the reverse of joinTags
+func splitTags(tags string) []string {
+	if tags == "" {
+		return []string{}
+	}
+	return common.NormalizeTags(strings.Split(tags, ","))
+}
+
+// GetAllRecords is a function for getting all torrents records from the database
+func (r *sqlRepository) GetAllRecords() ([]Torrent, error) {
+	return r.queryTorrents("SELECT " + torrentColumns + " FROM torrents ORDER BY id")
+}
+
+// queryTorrents runs a query selecting torrentColumns and reads all rows
+func (r *sqlRepository) queryTorrents(query string, args ...interface{}) (records []Torrent, err error) {
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	records = make([]Torrent, 0)
+	for rows.Next() {
+		t, scanErr := scanTorrent(rows)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		records = append(records, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetRecordByUrl is a function for getting a torrent record by its topic url
+func (r *sqlRepository) GetRecordByUrl(url string) (Torrent, error) {
+	t, err := scanTorrent(r.queryRow("SELECT "+torrentColumns+" FROM torrents WHERE url = ?", common.CanonicalTorrentUrl(url)))
+	if err == sql.ErrNoRows {
+		return Torrent{}, ErrNotFound
+	}
+	return t, err
+}
+
+// GetRecordByID is a function for getting a torrent record by its id
+func (r *sqlRepository) GetRecordByID(id int) (Torrent, error) {
+	t, err := scanTorrent(r.queryRow("SELECT "+torrentColumns+" FROM torrents WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return Torrent{}, ErrNotFound
+	}
+	return t, err
+}
+
+// CreateOrUpdateRecord is a function for creating or updating a torrent record in the database
+func (r *sqlRepository) CreateOrUpdateRecord(torrentInfo models.Torrent) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind(`INSERT INTO torrents (title, name, hash, url) VALUES (?, ?, ?, ?)
+			ON CONFLICT (url) DO UPDATE SET hash = excluded.hash, title = excluded.title`),
+			torrentInfo.Title, torrentInfo.Name, torrentInfo.Hash, common.CanonicalTorrentUrl(torrentInfo.Url))
+		return err
+	})
+}
+
+// AddRecord is a function for adding a torrent record to the database
+func (r *sqlRepository) AddRecord(torrentInfo models.Torrent) error {
+	_, err := r.exec("INSERT INTO torrents (title, name, hash, url) VALUES (?, ?, ?, ?)",
+		torrentInfo.Title, torrentInfo.Name, torrentInfo.Hash, common.CanonicalTorrentUrl(torrentInfo.Url))
+	return r.mapError(err)
+}
+
+// DeleteRecord is a function for deleting a torrent record and its watchers from the database
+func (r *sqlRepository) DeleteRecord(url string) error {
+	url = common.CanonicalTorrentUrl(url)
+	err := r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind("DELETE FROM torrent_watchers WHERE torrent_id IN (SELECT id FROM torrents WHERE url = ?)"), url)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.rebind("DELETE FROM torrents WHERE url = ?"), url)
+		return err
+	})
+	if err == nil {
+		watchersChanged(0, url)
+	}
+	return err
+}
+
+// UpdateRecord is a function for updating hash and title for a torrent record in the database
+func (r *sqlRepository) UpdateRecord(torrentInfo models.Torrent) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind("UPDATE torrents SET hash = ?, title = ? WHERE url = ?"),
+			torrentInfo.Hash, torrentInfo.Title, common.CanonicalTorrentUrl(torrentInfo.Url))
+		return err
+	})
+}
+
+// SetWatchFlag is a function for setting watch_it flag for a torrent record in the database
+func (r *sqlRepository) SetWatchFlag(url string, watchPeriod int) error {
+	if watchPeriod > 0 && common.IsExternalTorrentUrl(url) {
+		return ErrExternalTorrent
+	}
+	_, err := r.exec("UPDATE torrents SET watch_every = ? WHERE url = ?", watchPeriod, common.CanonicalTorrentUrl(url))
+	return err
+}
+
+// SetDownloadPath is a function for setting the save path for a torrent record in the database
+func (r *sqlRepository) SetDownloadPath(url string, downloadPath string) error {
+	_, err := r.exec("UPDATE torrents SET download_path = ? WHERE url = ?", downloadPath, common.CanonicalTorrentUrl(url))
+	return err
+}
+
+// SetTags is a function for replacing tags of a torrent record in the database
+func (r *sqlRepository) SetTags(url string, tags []string) error {
+	_, err := r.exec("UPDATE torrents SET tags = ? WHERE url = ?", joinTags(tags), common.CanonicalTorrentUrl(url))
+	return err
+}
+
+// SetUpdateStrategy is a function for setting the update strategy of a torrent record in the database
+func (r *sqlRepository) SetUpdateStrategy(url string, strategy string) error {
+	_, err := r.exec("UPDATE torrents SET update_strategy = ? WHERE url = ?", strategy, common.CanonicalTorrentUrl(url))
+	return err
+}
+
+// SetCategory is a function for setting the qBittorrent category of a torrent record in the database
+func (r *sqlRepository) SetCategory(url string, category string) error {
+	_, err := r.exec("UPDATE torrents SET category = ? WHERE url = ?", category, common.CanonicalTorrentUrl(url))
+	return err
+}
+
+// withTx runs fn inside a transaction and maps constraint violations to repository errors
+func (r *sqlRepository) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return r.mapError(err)
+	}
+	return r.mapError(tx.Commit())
+}
+
+// mapError converts backend specific errors to repository errors
+func (r *sqlRepository) mapError(err error) error {
+	if err != nil && r.isUniqueViolation != nil && r.isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Ping checks the database connection
+func (r *sqlRepository) Ping() error {
+	return r.db.Ping()
+}
+
+// Close closes the database connection
+func (r *sqlRepository) Close() error {
+	return r.db.Close()
+}
@@ -2,50 +2,192 @@ package database
 
 import (
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"errors"
 	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// DefaultSQLitePath is the database file used when no DSN is configured
+const DefaultSQLitePath = "db/kinozaltv_monitor.db"
+
+// SQLiteRepository is a TorrentRepository backed by a local SQLite file
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+// NewSQLiteRepository opens (and creates if needed) the SQLite database at path
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	if path == "" {
+		path = DefaultSQLitePath
+	}
 
-// CreateSQLiteDB is a function for creating a database
-func CreateSQLiteDB() *sql.DB {
 	// Create database folder if not exists
-	folderName := "db"
-	err := os.MkdirAll(folderName, 0750)
-	if err != nil {
-		log.Fatal(err)
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
 	}
 
-	db, err := sql.Open("sqlite3", "db/kinozaltv_monitor.db")
+	// Wait for concurrent writers instead of failing with "database is locked"
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS torrents (
-		id INTEGER PRIMARY KEY,
-		title TEXT,
-		name TEXT,
-		hash TEXT,
-		url  TEXT
-	)`)
-	if err != nil {
-		log.Fatal(err)
+	repo := &SQLiteRepository{sqlRepository{db: db, rebind: questionMarks, isUniqueViolation: sqliteUniqueViolation}}
+	if err := repo.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
+	return repo, nil
+}
 
-	// Check if column watch_every exists
-	_, err = db.Exec(`SELECT watch_every FROM torrents LIMIT 1`)
-	if err != nil {
-		// Add column watch_every
-		_, err = db.Exec(`ALTER TABLE torrents ADD COLUMN watch_every INTEGER DEFAULT 0`)
+// sqliteMigrations is the schema history of the SQLite backend
+var sqliteMigrations = []migration{
+	{version: 1, name: "create_torrents", apply: func(tx *sql.Tx, _ func(string) string) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS torrents (
+			id INTEGER PRIMARY KEY,
+			title TEXT,
+			name TEXT,
+			hash TEXT,
+			url  TEXT
+		)`)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-	}
-	return db
+
+		// Databases created by older versions may lack watch_every
+		var count int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('torrents') WHERE name = 'watch_every'`).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			_, err = tx.Exec(`ALTER TABLE torrents ADD COLUMN watch_every INTEGER DEFAULT 0`)
+		}
+		return err
+	}},
+	{version: 2, name: "unique_torrent_url_and_hash", apply: canonicalizeTorrents},
+	{version: 3, name: "torrent_download_path_and_tags", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN download_path TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE torrents ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	)},
+	{version: 4, name: "create_torrent_history", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS torrent_history (
+			id INTEGER PRIMARY KEY,
+			type TEXT NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			hash TEXT NOT NULL DEFAULT '',
+			old_hash TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS torrent_history_url_idx ON torrent_history (url)`,
+	)},
+	{version: 5, name: "create_users", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'user',
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME
+		)`,
+	)},
+	{version: 6, name: "add_user_settings_and_watchers", apply: execStatements(
+		`ALTER TABLE users ADD COLUMN telegram_chat_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN download_path TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE torrents ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS torrent_watchers (
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			torrent_id INTEGER NOT NULL REFERENCES torrents (id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, torrent_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS torrent_watchers_torrent_idx ON torrent_watchers (torrent_id)`,
+	)},
+	{version: 7, name: "create_audit_log", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY,
+			actor TEXT NOT NULL,
+			actor_id INTEGER NOT NULL DEFAULT 0,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			before_value TEXT NOT NULL DEFAULT '',
+			after_value TEXT NOT NULL DEFAULT '',
+			source_ip TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`,
+		`CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target)`,
+	)},
+	{version: 8, name: "create_replacements", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS replacements (
+			id INTEGER PRIMARY KEY,
+			url TEXT NOT NULL,
+			old_hash TEXT NOT NULL,
+			new_hash TEXT NOT NULL,
+			new_title TEXT NOT NULL DEFAULT '',
+			save_path TEXT NOT NULL DEFAULT '',
+			state TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS replacements_state_idx ON replacements (state)`,
+	)},
+	{version: 9, name: "add_update_strategy", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN update_strategy TEXT NOT NULL DEFAULT 'replace'`,
+		`ALTER TABLE replacements ADD COLUMN strategy TEXT NOT NULL DEFAULT 'replace'`,
+	)},
+	{version: 10, name: "create_torrent_versions", apply: execStatements(
+		`CREATE TABLE IF NOT EXISTS torrent_versions (
+			id INTEGER PRIMARY KEY,
+			url TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			meta_version TEXT NOT NULL DEFAULT '',
+			total_size BIGINT NOT NULL DEFAULT 0,
+			piece_length BIGINT NOT NULL DEFAULT 0,
+			private BOOLEAN NOT NULL DEFAULT FALSE,
+			announce TEXT NOT NULL DEFAULT '',
+			files TEXT NOT NULL DEFAULT '[]',
+			created_at DATETIME,
+			recorded_at DATETIME NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS torrent_versions_url_hash_idx ON torrent_versions (url, hash)`,
+	)},
+	{version: 11, name: "add_qbittorrent_category", apply: execStatements(
+		`ALTER TABLE torrents ADD COLUMN category TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN category TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE replacements ADD COLUMN auto_tmm BOOLEAN NOT NULL DEFAULT FALSE`,
+	)},
+}
+
+// migrate creates the schema and upgrades databases created by older versions
+func (r *SQLiteRepository) migrate() error {
+	return r.runMigrations(sqliteMigrations)
 }
 
-func init() {
-	DB = CreateSQLiteDB()
+// sqliteUniqueViolation reports whether err is a UNIQUE constraint failure
+func sqliteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
 }
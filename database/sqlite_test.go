@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql"
+	"kinozaltv_monitor/models"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSQLiteRepository(t *testing.T) TorrentRepository {
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	testTorrentRepository(t, newTestSQLiteRepository)
+}
+
+func TestSQLiteRepository_MigratesLegacySchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Create the database as it was before watch_every and unique urls were introduced
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	statements := []string{
+		`CREATE TABLE torrents (id INTEGER PRIMARY KEY, title TEXT, name TEXT, hash TEXT, url TEXT)`,
+		`INSERT INTO torrents (title, name, hash, url) VALUES ('A', 'A', 'aaa', 'https://kinozal.tv/details.php?id=1')`,
+		`INSERT INTO torrents (title, name, hash, url) VALUES ('A', 'A', 'aaa', 'http://www.kinozal.tv/details.php?id=1')`,
+		`INSERT INTO torrents (title, name, hash, url) VALUES ('B', NULL, 'bbb', 'https://rutracker.org/forum/viewtopic.php?t=2&start=30')`,
+	}
+	for _, statement := range statements {
+		if _, err := legacy.Exec(statement); err != nil {
+			t.Fatalf("Failed to prepare legacy database: %v", err)
+		}
+	}
+	_ = legacy.Close()
+
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() on legacy schema failed: %v", err)
+	}
+	defer repo.Close()
+
+	records, err := repo.GetAllRecords()
+	if err != nil {
+		t.Fatalf("GetAllRecords() after migration failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected duplicates to be removed, got %+v", records)
+	}
+	if records[1].Url != "https://rutracker.org/forum/viewtopic.php?t=2" {
+		t.Errorf("Expected canonical url, got %s", records[1].Url)
+	}
+
+	if err := repo.AddRecord(models.Torrent{Title: "A", Hash: "other", Url: "https://www.kinozal.tv/details.php?id=1"}); err != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate for an existing url, got %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// User roles
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Account errors
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUserExists      = errors.New("user already exists")
+	ErrSessionNotFound = errors.New("session not found")
+	ErrTokenNotFound   = errors.New("api token not found")
+)
+
+// User is an account that can sign in to the web UI and own API tokens.
+// TelegramChatID and DownloadPath are the user's notification target and default save path.
+type User struct {
+	ID             int       `json:"id"`
+	Username       string    `json:"username"`
+	PasswordHash   string    `json:"-"`
+	Role           string    `json:"role"`
+	TelegramChatID string    `json:"telegram_chat_id"`
+	DownloadPath   string    `json:"download_path"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Session is a signed in browser. Only the hash of the cookie value is stored.
+type Session struct {
+	TokenHash string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// APIToken lets scripts authenticate as a user. Only the hash of the token is stored.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// userColumns is the column list read by scanUser
+const userColumns = "id, username, password_hash, role, telegram_chat_id, download_path, created_at"
+
+// scanUser reads a user selected with userColumns
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.TelegramChatID, &u.DownloadPath, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+// CreateUser is a function for adding a user, returns ErrUserExists if the username is taken
+func (r *sqlRepository) CreateUser(user User) (User, error) {
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now().UTC()
+	}
+	err := r.queryRow(`INSERT INTO users (username, password_hash, role, telegram_chat_id, download_path, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		user.Username, user.PasswordHash, user.Role, user.TelegramChatID, user.DownloadPath, user.CreatedAt).Scan(&user.ID)
+	if err != nil && r.isUniqueViolation(err) {
+		return User{}, ErrUserExists
+	}
+	return user, err
+}
+
+// GetUserByName is a function for getting a user by username
+func (r *sqlRepository) GetUserByName(username string) (User, error) {
+	return scanUser(r.queryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+// GetUserByID is a function for getting a user by id
+func (r *sqlRepository) GetUserByID(id int) (User, error) {
+	return scanUser(r.queryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// ListUsers is a function for getting all users ordered by id
+func (r *sqlRepository) ListUsers() ([]User, error) {
+	return r.queryUsers("SELECT " + userColumns + " FROM users ORDER BY id")
+}
+
+// queryUsers runs a query selecting userColumns and reads all rows
+func (r *sqlRepository) queryUsers(query string, args ...interface{}) (users []User, err error) {
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	users = make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUser is a function for saving role, Telegram chat and default download path of a user
+func (r *sqlRepository) UpdateUser(user User) error {
+	result, err := r.exec("UPDATE users SET role = ?, telegram_chat_id = ?, download_path = ? WHERE id = ?",
+		user.Role, user.TelegramChatID, user.DownloadPath, user.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser is a function for removing a user with everything that belongs to the account
+func (r *sqlRepository) DeleteUser(id int) error {
+	deleted := false
+	err := r.withTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"torrent_watchers", "api_tokens", "sessions"} {
+			if _, err := tx.Exec(r.rebind("DELETE FROM "+table+" WHERE user_id = ?"), id); err != nil {
+				return err
+			}
+		}
+		result, err := tx.Exec(r.rebind("DELETE FROM users WHERE id = ?"), id)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		deleted = n > 0
+		return err
+	})
+	if err == nil && !deleted {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// CountUsers is a function for counting user accounts
+func (r *sqlRepository) CountUsers() (count int, err error) {
+	err = r.queryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// SetPassword is a function for replacing the password hash of a user
+func (r *sqlRepository) SetPassword(userID int, passwordHash string) error {
+	result, err := r.exec("UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// CreateSession is a function for storing a new session
+func (r *sqlRepository) CreateSession(session Session) error {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now().UTC()
+	}
+	_, err := r.exec("INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		session.TokenHash, session.UserID, session.CreatedAt, session.ExpiresAt.UTC())
+	return err
+}
+
+// GetSession is a function for getting an unexpired session by token hash
+func (r *sqlRepository) GetSession(tokenHash string) (Session, error) {
+	var s Session
+	err := r.queryRow("SELECT token_hash, user_id, created_at, expires_at FROM sessions WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, time.Now().UTC()).Scan(&s.TokenHash, &s.UserID, &s.CreatedAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrSessionNotFound
+	}
+	return s, err
+}
+
+// DeleteSession is a function for signing out a session
+func (r *sqlRepository) DeleteSession(tokenHash string) error {
+	_, err := r.exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteExpiredSessions is a function for removing sessions that expired before now
+func (r *sqlRepository) DeleteExpiredSessions(now time.Time) error {
+	_, err := r.exec("DELETE FROM sessions WHERE expires_at <= ?", now.UTC())
+	return err
+}
+
+// CreateAPIToken is a function for storing a new API token
+func (r *sqlRepository) CreateAPIToken(token APIToken) (APIToken, error) {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now().UTC()
+	}
+	err := r.queryRow("INSERT INTO api_tokens (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?) RETURNING id",
+		token.UserID, token.Name, token.TokenHash, token.CreatedAt).Scan(&token.ID)
+	return token, err
+}
+
+// apiTokenColumns is the column list read by scanAPIToken
+const apiTokenColumns = "id, user_id, name, token_hash, created_at, last_used_at"
+
+// scanAPIToken reads an API token selected with apiTokenColumns
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var lastUsed sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.CreatedAt, &lastUsed)
+	if err == sql.ErrNoRows {
+		return APIToken{}, ErrTokenNotFound
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	return t, err
+}
+
+// GetAPIToken is a function for getting an API token by token hash and marking it as used
+func (r *sqlRepository) GetAPIToken(tokenHash string) (APIToken, error) {
+	token, err := scanAPIToken(r.queryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+	if err != nil {
+		return APIToken{}, err
+	}
+	now := time.Now().UTC()
+	if _, err := r.exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+		return APIToken{}, err
+	}
+	token.LastUsedAt = &now
+	return token, nil
+}
+
+// ListAPITokens is a function for getting the API tokens of a user
+func (r *sqlRepository) ListAPITokens(userID int) (tokens []APIToken, err error) {
+	rows, err := r.query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	tokens = make([]APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken is a function for revoking an API token of a user
+func (r *sqlRepository) DeleteAPIToken(userID, id int) error {
+	result, err := r.exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
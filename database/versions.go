@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/models"
+	"strings"
+	"time"
+)
+
+// TorrentVersion is the metadata of one version of a torrent, recorded from its .torrent
+// file when the version is added to qBittorrent. CreatedAt is the creation date of the
+// .torrent file, RecordedAt when the version was seen first.
+type TorrentVersion struct {
+	ID          int               `json:"id"`
+	Url         string            `json:"url"`
+	Hash        string            `json:"hash"`
+	Name        string            `json:"name"`
+	MetaVersion string            `json:"meta_version"`
+	TotalSize   int64             `json:"total_size"`
+	PieceLength int64             `json:"piece_length"`
+	Private     bool              `json:"private"`
+	Announce    []string          `json:"announce"`
+	Files       []models.MetaFile `json:"files"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	RecordedAt  time.Time         `json:"recorded_at"`
+}
+
+// NewTorrentVersion returns the version of the torrent of url described by meta
+func NewTorrentVersion(url string, meta models.TorrentMeta) TorrentVersion {
+	version := TorrentVersion{
+		Url:         url,
+		Hash:        meta.Hash(),
+		Name:        meta.Name,
+		MetaVersion: meta.MetaVersion,
+		TotalSize:   meta.TotalSize,
+		PieceLength: meta.PieceLength,
+		Private:     meta.Private,
+		Announce:    meta.Announce,
+		Files:       meta.Files,
+	}
+	if !meta.CreatedAt.IsZero() {
+		created := meta.CreatedAt
+		version.CreatedAt = &created
+	}
+	return version
+}
+
+// torrentVersionColumns is the column list read by scanTorrentVersion
+const torrentVersionColumns = "id, url, hash, name, meta_version, total_size, piece_length, private, announce, files, created_at, recorded_at"
+
+// AddTorrentVersion is a function for recording a torrent version, a version that is
+// already recorded for the url is left unchanged
+func (r *sqlRepository) AddTorrentVersion(version TorrentVersion) error {
+	files, err := json.Marshal(version.Files)
+	if err != nil {
+		return err
+	}
+	var created sql.NullTime
+	if version.CreatedAt != nil {
+		created = sql.NullTime{Time: version.CreatedAt.UTC(), Valid: true}
+	}
+	_, err = r.exec(`INSERT INTO torrent_versions (url, hash, name, meta_version, total_size, piece_length, private, announce, files, created_at, recorded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (url, hash) DO NOTHING`,
+		common.CanonicalTorrentUrl(version.Url), version.Hash, version.Name, version.MetaVersion, version.TotalSize, version.PieceLength,
+		version.Private, strings.Join(version.Announce, "\n"), string(files), created, time.Now().UTC())
+	return err
+}
+
+// GetTorrentVersions is a function for getting the recorded versions of a torrent, newest first
+func (r *sqlRepository) GetTorrentVersions(url string) (versions []TorrentVersion, err error) {
+	rows, err := r.query("SELECT "+torrentVersionColumns+" FROM torrent_versions WHERE url = ? ORDER BY id DESC",
+		common.CanonicalTorrentUrl(url))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	versions = make([]TorrentVersion, 0)
+	for rows.Next() {
+		version, err := scanTorrentVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// scanTorrentVersion reads a row selected with torrentVersionColumns
+func scanTorrentVersion(row rowScanner) (TorrentVersion, error) {
+	var v TorrentVersion
+	var announce, files string
+	var created sql.NullTime
+	err := row.Scan(&v.ID, &v.Url, &v.Hash, &v.Name, &v.MetaVersion, &v.TotalSize, &v.PieceLength, &v.Private,
+		&announce, &files, &created, &v.RecordedAt)
+	if err != nil {
+		return TorrentVersion{}, err
+	}
+	v.Announce = []string{}
+	if announce != "" {
+		v.Announce = strings.Split(announce, "\n")
+	}
+	if err := json.Unmarshal([]byte(files), &v.Files); err != nil {
+		return TorrentVersion{}, err
+	}
+	if created.Valid {
+		v.CreatedAt = &created.Time
+	}
+	return v, nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/events"
+	"time"
+)
+
+// watchersChanged announces a change of the watch list of userID, so that e.g. the
+// live feed can update the torrents it shows the user
+func watchersChanged(userID int, url string) {
+	events.Publish(events.Event{Type: events.WatchersChanged, UserID: userID, Url: url})
+}
+
+// GetRecordsForUser is a function for getting the torrent records watched by a user
+func (r *sqlRepository) GetRecordsForUser(userID int) ([]Torrent, error) {
+	return r.queryTorrents(`SELECT `+torrentColumns+` FROM torrents
+		WHERE id IN (SELECT torrent_id FROM torrent_watchers WHERE user_id = ?) ORDER BY id`, userID)
+}
+
+// AddWatcher is a function for adding a torrent record to the watch list of a user
+func (r *sqlRepository) AddWatcher(userID int, url string) error {
+	torrent, err := r.GetRecordByUrl(url)
+	if err != nil {
+		return err
+	}
+	err = r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind(`INSERT INTO torrent_watchers (user_id, torrent_id, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, torrent_id) DO NOTHING`), userID, torrent.ID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.rebind("UPDATE torrents SET owner_id = ? WHERE id = ? AND owner_id = 0"), userID, torrent.ID)
+		return err
+	})
+	if err == nil {
+		watchersChanged(userID, torrent.Url)
+	}
+	return err
+}
+
+// RemoveWatcher is a function for removing a torrent record from the watch list of a user
+func (r *sqlRepository) RemoveWatcher(userID int, url string) (remaining int, err error) {
+	torrent, err := r.GetRecordByUrl(url)
+	if err != nil {
+		return 0, err
+	}
+	err = r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind("DELETE FROM torrent_watchers WHERE user_id = ? AND torrent_id = ?"), userID, torrent.ID)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(r.rebind("SELECT COUNT(*) FROM torrent_watchers WHERE torrent_id = ?"), torrent.ID).Scan(&remaining)
+	})
+	if err == nil {
+		watchersChanged(userID, torrent.Url)
+	}
+	return remaining, err
+}
+
+// IsWatching is a function for checking whether a user watches a torrent record
+func (r *sqlRepository) IsWatching(userID int, url string) (bool, error) {
+	var count int
+	err := r.queryRow(`SELECT COUNT(*) FROM torrent_watchers w JOIN torrents t ON t.id = w.torrent_id
+		WHERE w.user_id = ? AND t.url = ?`, userID, common.CanonicalTorrentUrl(url)).Scan(&count)
+	return count > 0, err
+}
+
+// GetWatchers is a function for getting the users watching a torrent record
+func (r *sqlRepository) GetWatchers(url string) ([]User, error) {
+	return r.queryUsers(`SELECT `+userColumns+` FROM users WHERE id IN (
+		SELECT w.user_id FROM torrent_watchers w JOIN torrents t ON t.id = w.torrent_id WHERE t.url = ?) ORDER BY id`,
+		common.CanonicalTorrentUrl(url))
+}
+
+// AdoptUnwatchedTorrents is a function for giving torrent records nobody watches to a user
+func (r *sqlRepository) AdoptUnwatchedTorrents(userID int) error {
+	err := r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.rebind(`INSERT INTO torrent_watchers (user_id, torrent_id, created_at)
+			SELECT ?, id, ? FROM torrents WHERE id NOT IN (SELECT torrent_id FROM torrent_watchers)`), userID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.rebind("UPDATE torrents SET owner_id = ? WHERE owner_id = 0"), userID)
+		return err
+	})
+	if err == nil {
+		watchersChanged(userID, "")
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/jobs"
+	logger "kinozaltv_monitor/logging"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var log = logger.New("events")
+
+// Type identifies what happened
+type Type string
+
+// Event types published by the checker, the add pipeline and the trackers
+const (
+	TorrentAdded       Type = "torrent_added"
+	TorrentUpdated     Type = "torrent_updated"
+	CheckCompleted     Type = "check_completed"
+	TrackerLoginFailed Type = "tracker_login_failed"
+	ClientUnavailable  Type = "client_unavailable"
+	JobUpdated         Type = "job_updated"
+	BulkProgress       Type = "bulk_progress"
+	TorrentRemoved     Type = "torrent_removed"
+	TorrentProgress    Type = "torrent_progress"
+	TorrentAdopted     Type = "torrent_adopted"
+	WatchersChanged    Type = "watchers_changed"
+)
+
+// Event is a typed notification. Fields that do not apply to a type are left empty.
+type Event struct {
+	Type    Type      `json:"type"`
+	Time    time.Time `json:"time"`
+	Url     string    `json:"url,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	OldHash string    `json:"old_hash,omitempty"`
+	Tracker string    `json:"tracker,omitempty"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	Mode    string    `json:"mode,omitempty"`
+	Job     *jobs.Job `json:"job,omitempty"`
+	Bulk    *BulkStep `json:"bulk,omitempty"`
+	// Status is the live state of the torrent in qBittorrent for TorrentProgress
+	Status *common.TorrentStatus `json:"status,omitempty"`
+	// UserID is the user whose watch list changed for WatchersChanged, 0 when the
+	// torrent of Url was removed together with all of its watchers
+	UserID int `json:"user_id,omitempty"`
+}
+
+// BulkStep reports the outcome of one torrent of a bulk operation
+type BulkStep struct {
+	ID        string `json:"id"`
+	Action    string `json:"action"`
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	TorrentID int    `json:"torrent_id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	// UserID is the user who started the operation, 0 when auth is disabled
+	UserID int `json:"-"`
+}
+
+// Subscription receives events of the requested types through its own buffer
+type Subscription struct {
+	Name    string
+	C       <-chan Event
+	ch      chan Event
+	types   map[Type]bool
+	dropped atomic.Uint64
+}
+
+// Dropped returns how many events were discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// wants reports whether the subscription receives events of type t
+func (s *Subscription) wants(t Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: when a subscriber's
+// buffer is full the event is dropped for that subscriber only.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// GlobalBus is the event bus shared by all packages
+var GlobalBus = NewBus()
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber with a buffer of the given size.
+// Without types the subscriber receives every event.
+func (b *Bus) Subscribe(name string, buffer int, types ...Type) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{Name: name, C: ch, ch: ch, types: make(map[Type]bool)}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Close unsubscribes every subscriber. Subscribers still receive the events already
+// in their buffer before their channel is drained; later events are discarded.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscriptions returns the current subscribers
+func (b *Bus) Subscriptions() []*Subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	subs := make([]*Subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// Publish delivers e to every interested subscriber without waiting
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.wants(e.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			dropped := sub.dropped.Add(1)
+			log.Error("event_dropped", "Subscriber buffer is full, event dropped", map[string]string{
+				"subscriber": sub.Name,
+				"event_type": string(e.Type),
+				"dropped":    strconv.FormatUint(dropped, 10),
+			})
+		}
+	}
+}
+
+// Publish publishes e on GlobalBus
+func Publish(e Event) {
+	GlobalBus.Publish(e)
+}
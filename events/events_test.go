@@ -0,0 +1,93 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusDeliversByType(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe("all", 10)
+	updates := bus.Subscribe("updates", 10, TorrentUpdated)
+
+	bus.Publish(Event{Type: CheckCompleted, Url: "a"})
+	bus.Publish(Event{Type: TorrentUpdated, Url: "b"})
+
+	if len(all.C) != 2 {
+		t.Errorf("Expected 2 events for the catch-all subscriber, got %d", len(all.C))
+	}
+	if len(updates.C) != 1 {
+		t.Fatalf("Expected 1 event for the filtered subscriber, got %d", len(updates.C))
+	}
+	if e := <-updates.C; e.Url != "b" || e.Time.IsZero() {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+}
+
+func TestBusSlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	slow := bus.Subscribe("slow", 1)
+	fast := bus.Subscribe("fast", 10)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			bus.Publish(Event{Type: CheckCompleted})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if slow.Dropped() != 4 {
+		t.Errorf("Expected 4 dropped events, got %d", slow.Dropped())
+	}
+	if len(fast.C) != 5 || fast.Dropped() != 0 {
+		t.Errorf("Fast subscriber should get every event, got %d (dropped %d)", len(fast.C), fast.Dropped())
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe("temp", 1)
+	bus.Unsubscribe(sub)
+	bus.Publish(Event{Type: CheckCompleted})
+
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected channel to be closed")
+	}
+}
+
+func TestCloseDrainsBufferedEvents(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe("history", 10)
+	bus.Publish(Event{Type: TorrentAdded, Url: "a"})
+	bus.Close()
+	bus.Publish(Event{Type: TorrentAdded, Url: "b"})
+
+	var urls []string
+	for e := range sub.C {
+		urls = append(urls, e.Url)
+	}
+	if len(urls) != 1 || urls[0] != "a" || len(bus.Subscriptions()) != 0 {
+		t.Errorf("Expected only the event published before Close, got %v", urls)
+	}
+}
+
+func TestStatsCountsEvents(t *testing.T) {
+	bus := NewBus()
+	stats := NewStats()
+	sub := bus.Subscribe("stats", 10)
+
+	bus.Publish(Event{Type: TorrentAdded})
+	bus.Publish(Event{Type: TorrentAdded})
+	bus.Unsubscribe(sub)
+	stats.Run(sub)
+
+	if counts := stats.Counts(); counts[TorrentAdded] != 2 {
+		t.Errorf("Expected 2 torrent_added events, got %v", counts)
+	}
+}
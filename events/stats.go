@@ -0,0 +1,60 @@
+package events
+
+import "sync"
+
+// Stats is a subscriber that counts events by type
+type Stats struct {
+	mu     sync.Mutex
+	counts map[Type]uint64
+}
+
+// SubscriberStats describes the state of a subscription
+type SubscriberStats struct {
+	Name     string `json:"name"`
+	Buffered int    `json:"buffered"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// GlobalStats counts events published on GlobalBus once Run is started
+var GlobalStats = NewStats()
+
+// NewStats creates an empty counter
+func NewStats() *Stats {
+	return &Stats{counts: make(map[Type]uint64)}
+}
+
+// Run counts events from sub until it is unsubscribed
+func (s *Stats) Run(sub *Subscription) {
+	for e := range sub.C {
+		s.mu.Lock()
+		s.counts[e.Type]++
+		s.mu.Unlock()
+	}
+}
+
+// Counts returns a copy of the counters
+func (s *Stats) Counts() map[Type]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[Type]uint64, len(s.counts))
+	for t, n := range s.counts {
+		counts[t] = n
+	}
+	return counts
+}
+
+// Subscribers returns buffer usage and drop counters of all subscribers of b
+func (b *Bus) Subscribers() []SubscriberStats {
+	subs := b.Subscriptions()
+	stats := make([]SubscriberStats, 0, len(subs))
+	for _, sub := range subs {
+		stats = append(stats, SubscriberStats{
+			Name:     sub.Name,
+			Buffered: len(sub.ch),
+			Capacity: cap(sub.ch),
+			Dropped:  sub.Dropped(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// State is a step of the add pipeline a job is in
+type State string
+
+// Job states. Added, duplicate and failed are final.
+const (
+	StateQueued      State = "queued"
+	StateResolving   State = "resolving"
+	StateDownloading State = "downloading"
+	StateAdded       State = "added"
+	StateDuplicate   State = "duplicate"
+	StateFailed      State = "failed"
+)
+
+// Final reports whether no further transitions are expected
+func (s State) Final() bool {
+	return s == StateAdded || s == StateDuplicate || s == StateFailed
+}
+
+// Job tracks a single request to add a torrent
+type Job struct {
+	ID           string    `json:"id"`
+	UserID       int       `json:"user_id,omitempty"`
+	Url          string    `json:"url"`
+	DownloadPath string    `json:"download_path"`
+	State        State     `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Store keeps jobs in memory. Once more than limit jobs are stored the oldest
+// finished ones are forgotten.
+type Store struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	limit int
+}
+
+// DefaultLimit is the number of jobs GlobalStore remembers
+const DefaultLimit = 1000
+
+// GlobalStore is the job store shared by the API and the add pipeline
+var GlobalStore = NewStore(DefaultLimit)
+
+// NewStore creates an empty job store
+func NewStore(limit int) *Store {
+	return &Store{
+		jobs:  make(map[string]*Job),
+		limit: limit,
+	}
+}
+
+// newID returns a random job ID
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("150405.000000")))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Create registers a new queued job started by userID, 0 when auth is disabled
+func (s *Store) Create(userID int, url, downloadPath string) Job {
+	now := time.Now()
+	job := &Job{
+		ID:           newID(),
+		UserID:       userID,
+		Url:          url,
+		DownloadPath: downloadPath,
+		State:        StateQueued,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	s.evict()
+	return *job
+}
+
+// Get returns a copy of the job with the given ID
+func (s *Store) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns copies of all stored jobs, newest first
+func (s *Store) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Job, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		list = append(list, *s.jobs[s.order[i]])
+	}
+	return list
+}
+
+// Update moves a job to state, applies optional changes and returns the updated copy.
+// Unknown IDs return false.
+func (s *Store) Update(id string, state State, changes ...func(job *Job)) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	job.State = state
+	job.UpdatedAt = time.Now()
+	for _, change := range changes {
+		change(job)
+	}
+	return *job, true
+}
+
+// Fail moves a job to the failed state with a reason
+func (s *Store) Fail(id string, reason error) (Job, bool) {
+	return s.Update(id, StateFailed, func(job *Job) {
+		job.Error = reason.Error()
+	})
+}
+
+// evict forgets the oldest finished jobs while the store is over its limit
+func (s *Store) evict() {
+	for i := 0; len(s.order) > s.limit && i < len(s.order); {
+		id := s.order[i]
+		if s.jobs[id].State.Final() {
+			delete(s.jobs, id)
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
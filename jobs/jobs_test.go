@@ -0,0 +1,55 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStoreLifecycle(t *testing.T) {
+	store := NewStore(10)
+
+	job := store.Create(0, "https://kinozal.tv/details.php?id=1", "/downloads")
+	if job.ID == "" || job.State != StateQueued {
+		t.Fatalf("Unexpected new job: %+v", job)
+	}
+
+	updated, ok := store.Update(job.ID, StateResolving, func(j *Job) { j.Hash = "aaa" })
+	if !ok || updated.State != StateResolving || updated.Hash != "aaa" {
+		t.Errorf("Unexpected updated job: %+v", updated)
+	}
+
+	failed, ok := store.Fail(job.ID, errors.New("tracker is down"))
+	if !ok || failed.State != StateFailed || failed.Error != "tracker is down" {
+		t.Errorf("Unexpected failed job: %+v", failed)
+	}
+
+	got, ok := store.Get(job.ID)
+	if !ok || got != failed {
+		t.Errorf("Get() returned %+v, expected %+v", got, failed)
+	}
+
+	if _, ok := store.Update("missing", StateAdded); ok {
+		t.Error("Update() of an unknown job must fail")
+	}
+}
+
+func TestStoreEvictsOnlyFinishedJobs(t *testing.T) {
+	store := NewStore(2)
+
+	running := store.Create(0, "running", "")
+	finished := store.Create(0, "finished", "")
+	store.Update(finished.ID, StateAdded)
+	newest := store.Create(0, "newest", "")
+
+	if _, ok := store.Get(finished.ID); ok {
+		t.Error("Expected the finished job to be evicted")
+	}
+	for _, id := range []string{running.ID, newest.ID} {
+		if _, ok := store.Get(id); !ok {
+			t.Errorf("Expected job %s to be kept", id)
+		}
+	}
+	if list := store.List(); len(list) != 2 || list[0].ID != newest.ID {
+		t.Errorf("Expected newest job first, got %+v", list)
+	}
+}
@@ -0,0 +1,291 @@
+// Package metrics collects counters, gauges and histograms and exposes them in the
+// Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram buckets in seconds used for request and check durations
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// collector is a metric family that can write itself in the text format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families in registration order
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	onScrape   []func()
+}
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// OnScrape registers fn to run before every scrape, e.g. to set gauges that are
+// cheaper to compute on demand than to keep up to date
+func (r *Registry) OnScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onScrape = append(r.onScrape, fn)
+}
+
+// WriteText writes all metrics of r in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	onScrape := append([]func(){}, r.onScrape...)
+	r.mu.Unlock()
+
+	for _, fn := range onScrape {
+		fn()
+	}
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// family holds the label names and the values of a metric family by label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string][]string
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, series: make(map[string][]string)}
+}
+
+// key returns the series key of label values, remembering the values for output
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := f.series[key]; !ok {
+		f.series[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns the series keys in a stable order
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+}
+
+// labelPairs formats label values as {name="value",...}, extra pairs are appended as is
+func (f *family) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+len(extra))
+	for i, value := range values {
+		pairs = append(pairs, f.labels[i]+`="`+escapeLabel(value)+`"`)
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeHelp escapes a help text, which unlike label values may contain quotes
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a metric family that only goes up
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names in Default
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels), values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the given label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(values)] += v
+}
+
+// Value returns the counter with the given label values
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(values, "\xff")]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(c.series[key]), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a metric family that can go up and down
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+// NewGauge registers a gauge with the given label names in Default
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels), values: make(map[string]float64)}
+	Default.register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values to v
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(values)] = v
+}
+
+// Reset forgets all label values, e.g. before setting the gauge from scratch
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = make(map[string]float64)
+	g.series = make(map[string][]string)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(g.series[key]), formatFloat(g.values[key]))
+	}
+}
+
+// histogramValue holds the observations of a single histogram series
+type histogramValue struct {
+	counts []uint64 // cumulative count per bucket
+	count  uint64
+	sum    float64
+}
+
+// Histogram is a metric family counting observations in buckets
+type Histogram struct {
+	family
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+// NewHistogram registers a histogram with the given upper bounds and label names in Default
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(values)
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// ObserveSince records the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns the number of observations with the given label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if value, ok := h.values[strings.Join(values, "\xff")]; ok {
+		return value.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		labels, value := h.series[key], h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(labels, `le="`+formatFloat(bound)+`"`), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(labels, `le="+Inf"`), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(labels), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(labels), value.count)
+	}
+}
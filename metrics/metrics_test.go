@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := NewCounter("test_requests_total", "Requests.", "path")
+	inFlight := NewGauge("test_in_flight", "Requests in flight.")
+	latency := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+	registry.register(requests)
+	registry.register(inFlight)
+	registry.register(latency)
+	registry.OnScrape(func() { inFlight.Set(3) })
+
+	requests.Inc(`/a"b`)
+	requests.Add(2, "/c")
+	latency.Observe(0.05, "/c")
+	latency.Observe(0.5, "/c")
+	latency.Observe(5, "/c")
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() failed: %v", err)
+	}
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{path="/a\"b"} 1
+test_requests_total{path="/c"} 2
+# HELP test_in_flight Requests in flight.
+# TYPE test_in_flight gauge
+test_in_flight 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{path="/c",le="0.1"} 1
+test_latency_seconds_bucket{path="/c",le="1"} 2
+test_latency_seconds_bucket{path="/c",le="+Inf"} 3
+test_latency_seconds_sum{path="/c"} 5.55
+test_latency_seconds_count{path="/c"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+	if requests.Value("/c") != 2 || latency.Count("/c") != 3 {
+		t.Errorf("Unexpected values %v and %d", requests.Value("/c"), latency.Count("/c"))
+	}
+}
+
+// TestRegistry_ParsesAsPrometheusText runs the output through the parser of Prometheus,
+// so that escaping mistakes in help texts and label values are caught
+func TestRegistry_ParsesAsPrometheusText(t *testing.T) {
+	registry := NewRegistry()
+	requests := NewCounter("test_parsed_total", `Requests by path, e.g. C:\dir or "quoted".`+"\nSecond line.", "path")
+	latency := NewHistogram("test_parsed_seconds", "Latency.", DefaultBuckets, "path")
+	registry.register(requests)
+	registry.register(latency)
+
+	paths := []string{`/a"b`, `C:\dir\`, "line\nbreak", "путь", ""}
+	for i, path := range paths {
+		requests.Add(float64(i+1), path)
+		latency.Observe(0.3, path)
+	}
+
+	// The metrics of the application are valid as well
+	parseText(t, Default)
+	families := parseText(t, registry)
+	if help := families["test_parsed_total"].GetHelp(); help != requests.help {
+		t.Errorf("Expected help %q, got %q", requests.help, help)
+	}
+	counts := make(map[string]float64)
+	for _, metric := range families["test_parsed_total"].GetMetric() {
+		counts[labelValue(metric, "path")] = metric.GetCounter().GetValue()
+	}
+	for i, path := range paths {
+		if counts[path] != float64(i+1) {
+			t.Errorf("Expected %v for path %q, got %v", i+1, path, counts[path])
+		}
+	}
+	for _, metric := range families["test_parsed_seconds"].GetMetric() {
+		if histogram := metric.GetHistogram(); histogram.GetSampleCount() != 1 || len(histogram.GetBucket()) != len(DefaultBuckets)+1 {
+			t.Errorf("Unexpected histogram for path %q: %v", labelValue(metric, "path"), histogram)
+		}
+	}
+}
+
+// parseText parses the output of r with the parser of Prometheus
+func parseText(t *testing.T, r *Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() failed: %v", err)
+	}
+	text := buf.String()
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	families, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		t.Fatalf("Output is not valid Prometheus text: %v\n%s", err, text)
+	}
+	return families
+}
+
+// labelValue returns the value of the label name of metric
+func labelValue(metric *dto.Metric, name string) string {
+	for _, pair := range metric.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestGauge_Reset(t *testing.T) {
+	g := NewGauge("test_states", "States.", "state")
+	g.Set(1, "ok")
+	g.Reset()
+	g.Set(2, "failing")
+
+	var buf bytes.Buffer
+	if err := Default.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() failed: %v", err)
+	}
+	if strings.Contains(buf.String(), `test_states{state="ok"}`) || !strings.Contains(buf.String(), `test_states{state="failing"} 2`) {
+		t.Errorf("Expected only the state set after Reset, got:\n%s", buf.String())
+	}
+}
+
+func TestCounter_WrongLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for missing label values")
+		}
+	}()
+	NewCounter("test_wrong_total", "Wrong.", "a", "b").Inc("a")
+}
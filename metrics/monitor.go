@@ -0,0 +1,33 @@
+package metrics
+
+// Outcomes of a torrent check
+const (
+	CheckUpToDate = "up_to_date"
+	CheckUpdated  = "updated"
+	CheckReadded  = "readded"
+	CheckFailed   = "failed"
+)
+
+// Metrics of the monitor, exposed on /metrics
+var (
+	Checks = NewCounter("kinozal_monitor_checks_total",
+		"Torrent checks by tracker and outcome.", "tracker", "outcome")
+	CheckDuration = NewHistogram("kinozal_monitor_check_duration_seconds",
+		"Duration of torrent checks by tracker.", DefaultBuckets, "tracker")
+	UpdatesDetected = NewCounter("kinozal_monitor_updates_detected_total",
+		"Torrent updates found on the tracker.", "tracker")
+	TrackerLogins = NewCounter("kinozal_monitor_tracker_login_attempts_total",
+		"Tracker login attempts.", "tracker")
+	TrackerLoginFailures = NewCounter("kinozal_monitor_tracker_login_failures_total",
+		"Failed tracker login attempts.", "tracker")
+	QbittorrentRequestDuration = NewHistogram("kinozal_monitor_qbittorrent_request_duration_seconds",
+		"Latency of qBittorrent Web API requests by endpoint.", DefaultBuckets, "endpoint")
+	QbittorrentErrors = NewCounter("kinozal_monitor_qbittorrent_errors_total",
+		"qBittorrent Web API requests that failed or were answered with an error status.", "endpoint")
+	WebSocketClients = NewGauge("kinozal_monitor_websocket_clients",
+		"Connected WebSocket clients.")
+	AddQueueDepth = NewGauge("kinozal_monitor_add_queue_depth",
+		"Torrent urls waiting in the add channel.")
+	WatchedTorrents = NewGauge("kinozal_monitor_torrents",
+		"Torrents in the database by state.", "state")
+)
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// loginPage is where unauthenticated browsers are sent
+const loginPage = "/login.html"
+
+// Auth requires a session cookie or an API token on every route except publicPaths.
+// API and WebSocket requests are answered with 401, the web UI redirects to the login page.
+// Cookie authenticated requests that change state must come from an allowed origin.
+func Auth(repo database.TorrentRepository, allowedOrigins []string, publicPaths ...string) echo.MiddlewareFunc {
+	public := make(map[string]bool, len(publicPaths))
+	for _, path := range publicPaths {
+		public[path] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := c.Request().URL.Path
+			if public[path] {
+				return next(c)
+			}
+
+			user, err := auth.Authenticate(repo, c)
+			if err != nil {
+				if err != auth.ErrUnauthenticated {
+					log.Error("authenticate", err.Error(), map[string]string{"path": path})
+				}
+				if strings.HasPrefix(path, "/api/") || path == "/ws" {
+					// Return 401 Unauthorized
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": auth.ErrUnauthenticated.Error()})
+				}
+				return c.Redirect(http.StatusFound, loginPage)
+			}
+
+			usesCookie := c.Request().Header.Get(echo.HeaderAuthorization) == ""
+			if usesCookie && !safeMethod(c.Request().Method) && !auth.OriginAllowed(c.Request(), allowedOrigins) {
+				// Return 403 Forbidden
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "origin not allowed"})
+			}
+
+			auth.SetUser(c, user)
+			return next(c)
+		}
+	}
+}
+
+// RequireAdmin allows only admins through. Without auth there is no signed in
+// user and every request passes.
+func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := auth.CurrentUser(c)
+		if ok && user.Role != database.RoleAdmin {
+			// Return 403 Forbidden
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "admin role required"})
+		}
+		return next(c)
+	}
+}
+
+// safeMethod reports whether method does not change state
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
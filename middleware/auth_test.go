@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"kinozaltv_monitor/auth"
+	"kinozaltv_monitor/database"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuth(t *testing.T) {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+
+	if err := auth.EnsureAdmin(repo, "admin", "correct horse"); err != nil {
+		t.Fatalf("EnsureAdmin() failed: %v", err)
+	}
+	user, session, err := auth.Login(repo, "admin", "correct horse", time.Hour)
+	if err != nil {
+		t.Fatalf("Login() failed: %v", err)
+	}
+	_, apiToken, err := auth.CreateAPIToken(repo, user.ID, "script")
+	if err != nil {
+		t.Fatalf("CreateAPIToken() failed: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(Auth(repo, nil, "/login.html", "/api/auth/login"))
+	handler := func(c echo.Context) error {
+		current, _ := auth.CurrentUser(c)
+		return c.String(http.StatusOK, current.Username)
+	}
+	e.GET("/", handler)
+	e.GET("/login.html", handler)
+	e.GET("/api/torrents", handler)
+	e.DELETE("/api/remove", handler)
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		cookie     string
+		bearer     string
+		origin     string
+		wantStatus int
+	}{
+		{name: "Public page", method: "GET", path: "/login.html", wantStatus: 200},
+		{name: "UI redirects to login", method: "GET", path: "/", wantStatus: 302},
+		{name: "API without credentials", method: "GET", path: "/api/torrents", wantStatus: 401},
+		{name: "API with session", method: "GET", path: "/api/torrents", cookie: session, wantStatus: 200},
+		{name: "API with token", method: "DELETE", path: "/api/remove", bearer: apiToken, origin: "http://evil.example", wantStatus: 200},
+		{name: "API with wrong token", method: "GET", path: "/api/torrents", bearer: "guessed", wantStatus: 401},
+		{name: "Cross-site request with session", method: "DELETE", path: "/api/remove", cookie: session, origin: "http://evil.example", wantStatus: 403},
+		{name: "Same-site request with session", method: "DELETE", path: "/api/remove", cookie: session, origin: "http://example.com", wantStatus: 200},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: tc.cookie})
+			}
+			if tc.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code == 302 && rec.Header().Get("Location") != loginPage {
+				t.Errorf("Expected redirect to %s, got %s", loginPage, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	e := echo.New()
+	handler := RequireAdmin(func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	testCases := []struct {
+		name       string
+		user       *database.User
+		wantStatus int
+	}{
+		{name: "Auth disabled", wantStatus: 200},
+		{name: "Admin", user: &database.User{ID: 1, Role: database.RoleAdmin}, wantStatus: 200},
+		{name: "Regular user", user: &database.User{ID: 2, Role: database.RoleUser}, wantStatus: 403},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/users", nil), rec)
+			if tc.user != nil {
+				auth.SetUser(c, *tc.user)
+			}
+			if err := handler(c); err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "kinozaltv_monitor/events"
+
+// loginFailed publishes a TrackerLoginFailed event for the named tracker
+func loginFailed(tracker string, err error) {
+	events.Publish(events.Event{
+		Type:    events.TrackerLoginFailed,
+		Tracker: tracker,
+		Error:   err.Error(),
+	})
+}
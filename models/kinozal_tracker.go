@@ -56,7 +56,9 @@ func (k *KinozalTracker) GetTrackerName() string {
 }
 
 // Login authenticates the user with kinozal.tv
-func (k *KinozalTracker) Login() error {
+func (k *KinozalTracker) Login() (err error) {
+	defer func() { loginAttempted(k.config.Name, err) }()
+
 	jar, _ := cookiejar.New(nil)
 	k.user.Client = &http.Client{
 		Jar:     jar,
@@ -155,6 +157,7 @@ func (kz *KinozalTracker) DownloadTorrentFile(url string) ([]byte, error) {
 			err = kz.Login()
 			if err != nil {
 				kz.log.Error("login_err", err.Error(), map[string]string{"url": url})
+				loginFailed("kinozal", err)
 			}
 		}
 	}
@@ -183,6 +186,7 @@ func (k *KinozalTracker) GetTitleFromUrl(originalUrl string) (string, error) {
 			err = k.Login()
 			if err != nil {
 				k.log.Error("login_err", err.Error(), map[string]string{"url": originalUrl})
+				loginFailed("kinozal", err)
 			}
 		}
 	}
@@ -305,6 +309,7 @@ func (k *KinozalTracker) GetTorrentHash(url string) (Torrent, error) {
 			err = k.Login()
 			if err != nil {
 				k.log.Error("login_err", err.Error(), map[string]string{"url": url})
+				loginFailed("kinozal", err)
 			}
 		}
 	}
@@ -552,6 +557,7 @@ func (k *KinozalTracker) handleRequestError(err error, url string) {
 	err = k.Login()
 	if err != nil {
 		k.log.Error("login_err", err.Error(), map[string]string{"url": url})
+		loginFailed("kinozal", err)
 	}
 }
 
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"kinozaltv_monitor/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoginState is the outcome of the latest login to a tracker
+type LoginState struct {
+	Tracker     string    `json:"tracker"`
+	LoggedIn    bool      `json:"logged_in"`
+	LastAttempt time.Time `json:"last_attempt"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// loginStates holds the latest login of every tracker by name, guarded by loginStatesMu
+var (
+	loginStates   = make(map[string]LoginState)
+	loginStatesMu sync.RWMutex
+)
+
+// loginAttempted records a login to the named tracker and whether it failed
+func loginAttempted(tracker string, err error) {
+	metrics.TrackerLogins.Inc(tracker)
+	state := LoginState{Tracker: tracker, LoggedIn: err == nil, LastAttempt: time.Now()}
+	if err != nil {
+		metrics.TrackerLoginFailures.Inc(tracker)
+		state.Error = err.Error()
+	}
+
+	loginStatesMu.Lock()
+	loginStates[tracker] = state
+	loginStatesMu.Unlock()
+}
+
+// LoginStates returns the latest login of every tracker that tried to log in, sorted by name
+func LoginStates() []LoginState {
+	loginStatesMu.RLock()
+	defer loginStatesMu.RUnlock()
+	states := make([]LoginState, 0, len(loginStates))
+	for _, state := range loginStates {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Tracker < states[j].Tracker })
+	return states
+}
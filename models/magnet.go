@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// MagnetLink is a parsed magnet URI
+type MagnetLink struct {
+	// Hash is the info hash qBittorrent identifies the torrent by, see TorrentMeta.Hash
+	Hash string
+	// Name is the display name, empty when the link has none
+	Name     string
+	Trackers []string
+}
+
+// ParseMagnet reads the info hash, display name and trackers of a magnet URI. The
+// v1 hash of urn:btih is preferred, a v2 only link is identified by its urn:btmh hash.
+func ParseMagnet(uri string) (MagnetLink, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || !strings.EqualFold(u.Scheme, "magnet") {
+		return MagnetLink{}, errors.New("not a magnet link")
+	}
+	query := u.Query()
+	link := MagnetLink{Name: query.Get("dn"), Trackers: make([]string, 0)}
+	for _, tracker := range query["tr"] {
+		if tracker != "" {
+			link.Trackers = append(link.Trackers, tracker)
+		}
+	}
+
+	var v2Hash string
+	for _, topic := range query["xt"] {
+		switch {
+		case strings.HasPrefix(topic, "urn:btih:"):
+			hash, err := btihHash(strings.TrimPrefix(topic, "urn:btih:"))
+			if err != nil {
+				return MagnetLink{}, err
+			}
+			link.Hash = hash
+		case strings.HasPrefix(topic, "urn:btmh:"):
+			// A multihash, 0x12 0x20 is a 32 byte SHA256
+			multihash := strings.ToLower(strings.TrimPrefix(topic, "urn:btmh:"))
+			if len(multihash) != 68 || !strings.HasPrefix(multihash, "1220") || !isHex(multihash) {
+				return MagnetLink{}, errors.New("invalid urn:btmh hash in magnet link")
+			}
+			v2Hash = multihash[4:44]
+		}
+	}
+	if link.Hash == "" {
+		link.Hash = v2Hash
+	}
+	if link.Hash == "" {
+		return MagnetLink{}, errors.New("info hash not found in magnet link")
+	}
+	return link, nil
+}
+
+// btihHash returns a urn:btih hash, given in hex or base32, as lowercase hex
+func btihHash(hash string) (string, error) {
+	switch {
+	case len(hash) == 40 && isHex(hash):
+		return strings.ToLower(hash), nil
+	case len(hash) == 32:
+		decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err == nil {
+			return hex.EncodeToString(decoded), nil
+		}
+	}
+	return "", errors.New("invalid urn:btih hash in magnet link")
+}
+
+// isHex reports whether s consists of hex digits only
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s)%2 == 0
+}
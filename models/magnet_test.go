@@ -0,0 +1,61 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	v1 := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	v2 := "1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+	tests := []struct {
+		name string
+		uri  string
+		want MagnetLink
+	}{
+		{
+			name: "hex hash with name and trackers",
+			uri:  "magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=Show+S01&tr=http%3A%2F%2Ftracker.example%2Fannounce",
+			want: MagnetLink{Hash: v1, Name: "Show S01", Trackers: []string{"http://tracker.example/announce"}},
+		},
+		{
+			name: "base32 hash",
+			uri:  "magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK",
+			want: MagnetLink{Hash: v1, Trackers: []string{}},
+		},
+		{
+			name: "hybrid prefers the v1 hash",
+			uri:  "magnet:?xt=urn:btmh:" + v2 + "&xt=urn:btih:" + v1,
+			want: MagnetLink{Hash: v1, Trackers: []string{}},
+		},
+		{
+			name: "v2 only",
+			uri:  "magnet:?xt=urn:btmh:" + v2,
+			want: MagnetLink{Hash: v2[4:44], Trackers: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := ParseMagnet(tt.uri)
+			if err != nil {
+				t.Fatalf("ParseMagnet() failed: %v", err)
+			}
+			if !reflect.DeepEqual(link, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, link)
+			}
+		})
+	}
+}
+
+func TestParseMagnet_Invalid(t *testing.T) {
+	for _, uri := range []string{
+		"https://kinozal.tv/details.php?id=1",
+		"magnet:?dn=no+hash",
+		"magnet:?xt=urn:btih:nothex",
+		"magnet:?xt=urn:btmh:1220abc",
+	} {
+		if _, err := ParseMagnet(uri); err == nil {
+			t.Errorf("Expected an error for %q", uri)
+		}
+	}
+}
@@ -192,6 +192,10 @@ func init() {
 		Username: globalConfig.RtUsername,
 		Password: globalConfig.RtPassword,
 	}
+	// Nothing to log in with, RuTracker support is disabled
+	if RTUser.Username == "" || RTUser.Password == "" {
+		return
+	}
 	err := RTUser.RTLogin(loginURL)
 	if err != nil {
 		log.Error("rutracker_init", "Error while logging in", map[string]string{"error": err.Error()})
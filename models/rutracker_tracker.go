@@ -48,7 +48,9 @@ func (r *RuTrackerTracker) GetTrackerName() string {
 }
 
 // Login authenticates the user with rutracker.org
-func (r *RuTrackerTracker) Login() error {
+func (r *RuTrackerTracker) Login() (err error) {
+	defer func() { loginAttempted(r.config.Name, err) }()
+
 	jar, _ := cookiejar.New(nil)
 	r.user.Client = &http.Client{
 		Jar:     jar,
@@ -116,6 +118,7 @@ func (r *RuTrackerTracker) DownloadTorrentFile(originalUrl string) ([]byte, erro
 			err = r.Login()
 			if err != nil {
 				r.log.Error("login_err", err.Error(), map[string]string{"url": originalUrl})
+				loginFailed("rutracker", err)
 			}
 		}
 	}
@@ -253,6 +256,7 @@ func (r *RuTrackerTracker) GetTitleFromUrl(url string) (string, error) {
 			err = r.Login()
 			if err != nil {
 				r.log.Error("login_err", err.Error(), map[string]string{"url": url})
+				loginFailed("rutracker", err)
 			}
 		}
 	}
@@ -339,6 +343,7 @@ func (r *RuTrackerTracker) GetTorrentHash(url string) (Torrent, error) {
 			err = r.Login()
 			if err != nil {
 				r.log.Error("login_err", err.Error(), map[string]string{"url": url})
+				loginFailed("rutracker", err)
 			}
 		}
 	}
@@ -405,6 +410,7 @@ func (r *RuTrackerTracker) handleRequestError(err error, url string) {
 	err = r.Login()
 	if err != nil {
 		r.log.Error("login_err", err.Error(), map[string]string{"url": url})
+		loginFailed("rutracker", err)
 	}
 }
 
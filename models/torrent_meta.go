@@ -0,0 +1,257 @@
+package models
+
+import (
+	"crypto/sha1" // #nosec G505 - SHA1 is required by BitTorrent protocol specification for info hash calculation
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// MetaFile is a file of a torrent. Path is relative to the torrent folder and
+// uses slashes; a torrent with a single file has its name as path.
+type MetaFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Versions of the BitTorrent metadata format, a hybrid torrent carries both
+const (
+	MetaVersionV1     = "v1"
+	MetaVersionV2     = "v2"
+	MetaVersionHybrid = "hybrid"
+)
+
+// TorrentMeta is the metadata of a .torrent file
+type TorrentMeta struct {
+	// InfoHashV1 is the SHA1 info hash, empty for v2 only torrents
+	InfoHashV1 string
+	// InfoHashV2 is the SHA256 info hash, empty for v1 torrents
+	InfoHashV2  string
+	MetaVersion string
+	Name        string
+	TotalSize   int64
+	PieceLength int64
+	Files       []MetaFile
+	Private     bool
+	// Announce holds the tracker urls of announce and announce-list without duplicates
+	Announce []string
+	// CreatedAt is the creation date, zero when the file has none
+	CreatedAt time.Time
+}
+
+// Hash returns the hash qBittorrent identifies the torrent by: the v1 info hash, or
+// the v2 info hash truncated to 40 characters for v2 only torrents
+func (m TorrentMeta) Hash() string {
+	if m.InfoHashV1 != "" {
+		return m.InfoHashV1
+	}
+	return m.InfoHashV2[:40]
+}
+
+// rawTorrent is the top level dictionary of a .torrent file
+type rawTorrent struct {
+	Announce     string             `bencode:"announce"`
+	AnnounceList [][]string         `bencode:"announce-list"`
+	CreationDate int64              `bencode:"creation date"`
+	Info         bencode.RawMessage `bencode:"info"`
+}
+
+// rawInfo is the info dictionary of a .torrent file, BEP 3 and BEP 52. Pieces and
+// Length are nil when the keys are missing, a v1 file may well be empty.
+type rawInfo struct {
+	Name        string                 `bencode:"name"`
+	NameUTF8    string                 `bencode:"name.utf-8"`
+	PieceLength int64                  `bencode:"piece length"`
+	Pieces      *string                `bencode:"pieces"`
+	Length      *int64                 `bencode:"length"`
+	Files       []rawFile              `bencode:"files"`
+	Private     int64                  `bencode:"private"`
+	MetaVersion int64                  `bencode:"meta version"`
+	FileTree    map[string]interface{} `bencode:"file tree"`
+}
+
+// rawFile is an entry of the v1 file list
+type rawFile struct {
+	Length   int64    `bencode:"length"`
+	Path     []string `bencode:"path"`
+	PathUTF8 []string `bencode:"path.utf-8"`
+	Attr     string   `bencode:"attr"`
+}
+
+// ParseTorrent reads the metadata of a .torrent file
+func ParseTorrent(data []byte) (TorrentMeta, error) {
+	var torrent rawTorrent
+	if err := bencode.DecodeBytes(data, &torrent); err != nil {
+		return TorrentMeta{}, errors.New("failed to decode torrent data: " + err.Error())
+	}
+	if len(torrent.Info) == 0 {
+		return TorrentMeta{}, errors.New("info dictionary not found in torrent data")
+	}
+	var info rawInfo
+	if err := bencode.DecodeBytes(torrent.Info, &info); err != nil {
+		return TorrentMeta{}, errors.New("failed to decode info dictionary: " + err.Error())
+	}
+
+	meta := TorrentMeta{
+		Name:        info.Name,
+		PieceLength: info.PieceLength,
+		Private:     info.Private == 1,
+		Announce:    announceUrls(torrent),
+	}
+	if info.NameUTF8 != "" {
+		meta.Name = info.NameUTF8
+	}
+	if torrent.CreationDate > 0 {
+		meta.CreatedAt = time.Unix(torrent.CreationDate, 0).UTC()
+	}
+
+	// The info hashes are taken over the info dictionary exactly as stored in the file
+	hasV1 := info.Pieces != nil || info.Length != nil || len(info.Files) > 0
+	hasV2 := info.MetaVersion == 2
+	switch {
+	case hasV1 && hasV2:
+		meta.MetaVersion = MetaVersionHybrid
+	case hasV2:
+		meta.MetaVersion = MetaVersionV2
+	case hasV1:
+		meta.MetaVersion = MetaVersionV1
+	default:
+		return TorrentMeta{}, errors.New("torrent data has no files")
+	}
+	if hasV1 {
+		// #nosec G401 - SHA1 is required by BitTorrent protocol specification for info hash calculation
+		sum := sha1.Sum(torrent.Info)
+		meta.InfoHashV1 = hex.EncodeToString(sum[:])
+	}
+	if hasV2 {
+		sum := sha256.Sum256(torrent.Info)
+		meta.InfoHashV2 = hex.EncodeToString(sum[:])
+	}
+
+	// A hybrid torrent lists its files twice, the v1 list also has padding files
+	if hasV1 {
+		meta.Files = v1Files(info, meta.Name)
+	} else {
+		meta.Files = treeFiles(info.FileTree, "")
+	}
+	for _, file := range meta.Files {
+		meta.TotalSize += file.Size
+	}
+	return meta, nil
+}
+
+// v1Files returns the files of the v1 file list without padding files
+func v1Files(info rawInfo, name string) []MetaFile {
+	if len(info.Files) == 0 {
+		var size int64
+		if info.Length != nil {
+			size = *info.Length
+		}
+		return []MetaFile{{Path: name, Size: size}}
+	}
+	files := make([]MetaFile, 0, len(info.Files))
+	for _, file := range info.Files {
+		if strings.Contains(file.Attr, "p") {
+			continue
+		}
+		path := file.Path
+		if len(file.PathUTF8) > 0 {
+			path = file.PathUTF8
+		}
+		files = append(files, MetaFile{Path: strings.Join(path, "/"), Size: file.Length})
+	}
+	return files
+}
+
+// treeFiles walks a v2 file tree. A file is a dictionary with an empty key holding its length.
+func treeFiles(tree map[string]interface{}, prefix string) []MetaFile {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]MetaFile, 0)
+	for _, name := range names {
+		node, ok := tree[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := prefix + name
+		if leaf, ok := node[""].(map[string]interface{}); ok {
+			length, _ := leaf["length"].(int64)
+			files = append(files, MetaFile{Path: path, Size: length})
+			continue
+		}
+		files = append(files, treeFiles(node, path+"/")...)
+	}
+	return files
+}
+
+// announceUrls merges announce and announce-list, keeping the order of first appearance
+func announceUrls(torrent rawTorrent) []string {
+	seen := make(map[string]bool)
+	urls := make([]string, 0)
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	add(torrent.Announce)
+	for _, tier := range torrent.AnnounceList {
+		for _, url := range tier {
+			add(url)
+		}
+	}
+	return urls
+}
+
+// FileChange is a file whose size differs between two versions of a torrent
+type FileChange struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+// FileDiff lists the files added, removed and changed between two versions of a torrent
+type FileDiff struct {
+	Added   []MetaFile   `json:"added"`
+	Removed []MetaFile   `json:"removed"`
+	Changed []FileChange `json:"changed"`
+}
+
+// DiffFiles compares the files of two versions of a torrent by path, sorted by path
+func DiffFiles(oldFiles, newFiles []MetaFile) FileDiff {
+	diff := FileDiff{Added: []MetaFile{}, Removed: []MetaFile{}, Changed: []FileChange{}}
+	oldSizes := make(map[string]int64, len(oldFiles))
+	for _, file := range oldFiles {
+		oldSizes[file.Path] = file.Size
+	}
+	newSizes := make(map[string]int64, len(newFiles))
+	for _, file := range newFiles {
+		newSizes[file.Path] = file.Size
+		oldSize, existed := oldSizes[file.Path]
+		switch {
+		case !existed:
+			diff.Added = append(diff.Added, file)
+		case oldSize != file.Size:
+			diff.Changed = append(diff.Changed, FileChange{Path: file.Path, OldSize: oldSize, NewSize: file.Size})
+		}
+	}
+	for _, file := range oldFiles {
+		if _, kept := newSizes[file.Path]; !kept {
+			diff.Removed = append(diff.Removed, file)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Path < diff.Added[j].Path })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Path < diff.Changed[j].Path })
+	return diff
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"crypto/sha1" // #nosec G505 - SHA1 is required by BitTorrent protocol specification for info hash calculation
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+// encodeTorrent builds a .torrent file and returns it with the bencoded info dictionary
+func encodeTorrent(t *testing.T, torrent map[string]interface{}) ([]byte, []byte) {
+	t.Helper()
+	info, err := bencode.EncodeBytes(torrent["info"])
+	if err != nil {
+		t.Fatalf("EncodeBytes(info) failed: %v", err)
+	}
+	data, err := bencode.EncodeBytes(torrent)
+	if err != nil {
+		t.Fatalf("EncodeBytes() failed: %v", err)
+	}
+	return data, info
+}
+
+func TestParseTorrent_V1MultiFile(t *testing.T) {
+	data, info := encodeTorrent(t, map[string]interface{}{
+		"announce":      "http://tracker.example/announce",
+		"announce-list": [][]string{{"http://tracker.example/announce"}, {"http://backup.example/announce"}},
+		"creation date": 1700000000,
+		"info": map[string]interface{}{
+			"name":         "Show S01",
+			"piece length": 262144,
+			"pieces":       "01234567890123456789",
+			"private":      1,
+			"files": []map[string]interface{}{
+				{"length": 100, "path": []string{"e01.mkv"}},
+				{"length": 200, "path": []string{"extras", "e01.srt"}},
+			},
+		},
+	})
+
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatalf("ParseTorrent() failed: %v", err)
+	}
+	sum := sha1.Sum(info) // #nosec G401 - SHA1 is required by BitTorrent protocol specification for info hash calculation
+	if meta.InfoHashV1 != hex.EncodeToString(sum[:]) || meta.InfoHashV2 != "" || meta.Hash() != meta.InfoHashV1 {
+		t.Errorf("Unexpected hashes %q, %q", meta.InfoHashV1, meta.InfoHashV2)
+	}
+	if meta.MetaVersion != MetaVersionV1 || meta.Name != "Show S01" || meta.PieceLength != 262144 || !meta.Private {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+	if meta.TotalSize != 300 || meta.CreatedAt.Unix() != 1700000000 {
+		t.Errorf("Unexpected size %d or creation date %v", meta.TotalSize, meta.CreatedAt)
+	}
+	wantFiles := []MetaFile{{Path: "e01.mkv", Size: 100}, {Path: "extras/e01.srt", Size: 200}}
+	if !reflect.DeepEqual(meta.Files, wantFiles) {
+		t.Errorf("Expected files %v, got %v", wantFiles, meta.Files)
+	}
+	wantAnnounce := []string{"http://tracker.example/announce", "http://backup.example/announce"}
+	if !reflect.DeepEqual(meta.Announce, wantAnnounce) {
+		t.Errorf("Expected announce urls %v, got %v", wantAnnounce, meta.Announce)
+	}
+
+	hash, err := GetInfoHashFromTorrentData(data)
+	if err != nil || hash != meta.InfoHashV1 {
+		t.Errorf("GetInfoHashFromTorrentData() = %q, %v", hash, err)
+	}
+}
+
+func TestParseTorrent_Hybrid(t *testing.T) {
+	data, info := encodeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "Show S01",
+			"piece length": 16384,
+			"meta version": 2,
+			"files": []map[string]interface{}{
+				{"length": 100, "path": []string{"e01.mkv"}},
+				{"length": 16284, "path": []string{".pad", "16284"}, "attr": "p"},
+				{"length": 50, "path": []string{"e02.mkv"}},
+			},
+			"file tree": map[string]interface{}{
+				"e01.mkv": map[string]interface{}{"": map[string]interface{}{"length": 100}},
+				"e02.mkv": map[string]interface{}{"": map[string]interface{}{"length": 50}},
+			},
+		},
+	})
+
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatalf("ParseTorrent() failed: %v", err)
+	}
+	sum := sha256.Sum256(info)
+	if meta.MetaVersion != MetaVersionHybrid || meta.InfoHashV1 == "" || meta.InfoHashV2 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected version %q or hashes %q, %q", meta.MetaVersion, meta.InfoHashV1, meta.InfoHashV2)
+	}
+	if len(meta.Files) != 2 || meta.TotalSize != 150 {
+		t.Errorf("Expected the padding file to be left out, got %v", meta.Files)
+	}
+}
+
+func TestParseTorrent_V2(t *testing.T) {
+	data, info := encodeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "Show S01",
+			"piece length": 16384,
+			"meta version": 2,
+			"file tree": map[string]interface{}{
+				"Season 1": map[string]interface{}{
+					"e01.mkv": map[string]interface{}{"": map[string]interface{}{"length": 100}},
+				},
+				"readme.txt": map[string]interface{}{"": map[string]interface{}{"length": 5}},
+			},
+		},
+	})
+
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatalf("ParseTorrent() failed: %v", err)
+	}
+	sum := sha256.Sum256(info)
+	if meta.MetaVersion != MetaVersionV2 || meta.InfoHashV1 != "" || meta.Hash() != hex.EncodeToString(sum[:])[:40] {
+		t.Errorf("Unexpected version %q or hash %q", meta.MetaVersion, meta.Hash())
+	}
+	wantFiles := []MetaFile{{Path: "Season 1/e01.mkv", Size: 100}, {Path: "readme.txt", Size: 5}}
+	if !reflect.DeepEqual(meta.Files, wantFiles) {
+		t.Errorf("Expected files %v, got %v", wantFiles, meta.Files)
+	}
+}
+
+func TestParseTorrent_V1EmptyFile(t *testing.T) {
+	data, _ := encodeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{"name": "empty.txt", "piece length": 16384, "pieces": "", "length": 0},
+	})
+
+	meta, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatalf("ParseTorrent() failed: %v", err)
+	}
+	if meta.MetaVersion != MetaVersionV1 || meta.InfoHashV1 == "" || !reflect.DeepEqual(meta.Files, []MetaFile{{Path: "empty.txt"}}) {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+}
+
+func TestParseTorrent_Invalid(t *testing.T) {
+	for _, data := range []string{"", "not bencode", "d8:announce3:urle", "d4:infod4:name1:xee"} {
+		if _, err := ParseTorrent([]byte(data)); err == nil {
+			t.Errorf("Expected ParseTorrent(%q) to fail", data)
+		}
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	oldFiles := []MetaFile{{Path: "e01.mkv", Size: 100}, {Path: "e02.mkv", Size: 200}, {Path: "sample.mkv", Size: 10}}
+	newFiles := []MetaFile{{Path: "e02.mkv", Size: 250}, {Path: "e01.mkv", Size: 100}, {Path: "e03.mkv", Size: 300}}
+
+	diff := DiffFiles(oldFiles, newFiles)
+	want := FileDiff{
+		Added:   []MetaFile{{Path: "e03.mkv", Size: 300}},
+		Removed: []MetaFile{{Path: "sample.mkv", Size: 10}},
+		Changed: []FileChange{{Path: "e02.mkv", OldSize: 200, NewSize: 250}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Expected %+v, got %+v", want, diff)
+	}
+}
@@ -26,6 +26,7 @@ func NewTrackerManager(globalConfig *config.AppConfig) *TrackerManager {
 		err := kinozalTracker.Login()
 		if err != nil {
 			manager.log.Error("kinozal_init", "Error while logging in to Kinozal", map[string]string{"error": err.Error()})
+			loginFailed("kinozal", err)
 		} else {
 			manager.trackers["kinozal"] = kinozalTracker
 			manager.log.Info("kinozal_init", "Kinozal user logged in successfully", nil)
@@ -38,6 +39,7 @@ func NewTrackerManager(globalConfig *config.AppConfig) *TrackerManager {
 		err := rutrackerTracker.Login()
 		if err != nil {
 			manager.log.Error("rutracker_init", "Error while logging in to RuTracker", map[string]string{"error": err.Error()})
+			loginFailed("rutracker", err)
 		} else {
 			manager.trackers["rutracker"] = rutrackerTracker
 			manager.log.Info("rutracker_init", "RuTracker user logged in successfully", nil)
@@ -56,19 +58,28 @@ func (tm *TrackerManager) GetTracker(name string) (TorrentTracker, error) {
 	return tracker, nil
 }
 
-// GetTrackerByURL determines the appropriate tracker based on the URL
-func (tm *TrackerManager) GetTrackerByURL(url string) (TorrentTracker, error) {
+// TrackerNameByURL returns the name of the tracker serving url, empty for unsupported urls
+func TrackerNameByURL(url string) string {
 	url = strings.ToLower(url)
 
 	if strings.Contains(url, "kinozal.tv") {
-		return tm.GetTracker("kinozal")
+		return "kinozal"
 	}
 
 	if strings.Contains(url, "rutracker.org") {
-		return tm.GetTracker("rutracker")
+		return "rutracker"
 	}
 
-	return nil, fmt.Errorf("no suitable tracker found for URL: %s", url)
+	return ""
+}
+
+// GetTrackerByURL determines the appropriate tracker based on the URL
+func (tm *TrackerManager) GetTrackerByURL(url string) (TorrentTracker, error) {
+	name := TrackerNameByURL(url)
+	if name == "" {
+		return nil, fmt.Errorf("no suitable tracker found for URL: %s", strings.ToLower(url))
+	}
+	return tm.GetTracker(name)
 }
 
 // GetAvailableTrackers returns a list of available tracker names
@@ -0,0 +1,212 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/models"
+	"net/url"
+	"strings"
+)
+
+// Statuses of an adoption proposal. A matched torrent has a topic url, an unmatched one at
+// most the name of its tracker and a taken one a topic url that is already monitored under
+// another hash, usually an older version of the torrent.
+const (
+	ProposalMatched   = "matched"
+	ProposalUnmatched = "unmatched"
+	ProposalTaken     = "taken"
+)
+
+// Outcomes of adopting a single torrent
+const (
+	AdoptionAdopted   = "adopted"
+	AdoptionDuplicate = "duplicate"
+	AdoptionNotFound  = "not_found"
+	AdoptionFailed    = "failed"
+)
+
+// AdoptionProposal is a qBittorrent torrent that is not monitored yet, with the topic it was
+// downloaded from as found in the comment of its .torrent file
+type AdoptionProposal struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	SavePath string `json:"save_path"`
+	// Tracker is the tracker of the announce url or topic, e.g. kinozal, empty for other trackers
+	Tracker string `json:"tracker"`
+	Url     string `json:"url"`
+	Status  string `json:"status"`
+	// Error is why the comment could not be read, the proposal is unmatched then
+	Error string `json:"error,omitempty"`
+}
+
+// Adoption is an accepted proposal: the torrent of Hash is monitored as the topic Url
+type Adoption struct {
+	Hash       string `json:"hash"`
+	Url        string `json:"url"`
+	WatchEvery int    `json:"watch_every"`
+}
+
+// AdoptionResult is the outcome of adopting a single torrent
+type AdoptionResult struct {
+	Hash   string `json:"hash"`
+	Url    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// torrentSource lists the torrents of qBittorrent and their details, QbittorrentUser in production
+type torrentSource interface {
+	GetTorrentHashList() ([]Torrent, error)
+	GetTorrentProperties(hash string) (TorrentProperties, error)
+}
+
+// announceTrackers maps announce hosts to tracker names, rutracker announces on t-ru.org
+var announceTrackers = map[string]string{
+	"kinozal.tv":    "kinozal",
+	"rutracker.org": "rutracker",
+	"t-ru.org":      "rutracker",
+}
+
+// ScanAdoptable proposes topics for the qBittorrent torrents that are not monitored yet
+func ScanAdoptable() ([]AdoptionProposal, error) {
+	return scanAdoptable(GlobalManager.User)
+}
+
+// scanAdoptable reads the comment of every torrent of source that is not monitored yet and
+// proposes the tracker topic it names
+func scanAdoptable(source torrentSource) ([]AdoptionProposal, error) {
+	qbTorrents, err := source.GetTorrentHashList()
+	if err != nil {
+		clientUnavailable(err)
+		return nil, err
+	}
+	records, err := database.Repo.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	monitoredHashes := make(map[string]bool, len(records))
+	monitoredUrls := make(map[string]bool, len(records))
+	for _, record := range records {
+		monitoredHashes[strings.ToLower(record.Hash)] = true
+		monitoredUrls[record.Url] = true
+	}
+
+	proposals := make([]AdoptionProposal, 0)
+	for _, qbTorrent := range qbTorrents {
+		if monitoredHashes[strings.ToLower(qbTorrent.Hash)] {
+			continue
+		}
+		proposal := AdoptionProposal{
+			Hash:     qbTorrent.Hash,
+			Name:     qbTorrent.Name,
+			SavePath: qbTorrent.SavePath,
+			Tracker:  announceTracker(qbTorrent.Tracker),
+			Status:   ProposalUnmatched,
+		}
+		// A torrent whose comment can not be read does not stop the scan of the others
+		properties, err := source.GetTorrentProperties(qbTorrent.Hash)
+		if err != nil {
+			proposal.Error = err.Error()
+		} else {
+			proposal.Url = common.FindTopicUrl(properties.Comment)
+		}
+		if proposal.Url != "" {
+			proposal.Tracker = models.TrackerNameByURL(proposal.Url)
+			proposal.Status = ProposalMatched
+			if monitoredUrls[proposal.Url] {
+				proposal.Status = ProposalTaken
+			}
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals, nil
+}
+
+// announceTracker returns the name of the tracker of an announce url, empty for other trackers
+func announceTracker(announceUrl string) string {
+	u, err := url.Parse(announceUrl)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	for domain, tracker := range announceTrackers {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return tracker
+		}
+	}
+	return ""
+}
+
+// Adopt monitors qBittorrent torrents as the topics of adoptions, see adoptTorrent
+func Adopt(userID int, adoptions []Adoption) ([]AdoptionResult, error) {
+	return adopt(GlobalManager.User, userID, adoptions)
+}
+
+// adopt adopts the torrents of source one by one, a torrent that fails does not stop the others
+func adopt(source torrentSource, userID int, adoptions []Adoption) ([]AdoptionResult, error) {
+	qbTorrents, err := source.GetTorrentHashList()
+	if err != nil {
+		clientUnavailable(err)
+		return nil, err
+	}
+	byHash := make(map[string]Torrent, len(qbTorrents))
+	for _, qbTorrent := range qbTorrents {
+		byHash[strings.ToLower(qbTorrent.Hash)] = qbTorrent
+	}
+
+	results := make([]AdoptionResult, 0, len(adoptions))
+	for _, adoption := range adoptions {
+		result := AdoptionResult{Hash: adoption.Hash, Url: common.CanonicalTorrentUrl(adoption.Url), Status: AdoptionAdopted}
+		qbTorrent, ok := byHash[strings.ToLower(adoption.Hash)]
+		if !ok {
+			result.Status = AdoptionNotFound
+			results = append(results, result)
+			continue
+		}
+		err := adoptTorrent(userID, qbTorrent, result.Url, adoption.WatchEvery)
+		if err == database.ErrDuplicate {
+			result.Status = AdoptionDuplicate
+		} else if err != nil {
+			log.Error("adopt_torrent", err.Error(), map[string]string{"torrent_url": result.Url, "torrent_hash": adoption.Hash})
+			result.Status = AdoptionFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// adoptTorrent monitors a qBittorrent torrent as the topic url without downloading it again:
+// the record takes its hash, name and save path. When the tracker has a newer version, the
+// first check of the topic replaces the torrent as usual.
+func adoptTorrent(userID int, qbTorrent Torrent, topicUrl string, watchEvery int) error {
+	topicUrl = common.CanonicalTorrentUrl(topicUrl)
+	torrentInfo := models.Torrent{Title: qbTorrent.Name, Name: qbTorrent.Name, Hash: qbTorrent.Hash, Url: topicUrl}
+	if err := database.Repo.AddRecord(torrentInfo); err != nil {
+		return err
+	}
+	var err error
+	if userID != 0 {
+		err = database.Repo.AddWatcher(userID, topicUrl)
+	}
+	if err == nil {
+		err = applyTorrentSettings(common.TorrentData{Url: topicUrl, DownloadPath: qbTorrent.SavePath, WatchEvery: watchEvery})
+	}
+	if err != nil {
+		// Remove the record again, else the torrent could not be adopted on a retry
+		if deleteErr := database.Repo.DeleteRecord(topicUrl); deleteErr != nil {
+			log.Error("delete_record", deleteErr.Error(), map[string]string{"torrent_url": topicUrl})
+		}
+		return err
+	}
+	InitCheckInfo(topicUrl)
+
+	log.Info("info", "Torrent adopted", map[string]string{
+		"torrent_url":  topicUrl,
+		"torrent_hash": qbTorrent.Hash,
+	})
+	// Nothing was added to qBittorrent, so watchers are not told about a new torrent
+	events.Publish(events.Event{Type: events.TorrentAdopted, Url: topicUrl, Title: torrentInfo.Title, Hash: torrentInfo.Hash})
+	return nil
+}
@@ -0,0 +1,146 @@
+package qbittorrent
+
+import (
+	"errors"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeSource is a qBittorrent with fixed torrents and comments
+type fakeSource struct {
+	torrents []Torrent
+	comments map[string]string
+	failing  map[string]bool
+}
+
+func (f fakeSource) GetTorrentHashList() ([]Torrent, error) {
+	return f.torrents, nil
+}
+
+func (f fakeSource) GetTorrentProperties(hash string) (TorrentProperties, error) {
+	if f.failing[hash] {
+		return TorrentProperties{}, errors.New("properties unavailable")
+	}
+	return TorrentProperties{Comment: f.comments[hash]}, nil
+}
+
+func useTestRepository(t *testing.T) {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	previous := database.Repo
+	database.Repo = repo
+	t.Cleanup(func() {
+		database.Repo = previous
+		_ = repo.Close()
+	})
+}
+
+var testSource = fakeSource{
+	torrents: []Torrent{
+		{Hash: "aaa", Name: "Monitored", SavePath: "/downloads"},
+		{Hash: "bbb", Name: "Series", SavePath: "/downloads/series", Tracker: "http://tr1.kinozal.tv/announce.php?uk=x"},
+		{Hash: "ccc", Name: "Movie", SavePath: "/downloads/movies", Tracker: "http://bt4.t-ru.org/ann?pk=x"},
+		{Hash: "ddd", Name: "Old version", SavePath: "/downloads"},
+		{Hash: "eee", Name: "Other", SavePath: "/downloads"},
+		{Hash: "fff", Name: "Unreadable", SavePath: "/downloads", Tracker: "http://tr1.kinozal.tv/announce.php?uk=x"},
+	},
+	comments: map[string]string{
+		"aaa": "http://kinozal.tv/details.php?id=1",
+		"bbb": "http://www.kinozal.tv/details.php?id=2",
+		"ddd": "http://kinozal.tv/details.php?id=1",
+		"eee": "https://example.com/torrent/5",
+		"fff": "http://kinozal.tv/details.php?id=3",
+	},
+	failing: map[string]bool{"fff": true},
+}
+
+func TestScanAdoptable(t *testing.T) {
+	useTestRepository(t)
+	if err := database.Repo.AddRecord(models.Torrent{Title: "Monitored", Hash: "aaa", Url: "https://kinozal.tv/details.php?id=1"}); err != nil {
+		t.Fatalf("AddRecord() failed: %v", err)
+	}
+
+	proposals, err := scanAdoptable(testSource)
+	if err != nil {
+		t.Fatalf("scanAdoptable() failed: %v", err)
+	}
+	want := []AdoptionProposal{
+		{Hash: "bbb", Name: "Series", SavePath: "/downloads/series", Tracker: "kinozal", Url: "https://kinozal.tv/details.php?id=2", Status: ProposalMatched},
+		{Hash: "ccc", Name: "Movie", SavePath: "/downloads/movies", Tracker: "rutracker", Status: ProposalUnmatched},
+		{Hash: "ddd", Name: "Old version", SavePath: "/downloads", Tracker: "kinozal", Url: "https://kinozal.tv/details.php?id=1", Status: ProposalTaken},
+		{Hash: "eee", Name: "Other", SavePath: "/downloads", Status: ProposalUnmatched},
+		{Hash: "fff", Name: "Unreadable", SavePath: "/downloads", Tracker: "kinozal", Status: ProposalUnmatched, Error: "properties unavailable"},
+	}
+	if !reflect.DeepEqual(proposals, want) {
+		t.Errorf("Expected proposals\n%+v\ngot\n%+v", want, proposals)
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	useTestRepository(t)
+	user, err := database.Repo.CreateUser(database.User{Username: "alice", PasswordHash: "x", Role: database.RoleUser})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	results, err := adopt(testSource, user.ID, []Adoption{
+		{Hash: "bbb", Url: "http://www.kinozal.tv/details.php?id=2", WatchEvery: 60},
+		{Hash: "ddd", Url: "https://kinozal.tv/details.php?id=2"},
+		{Hash: "zzz", Url: "https://kinozal.tv/details.php?id=3"},
+	})
+	if err != nil {
+		t.Fatalf("adopt() failed: %v", err)
+	}
+	statuses := []string{results[0].Status, results[1].Status, results[2].Status}
+	if want := []string{AdoptionAdopted, AdoptionDuplicate, AdoptionNotFound}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Expected statuses %v, got %v", want, statuses)
+	}
+
+	record, err := database.Repo.GetRecordByUrl("https://kinozal.tv/details.php?id=2")
+	if err != nil {
+		t.Fatalf("GetRecordByUrl() failed: %v", err)
+	}
+	if record.Hash != "bbb" || record.Title != "Series" || record.DownloadPath != "/downloads/series" || record.WatchEvery != 60 {
+		t.Errorf("Unexpected adopted record %+v", record)
+	}
+	if watching, _ := database.Repo.IsWatching(user.ID, record.Url); !watching {
+		t.Error("Expected the adopted torrent on the watch list of the user")
+	}
+}
+
+// failingWatchers is a repository that can not add watchers
+type failingWatchers struct {
+	database.TorrentRepository
+}
+
+func (failingWatchers) AddWatcher(int, string) error {
+	return errors.New("watchers unavailable")
+}
+
+func TestAdopt_WatcherFails(t *testing.T) {
+	useTestRepository(t)
+	repo := database.Repo
+	database.Repo = failingWatchers{repo}
+
+	results, err := adopt(testSource, 1, []Adoption{{Hash: "bbb", Url: "https://kinozal.tv/details.php?id=2"}})
+	if err != nil {
+		t.Fatalf("adopt() failed: %v", err)
+	}
+	if results[0].Status != AdoptionFailed {
+		t.Errorf("Expected the adoption to fail, got %+v", results[0])
+	}
+	if _, err := repo.GetRecordByUrl("https://kinozal.tv/details.php?id=2"); err != database.ErrNotFound {
+		t.Errorf("Expected no record left behind, got %v", err)
+	}
+
+	database.Repo = repo
+	results, _ = adopt(testSource, 1, []Adoption{{Hash: "bbb", Url: "https://kinozal.tv/details.php?id=2"}})
+	if results[0].Status != AdoptionAdopted {
+		t.Errorf("Expected the retry to adopt the torrent, got %+v", results[0])
+	}
+}
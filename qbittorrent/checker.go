@@ -2,20 +2,21 @@ package qbittorrent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"kinozaltv_monitor/common"
-	"kinozaltv_monitor/config"
 	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/jobs"
 	logger "kinozaltv_monitor/logging"
+	"kinozaltv_monitor/metrics"
 	"kinozaltv_monitor/models"
-	"kinozaltv_monitor/telegram"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var log = logger.New("qbittorrent")
-var globalConfig = config.GlobalConfig
 
 type TorrentWatcher struct {
 	cancel     context.CancelFunc
@@ -27,37 +28,134 @@ type TorrentCheckInfo struct {
 	LastCheckSuccess bool
 }
 
-type CheckUpdateMessage struct {
-	Type             string `json:"type"`
-	Url              string `json:"url"`
-	LastCheckTime    string `json:"last_check_time"`
-	LastCheckSuccess bool   `json:"last_check_success"`
+// TorrentCheckInfos holds the latest check of every torrent url and lastSuccess the time
+// of the latest successful check of every tracker, both guarded by checkInfosMu
+var (
+	TorrentCheckInfos = make(map[string]*TorrentCheckInfo)
+	lastSuccess       = make(map[string]time.Time)
+	checkInfosMu      sync.RWMutex
+)
+
+// watchers is the number of torrents with a running watcher
+var watchers atomic.Int64
+
+// Watchers returns the number of torrents that are checked periodically
+func Watchers() int {
+	return int(watchers.Load())
+}
+
+// LastSuccessfulChecks returns the time of the latest successful check of every tracker by name
+func LastSuccessfulChecks() map[string]time.Time {
+	checkInfosMu.RLock()
+	defer checkInfosMu.RUnlock()
+	checks := make(map[string]time.Time, len(lastSuccess))
+	for tracker, checkTime := range lastSuccess {
+		checks[tracker] = checkTime
+	}
+	return checks
+}
+
+// LastCheck returns the latest check of url
+func LastCheck(url string) (TorrentCheckInfo, bool) {
+	checkInfosMu.RLock()
+	defer checkInfosMu.RUnlock()
+	checkInfo, exists := TorrentCheckInfos[url]
+	if !exists {
+		return TorrentCheckInfo{}, false
+	}
+	return *checkInfo, true
+}
+
+// InitCheckInfo assumes a successful check now for a url that was not checked yet and
+// returns the latest check of url
+func InitCheckInfo(url string) (info TorrentCheckInfo, added bool) {
+	checkInfosMu.Lock()
+	defer checkInfosMu.Unlock()
+	if checkInfo, exists := TorrentCheckInfos[url]; exists {
+		return *checkInfo, false
+	}
+	checkInfo := &TorrentCheckInfo{LastCheckTime: time.Now(), LastCheckSuccess: true}
+	TorrentCheckInfos[url] = checkInfo
+	return *checkInfo, true
+}
+
+// recordCheck stores the result of a check and publishes it as a CheckCompleted event
+func recordCheck(url string, success bool, checkErr error) {
+	checkInfo := TorrentCheckInfo{LastCheckTime: time.Now(), LastCheckSuccess: success}
+	checkInfosMu.Lock()
+	TorrentCheckInfos[url] = &checkInfo
+	// External torrents have no tracker
+	if tracker := models.TrackerNameByURL(url); success && tracker != "" {
+		lastSuccess[tracker] = checkInfo.LastCheckTime
+	}
+	checkInfosMu.Unlock()
+
+	e := events.Event{
+		Type:    events.CheckCompleted,
+		Time:    checkInfo.LastCheckTime,
+		Url:     url,
+		Success: success,
+	}
+	if checkErr != nil {
+		e.Error = checkErr.Error()
+	}
+	events.Publish(e)
+}
+
+// clientUnavailable publishes a ClientUnavailable event for a failed qBittorrent request
+func clientUnavailable(err error) {
+	events.Publish(events.Event{Type: events.ClientUnavailable, Error: err.Error()})
 }
 
-var TorrentCheckInfos = make(map[string]*TorrentCheckInfo)
+// updateJob moves an add job to state and publishes the change
+func updateJob(jobID string, state jobs.State, changes ...func(job *jobs.Job)) {
+	job, ok := jobs.GlobalStore.Update(jobID, state, changes...)
+	if !ok {
+		return
+	}
+	events.Publish(events.Event{Type: events.JobUpdated, Url: job.Url, Job: &job})
+}
+
+// failJob marks an add job as failed with the reason
+func failJob(jobID string, reason error) {
+	updateJob(jobID, jobs.StateFailed, func(job *jobs.Job) {
+		job.Error = reason.Error()
+	})
+}
+
+// torrentAdder resolves a topic url, stores it and adds the torrent to qBittorrent,
+// reporting progress through the job of torrentData
+func torrentAdder(qbUser *QbittorrentUser, torrentData common.TorrentData) {
+	if torrentData.JobID == "" {
+		torrentData.JobID = jobs.GlobalStore.Create(torrentData.UserID, torrentData.Url, torrentData.DownloadPath).ID
+	}
+	jobID := torrentData.JobID
+	updateJob(jobID, jobs.StateResolving)
+	if common.IsExternalTorrentUrl(torrentData.Url) {
+		externalAdder(qbUser, torrentData)
+		return
+	}
 
-// torrentAdder
-func torrentAdder(qbUser *QbittorrentUser, torrentData common.TorrentData, wsMsg chan string) {
 	// Get the appropriate tracker based on URL
 	tracker, err := models.GlobalTrackerManager.GetTrackerByURL(torrentData.Url)
 	if err != nil {
 		log.Error("get_tracker", "Error while getting tracker for URL", map[string]string{"error": err.Error(), "url": torrentData.Url})
-		wsMsg <- "500"
+		failJob(jobID, err)
 		return
 	}
 
 	torrentInfo, err := tracker.GetTorrentHash(torrentData.Url)
 	if err != nil {
 		log.Error("get_torrent_info", "Error while getting torrent info", map[string]string{"error": err.Error()})
-		// Return 500 Internal Server Error
-		wsMsg <- "500"
+		failJob(jobID, fmt.Errorf("failed to get torrent info: %w", err))
 		return
 	}
 	// Check if torrent exists in qbittorrent
 	torrentHashList, err := qbUser.GetTorrentHashList()
 	if err != nil {
 		log.Error("get_qb_torrents", err.Error(), nil)
-		wsMsg <- "500"
+		clientUnavailable(err)
+		failJob(jobID, fmt.Errorf("qBittorrent is unavailable: %w", err))
 		return
 	}
 
@@ -65,7 +163,7 @@ func torrentAdder(qbUser *QbittorrentUser, torrentData common.TorrentData, wsMsg
 	title, err := tracker.GetTitleFromUrl(torrentData.Url)
 	if err != nil {
 		log.Error("get_title_from_url", err.Error(), nil)
-		wsMsg <- "500"
+		failJob(jobID, fmt.Errorf("failed to get title: %w", err))
 		return
 	}
 
@@ -73,42 +171,44 @@ func torrentAdder(qbUser *QbittorrentUser, torrentData common.TorrentData, wsMsg
 	torrentInfo.Title = title
 
 	// Add torrent to database
-	err = database.CreateOrUpdateRecord(database.DB, torrentInfo)
+	err = database.Repo.CreateOrUpdateRecord(torrentInfo)
 	if err != nil {
 		log.Error("create_or_update_record", err.Error(), nil)
-		wsMsg <- "500"
+		failJob(jobID, err)
 		return
 	}
 
-	// Initialize check info for the new torrent
-	checkInfo, exists := TorrentCheckInfos[torrentData.Url]
-	if !exists {
-		checkInfo = &TorrentCheckInfo{}
-		TorrentCheckInfos[torrentData.Url] = checkInfo
+	// Put the torrent on the watch list of the user who added it
+	if torrentData.UserID != 0 {
+		if err := database.Repo.AddWatcher(torrentData.UserID, torrentData.Url); err != nil {
+			log.Error("add_watcher", err.Error(), map[string]string{"torrent_url": torrentData.Url})
+			failJob(jobID, err)
+			return
+		}
+	}
+
+	// Store settings that came with the request (e.g. from an imported watch list)
+	err = applyTorrentSettings(torrentData)
+	if err != nil {
+		log.Error("apply_torrent_settings", err.Error(), map[string]string{"torrent_url": torrentData.Url})
 	}
-	checkInfo.LastCheckTime = time.Now()
-	checkInfo.LastCheckSuccess = true // Torrent added successfully
 
-	// Send WebSocket message for check update
-	msg := CheckUpdateMessage{
-		Type:             "check_update",
-		Url:              torrentData.Url,
-		LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-		LastCheckSuccess: checkInfo.LastCheckSuccess,
+	// Initialize check info for the new torrent
+	recordCheck(torrentData.Url, true, nil)
+
+	resolved := func(job *jobs.Job) {
+		job.Hash = torrentInfo.Hash
+		job.Title = torrentInfo.Title
 	}
-	jsonMsg, _ := json.Marshal(msg)
-	wsMsg <- string(jsonMsg)
 
 	for _, hash := range torrentHashList {
 		if hash.Hash == torrentInfo.Hash {
 			// Torrent already exists in qbittorrent
-			wsMsg <- "added"
+			updateJob(jobID, jobs.StateDuplicate, resolved)
 			return
 		}
 	}
 
-	// Add torrent to qbittorrent by addToQbittorrent function
-
 	// Create qbitTorrent struct
 	qbTorrent := Torrent{
 		Hash:     torrentInfo.Hash,
@@ -116,19 +216,44 @@ func torrentAdder(qbUser *QbittorrentUser, torrentData common.TorrentData, wsMsg
 		Url:      torrentInfo.Url,
 		SavePath: torrentData.DownloadPath,
 	}
-	go func() {
-		// Add torrent to qbittorrent
-		addTorrentToQbittorrent(qbTorrent, true)
+	qbTorrent.place(torrentData.Category, torrentData.Tags)
+	updateJob(jobID, jobs.StateDownloading, resolved)
+	// Add torrent to qbittorrent before returning, so that Drain waits for the add
+	if !addTorrentToQbittorrent(qbTorrent, true) {
+		failJob(jobID, fmt.Errorf("failed to add torrent to qBittorrent"))
+		return
+	}
 
-		// Send websocket message about adding torrent
-		log.Info("info", "Torrent added", map[string]string{
-			"torrent_url": torrentData.Url,
-		})
-		wsMsg <- "added"
-	}()
+	log.Info("info", "Torrent added", map[string]string{
+		"torrent_url": torrentData.Url,
+	})
+	updateJob(jobID, jobs.StateAdded)
+}
+
+// applyTorrentSettings stores download path, watch period, category and tags of a newly added torrent
+func applyTorrentSettings(torrentData common.TorrentData) error {
+	if torrentData.DownloadPath != "" {
+		if err := database.Repo.SetDownloadPath(torrentData.Url, torrentData.DownloadPath); err != nil {
+			return err
+		}
+	}
+	if torrentData.WatchEvery > 0 {
+		if err := database.Repo.SetWatchFlag(torrentData.Url, torrentData.WatchEvery); err != nil {
+			return err
+		}
+	}
+	if torrentData.Category != "" {
+		if err := database.Repo.SetCategory(torrentData.Url, torrentData.Category); err != nil {
+			return err
+		}
+	}
+	if len(torrentData.Tags) > 0 {
+		return database.Repo.SetTags(torrentData.Url, torrentData.Tags)
+	}
+	return nil
 }
 
-func torrentWorker(ctx context.Context, dbTorrent database.Torrent, wsChan chan string) {
+func torrentWorker(ctx context.Context, dbTorrent database.Torrent) {
 	log.Info("info", "Torrent worker started", map[string]string{
 		"torrent_url":  dbTorrent.Url,
 		"torrent_hash": dbTorrent.Hash,
@@ -136,7 +261,7 @@ func torrentWorker(ctx context.Context, dbTorrent database.Torrent, wsChan chan
 	})
 
 	// Check torrent
-	updatedTorrent, err := torrentChecker(dbTorrent, wsChan)
+	updatedTorrent, err := torrentChecker(dbTorrent)
 	if err != nil {
 		log.Error("torrent_checker", err.Error(), nil)
 	} else {
@@ -151,8 +276,8 @@ func torrentWorker(ctx context.Context, dbTorrent database.Torrent, wsChan chan
 	for {
 		select {
 		case <-ticker.C:
-			// Check torrent
-			updatedTorrent, err := torrentChecker(dbTorrent, wsChan)
+			// Check torrent with the settings changed since the last check
+			updatedTorrent, err := torrentChecker(currentRecord(dbTorrent))
 			if err != nil {
 				log.Error("torrent_checker", err.Error(), nil)
 			} else {
@@ -170,28 +295,55 @@ func torrentWorker(ctx context.Context, dbTorrent database.Torrent, wsChan chan
 	}
 }
 
-func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.Torrent, error) {
+// currentRecord reloads a torrent so that a check uses its latest settings, e.g. a changed
+// update strategy, category or download path. The copy is kept when it can not be read.
+func currentRecord(dbTorrent database.Torrent) database.Torrent {
+	record, err := database.Repo.GetRecordByID(dbTorrent.ID)
+	if err != nil {
+		log.Error("get_db_record", err.Error(), map[string]string{"torrent_url": dbTorrent.Url})
+		return dbTorrent
+	}
+	return record
+}
+
+// CheckNow checks a torrent for updates right away instead of waiting for its watcher
+func CheckNow(dbTorrent database.Torrent) error {
+	_, err := torrentChecker(dbTorrent)
+	return err
+}
+
+// torrentChecker checks a torrent and records the check duration and outcome
+func torrentChecker(dbTorrent database.Torrent) (database.Torrent, error) {
+	if !work.begin() {
+		return dbTorrent, ErrShuttingDown
+	}
+	defer work.end()
+
+	tracker := models.TrackerNameByURL(dbTorrent.Url)
+	start := time.Now()
+	updatedTorrent, outcome, err := checkTorrent(dbTorrent)
+	metrics.CheckDuration.ObserveSince(start, tracker)
+	metrics.Checks.Inc(tracker, outcome)
+	return updatedTorrent, err
+}
+
+// checkTorrent re-adds a torrent missing from qBittorrent or replaces it when the
+// tracker has a new version. The outcome is one of the metrics.Check* values.
+func checkTorrent(dbTorrent database.Torrent) (database.Torrent, string, error) {
+	outcome := metrics.CheckUpToDate
+	// The old torrent may be missing because a replacement was interrupted, finish it first
+	if replacement, ok := PendingReplacement(dbTorrent.Url); ok {
+		return resumeReplacement(dbTorrent, replacement)
+	}
+
 	// Get torrent list from qbittorrent
 	qbTorrents, err := GlobalManager.User.GetTorrentHashList()
 	if err != nil {
 		log.Error("get_qb_torrents", err.Error(), nil)
+		clientUnavailable(err)
 		handleQbittorrentError(err)
-		checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-		if !exists {
-			checkInfo = &TorrentCheckInfo{}
-			TorrentCheckInfos[dbTorrent.Url] = checkInfo
-		}
-		checkInfo.LastCheckTime = time.Now()
-		checkInfo.LastCheckSuccess = false
-		msg := CheckUpdateMessage{
-			Type:             "check_update",
-			Url:              dbTorrent.Url,
-			LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-			LastCheckSuccess: checkInfo.LastCheckSuccess,
-		}
-		jsonMsg, _ := json.Marshal(msg)
-		wsChan <- string(jsonMsg)
-		return dbTorrent, err
+		recordCheck(dbTorrent.Url, false, err)
+		return dbTorrent, metrics.CheckFailed, err
 	}
 
 	qbTorrent := Torrent{
@@ -199,7 +351,7 @@ func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.To
 		Title:    dbTorrent.Title,
 		Name:     dbTorrent.Name,
 		Url:      dbTorrent.Url,
-		SavePath: "", // Will be populated if needed
+		SavePath: dbTorrent.DownloadPath,
 	}
 
 	// If torrent does not exist in qbittorrent
@@ -208,45 +360,26 @@ func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.To
 			"torrent_url":  dbTorrent.Url,
 			"torrent_hash": dbTorrent.Hash,
 		})
+		qbTorrent.place(dbTorrent.Category, dbTorrent.Tags)
 		if !addTorrentToQbittorrent(qbTorrent, true) {
-			checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-			if !exists {
-				checkInfo = &TorrentCheckInfo{}
-				TorrentCheckInfos[dbTorrent.Url] = checkInfo
-			}
-			checkInfo.LastCheckTime = time.Now()
-			checkInfo.LastCheckSuccess = false
-			msg := CheckUpdateMessage{
-				Type:             "check_update",
-				Url:              dbTorrent.Url,
-				LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-				LastCheckSuccess: checkInfo.LastCheckSuccess,
-			}
-			jsonMsg, _ := json.Marshal(msg)
-			wsChan <- string(jsonMsg)
-			return dbTorrent, fmt.Errorf("torrent not added to qbittorrent")
+			err = fmt.Errorf("torrent not added to qbittorrent")
+			recordCheck(dbTorrent.Url, false, err)
+			return dbTorrent, metrics.CheckFailed, err
 		}
+		outcome = metrics.CheckReadded
+	} else if common.IsExternalTorrentUrl(dbTorrent.Url) {
+		// External torrents have no tracker topic to look for a new version on
+		log.Info("torrent_up_to_date", "External torrent is in qBittorrent", map[string]string{
+			"torrent_url":  dbTorrent.Url,
+			"torrent_hash": dbTorrent.Hash,
+		})
 	} else {
 		// Get the appropriate tracker based on URL
 		tracker, err := models.GlobalTrackerManager.GetTrackerByURL(dbTorrent.Url)
 		if err != nil {
 			log.Error("get_tracker", "Error while getting tracker for URL", map[string]string{"error": err.Error(), "url": dbTorrent.Url})
-			checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-			if !exists {
-				checkInfo = &TorrentCheckInfo{}
-				TorrentCheckInfos[dbTorrent.Url] = checkInfo
-			}
-			checkInfo.LastCheckTime = time.Now()
-			checkInfo.LastCheckSuccess = false
-			msg := CheckUpdateMessage{
-				Type:             "check_update",
-				Url:              dbTorrent.Url,
-				LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-				LastCheckSuccess: checkInfo.LastCheckSuccess,
-			}
-			jsonMsg, _ := json.Marshal(msg)
-			wsChan <- string(jsonMsg)
-			return dbTorrent, err
+			recordCheck(dbTorrent.Url, false, err)
+			return dbTorrent, metrics.CheckFailed, err
 		}
 
 		// Get torrent info from tracker
@@ -258,26 +391,13 @@ func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.To
 		torrentInfo, err := tracker.GetTorrentHash(dbTorrent.Url)
 		if err != nil {
 			log.Error("get_torrent_info", "Error while getting torrent info from tracker", map[string]string{"error": err.Error()})
-			checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-			if !exists {
-				checkInfo = &TorrentCheckInfo{}
-				TorrentCheckInfos[dbTorrent.Url] = checkInfo
-			}
-			checkInfo.LastCheckTime = time.Now()
-			checkInfo.LastCheckSuccess = false
-			msg := CheckUpdateMessage{
-				Type:             "check_update",
-				Url:              dbTorrent.Url,
-				LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-				LastCheckSuccess: checkInfo.LastCheckSuccess,
-			}
-			jsonMsg, _ := json.Marshal(msg)
-			wsChan <- string(jsonMsg)
-			return dbTorrent, err
+			recordCheck(dbTorrent.Url, false, err)
+			return dbTorrent, metrics.CheckFailed, err
 		}
 
 		// If hash is not equal then update torrent
 		if torrentInfo.Hash != dbTorrent.Hash {
+			metrics.UpdatesDetected.Inc(models.TrackerNameByURL(dbTorrent.Url))
 			log.Info("torrent_update_detected", "Torrent hash changed, updating", map[string]string{
 				"torrent_url": dbTorrent.Url,
 				"old_hash":    dbTorrent.Hash,
@@ -302,35 +422,29 @@ func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.To
 				savePath = "/downloads" // fallback path
 			}
 			qbTorrent.SavePath = savePath
+			// The new version keeps the category and tags of the old one
+			if current, ok := findTorrent(qbTorrents, dbTorrent.Hash); ok {
+				qbTorrent.Category, qbTorrent.Tags, qbTorrent.AutoTMM = current.Category, current.Tags, current.AutoTMM
+			} else {
+				qbTorrent.place(dbTorrent.Category, dbTorrent.Tags)
+			}
 
-			if !updateTorrentInQbittorrent(qbTorrent, torrentInfo) {
+			if !updateTorrentInQbittorrent(qbTorrent, torrentInfo, dbTorrent.UpdateStrategy) {
 				log.Error("update_torrent_in_qbittorrent", "Failed to update torrent in qBittorrent", map[string]string{
 					"torrent_url": dbTorrent.Url,
 					"old_hash":    dbTorrent.Hash,
 					"new_hash":    torrentInfo.Hash,
 				})
-				checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-				if !exists {
-					checkInfo = &TorrentCheckInfo{}
-					TorrentCheckInfos[dbTorrent.Url] = checkInfo
-				}
-				checkInfo.LastCheckTime = time.Now()
-				checkInfo.LastCheckSuccess = false
-				msg := CheckUpdateMessage{
-					Type:             "check_update",
-					Url:              dbTorrent.Url,
-					LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-					LastCheckSuccess: checkInfo.LastCheckSuccess,
-				}
-				jsonMsg, _ := json.Marshal(msg)
-				wsChan <- string(jsonMsg)
-				return dbTorrent, fmt.Errorf("torrent not updated in qbittorrent")
+				err = fmt.Errorf("torrent not updated in qbittorrent")
+				recordCheck(dbTorrent.Url, false, err)
+				return dbTorrent, metrics.CheckFailed, err
 			}
 
 			// Update the database torrent record with new hash and title
 			dbTorrent.Hash = torrentInfo.Hash
 			dbTorrent.Title = torrentInfo.Title
 			dbTorrent.Name = torrentInfo.Title
+			outcome = metrics.CheckUpdated
 
 			log.Info("torrent_updated_successfully", "Torrent updated successfully", map[string]string{
 				"torrent_url": dbTorrent.Url,
@@ -345,34 +459,20 @@ func torrentChecker(dbTorrent database.Torrent, wsChan chan string) (database.To
 		}
 	}
 
-	checkInfo, exists := TorrentCheckInfos[dbTorrent.Url]
-	if !exists {
-		checkInfo = &TorrentCheckInfo{}
-		TorrentCheckInfos[dbTorrent.Url] = checkInfo
-	}
-	checkInfo.LastCheckTime = time.Now()
-	checkInfo.LastCheckSuccess = true
-	msg := CheckUpdateMessage{
-		Type:             "check_update",
-		Url:              dbTorrent.Url,
-		LastCheckTime:    checkInfo.LastCheckTime.Format(time.RFC3339),
-		LastCheckSuccess: checkInfo.LastCheckSuccess,
-	}
-	jsonMsg, _ := json.Marshal(msg)
-	wsChan <- string(jsonMsg)
-	return dbTorrent, nil
+	recordCheck(dbTorrent.Url, true, nil)
+	return dbTorrent, outcome, nil
 }
 
 // createOrUpdateWatcher creates or updates watcher for torrent
-func createOrUpdateWatcher(dbTorrent database.Torrent, torrentWatchers map[int]*TorrentWatcher, wsChan chan string) {
+func createOrUpdateWatcher(parent context.Context, dbTorrent database.Torrent, torrentWatchers map[int]*TorrentWatcher) {
 	// Create context for watcher
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 	// Create or update watcher
 	torrentWatchers[dbTorrent.ID] = &TorrentWatcher{
 		cancel:     cancel,
 		watchEvery: dbTorrent.WatchEvery,
 	}
-	go torrentWorker(ctx, dbTorrent, wsChan)
+	go torrentWorker(ctx, dbTorrent)
 	log.Info("info", "Torrent watcher created or updated", map[string]string{
 		"torrent_url":  dbTorrent.Url,
 		"torrent_hash": dbTorrent.Hash,
@@ -408,8 +508,34 @@ func deleteRemovedTorrents(dbTorrents []database.Torrent, torrentWatchers map[in
 	}
 }
 
-// TorrentChecker checks torrents in database and qbittorrent
-func TorrentChecker(wsChan chan string) {
+// syncWatchers creates a watcher for every torrent with watch interval, restarts the
+// watchers whose interval changed and deletes the ones of torrents no longer watched
+func syncWatchers(ctx context.Context, dbTorrents []database.Torrent, torrentWatchers map[int]*TorrentWatcher) {
+	for _, dbTorrent := range dbTorrents {
+		watcher, ok := torrentWatchers[dbTorrent.ID]
+		switch {
+		case dbTorrent.WatchEvery <= 0:
+			// If watch interval equals to 0 then delete watcher
+			if ok {
+				watcher.cancel()
+				delete(torrentWatchers, dbTorrent.ID)
+				log.Info("info", "Torrent watcher deleted", map[string]string{
+					"torrent_url":  dbTorrent.Url,
+					"torrent_hash": dbTorrent.Hash,
+				})
+			}
+		case !ok:
+			createOrUpdateWatcher(ctx, dbTorrent, torrentWatchers)
+		case watcher.watchEvery != dbTorrent.WatchEvery:
+			// If watch interval changed then delete watcher and create new one
+			watcher.cancel()
+			createOrUpdateWatcher(ctx, dbTorrent, torrentWatchers)
+		}
+	}
+}
+
+// TorrentChecker checks torrents in database and qbittorrent until ctx is done
+func TorrentChecker(ctx context.Context) {
 	log.Info("info", "Checker started", nil)
 
 	// Get torrent list from database every 5 minutes
@@ -417,7 +543,7 @@ func TorrentChecker(wsChan chan string) {
 	torrentWatchers := make(map[int]*TorrentWatcher)
 
 	// Initialize check info for existing torrents at startup
-	dbTorrents, err := database.GetAllRecords(database.DB)
+	dbTorrents, err := database.Repo.GetAllRecords()
 	if err != nil {
 		log.Error("get_db_records_initial", err.Error(), nil)
 		return
@@ -426,21 +552,20 @@ func TorrentChecker(wsChan chan string) {
 	// Initialize check info for all existing torrents with proper default values
 	// No need to send initial messages here - WebSocket pool handles this when clients connect
 	for _, dbTorrent := range dbTorrents {
-		if _, exists := TorrentCheckInfos[dbTorrent.Url]; !exists {
-			checkInfo := &TorrentCheckInfo{
-				LastCheckTime:    time.Now(),
-				LastCheckSuccess: true, // Assume success for existing torrents initially
-			}
-			TorrentCheckInfos[dbTorrent.Url] = checkInfo
+		// Assume success for existing torrents initially
+		if _, added := InitCheckInfo(dbTorrent.Url); added {
 			log.Info("info", "Initialized check info for existing torrent", map[string]string{
 				"torrent_url": dbTorrent.Url,
 			})
 		}
 	}
 
+	// Finish the replacements interrupted by a crash before the watchers start checking
+	RecoverReplacements()
+
 	for {
 		// Get torrent list from database
-		dbTorrents, err := database.GetAllRecords(database.DB)
+		dbTorrents, err := database.Repo.GetAllRecords()
 		if err != nil {
 			log.Error("get_db_records", err.Error(), nil)
 			return
@@ -448,12 +573,8 @@ func TorrentChecker(wsChan chan string) {
 
 		// Initialize check info for any new torrents that might have been added
 		for _, dbTorrent := range dbTorrents {
-			if _, exists := TorrentCheckInfos[dbTorrent.Url]; !exists {
-				checkInfo := &TorrentCheckInfo{
-					LastCheckTime:    time.Now(),
-					LastCheckSuccess: true, // Assume success for new torrents initially
-				}
-				TorrentCheckInfos[dbTorrent.Url] = checkInfo
+			// Assume success for new torrents initially
+			if _, added := InitCheckInfo(dbTorrent.Url); added {
 				log.Info("info", "Initialized check info for new torrent", map[string]string{
 					"torrent_url": dbTorrent.Url,
 				})
@@ -463,44 +584,43 @@ func TorrentChecker(wsChan chan string) {
 		// Delete removed torrents
 		deleteRemovedTorrents(dbTorrents, torrentWatchers)
 
-		// Iterate over torrents and create watcher for every torrent with watch interval
-		for _, dbTorrent := range dbTorrents {
-			if dbTorrent.WatchEvery > 0 {
-				// Check if watcher already exists
-				if watcher, ok := torrentWatchers[dbTorrent.ID]; ok {
-					// If watch interval changed then delete watcher and create new one
-					if watcher.watchEvery != dbTorrent.WatchEvery {
-						watcher.cancel()
-
-						// If watch interval equals to 0 then delete watcher
-						if dbTorrent.WatchEvery == 0 {
-							delete(torrentWatchers, dbTorrent.ID)
-							log.Info("info", "Torrent watcher deleted", map[string]string{
-								"torrent_url":  dbTorrent.Url,
-								"torrent_hash": dbTorrent.Hash,
-							})
-						} else {
-							createOrUpdateWatcher(dbTorrent, torrentWatchers, wsChan)
-						}
-					}
-				} else {
-					createOrUpdateWatcher(dbTorrent, torrentWatchers, wsChan)
-				}
-			}
-		}
+		// Create a watcher for every torrent with watch interval
+		syncWatchers(ctx, dbTorrents, torrentWatchers)
+		watchers.Store(int64(len(torrentWatchers)))
+
 		// Sleep for 5 seconds
-		time.Sleep(5 * time.Second)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			// The watchers stop with ctx, running checks are awaited by Drain
+			watchers.Store(0)
+			log.Info("info", "Checker stopped", nil)
+			return
+		}
 	}
 }
 
-// WsMessageHandler for handling websocket messages
-func WsMessageHandler(wsMsg chan string, torrentData chan common.TorrentData) {
+// WsMessageHandler adds torrents received from the API until ctx is done
+func WsMessageHandler(ctx context.Context, torrentData chan common.TorrentData) {
 	log.Info("info", "Websocket handler started", nil)
-	for torrentUrl := range torrentData {
-		log.Info("info", "URL received for adding", map[string]string{
-			"torrent_url": torrentUrl.Url,
-		})
-		go torrentAdder(GlobalManager.User, torrentUrl, wsMsg)
+	for {
+		select {
+		case torrentUrl := <-torrentData:
+			log.Info("info", "URL received for adding", map[string]string{
+				"torrent_url": torrentUrl.Url,
+			})
+			if !work.begin() {
+				failJob(torrentUrl.JobID, ErrShuttingDown)
+				continue
+			}
+			go func() {
+				defer work.end()
+				torrentAdder(GlobalManager.User, torrentUrl)
+			}()
+		case <-ctx.Done():
+			log.Info("info", "Websocket handler stopped", nil)
+			return
+		}
 	}
 }
 
@@ -524,20 +644,28 @@ func handleQbittorrentError(err error) {
 }
 
 func contains(s []Torrent, e string) bool {
+	_, ok := findTorrent(s, e)
+	return ok
+}
+
+// findTorrent returns the torrent with the given hash
+func findTorrent(s []Torrent, hash string) (Torrent, bool) {
 	for _, a := range s {
-		if a.Hash == e {
-			return true
+		if a.Hash == hash {
+			return a, true
 		}
 	}
-	return false
+	return Torrent{}, false
 }
 
-func kinozalAction(dbTorrent Torrent) (models.Torrent, error) {
+// kinozalAction adds a kinozal.tv torrent to qBittorrent. The .torrent file is
+// returned as well, it is nil when the torrent was added by magnet link.
+func kinozalAction(dbTorrent Torrent) (models.Torrent, []byte, error) {
 	// Get the Kinozal tracker from tracker manager
 	tracker, err := models.GlobalTrackerManager.GetTracker("kinozal")
 	if err != nil {
 		log.Error("get_tracker", "Error getting Kinozal tracker from manager", map[string]string{"error": err.Error()})
-		return models.Torrent{}, err
+		return models.Torrent{}, nil, err
 	}
 
 	torrentFile, err := tracker.DownloadTorrentFile(dbTorrent.Url)
@@ -549,38 +677,38 @@ func kinozalAction(dbTorrent Torrent) (models.Torrent, error) {
 		})
 
 		// Add torrent by magnet link
-		addErr := GlobalManager.User.AddTorrentByMagnet(dbTorrent.Hash, dbTorrent.SavePath)
+		addErr := GlobalManager.User.AddTorrentByMagnet(dbTorrent)
 		if addErr != nil {
 			log.Error("add_torrent_by_magnet", "Error adding torrent by magnet link", map[string]string{"error": addErr.Error()})
-			return models.Torrent{}, addErr
+			return models.Torrent{}, nil, addErr
 		}
 	} else {
-		addErr := GlobalManager.User.AddTorrent(dbTorrent.Hash, dbTorrent.SavePath, torrentFile)
+		addErr := GlobalManager.User.AddTorrent(dbTorrent, torrentFile)
 		if addErr != nil {
 			log.Error("add_torrent", "Error adding torrent", map[string]string{"error": addErr.Error()})
-			return models.Torrent{}, addErr
+			return models.Torrent{}, nil, addErr
 		}
 	}
 
 	torrentInfo, err := tracker.GetTorrentHash(dbTorrent.Url)
 	if err != nil {
 		log.Error("get_torrent_info", "Error getting torrent info", map[string]string{"error": err.Error()})
-		return models.Torrent{}, err
+		return models.Torrent{}, nil, err
 	}
 
 	// Get title from kinozal.tv
 	title, err := tracker.GetTitleFromUrl(dbTorrent.Url)
 	if err != nil {
 		log.Error("get_title_from_url", "Error getting title from URL", map[string]string{"error": err.Error()})
-		return models.Torrent{}, err
+		return models.Torrent{}, nil, err
 	}
 
 	torrentInfo.Title = title
 
-	return torrentInfo, nil
+	return torrentInfo, torrentFile, nil
 }
 
-func addTorrentToQbittorrent(dbTorrent Torrent, sendTgMessage bool) bool {
+func addTorrentToQbittorrent(dbTorrent Torrent, notify bool) bool {
 	// Check what torrent tracker is in the URL
 	trackerDomain := common.GetTrackerDomain(dbTorrent.Url)
 	var torrentInfo models.Torrent
@@ -589,7 +717,7 @@ func addTorrentToQbittorrent(dbTorrent Torrent, sendTgMessage bool) bool {
 
 	switch trackerDomain {
 	case "kinozal.tv":
-		torrentInfo, err = kinozalAction(dbTorrent)
+		torrentInfo, torrentData, err = kinozalAction(dbTorrent)
 		if err != nil {
 			log.Error("kinozal_action", err.Error(), nil)
 			return false
@@ -628,33 +756,63 @@ func addTorrentToQbittorrent(dbTorrent Torrent, sendTgMessage bool) bool {
 		}
 
 		// Add the torrent to qBittorrent using the downloaded file
-		addErr := GlobalManager.User.AddTorrent(dbTorrent.Hash, dbTorrent.SavePath, torrentData)
+		addErr := GlobalManager.User.AddTorrent(dbTorrent, torrentData)
 		if addErr != nil {
 			log.Error("add_torrent", "Error adding torrent", map[string]string{"error": addErr.Error()})
 			return false
 		}
+	default:
+		if !common.IsExternalTorrentUrl(dbTorrent.Url) {
+			log.Error("get_tracker", "No tracker for URL", map[string]string{"url": dbTorrent.Url})
+			return false
+		}
+		// The .torrent file of an external torrent is not kept, it is added back by its hash
+		if err := GlobalManager.User.AddTorrentByMagnet(dbTorrent); err != nil {
+			log.Error("add_torrent_by_magnet", "Error adding torrent by magnet link", map[string]string{"error": err.Error()})
+			return false
+		}
+		torrentInfo = models.Torrent{Title: dbTorrent.Title, Name: dbTorrent.Name, Hash: dbTorrent.Hash, Url: dbTorrent.Url}
 	}
 
 	// Save torrent information to database
-	err = database.CreateOrUpdateRecord(database.DB, torrentInfo)
+	err = database.Repo.CreateOrUpdateRecord(torrentInfo)
 	if err != nil {
 		log.Error("create_or_update_record", "Error saving torrent info to database", map[string]string{"error": err.Error()})
 	}
+	if torrentData != nil {
+		recordTorrentVersion(torrentInfo.Url, torrentData)
+	}
 
-	if sendTgMessage {
-		err := telegram.SendTorrentAction("added", globalConfig.TelegramToken, torrentInfo)
-		if err != nil {
-			log.Error("send_telegram_notification", err.Error(), nil)
-		}
+	if notify {
+		notifyAdded(torrentInfo, dbTorrent.SavePath)
 	}
 	return true
 }
 
-func updateTorrentInQbittorrent(dbTorrent Torrent, torrentInfo models.Torrent) bool {
+// notifyAdded publishes a TorrentAdded event for a torrent added to qBittorrent and audits the add
+func notifyAdded(torrentInfo models.Torrent, savePath string) {
+	events.Publish(events.Event{
+		Type:  events.TorrentAdded,
+		Url:   torrentInfo.Url,
+		Title: torrentInfo.Title,
+		Hash:  torrentInfo.Hash,
+	})
+	database.Audit(database.AuditEntry{
+		Actor:  database.ActorSystem,
+		Action: database.AuditQbittorrentAdd,
+		Target: torrentInfo.Url,
+		After:  database.AuditValue(map[string]string{"hash": torrentInfo.Hash, "title": torrentInfo.Title, "save_path": savePath}),
+	})
+}
+
+// updateTorrentInQbittorrent replaces the torrent of dbTorrent with its new version
+// following the update strategy. The replacement is journaled first, see runReplacement.
+func updateTorrentInQbittorrent(dbTorrent Torrent, torrentInfo models.Torrent, strategy string) bool {
 	log.Info("update_torrent_start", "Starting torrent update process", map[string]string{
 		"torrent_url": dbTorrent.Url,
 		"old_hash":    dbTorrent.Hash,
 		"new_hash":    torrentInfo.Hash,
+		"strategy":    strategy,
 	})
 
 	// Find save path of torrent before deletion
@@ -671,60 +829,25 @@ func updateTorrentInQbittorrent(dbTorrent Torrent, torrentInfo models.Torrent) b
 			savePath = "/downloads" // default fallback
 		}
 	}
-	dbTorrent.SavePath = savePath
-
-	// Delete old torrent from qBittorrent (keep files)
-	err = GlobalManager.User.DeleteTorrent(dbTorrent.Hash, false)
-	if err != nil {
-		log.Error("delete_torrent", "Error deleting old torrent from qBittorrent", map[string]string{
-			"error":        err.Error(),
-			"torrent_hash": dbTorrent.Hash,
-		})
-		return false
-	}
-
-	log.Info("old_torrent_deleted", "Old torrent deleted from qBittorrent", map[string]string{
-		"old_hash": dbTorrent.Hash,
-	})
-
-	// Update database record with new torrent info
-	err = database.UpdateRecord(database.DB, torrentInfo)
-	if err != nil {
-		log.Error("update_db_record", "Error updating torrent record in database", map[string]string{
-			"error":    err.Error(),
-			"new_hash": torrentInfo.Hash,
-		})
-		return false
-	}
 
-	log.Info("database_updated", "Database record updated with new torrent info", map[string]string{
-		"new_hash": torrentInfo.Hash,
-	})
-
-	// Create new torrent struct with updated info for adding to qBittorrent
-	newTorrent := Torrent{
-		Hash:     torrentInfo.Hash,
-		Name:     torrentInfo.Title,
-		Title:    torrentInfo.Title,
+	replacement, err := startReplacement(database.Replacement{
 		Url:      torrentInfo.Url,
+		OldHash:  dbTorrent.Hash,
+		NewHash:  torrentInfo.Hash,
+		NewTitle: torrentInfo.Title,
 		SavePath: savePath,
-	}
-
-	// Add updated torrent to qBittorrent
-	if !addTorrentToQbittorrent(newTorrent, false) {
-		log.Error("add_updated_torrent", "Error adding updated torrent to qBittorrent", map[string]string{
-			"new_hash": torrentInfo.Hash,
-		})
-		return false
-	}
-
-	// Send Telegram notification about the update
-	err = telegram.SendTorrentAction("updated", globalConfig.TelegramToken, torrentInfo)
+		Strategy: strategy,
+		Category: dbTorrent.Category,
+		Tags:     splitList(dbTorrent.Tags),
+		AutoTMM:  dbTorrent.AutoTMM,
+	})
 	if err != nil {
-		log.Error("send_telegram_notification", "Error sending Telegram notification", map[string]string{
-			"error": err.Error(),
+		log.Error("update_torrent", "Error replacing torrent in qBittorrent", map[string]string{
+			"error":       err.Error(),
+			"torrent_url": torrentInfo.Url,
+			"state":       replacement.State,
 		})
-		// Don't return false here as the update was successful, just notification failed
+		return false
 	}
 
 	log.Info("torrent_update_completed", "Torrent update process completed successfully", map[string]string{
@@ -736,3 +859,42 @@ func updateTorrentInQbittorrent(dbTorrent Torrent, torrentInfo models.Torrent) b
 
 	return true
 }
+
+// resumeReplacement finishes the interrupted replacement of dbTorrent before it is checked
+func resumeReplacement(dbTorrent database.Torrent, replacement database.Replacement) (database.Torrent, string, error) {
+	log.Info("replacement_resume", "Resuming interrupted replacement", map[string]string{
+		"torrent_url": dbTorrent.Url,
+		"state":       replacement.State,
+	})
+	replacement, err := runReplacement(replacement)
+	if err != nil {
+		recordCheck(dbTorrent.Url, false, err)
+		return dbTorrent, metrics.CheckFailed, err
+	}
+	if replacement.State != database.ReplacementDone {
+		// Another check finished the replacement, the record holds the hash it left in place
+		current, err := database.Repo.GetRecordByUrl(dbTorrent.Url)
+		if err != nil {
+			recordCheck(dbTorrent.Url, false, err)
+			return dbTorrent, metrics.CheckFailed, err
+		}
+		recordCheck(dbTorrent.Url, true, nil)
+		return current, metrics.CheckUpToDate, nil
+	}
+	dbTorrent.Hash = replacement.NewHash
+	dbTorrent.Title = replacement.NewTitle
+	dbTorrent.Name = replacement.NewTitle
+	recordCheck(dbTorrent.Url, true, nil)
+	return dbTorrent, metrics.CheckUpdated, nil
+}
+
+// recordTorrentVersion stores the metadata of a downloaded .torrent file as a version of the torrent of url
+func recordTorrentVersion(url string, torrentData []byte) {
+	meta, err := models.ParseTorrent(torrentData)
+	if err == nil {
+		err = database.Repo.AddTorrentVersion(database.NewTorrentVersion(url, meta))
+	}
+	if err != nil {
+		log.Error("record_torrent_version", "Error recording torrent version", map[string]string{"error": err.Error(), "torrent_url": url})
+	}
+}
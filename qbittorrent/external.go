@@ -0,0 +1,100 @@
+package qbittorrent
+
+import (
+	"fmt"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/jobs"
+	"kinozaltv_monitor/models"
+)
+
+// externalAdder stores a torrent that came as an uploaded .torrent file or a magnet link
+// instead of a tracker topic and adds it to qBittorrent. There is no topic to check for
+// new versions, so the torrent is recorded unwatched under common.ExternalTorrentUrl.
+func externalAdder(qbUser *QbittorrentUser, torrentData common.TorrentData) {
+	jobID := torrentData.JobID
+	link, err := models.ParseMagnet(torrentData.Url)
+	if err != nil {
+		failJob(jobID, err)
+		return
+	}
+	torrentInfo := models.Torrent{Title: torrentData.Title, Name: torrentData.Title, Hash: link.Hash, Url: torrentData.Url}
+	if torrentInfo.Title == "" {
+		torrentInfo.Title, torrentInfo.Name = link.Hash, link.Hash
+	}
+
+	// Check if torrent exists in qbittorrent
+	torrentHashList, err := qbUser.GetTorrentHashList()
+	if err != nil {
+		log.Error("get_qb_torrents", err.Error(), nil)
+		clientUnavailable(err)
+		failJob(jobID, fmt.Errorf("qBittorrent is unavailable: %w", err))
+		return
+	}
+
+	resolved := func(job *jobs.Job) {
+		job.Hash = torrentInfo.Hash
+		job.Title = torrentInfo.Title
+	}
+	// The torrent is only recorded once qBittorrent has it
+	duplicate := contains(torrentHashList, torrentInfo.Hash)
+	if !duplicate {
+		updateJob(jobID, jobs.StateDownloading, resolved)
+		qbTorrent := Torrent{Hash: torrentInfo.Hash, Url: torrentData.Url, SavePath: torrentData.DownloadPath}
+		qbTorrent.place(torrentData.Category, torrentData.Tags)
+		if torrentData.Torrent != nil {
+			err = qbUser.AddTorrent(qbTorrent, torrentData.Torrent)
+		} else {
+			err = qbUser.AddMagnet(qbTorrent, torrentData.Magnet)
+		}
+		if err != nil {
+			log.Error("add_torrent", "Error adding external torrent", map[string]string{"error": err.Error(), "torrent_url": torrentData.Url})
+			failJob(jobID, fmt.Errorf("failed to add torrent to qBittorrent: %w", err))
+			return
+		}
+	}
+
+	if err := recordExternal(torrentInfo, torrentData); err != nil {
+		// Nothing would track the torrent, take it out of qBittorrent again
+		if !duplicate {
+			if deleteErr := qbUser.DeleteTorrent(torrentInfo.Hash, false); deleteErr != nil {
+				log.Error("delete_torrent", deleteErr.Error(), map[string]string{"torrent_url": torrentData.Url})
+			}
+		}
+		failJob(jobID, err)
+		return
+	}
+	if duplicate {
+		updateJob(jobID, jobs.StateDuplicate, resolved)
+		return
+	}
+
+	notifyAdded(torrentInfo, torrentData.DownloadPath)
+	log.Info("info", "External torrent added", map[string]string{
+		"torrent_url": torrentData.Url,
+	})
+	updateJob(jobID, jobs.StateAdded)
+}
+
+// recordExternal records an external torrent unwatched on the watch list of the user who added it
+func recordExternal(torrentInfo models.Torrent, torrentData common.TorrentData) error {
+	if err := database.Repo.CreateOrUpdateRecord(torrentInfo); err != nil {
+		log.Error("create_or_update_record", err.Error(), nil)
+		return err
+	}
+	if torrentData.UserID != 0 {
+		if err := database.Repo.AddWatcher(torrentData.UserID, torrentData.Url); err != nil {
+			log.Error("add_watcher", err.Error(), map[string]string{"torrent_url": torrentData.Url})
+			return err
+		}
+	}
+	torrentData.WatchEvery = 0
+	if err := applyTorrentSettings(torrentData); err != nil {
+		log.Error("apply_torrent_settings", err.Error(), map[string]string{"torrent_url": torrentData.Url})
+	}
+	if torrentData.Torrent != nil {
+		recordTorrentVersion(torrentData.Url, torrentData.Torrent)
+	}
+	recordCheck(torrentData.Url, true, nil)
+	return nil
+}
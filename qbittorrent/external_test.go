@@ -0,0 +1,72 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestExternalAdder_AddFails(t *testing.T) {
+	useTestRepository(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/app/version":
+			_, _ = w.Write([]byte("v5.0.0"))
+		case "/api/v2/torrents/info":
+			_, _ = w.Write([]byte("[]"))
+		default:
+			http.Error(w, "Fails", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	saved := config.GlobalConfig.QBUrl
+	config.GlobalConfig.QBUrl = server.URL
+	t.Cleanup(func() { config.GlobalConfig.QBUrl = saved })
+	jar, _ := cookiejar.New(nil)
+
+	hash := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	torrentData := common.TorrentData{Url: common.ExternalTorrentUrl(hash), Magnet: "magnet:?xt=urn:btih:" + hash, DownloadPath: "/downloads"}
+	externalAdder(&QbittorrentUser{Client: &http.Client{Jar: jar}}, torrentData)
+
+	if _, err := database.Repo.GetRecordByUrl(torrentData.Url); err != database.ErrNotFound {
+		t.Errorf("Expected no record for a torrent qBittorrent did not add, got %v", err)
+	}
+}
+
+func TestExternalAdder_RecordFails(t *testing.T) {
+	useTestRepository(t)
+	repo := database.Repo
+	database.Repo = failingWatchers{repo}
+	var mu sync.Mutex
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/torrents/info":
+			_, _ = w.Write([]byte("[]"))
+		case "/api/v2/torrents/delete":
+			mu.Lock()
+			deleted = append(deleted, r.FormValue("hashes"))
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+	saved := config.GlobalConfig.QBUrl
+	config.GlobalConfig.QBUrl = server.URL
+	t.Cleanup(func() { config.GlobalConfig.QBUrl = saved })
+	jar, _ := cookiejar.New(nil)
+
+	hash := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	torrentData := common.TorrentData{Url: common.ExternalTorrentUrl(hash), Magnet: "magnet:?xt=urn:btih:" + hash, UserID: 1}
+	externalAdder(&QbittorrentUser{Client: &http.Client{Jar: jar}}, torrentData)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deleted) != 1 || deleted[0] != hash {
+		t.Errorf("Expected the untracked torrent to be removed from qBittorrent, got %v", deleted)
+	}
+}
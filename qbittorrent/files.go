@@ -0,0 +1,224 @@
+package qbittorrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kinozaltv_monitor/config"
+)
+
+// TorrentFile is a file of a torrent as listed by qBittorrent
+type TorrentFile struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Priority int    `json:"priority"`
+}
+
+// FilePrioritySkip is the priority of files qBittorrent does not download
+const FilePrioritySkip = 0
+
+// request sends a request built by newRequest, re-authenticating once when the
+// session expired, and checks that qBittorrent answered 200 OK. action names the
+// request in logs and errors. The caller closes the body of the returned response.
+func (qb *QbittorrentUser) request(action, hash string, newRequest func() (*http.Response, error)) (*http.Response, error) {
+	// Ensure we have a valid session before making the request
+	if err := qb.ensureValidSession(); err != nil {
+		log.Error("qbittorrent", "Failed to ensure valid session to "+action, map[string]string{"error": err.Error(), "hash": hash})
+		return nil, err
+	}
+
+	resp, err := newRequest()
+	if err != nil {
+		log.Error("qbittorrent", "Failed to "+action, map[string]string{"hash": hash, "error": err.Error()})
+		return nil, err
+	}
+
+	// Check if we got a forbidden response (session expired during request)
+	if resp.StatusCode == http.StatusForbidden {
+		_ = resp.Body.Close()
+		log.Info("qbittorrent", "Received 403 Forbidden, attempting to re-authenticate", map[string]string{"hash": hash, "action": action})
+		if err := qb.ensureValidSession(); err != nil {
+			log.Error("qbittorrent", "Failed to re-authenticate after 403", map[string]string{"error": err.Error(), "hash": hash})
+			return nil, err
+		}
+		resp, err = newRequest()
+		if err != nil {
+			log.Error("qbittorrent", "Failed to "+action+" after re-authentication", map[string]string{"hash": hash, "error": err.Error()})
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		log.Error("qbittorrent", "Failed to "+action+", non-200 response", map[string]string{
+			"hash":        hash,
+			"status_code": fmt.Sprintf("%d", resp.StatusCode),
+		})
+		return resp, fmt.Errorf("failed to %s, status: %d", action, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// post sends a form to a qBittorrent endpoint, see request
+func (qb *QbittorrentUser) post(action, hash, endpoint string, form url.Values) (*http.Response, error) {
+	resp, err := qb.request(action, hash, func() (*http.Response, error) {
+		return qb.Client.PostForm(config.GlobalConfig.QBUrl+endpoint, form)
+	})
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
+
+// GetTorrentFiles is a method for getting the files of a torrent. The list is empty
+// while qBittorrent is still fetching the metadata of a torrent added by magnet link.
+func (qb *QbittorrentUser) GetTorrentFiles(hash string) ([]TorrentFile, error) {
+	resp, err := qb.request("get torrent files", hash, func() (*http.Response, error) {
+		return qb.Client.Get(config.GlobalConfig.QBUrl + "/api/v2/torrents/files?hash=" + url.QueryEscape(hash))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var files []TorrentFile
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		log.Error("qbittorrent", "Failed to decode torrent files JSON", map[string]string{"hash": hash, "error": err.Error()})
+		return nil, err
+	}
+	return files, nil
+}
+
+// SetFilePriority is a method for setting the download priority of files of a torrent by index
+func (qb *QbittorrentUser) SetFilePriority(hash string, indexes []int, priority int) error {
+	ids := make([]string, len(indexes))
+	for i, index := range indexes {
+		ids[i] = strconv.Itoa(index)
+	}
+	_, err := qb.post("set file priority", hash, "/api/v2/torrents/filePrio", url.Values{
+		"hash":     {hash},
+		"id":       {strings.Join(ids, "|")},
+		"priority": {strconv.Itoa(priority)},
+	})
+	return err
+}
+
+// RenameFolder is a method for renaming a folder of a torrent, moving its files on disk
+func (qb *QbittorrentUser) RenameFolder(hash, oldPath, newPath string) error {
+	_, err := qb.post("rename torrent folder", hash, "/api/v2/torrents/renameFolder", url.Values{
+		"hash":    {hash},
+		"oldPath": {oldPath},
+		"newPath": {newPath},
+	})
+	return err
+}
+
+// RenameFile is a method for renaming a file of a torrent on disk
+func (qb *QbittorrentUser) RenameFile(hash, oldPath, newPath string) error {
+	_, err := qb.post("rename torrent file", hash, "/api/v2/torrents/renameFile", url.Values{
+		"hash":    {hash},
+		"oldPath": {oldPath},
+		"newPath": {newPath},
+	})
+	return err
+}
+
+// StartTorrent is a method for starting a paused torrent
+func (qb *QbittorrentUser) StartTorrent(hash string) error {
+	resp, err := qb.post("start torrent", hash, "/api/v2/torrents/start", url.Values{"hashes": {hash}})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// qBittorrent before 5.0 calls it resume
+		_, err = qb.post("resume torrent", hash, "/api/v2/torrents/resume", url.Values{"hashes": {hash}})
+	}
+	return err
+}
+
+// AddMagnet is a method for adding a torrent by a magnet link as given, keeping its
+// trackers and name. AddTorrentByMagnet builds the link from the hash alone.
+func (qb *QbittorrentUser) AddMagnet(t Torrent, magnet string) error {
+	form := t.addForm()
+	form.Set("urls", magnet)
+	_, err := qb.post("add torrent by magnet link", t.Hash, "/api/v2/torrents/add", form)
+	return err
+}
+
+// Category is a qBittorrent category. SavePath is empty when torrents of the category
+// are saved to a subdirectory named after it in the default save path.
+type Category struct {
+	Name     string `json:"name"`
+	SavePath string `json:"save_path"`
+}
+
+// GetCategories is a method for getting the categories of qBittorrent sorted by name
+func (qb *QbittorrentUser) GetCategories() ([]Category, error) {
+	resp, err := qb.request("get categories", "", func() (*http.Response, error) {
+		return qb.Client.Get(config.GlobalConfig.QBUrl + "/api/v2/torrents/categories")
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var byName map[string]struct {
+		Name     string `json:"name"`
+		SavePath string `json:"savePath"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&byName); err != nil {
+		log.Error("qbittorrent", "Failed to decode categories JSON", map[string]string{"error": err.Error()})
+		return nil, err
+	}
+	categories := make([]Category, 0, len(byName))
+	for name, category := range byName {
+		categories = append(categories, Category{Name: name, SavePath: category.SavePath})
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+// SetCategory is a method for changing the category of a torrent, an empty category removes it
+func (qb *QbittorrentUser) SetCategory(hash, category string) error {
+	_, err := qb.post("set category", hash, "/api/v2/torrents/setCategory", url.Values{"hashes": {hash}, "category": {category}})
+	return err
+}
+
+// AddTags is a method for adding tags to a torrent, qBittorrent creates the tags it does not know
+func (qb *QbittorrentUser) AddTags(hash string, tags []string) error {
+	_, err := qb.post("add tags", hash, "/api/v2/torrents/addTags", url.Values{"hashes": {hash}, "tags": {strings.Join(tags, ",")}})
+	return err
+}
+
+// RemoveTags is a method for removing tags from a torrent
+func (qb *QbittorrentUser) RemoveTags(hash string, tags []string) error {
+	_, err := qb.post("remove tags", hash, "/api/v2/torrents/removeTags", url.Values{"hashes": {hash}, "tags": {strings.Join(tags, ",")}})
+	return err
+}
+
+// TorrentProperties are the details of a torrent that the torrent list leaves out
+type TorrentProperties struct {
+	Comment  string `json:"comment"`
+	SavePath string `json:"save_path"`
+}
+
+// GetTorrentProperties is a method for getting the details of a torrent, e.g. the comment of its .torrent file
+func (qb *QbittorrentUser) GetTorrentProperties(hash string) (TorrentProperties, error) {
+	resp, err := qb.request("get torrent properties", hash, func() (*http.Response, error) {
+		return qb.Client.Get(config.GlobalConfig.QBUrl + "/api/v2/torrents/properties?hash=" + url.QueryEscape(hash))
+	})
+	if err != nil {
+		return TorrentProperties{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var properties TorrentProperties
+	if err := json.NewDecoder(resp.Body).Decode(&properties); err != nil {
+		log.Error("qbittorrent", "Failed to decode torrent properties JSON", map[string]string{"hash": hash, "error": err.Error()})
+		return TorrentProperties{}, err
+	}
+	return properties, nil
+}
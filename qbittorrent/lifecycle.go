@@ -0,0 +1,75 @@
+package qbittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrShuttingDown is returned for checks and adds that are requested after Drain was called
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// workGroup counts running checks and adds so that shutdown can wait for them.
+// A check that replaces a torrent deletes the old one before adding the new one
+// and must not be interrupted in between.
+type workGroup struct {
+	mu       sync.Mutex
+	active   int
+	stopping bool
+	idle     chan struct{}
+}
+
+// work tracks the checks and adds of the checker
+var work = newWorkGroup()
+
+func newWorkGroup() *workGroup {
+	return &workGroup{idle: make(chan struct{})}
+}
+
+// begin registers a unit of work, it returns false once the group is draining
+func (g *workGroup) begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopping {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// end marks a unit of work started by begin as finished
+func (g *workGroup) end() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 && g.stopping {
+		close(g.idle)
+	}
+}
+
+// drain stops new work from starting and waits until the running work finishes or ctx is done
+func (g *workGroup) drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.stopping = true
+	active := g.active
+	g.mu.Unlock()
+	if active == 0 {
+		return nil
+	}
+
+	select {
+	case <-g.idle:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return fmt.Errorf("%d checks and adds still running: %w", g.active, ctx.Err())
+	}
+}
+
+// Drain stops new checks and adds from starting and waits until the running ones
+// finish or ctx is done
+func Drain(ctx context.Context) error {
+	return work.drain(ctx)
+}
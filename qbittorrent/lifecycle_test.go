@@ -0,0 +1,67 @@
+package qbittorrent
+
+import (
+	"context"
+	"errors"
+	"kinozaltv_monitor/database"
+	"testing"
+	"time"
+)
+
+func TestWorkGroup_Drain(t *testing.T) {
+	g := newWorkGroup()
+	if !g.begin() {
+		t.Fatal("Expected work to start before draining")
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- g.drain(context.Background()) }()
+
+	// Wait until drain stopped new work from starting
+	for g.begin() {
+		g.end()
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-drained:
+		t.Fatal("Expected drain to wait for running work")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	g.end()
+	if err := <-drained; err != nil {
+		t.Errorf("drain() failed: %v", err)
+	}
+}
+
+func TestWorkGroup_DrainTimeout(t *testing.T) {
+	g := newWorkGroup()
+	g.begin()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected drain to give up with the context, got %v", err)
+	}
+	if err := newWorkGroup().drain(ctx); err != nil {
+		t.Errorf("Expected an idle group to drain right away, got %v", err)
+	}
+}
+
+func TestSyncWatchers_Unwatch(t *testing.T) {
+	unwatched, cancelUnwatched := context.WithCancel(context.Background())
+	watched, cancelWatched := context.WithCancel(context.Background())
+	defer cancelWatched()
+	torrentWatchers := map[int]*TorrentWatcher{
+		1: {cancel: cancelUnwatched, watchEvery: 30},
+		2: {cancel: cancelWatched, watchEvery: 60},
+	}
+
+	syncWatchers(context.Background(), []database.Torrent{{ID: 1, WatchEvery: 0}, {ID: 2, WatchEvery: 60}}, torrentWatchers)
+	if _, ok := torrentWatchers[1]; ok || unwatched.Err() == nil {
+		t.Error("Expected the watcher of the unwatched torrent to be cancelled and deleted")
+	}
+	if _, ok := torrentWatchers[2]; !ok || watched.Err() != nil {
+		t.Error("Expected the watcher with an unchanged interval to keep running")
+	}
+}
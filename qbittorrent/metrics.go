@@ -0,0 +1,57 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/metrics"
+	"net/http"
+	"time"
+)
+
+// instrumentedTransport records the latency and errors of qBittorrent Web API requests
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip sends req and records it by endpoint. Error statuses count as errors,
+// including the 403 of an expired session that is retried after logging in again.
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	endpoint := req.URL.Path
+	metrics.QbittorrentRequestDuration.ObserveSince(start, endpoint)
+	if err != nil || resp.StatusCode >= 400 {
+		metrics.QbittorrentErrors.Inc(endpoint)
+	}
+	return resp, err
+}
+
+// States of torrents in the kinozal_monitor_torrents metric
+const (
+	stateUnwatched = "unwatched"
+	stateOk        = "ok"
+	stateFailing   = "failing"
+)
+
+// CollectTorrentStates sets the number of torrents that are not watched, watched with a
+// successful last check and watched with a failed last check
+func CollectTorrentStates() {
+	dbTorrents, err := database.Repo.GetAllRecords()
+	if err != nil {
+		log.Error("metrics", "Error getting torrents for metrics", map[string]string{"error": err.Error()})
+		return
+	}
+	counts := map[string]int{stateUnwatched: 0, stateOk: 0, stateFailing: 0}
+	for _, dbTorrent := range dbTorrents {
+		switch check, checked := LastCheck(dbTorrent.Url); {
+		case dbTorrent.WatchEvery == 0:
+			counts[stateUnwatched]++
+		case checked && !check.LastCheckSuccess:
+			counts[stateFailing]++
+		default:
+			counts[stateOk]++
+		}
+	}
+	for state, count := range counts {
+		metrics.WatchedTorrents.Set(float64(count), state)
+	}
+}
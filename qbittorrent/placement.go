@@ -0,0 +1,67 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/models"
+	"strconv"
+	"strings"
+)
+
+// place sets the qBittorrent category, tags and automatic management of t. The category
+// is the one of the torrent, else the one of its download path, else the one of its
+// tracker. Tags are the configured ones of qBittorrent, the tracker and the download path
+// followed by the tags of the torrent. In category mode a torrent with a category is
+// added with Automatic Torrent Management, so qBittorrent picks the save path.
+func (t *Torrent) place(category string, tags []string) {
+	cfg := config.GlobalConfig
+	trackerCategory, trackerTags := "", ""
+	switch models.TrackerNameByURL(t.Url) {
+	case "kinozal":
+		trackerCategory, trackerTags = cfg.KinozalCategory, cfg.KinozalTags
+	case "rutracker":
+		trackerCategory, trackerTags = cfg.RtCategory, cfg.RtTags
+	}
+
+	if category == "" {
+		category = pathRule(cfg.QBPathCategories, t.SavePath)
+	}
+	if category == "" {
+		category = trackerCategory
+	}
+	all := splitList(cfg.QBTags)
+	all = append(all, splitList(trackerTags)...)
+	all = append(all, splitList(pathRule(cfg.QBPathTags, t.SavePath))...)
+	all = append(all, tags...)
+
+	categoryMode, _ := strconv.ParseBool(cfg.QBCategoryMode)
+	t.Category = strings.TrimSpace(category)
+	t.Tags = strings.Join(common.NormalizeTags(all), ",")
+	t.AutoTMM = categoryMode && t.Category != ""
+}
+
+// pathRule returns the value of the rule for the longest path that contains savePath.
+// Rules are written as path=value pairs separated by semicolons.
+func pathRule(rules, savePath string) string {
+	savePath = strings.TrimRight(savePath, "/")
+	value, longest := "", -1
+	for _, rule := range strings.Split(rules, ";") {
+		path, ruleValue, ok := strings.Cut(rule, "=")
+		path = strings.TrimRight(strings.TrimSpace(path), "/")
+		if !ok || path == "" || len(path) <= longest {
+			continue
+		}
+		if savePath == path || strings.HasPrefix(savePath, path+"/") {
+			value, longest = strings.TrimSpace(ruleValue), len(path)
+		}
+	}
+	return value
+}
+
+// splitList splits a comma separated list as qBittorrent returns tags, e.g. "a, b"
+func splitList(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	return common.NormalizeTags(strings.Split(list, ","))
+}
@@ -0,0 +1,70 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/config"
+	"testing"
+)
+
+func TestTorrentPlace(t *testing.T) {
+	saved := *config.GlobalConfig
+	t.Cleanup(func() { *config.GlobalConfig = saved })
+	config.GlobalConfig.QBCategoryMode = "true"
+	config.GlobalConfig.QBTags = "kinozal-monitor"
+	config.GlobalConfig.QBPathCategories = "/downloads/series=series; /downloads/series/anime/=anime"
+	config.GlobalConfig.QBPathTags = "/downloads/series=series,tv"
+	config.GlobalConfig.KinozalCategory = "kinozal"
+	config.GlobalConfig.KinozalTags = "kinozal, tv"
+	config.GlobalConfig.RtCategory = ""
+	config.GlobalConfig.RtTags = ""
+
+	tests := []struct {
+		name         string
+		url          string
+		savePath     string
+		category     string
+		tags         []string
+		wantCategory string
+		wantTags     string
+		wantAutoTMM  bool
+	}{
+		{"Tracker", "https://kinozal.tv/details.php?id=1", "/downloads", "", nil, "kinozal", "kinozal-monitor,kinozal,tv", true},
+		{"Path", "https://kinozal.tv/details.php?id=1", "/downloads/series/", "", []string{"mine"}, "series", "kinozal-monitor,kinozal,tv,series,mine", true},
+		{"Longest path", "https://rutracker.org/forum/viewtopic.php?t=1", "/downloads/series/anime/show", "", nil, "anime", "kinozal-monitor,series,tv", true},
+		{"Not a subdirectory", "https://rutracker.org/forum/viewtopic.php?t=1", "/downloads/series2", "", nil, "", "kinozal-monitor", false},
+		{"Torrent", "https://kinozal.tv/details.php?id=1", "/downloads/series", "mine", nil, "mine", "kinozal-monitor,kinozal,tv,series", true},
+		{"External", "magnet:?xt=urn:btih:aaa", "/downloads", "", nil, "", "kinozal-monitor", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := Torrent{Url: tt.url, SavePath: tt.savePath}
+			torrent.place(tt.category, tt.tags)
+			if torrent.Category != tt.wantCategory || torrent.Tags != tt.wantTags || torrent.AutoTMM != tt.wantAutoTMM {
+				t.Errorf("place() set category %q, tags %q, autoTMM %t, want %q, %q, %t",
+					torrent.Category, torrent.Tags, torrent.AutoTMM, tt.wantCategory, tt.wantTags, tt.wantAutoTMM)
+			}
+		})
+	}
+
+	config.GlobalConfig.QBCategoryMode = "false"
+	torrent := Torrent{Url: "https://kinozal.tv/details.php?id=1", SavePath: "/downloads"}
+	torrent.place("", nil)
+	if torrent.AutoTMM {
+		t.Errorf("Expected no automatic management outside category mode")
+	}
+}
+
+func TestTorrentAddForm(t *testing.T) {
+	torrent := Torrent{SavePath: "/downloads", Paused: true}
+	torrent.Category, torrent.Tags = "series", "kinozal-monitor,series"
+	form := torrent.addForm()
+	if form.Get("savepath") != "/downloads" || form.Get("autoTMM") != "false" || form.Get("category") != "series" ||
+		form.Get("tags") != "kinozal-monitor,series" || form.Get("paused") != "true" || form.Get("stopped") != "true" {
+		t.Errorf("Unexpected form %v", form)
+	}
+
+	torrent.AutoTMM = true
+	form = torrent.addForm()
+	if form.Has("savepath") || form.Get("autoTMM") != "true" {
+		t.Errorf("Expected qBittorrent to pick the save path, got %v", form)
+	}
+}
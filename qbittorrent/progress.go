@@ -0,0 +1,152 @@
+package qbittorrent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNotInitialized is returned when qBittorrent is used before InitializeManager
+var errNotInitialized = errors.New("qBittorrent client is not initialized")
+
+// MainData is the reply of /api/v2/sync/maindata. Unless FullUpdate is set, Torrents holds
+// only the fields that changed since the rid of the request, keyed by hash.
+type MainData struct {
+	Rid             int                        `json:"rid"`
+	FullUpdate      bool                       `json:"full_update"`
+	Torrents        map[string]json.RawMessage `json:"torrents"`
+	TorrentsRemoved []string                   `json:"torrents_removed"`
+}
+
+// SyncMainData is a method for getting the changes since the response with the given rid,
+// 0 asks for everything
+func (qb *QbittorrentUser) SyncMainData(rid int) (MainData, error) {
+	resp, err := qb.request("sync main data", "", func() (*http.Response, error) {
+		return qb.Client.Get(config.GlobalConfig.QBUrl + "/api/v2/sync/maindata?rid=" + strconv.Itoa(rid))
+	})
+	if err != nil {
+		return MainData{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var data MainData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		log.Error("qbittorrent", "Failed to decode main data JSON", map[string]string{"error": err.Error()})
+		return MainData{}, err
+	}
+	return data, nil
+}
+
+// TorrentStatuses returns the live status of every qBittorrent torrent by lower case hash
+func TorrentStatuses() (map[string]common.TorrentStatus, error) {
+	if GlobalManager == nil || GlobalManager.User == nil {
+		return nil, errNotInitialized
+	}
+	qbTorrents, err := GlobalManager.User.GetTorrentHashList()
+	if err != nil {
+		clientUnavailable(err)
+		return nil, err
+	}
+	statuses := make(map[string]common.TorrentStatus, len(qbTorrents))
+	for _, qbTorrent := range qbTorrents {
+		statuses[strings.ToLower(qbTorrent.Hash)] = qbTorrent.TorrentStatus
+	}
+	return statuses, nil
+}
+
+// progressTracker keeps the statuses of the torrents up to date from the incremental
+// replies of /api/v2/sync/maindata
+type progressTracker struct {
+	rid      int
+	statuses map[string]common.TorrentStatus
+}
+
+// newProgressTracker creates a tracker that asks for everything first
+func newProgressTracker() *progressTracker {
+	return &progressTracker{statuses: make(map[string]common.TorrentStatus)}
+}
+
+// apply merges data into the known statuses and returns the ones that changed by lower
+// case hash. The first reply only fills the statuses, clients get them from the torrent list.
+func (p *progressTracker) apply(data MainData) map[string]common.TorrentStatus {
+	first := p.rid == 0
+	p.rid = data.Rid
+
+	previous := p.statuses
+	if data.FullUpdate {
+		p.statuses = make(map[string]common.TorrentStatus, len(data.Torrents))
+	}
+	for _, hash := range data.TorrentsRemoved {
+		delete(p.statuses, strings.ToLower(hash))
+	}
+
+	changed := make(map[string]common.TorrentStatus)
+	for hash, fields := range data.Torrents {
+		hash = strings.ToLower(hash)
+		old, known := previous[hash]
+		// A partial object only overwrites the fields it has
+		status := old
+		if err := json.Unmarshal(fields, &status); err != nil {
+			log.Error("torrent_progress", "Failed to decode torrent status", map[string]string{"hash": hash, "error": err.Error()})
+			continue
+		}
+		p.statuses[hash] = status
+		if !first && (!known || status != old) {
+			changed[hash] = status
+		}
+	}
+	return changed
+}
+
+// RunProgressFeed polls qBittorrent every interval and publishes a TorrentProgress event
+// for every monitored torrent whose status changed, until ctx is done
+func RunProgressFeed(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	tracker := newProgressTracker()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if GlobalManager == nil || GlobalManager.User == nil {
+			continue
+		}
+		data, err := GlobalManager.User.SyncMainData(tracker.rid)
+		if err != nil {
+			// Start over, the rid may belong to an expired session
+			tracker.rid = 0
+			continue
+		}
+		publishProgress(tracker.apply(data))
+	}
+}
+
+// publishProgress publishes the changed statuses of the monitored torrents
+func publishProgress(changed map[string]common.TorrentStatus) {
+	if len(changed) == 0 {
+		return
+	}
+	records, err := database.Repo.GetAllRecords()
+	if err != nil {
+		log.Error("torrent_progress", "Failed to get torrents for progress", map[string]string{"error": err.Error()})
+		return
+	}
+	for _, record := range records {
+		status, ok := changed[strings.ToLower(record.Hash)]
+		if !ok {
+			continue
+		}
+		events.Publish(events.Event{Type: events.TorrentProgress, Url: record.Url, Hash: record.Hash, Status: &status})
+	}
+}
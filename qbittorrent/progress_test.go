@@ -0,0 +1,55 @@
+package qbittorrent
+
+import (
+	"encoding/json"
+	"kinozaltv_monitor/common"
+	"reflect"
+	"testing"
+)
+
+func mainData(t *testing.T, raw string) MainData {
+	var data MainData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	return data
+}
+
+func TestProgressTracker(t *testing.T) {
+	tracker := newProgressTracker()
+
+	changed := tracker.apply(mainData(t, `{"rid":1,"full_update":true,"torrents":{
+		"AAA":{"progress":0.5,"state":"downloading","size":1000,"dlspeed":100,"eta":5,"num_seeds":3,"category":"series","tags":"kinozal-monitor, series"},
+		"bbb":{"progress":1,"state":"stalledUP","size":2000,"ratio":1.5}}}`))
+	if len(changed) != 0 {
+		t.Errorf("Expected no changes from the first reply, got %v", changed)
+	}
+	if tracker.rid != 1 || tracker.statuses["aaa"].Tags != "kinozal-monitor, series" {
+		t.Errorf("Unexpected tracker state rid %d, statuses %+v", tracker.rid, tracker.statuses)
+	}
+
+	changed = tracker.apply(mainData(t, `{"rid":2,"torrents":{
+		"AAA":{"progress":0.75,"eta":2},
+		"bbb":{"ratio":1.5},
+		"ccc":{"state":"metaDL"}},"torrents_removed":["ddd"]}`))
+	want := map[string]common.TorrentStatus{
+		"aaa": {Progress: 0.75, State: "downloading", Size: 1000, DlSpeed: 100, ETA: 2, NumSeeds: 3, Category: "series", Tags: "kinozal-monitor, series"},
+		"ccc": {State: "metaDL"},
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("Expected changes\n%+v\ngot\n%+v", want, changed)
+	}
+
+	changed = tracker.apply(mainData(t, `{"rid":3,"torrents_removed":["ccc"]}`))
+	if _, known := tracker.statuses["ccc"]; known || len(changed) != 0 {
+		t.Errorf("Expected ccc to be removed without changes, got %v", changed)
+	}
+
+	// qBittorrent answers with a full update when it no longer knows the rid
+	changed = tracker.apply(mainData(t, `{"rid":1,"full_update":true,"torrents":{
+		"aaa":{"progress":0.75,"state":"downloading","size":1000,"dlspeed":100,"eta":2,"num_seeds":3,"category":"series","tags":"kinozal-monitor, series"},
+		"bbb":{"progress":1,"state":"pausedUP","size":2000,"ratio":1.5}}}`))
+	if len(changed) != 1 || changed["bbb"].State != "pausedUP" {
+		t.Errorf("Expected only bbb to change after a full update, got %v", changed)
+	}
+}
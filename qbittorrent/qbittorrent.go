@@ -10,8 +10,10 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"sort"
+	"strconv"
 	"sync"
 
+	"kinozaltv_monitor/common"
 	"kinozaltv_monitor/config"
 )
 
@@ -65,6 +67,32 @@ type Torrent struct {
 	Name     string `json:"name"`
 	Url      string `json:"url"`
 	SavePath string `json:"save_path"`
+	// Tracker is the announce url qBittorrent currently works with, empty when none answered
+	Tracker string `json:"tracker"`
+	// Paused adds the torrent stopped, see AddTorrent
+	Paused bool `json:"-"`
+	// AutoTMM is set when qBittorrent picks the save path from the category
+	AutoTMM bool `json:"auto_tmm"`
+	common.TorrentStatus
+}
+
+// addForm returns the fields of /api/v2/torrents/add that place t: its save path unless
+// qBittorrent manages it, category, tags and whether it is added stopped
+func (t Torrent) addForm() url.Values {
+	form := url.Values{"autoTMM": {strconv.FormatBool(t.AutoTMM)}}
+	if !t.AutoTMM {
+		form.Set("savepath", t.SavePath)
+	}
+	if t.Category != "" {
+		form.Set("category", t.Category)
+	}
+	if t.Tags != "" {
+		form.Set("tags", t.Tags)
+	}
+	// qBittorrent 5 renamed paused to stopped, older versions ignore the unknown field
+	form.Set("paused", strconv.FormatBool(t.Paused))
+	form.Set("stopped", strconv.FormatBool(t.Paused))
+	return form
 }
 
 // isSessionValid checks if the current session is still valid
@@ -84,6 +112,13 @@ func (qb *QbittorrentUser) isSessionValid() bool {
 	return resp.StatusCode == 200
 }
 
+// SessionValid reports whether qBittorrent is reachable with the current session
+func (qb *QbittorrentUser) SessionValid() bool {
+	qb.mutex.Lock()
+	defer qb.mutex.Unlock()
+	return qb.isSessionValid()
+}
+
 // ensureValidSession ensures we have a valid session, re-authenticating if necessary
 func (qb *QbittorrentUser) ensureValidSession() error {
 	qb.mutex.Lock()
@@ -101,7 +136,8 @@ func (qb *QbittorrentUser) ensureValidSession() error {
 func (qb *QbittorrentUser) login() error {
 	jar, _ := cookiejar.New(nil)
 	qb.Client = &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: instrumentedTransport{next: http.DefaultTransport},
 	}
 
 	log.Info("qbittorrent", "Attempting to login to qbittorrent", map[string]string{"url": config.GlobalConfig.QBUrl + "/api/v2/auth/login", "username": qb.Username})
@@ -205,8 +241,10 @@ func (qb *QbittorrentUser) GetTorrentHashList() ([]Torrent, error) {
 	return torrents, nil
 }
 
-// AddTorrent is a method for adding a torrent to the client
-func (qb *QbittorrentUser) AddTorrent(hash, savePath string, torrent []byte) error {
+// AddTorrent is a method for adding a torrent to the client, paused torrents are
+// added stopped so their file priorities can be set before they download
+func (qb *QbittorrentUser) AddTorrent(t Torrent, torrent []byte) error {
+	hash := t.Hash
 	// Ensure we have a valid session before making the request
 	if err := qb.ensureValidSession(); err != nil {
 		log.Error("qbittorrent", "Failed to ensure valid session for adding torrent", map[string]string{"error": err.Error(), "hash": hash})
@@ -215,7 +253,9 @@ func (qb *QbittorrentUser) AddTorrent(hash, savePath string, torrent []byte) err
 
 	log.Info("qbittorrent", "Adding torrent to qBittorrent", map[string]string{
 		"hash":      hash,
-		"save_path": savePath,
+		"save_path": t.SavePath,
+		"category":  t.Category,
+		"tags":      t.Tags,
 	})
 
 	// Create a new form data buffer
@@ -240,25 +280,16 @@ func (qb *QbittorrentUser) AddTorrent(hash, savePath string, torrent []byte) err
 		return err
 	}
 
-	// Add the save_path field
-	err = writer.WriteField("savepath", savePath)
-	if err != nil {
-		log.Error("qbittorrent", "Failed to add save path field", map[string]string{
-			"hash":      hash,
-			"save_path": savePath,
-			"error":     err.Error(),
-		})
-		return err
-	}
-
-	// Add other fields
-	err = writer.WriteField("autoTMM", "false")
-	if err != nil {
-		log.Error("qbittorrent", "Failed to add autoTMM field", map[string]string{
-			"hash":  hash,
-			"error": err.Error(),
-		})
-		return err
+	// Add the save path, category, tags and paused fields
+	for field, values := range t.addForm() {
+		err = writer.WriteField(field, values[0])
+		if err != nil {
+			log.Error("qbittorrent", "Failed to add "+field+" field", map[string]string{
+				"hash":  hash,
+				"error": err.Error(),
+			})
+			return err
+		}
 	}
 
 	// Close the multipart writer
@@ -342,14 +373,15 @@ func (qb *QbittorrentUser) AddTorrent(hash, savePath string, torrent []byte) err
 
 	log.Info("qbittorrent", "Successfully added torrent", map[string]string{
 		"hash":      hash,
-		"save_path": savePath,
+		"save_path": t.SavePath,
 	})
 
 	return nil
 }
 
-// AddTorrentByMagnet is a method for adding a torrent by magnet link
-func (qb *QbittorrentUser) AddTorrentByMagnet(hash, downloadPath string) error {
+// AddTorrentByMagnet is a method for adding a torrent by magnet link, see AddTorrent for paused
+func (qb *QbittorrentUser) AddTorrentByMagnet(t Torrent) error {
+	hash := t.Hash
 	// Ensure we have a valid session before making the request
 	if err := qb.ensureValidSession(); err != nil {
 		log.Error("qbittorrent", "Failed to ensure valid session for adding torrent by magnet", map[string]string{"error": err.Error(), "hash": hash})
@@ -358,15 +390,18 @@ func (qb *QbittorrentUser) AddTorrentByMagnet(hash, downloadPath string) error {
 
 	log.Info("qbittorrent", "Adding torrent by magnet link", map[string]string{
 		"hash":          hash,
-		"download_path": downloadPath,
+		"download_path": t.SavePath,
+		"category":      t.Category,
+		"tags":          t.Tags,
 	})
 
 	// Convert hash to magnet
-	magnet := "magnet:?xt=urn:btih:" + hash
+	form := t.addForm()
+	form.Set("urls", "magnet:?xt=urn:btih:"+hash)
 
 	// Add torrent by magnet
 	resp, err := qb.Client.PostForm(config.GlobalConfig.QBUrl+"/api/v2/torrents/add",
-		url.Values{"urls": {magnet}, "save_path": {downloadPath}})
+		form)
 	if err != nil {
 		log.Error("qbittorrent", "Failed to add torrent by magnet link", map[string]string{
 			"hash":  hash,
@@ -387,7 +422,7 @@ func (qb *QbittorrentUser) AddTorrentByMagnet(hash, downloadPath string) error {
 
 		// Retry the request
 		resp, err = qb.Client.PostForm(config.GlobalConfig.QBUrl+"/api/v2/torrents/add",
-			url.Values{"urls": {magnet}, "save_path": {downloadPath}})
+			form)
 		if err != nil {
 			log.Error("qbittorrent", "Failed to add torrent by magnet link after re-authentication", map[string]string{
 				"hash":  hash,
@@ -408,7 +443,7 @@ func (qb *QbittorrentUser) AddTorrentByMagnet(hash, downloadPath string) error {
 
 	log.Info("qbittorrent", "Successfully added torrent by magnet link", map[string]string{
 		"hash":          hash,
-		"download_path": downloadPath,
+		"download_path": t.SavePath,
 	})
 
 	return nil
@@ -485,6 +520,22 @@ func (qb *QbittorrentUser) DeleteTorrent(hash string, dropFiles bool) error {
 	return nil
 }
 
+// SetLocation is a method for moving the files of a torrent to another directory
+func (qb *QbittorrentUser) SetLocation(hash, location string) error {
+	log.Info("qbittorrent", "Moving torrent", map[string]string{
+		"hash":     hash,
+		"location": location,
+	})
+	if _, err := qb.post("move torrent", hash, "/api/v2/torrents/setLocation", url.Values{"hashes": {hash}, "location": {location}}); err != nil {
+		return err
+	}
+	log.Info("qbittorrent", "Successfully moved torrent", map[string]string{
+		"hash":     hash,
+		"location": location,
+	})
+	return nil
+}
+
 // DeleteTorrentByName is a method for deleting a torrent by name
 func (qb *QbittorrentUser) DeleteTorrentByName(torrentName string, dropFiles bool) error {
 	// Ensure we have a valid session before making the request
@@ -768,5 +819,18 @@ func (qb *QbittorrentUser) GetDownloadPaths() ([]string, error) {
 		sortedPaths = append(sortedPaths, pf.path)
 	}
 
+	// Offer the save paths of categories no torrent uses yet after the others
+	categories, err := qb.GetCategories()
+	if err != nil {
+		log.Error("qbittorrent", "Failed to get categories for download paths", map[string]string{"error": err.Error()})
+		return sortedPaths, nil
+	}
+	for _, category := range categories {
+		if category.SavePath != "" && pathCount[category.SavePath] == 0 {
+			pathCount[category.SavePath]++
+			sortedPaths = append(sortedPaths, category.SavePath)
+		}
+	}
+
 	return sortedPaths, nil
 }
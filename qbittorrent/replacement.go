@@ -0,0 +1,351 @@
+package qbittorrent
+
+import (
+	"fmt"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	"kinozaltv_monitor/models"
+	"strings"
+	"sync"
+)
+
+// replacementSteps are the operations a replacement is made of. Every step can be
+// repeated, so a replacement interrupted by a crash is resumed at its recorded state.
+type replacementSteps struct {
+	// hasTorrent reports whether qBittorrent has the torrent with hash
+	hasTorrent func(hash string) (bool, error)
+	// removeOld removes the old torrent from qBittorrent, keeping its files
+	removeOld func(r database.Replacement) error
+	// addNew adds the new torrent to qBittorrent
+	addNew func(r database.Replacement) error
+	// restoreOld adds the old torrent back to use the files it left behind
+	restoreOld func(r database.Replacement) error
+	// removeNew removes the new torrent from qBittorrent, keeping its files
+	removeNew func(r database.Replacement) error
+	// renameOld moves the files of the old torrent out of the way of the new one, or back
+	renameOld func(r database.Replacement, back bool) error
+	// selectNewFiles skips the files of the new torrent the old one had and starts it
+	selectNewFiles func(r database.Replacement) error
+	// commit stores the new hash and announces the update
+	commit func(r database.Replacement) error
+}
+
+// steps are the replacement steps working on GlobalManager and database.Repo
+var steps = replacementSteps{
+	hasTorrent: func(hash string) (bool, error) {
+		qbTorrents, err := GlobalManager.User.GetTorrentHashList()
+		if err != nil {
+			return false, err
+		}
+		return contains(qbTorrents, hash), nil
+	},
+	removeOld: func(r database.Replacement) error {
+		// qBittorrent ignores hashes it does not have, so removing twice is fine
+		return GlobalManager.User.DeleteTorrent(r.OldHash, false)
+	},
+	addNew: func(r database.Replacement) error {
+		return addReplacementTorrent(GlobalManager.User, r)
+	},
+	restoreOld: func(r database.Replacement) error {
+		oldTorrent := Torrent{Hash: r.OldHash, SavePath: r.SavePath, AutoTMM: r.AutoTMM}
+		oldTorrent.Category, oldTorrent.Tags = r.Category, strings.Join(r.Tags, ",")
+		return GlobalManager.User.AddTorrentByMagnet(oldTorrent)
+	},
+	removeNew: func(r database.Replacement) error {
+		return GlobalManager.User.DeleteTorrent(r.NewHash, false)
+	},
+	renameOld:      renameOldContent,
+	selectNewFiles: selectNewFiles,
+	commit:         commitReplacement,
+}
+
+// plannedStep is a step of an update strategy and the state it leads to
+type plannedStep struct {
+	state string
+	run   func(r database.Replacement) error
+}
+
+// replacementPlan returns the steps of an update strategy in order, see database.UpdateStrategies
+func replacementPlan(strategy string) []plannedStep {
+	switch strategy {
+	case database.UpdateInPlace:
+		// qBittorrent checks the files of the old torrent when the new one is added
+		return []plannedStep{
+			{database.ReplacementNewAdded, addNewOnce},
+			{database.ReplacementOldRemoved, steps.removeOld},
+			{database.ReplacementDone, steps.commit},
+		}
+	case database.UpdateKeepBoth:
+		return []plannedStep{
+			{database.ReplacementOldRenamed, func(r database.Replacement) error { return steps.renameOld(r, false) }},
+			{database.ReplacementNewAdded, addNewOnce},
+			{database.ReplacementDone, steps.commit},
+		}
+	case database.UpdateNewFilesOnly:
+		return []plannedStep{
+			{database.ReplacementNewAdded, addNewOnce},
+			{database.ReplacementFilesSelected, steps.selectNewFiles},
+			{database.ReplacementOldRemoved, steps.removeOld},
+			{database.ReplacementDone, steps.commit},
+		}
+	}
+	return []plannedStep{
+		{database.ReplacementOldRemoved, steps.removeOld},
+		{database.ReplacementNewAdded, addNewOnce},
+		{database.ReplacementDone, steps.commit},
+	}
+}
+
+// addReplacementTorrent adds the new torrent journaled in r to qBittorrent. The .torrent
+// file is downloaded again and used only when it still has the journaled hash, otherwise
+// the torrent is added by that hash. The record of the topic is left to commitReplacement.
+func addReplacementTorrent(qbUser *QbittorrentUser, r database.Replacement) error {
+	// The files to skip are selected before the new torrent starts downloading
+	newTorrent := Torrent{
+		Hash:     r.NewHash,
+		Name:     r.NewTitle,
+		Title:    r.NewTitle,
+		Url:      r.Url,
+		SavePath: r.SavePath,
+		Paused:   r.Strategy == database.UpdateNewFilesOnly,
+		AutoTMM:  r.AutoTMM,
+	}
+	newTorrent.Category, newTorrent.Tags = r.Category, strings.Join(r.Tags, ",")
+
+	torrentFile, err := models.GlobalTrackerManager.DownloadTorrentFile(r.Url)
+	if err == nil {
+		var meta models.TorrentMeta
+		if meta, err = models.ParseTorrent(torrentFile); err == nil && !strings.EqualFold(meta.Hash(), r.NewHash) {
+			err = fmt.Errorf("tracker has version %s now", meta.Hash())
+		}
+	}
+	if err != nil {
+		log.Info("replacement_add", "Adding the journaled torrent by its hash", map[string]string{
+			"url":    r.Url,
+			"hash":   r.NewHash,
+			"reason": err.Error(),
+		})
+		return qbUser.AddTorrentByMagnet(newTorrent)
+	}
+	if err := qbUser.AddTorrent(newTorrent, torrentFile); err != nil {
+		return err
+	}
+	recordTorrentVersion(r.Url, torrentFile)
+	return nil
+}
+
+// addNewOnce adds the new torrent unless qBittorrent has it already
+func addNewOnce(r database.Replacement) error {
+	present, err := steps.hasTorrent(r.NewHash)
+	if err != nil || present {
+		return err
+	}
+	if err := steps.addNew(r); err != nil {
+		// The add may fail after qBittorrent took the torrent, e.g. when its answer is lost
+		if present, _ := steps.hasTorrent(r.NewHash); !present {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacements holds the unfinished replacements by canonical topic url. runMu
+// serializes replacements, so a check and a recheck requested through the API
+// cannot work on the same replacement at once.
+var (
+	replacements   = make(map[string]database.Replacement)
+	replacementsMu sync.RWMutex
+	runMu          sync.Mutex
+)
+
+// PendingReplacement returns the unfinished replacement of a topic url, if any
+func PendingReplacement(url string) (database.Replacement, bool) {
+	replacementsMu.RLock()
+	defer replacementsMu.RUnlock()
+	r, ok := replacements[common.CanonicalTorrentUrl(url)]
+	return r, ok
+}
+
+// trackReplacement remembers r until it is finished
+func trackReplacement(r database.Replacement) {
+	replacementsMu.Lock()
+	defer replacementsMu.Unlock()
+	if r.Finished() {
+		delete(replacements, common.CanonicalTorrentUrl(r.Url))
+	} else {
+		replacements[common.CanonicalTorrentUrl(r.Url)] = r
+	}
+}
+
+// saveReplacement records the state of r in the journal
+func saveReplacement(r database.Replacement) error {
+	if err := database.Repo.UpdateReplacement(r); err != nil {
+		log.Error("replacement_journal", "Error recording replacement state", map[string]string{
+			"error": err.Error(),
+			"url":   r.Url,
+			"state": r.State,
+		})
+		return err
+	}
+	trackReplacement(r)
+	return nil
+}
+
+// startReplacement records the intent to replace the torrent of url and carries it out.
+// Nothing is changed in qBittorrent unless the intent was recorded.
+func startReplacement(r database.Replacement) (database.Replacement, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	// A check and a recheck requested through the API may find the new version at once
+	if pending, ok := PendingReplacement(r.Url); ok {
+		if pending.NewHash != r.NewHash {
+			return pending, fmt.Errorf("replacement with hash %s is still pending", pending.NewHash)
+		}
+		return advanceReplacement(pending)
+	}
+	if record, err := database.Repo.GetRecordByUrl(r.Url); err == nil && record.Hash == r.NewHash {
+		// The other one finished while this one was waiting
+		r.State = database.ReplacementDone
+		return r, nil
+	}
+
+	r, err := database.Repo.CreateReplacement(r)
+	if err != nil {
+		return r, fmt.Errorf("recording replacement: %w", err)
+	}
+	trackReplacement(r)
+	return advanceReplacement(r)
+}
+
+// runReplacement resumes the unfinished replacement r, see advanceReplacement
+func runReplacement(r database.Replacement) (database.Replacement, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	// Another check may have finished the replacement in the meantime
+	current, ok := PendingReplacement(r.Url)
+	if !ok || current.ID != r.ID {
+		return r, nil
+	}
+	return advanceReplacement(current)
+}
+
+// advanceReplacement moves r through its remaining steps, runMu must be held. When a
+// step fails the replacement is rolled back if possible and the error of the step is returned.
+func advanceReplacement(r database.Replacement) (database.Replacement, error) {
+	for !r.Finished() {
+		next, err := replacementStep(r)
+		if err != nil {
+			return rollBackReplacement(r, err)
+		}
+		r.State, r.Error = next, ""
+		if err := saveReplacement(r); err != nil {
+			return r, err
+		}
+		log.Info("replacement_step", "Replacement advanced", map[string]string{"url": r.Url, "state": r.State})
+	}
+	return r, nil
+}
+
+// replacementStep carries out the step following the state of r and returns the next state
+func replacementStep(r database.Replacement) (string, error) {
+	plan := replacementPlan(r.Strategy)
+	if r.State == database.ReplacementPending {
+		return plan[0].state, plan[0].run(r)
+	}
+	for i := 0; i < len(plan)-1; i++ {
+		if plan[i].state == r.State {
+			return plan[i+1].state, plan[i+1].run(r)
+		}
+	}
+	return "", fmt.Errorf("unknown replacement state %q for strategy %q", r.State, r.Strategy)
+}
+
+// undoStep returns the step that undoes the steps of r carried out so far. It
+// returns false once both torrents are in place and only the record is left to update.
+func undoStep(r database.Replacement) (func(r database.Replacement) error, bool) {
+	switch {
+	case r.State == database.ReplacementPending:
+		return func(database.Replacement) error { return nil }, true
+	case r.State == database.ReplacementOldRemoved && (r.Strategy == database.UpdateReplace || r.Strategy == ""):
+		return steps.restoreOld, true
+	case r.State == database.ReplacementOldRenamed:
+		return func(r database.Replacement) error { return steps.renameOld(r, true) }, true
+	case r.State == database.ReplacementNewAdded && (r.Strategy == database.UpdateInPlace || r.Strategy == database.UpdateNewFilesOnly),
+		r.State == database.ReplacementFilesSelected:
+		return steps.removeNew, true
+	}
+	return nil, false
+}
+
+// rollBackReplacement handles a failed step of r by undoing the steps carried out so
+// far, e.g. adding the old torrent back after it was removed. When that fails as well,
+// or the new torrent is already in place, r stays unfinished and is resumed by the next check.
+func rollBackReplacement(r database.Replacement, cause error) (database.Replacement, error) {
+	r.Error = cause.Error()
+	if undo, ok := undoStep(r); ok {
+		if err := undo(r); err != nil {
+			r.Error += "; rolling back failed: " + err.Error()
+		} else {
+			r.State = database.ReplacementRolledBack
+		}
+	}
+	log.Error("replacement_failed", "Replacement step failed", map[string]string{
+		"url":   r.Url,
+		"state": r.State,
+		"error": r.Error,
+	})
+	_ = saveReplacement(r)
+	return r, cause
+}
+
+// commitReplacement stores the new hash of the topic and announces the update
+func commitReplacement(r database.Replacement) error {
+	err := database.Repo.UpdateRecord(models.Torrent{Hash: r.NewHash, Title: r.NewTitle, Url: r.Url})
+	if err != nil {
+		return err
+	}
+	events.Publish(events.Event{
+		Type:    events.TorrentUpdated,
+		Url:     r.Url,
+		Title:   r.NewTitle,
+		Hash:    r.NewHash,
+		OldHash: r.OldHash,
+	})
+	database.Audit(database.AuditEntry{
+		Actor:  database.ActorSystem,
+		Action: database.AuditTorrentReplace,
+		Target: r.Url,
+		Before: database.AuditValue(map[string]string{"hash": r.OldHash}),
+		After:  database.AuditValue(map[string]string{"hash": r.NewHash, "title": r.NewTitle, "save_path": r.SavePath}),
+	})
+	return nil
+}
+
+// RecoverReplacements resumes the replacements that were interrupted, e.g. by a crash
+func RecoverReplacements() {
+	unfinished, err := database.Repo.GetUnfinishedReplacements()
+	if err != nil {
+		log.Error("replacement_recovery", "Error reading unfinished replacements", map[string]string{"error": err.Error()})
+		return
+	}
+	for _, r := range unfinished {
+		trackReplacement(r)
+	}
+	for _, r := range unfinished {
+		if !work.begin() {
+			return
+		}
+		log.Info("replacement_recovery", "Resuming interrupted replacement", map[string]string{"url": r.Url, "state": r.State})
+		if r, err := runReplacement(r); err != nil {
+			log.Error("replacement_recovery", "Interrupted replacement not finished", map[string]string{
+				"url":   r.Url,
+				"state": r.State,
+				"error": err.Error(),
+			})
+		}
+		work.end()
+	}
+}
@@ -0,0 +1,319 @@
+package qbittorrent
+
+import (
+	"errors"
+	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSteps records the replacement steps carried out and fails the ones listed in fail
+type fakeSteps struct {
+	calls   []string
+	fail    map[string]bool
+	present map[string]bool
+}
+
+func useFakeSteps(t *testing.T, fake *fakeSteps) {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	previousRepo, previousSteps := database.Repo, steps
+	database.Repo = repo
+	t.Cleanup(func() {
+		database.Repo, steps = previousRepo, previousSteps
+		replacements = make(map[string]database.Replacement)
+		_ = repo.Close()
+	})
+
+	step := func(name string, done func(r database.Replacement)) func(r database.Replacement) error {
+		return func(r database.Replacement) error {
+			fake.calls = append(fake.calls, name)
+			if fake.fail[name] {
+				return errors.New(name + " failed")
+			}
+			if done != nil {
+				done(r)
+			}
+			return nil
+		}
+	}
+	steps = replacementSteps{
+		hasTorrent: func(hash string) (bool, error) { return fake.present[hash], nil },
+		removeOld:  step("removeOld", func(r database.Replacement) { delete(fake.present, r.OldHash) }),
+		addNew:     step("addNew", func(r database.Replacement) { fake.present[r.NewHash] = true }),
+		restoreOld: step("restoreOld", func(r database.Replacement) { fake.present[r.OldHash] = true }),
+		removeNew:  step("removeNew", func(r database.Replacement) { delete(fake.present, r.NewHash) }),
+		renameOld: func(r database.Replacement, back bool) error {
+			if back {
+				return step("renameBack", nil)(r)
+			}
+			return step("renameOld", nil)(r)
+		},
+		selectNewFiles: step("selectNewFiles", nil),
+		commit:         step("commit", nil),
+	}
+}
+
+func newFakeSteps(fail ...string) *fakeSteps {
+	fake := &fakeSteps{fail: make(map[string]bool), present: map[string]bool{"old": true}}
+	for _, name := range fail {
+		fake.fail[name] = true
+	}
+	return fake
+}
+
+var testReplacement = database.Replacement{
+	Url:      "https://kinozal.tv/details.php?id=1",
+	OldHash:  "old",
+	NewHash:  "new",
+	NewTitle: "New title",
+	SavePath: "/downloads",
+}
+
+func TestReplacement_Completes(t *testing.T) {
+	fake := newFakeSteps()
+	useFakeSteps(t, fake)
+
+	r, err := startReplacement(testReplacement)
+	if err != nil {
+		t.Fatalf("startReplacement() failed: %v", err)
+	}
+	if r.State != database.ReplacementDone {
+		t.Errorf("Expected state %s, got %s", database.ReplacementDone, r.State)
+	}
+	if want := []string{"removeOld", "addNew", "commit"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Expected steps %v, got %v", want, fake.calls)
+	}
+	if _, ok := PendingReplacement(testReplacement.Url); ok {
+		t.Error("Expected no pending replacement after it completed")
+	}
+	if unfinished, _ := database.Repo.GetUnfinishedReplacements(); len(unfinished) != 0 {
+		t.Errorf("Expected an empty journal, got %v", unfinished)
+	}
+}
+
+func TestReplacement_Strategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		steps    []string
+	}{
+		{database.UpdateReplace, []string{"removeOld", "addNew", "commit"}},
+		{database.UpdateInPlace, []string{"addNew", "removeOld", "commit"}},
+		{database.UpdateKeepBoth, []string{"renameOld", "addNew", "commit"}},
+		{database.UpdateNewFilesOnly, []string{"addNew", "selectNewFiles", "removeOld", "commit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			fake := newFakeSteps()
+			useFakeSteps(t, fake)
+
+			replacement := testReplacement
+			replacement.Strategy = tt.strategy
+			r, err := startReplacement(replacement)
+			if err != nil || r.State != database.ReplacementDone {
+				t.Fatalf("startReplacement() returned %+v, %v", r, err)
+			}
+			if !reflect.DeepEqual(fake.calls, tt.steps) {
+				t.Errorf("Expected steps %v, got %v", tt.steps, fake.calls)
+			}
+		})
+	}
+}
+
+func TestReplacement_StrategyRollsBack(t *testing.T) {
+	tests := []struct {
+		strategy string
+		fail     string
+		steps    []string
+	}{
+		{database.UpdateInPlace, "removeOld", []string{"addNew", "removeOld", "removeNew"}},
+		{database.UpdateKeepBoth, "addNew", []string{"renameOld", "addNew", "renameBack"}},
+		{database.UpdateNewFilesOnly, "selectNewFiles", []string{"addNew", "selectNewFiles", "removeNew"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			fake := newFakeSteps(tt.fail)
+			useFakeSteps(t, fake)
+
+			replacement := testReplacement
+			replacement.Strategy = tt.strategy
+			r, err := startReplacement(replacement)
+			if err == nil || r.State != database.ReplacementRolledBack {
+				t.Fatalf("Expected a rolled back replacement, got %+v, %v", r, err)
+			}
+			if !reflect.DeepEqual(fake.calls, tt.steps) {
+				t.Errorf("Expected steps %v, got %v", tt.steps, fake.calls)
+			}
+		})
+	}
+}
+
+func TestReplacement_RollsBack(t *testing.T) {
+	fake := newFakeSteps("addNew")
+	useFakeSteps(t, fake)
+
+	r, err := startReplacement(testReplacement)
+	if err == nil {
+		t.Fatal("Expected the failed add to be reported")
+	}
+	if r.State != database.ReplacementRolledBack || r.Error == "" {
+		t.Errorf("Expected a rolled back replacement with its error, got %+v", r)
+	}
+	if !fake.present["old"] {
+		t.Error("Expected the old torrent to be restored")
+	}
+	if _, ok := PendingReplacement(testReplacement.Url); ok {
+		t.Error("Expected no pending replacement after the roll back")
+	}
+}
+
+func TestReplacement_RollBackKeepsRecord(t *testing.T) {
+	realSteps := steps
+	fake := newFakeSteps("removeOld")
+	useFakeSteps(t, fake)
+	steps.addNew = realSteps.addNew
+
+	var added []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/app/version":
+			_, _ = w.Write([]byte("v5.0.0"))
+		case "/api/v2/torrents/add":
+			mu.Lock()
+			added = append(added, r.FormValue("urls"))
+			mu.Unlock()
+			_, _ = w.Write([]byte("Ok."))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	savedUrl, savedManager, savedTrackers := config.GlobalConfig.QBUrl, GlobalManager, models.GlobalTrackerManager
+	config.GlobalConfig.QBUrl = server.URL
+	jar, _ := cookiejar.New(nil)
+	GlobalManager = &Manager{User: &QbittorrentUser{Client: &http.Client{Jar: jar}}}
+	// No tracker is logged in, the journaled torrent is added by its hash
+	models.GlobalTrackerManager = &models.TrackerManager{}
+	t.Cleanup(func() {
+		config.GlobalConfig.QBUrl, GlobalManager, models.GlobalTrackerManager = savedUrl, savedManager, savedTrackers
+	})
+
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Old title", Hash: "old", Url: testReplacement.Url}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+	replacement := testReplacement
+	replacement.Strategy = database.UpdateInPlace
+	r, err := startReplacement(replacement)
+	if err == nil || r.State != database.ReplacementRolledBack {
+		t.Fatalf("Expected a rolled back replacement, got %+v, %v", r, err)
+	}
+	if len(added) != 1 || !strings.HasSuffix(added[0], ":new") {
+		t.Errorf("Expected the journaled torrent to be added, got %v", added)
+	}
+	record, err := database.Repo.GetRecordByUrl(testReplacement.Url)
+	if err != nil {
+		t.Fatalf("GetRecordByUrl() failed: %v", err)
+	}
+	if record.Hash != "old" || record.Title != "Old title" {
+		t.Errorf("Expected the record to keep the old torrent, got %s %q", record.Hash, record.Title)
+	}
+}
+
+func TestReplacement_Concurrent(t *testing.T) {
+	fake := newFakeSteps()
+	useFakeSteps(t, fake)
+	steps.commit = commitReplacement
+	if err := database.Repo.CreateOrUpdateRecord(models.Torrent{Title: "Old title", Hash: "old", Url: testReplacement.Url}); err != nil {
+		t.Fatalf("CreateOrUpdateRecord() failed: %v", err)
+	}
+
+	// A watcher check and a recheck find the new version at the same time
+	runMu.Lock()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = startReplacement(testReplacement)
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	runMu.Unlock()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("startReplacement() failed: %v", err)
+		}
+	}
+	if want := []string{"removeOld", "addNew"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Expected steps %v, got %v", want, fake.calls)
+	}
+	if unfinished, _ := database.Repo.GetUnfinishedReplacements(); len(unfinished) != 0 {
+		t.Errorf("Expected an empty journal, got %v", unfinished)
+	}
+	if record, _ := database.Repo.GetRecordByUrl(testReplacement.Url); record.Hash != "new" {
+		t.Errorf("Expected the record to have the new hash, got %s", record.Hash)
+	}
+}
+
+func TestReplacement_StuckThenResumed(t *testing.T) {
+	fake := newFakeSteps("addNew", "restoreOld")
+	useFakeSteps(t, fake)
+
+	r, err := startReplacement(testReplacement)
+	if err == nil {
+		t.Fatal("Expected the failed add to be reported")
+	}
+	pending, ok := PendingReplacement(testReplacement.Url)
+	if !ok || pending.State != database.ReplacementOldRemoved || pending.Error == "" {
+		t.Fatalf("Expected the replacement to stay old_removed with its error, got %+v", pending)
+	}
+
+	// qBittorrent is back, the next run finishes the replacement
+	fake.fail = map[string]bool{}
+	fake.calls = nil
+	r, err = runReplacement(r)
+	if err != nil {
+		t.Fatalf("runReplacement() failed: %v", err)
+	}
+	if r.State != database.ReplacementDone {
+		t.Errorf("Expected state %s, got %s", database.ReplacementDone, r.State)
+	}
+	if want := []string{"addNew", "commit"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Expected steps %v, got %v", want, fake.calls)
+	}
+}
+
+func TestRecoverReplacements(t *testing.T) {
+	fake := newFakeSteps()
+	useFakeSteps(t, fake)
+
+	// The service stopped after the new torrent was added, before the record was updated
+	interrupted := testReplacement
+	interrupted.State = database.ReplacementNewAdded
+	if _, err := database.Repo.CreateReplacement(interrupted); err != nil {
+		t.Fatalf("CreateReplacement() failed: %v", err)
+	}
+
+	RecoverReplacements()
+	if want := []string{"commit"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("Expected steps %v, got %v", want, fake.calls)
+	}
+	if unfinished, _ := database.Repo.GetUnfinishedReplacements(); len(unfinished) != 0 {
+		t.Errorf("Expected an empty journal, got %v", unfinished)
+	}
+}
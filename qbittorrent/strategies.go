@@ -0,0 +1,155 @@
+package qbittorrent
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"kinozaltv_monitor/database"
+)
+
+// metadataTimeout is how long selectNewFiles waits for qBittorrent to fetch the file
+// list of a torrent added by magnet link
+var metadataTimeout = 30 * time.Second
+
+// keptSuffix is appended to the folder or file name of a torrent kept by the
+// keep_both update strategy. It contains the hash so a renamed torrent is recognized.
+func keptSuffix(hash string) string {
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	return ".old-" + hash
+}
+
+// contentRoot returns the folder all files of a torrent are in, or the name of the
+// only file of a torrent without a folder. ok is false for several files without a folder.
+func contentRoot(files []TorrentFile) (root string, folder bool, ok bool) {
+	if len(files) == 1 && !strings.Contains(files[0].Name, "/") {
+		return files[0].Name, false, true
+	}
+	for _, file := range files {
+		first, _, found := strings.Cut(file.Name, "/")
+		if !found || (root != "" && first != root) {
+			return "", false, false
+		}
+		root = first
+	}
+	return root, true, root != ""
+}
+
+// keptName returns the name the folder or file name is renamed to by the keep_both
+// update strategy. A file keeps its extension.
+func keptName(name string, folder bool, hash string) string {
+	if folder {
+		return name + keptSuffix(hash)
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + keptSuffix(hash) + ext
+}
+
+// renameOldContent renames the folder or file of the old torrent so the new torrent
+// downloads next to it, or back to its name when back is true. Renaming twice is a no-op.
+func renameOldContent(r database.Replacement, back bool) error {
+	files, err := GlobalManager.User.GetTorrentFiles(r.OldHash)
+	if err != nil {
+		return err
+	}
+	current, folder, ok := contentRoot(files)
+	if !ok {
+		return errors.New("the files of the old torrent are not in a single folder")
+	}
+
+	var original string
+	if folder {
+		original = strings.TrimSuffix(current, keptSuffix(r.OldHash))
+	} else {
+		ext := path.Ext(current)
+		original = strings.TrimSuffix(strings.TrimSuffix(current, ext), keptSuffix(r.OldHash)) + ext
+	}
+	rename := GlobalManager.User.RenameFile
+	if folder {
+		rename = GlobalManager.User.RenameFolder
+	}
+
+	renamed := current != original
+	if back {
+		if !renamed {
+			return nil
+		}
+		return rename(r.OldHash, current, original)
+	}
+	if renamed {
+		return nil
+	}
+	return rename(r.OldHash, current, keptName(original, folder, r.OldHash))
+}
+
+// relativePath returns the path of a file below the folder of its torrent
+func relativePath(name string, folder bool) string {
+	if folder {
+		_, rest, _ := strings.Cut(name, "/")
+		return rest
+	}
+	return name
+}
+
+// knownFiles returns the indexes of the new files the old torrent had as well. Files
+// are compared by their path below the folder of the torrent, which usually changes
+// its name with the release.
+func knownFiles(oldFiles, newFiles []TorrentFile) []int {
+	_, oldFolder, _ := contentRoot(oldFiles)
+	_, newFolder, _ := contentRoot(newFiles)
+
+	known := make(map[string]bool, len(oldFiles))
+	for _, file := range oldFiles {
+		known[relativePath(file.Name, oldFolder)] = true
+	}
+	indexes := make([]int, 0)
+	for _, file := range newFiles {
+		if known[relativePath(file.Name, newFolder)] {
+			indexes = append(indexes, file.Index)
+		}
+	}
+	return indexes
+}
+
+// waitForFiles returns the files of a torrent once qBittorrent has its metadata
+func waitForFiles(hash string) ([]TorrentFile, error) {
+	deadline := time.Now().Add(metadataTimeout)
+	for {
+		files, err := GlobalManager.User.GetTorrentFiles(hash)
+		if err != nil || len(files) > 0 {
+			return files, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("qbittorrent has no metadata for the new torrent yet")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// selectNewFiles skips the files of the new torrent the old torrent had, so files
+// the user deleted after watching are not downloaded again, and starts the new torrent
+func selectNewFiles(r database.Replacement) error {
+	newFiles, err := waitForFiles(r.NewHash)
+	if err != nil {
+		return err
+	}
+	oldFiles, err := GlobalManager.User.GetTorrentFiles(r.OldHash)
+	if err != nil {
+		return err
+	}
+	if skip := knownFiles(oldFiles, newFiles); len(skip) > 0 {
+		log.Info("select_new_files", "Skipping files of the previous version", map[string]string{
+			"url":     r.Url,
+			"skipped": strconv.Itoa(len(skip)),
+			"files":   strconv.Itoa(len(newFiles)),
+		})
+		if err := GlobalManager.User.SetFilePriority(r.NewHash, skip, FilePrioritySkip); err != nil {
+			return err
+		}
+	}
+	return GlobalManager.User.StartTorrent(r.NewHash)
+}
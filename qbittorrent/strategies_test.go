@@ -0,0 +1,77 @@
+package qbittorrent
+
+import (
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"reflect"
+	"testing"
+)
+
+func TestContentRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []TorrentFile
+		root   string
+		folder bool
+		ok     bool
+	}{
+		{"folder", []TorrentFile{{Name: "Show S01/e01.mkv"}, {Name: "Show S01/e02.mkv"}}, "Show S01", true, true},
+		{"single file", []TorrentFile{{Name: "movie.mkv"}}, "movie.mkv", false, true},
+		{"no common folder", []TorrentFile{{Name: "a/e01.mkv"}, {Name: "b/e02.mkv"}}, "", false, false},
+		{"no metadata", nil, "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, folder, ok := contentRoot(tt.files)
+			if root != tt.root || ok != tt.ok || (ok && folder != tt.folder) {
+				t.Errorf("contentRoot() = %q, %t, %t", root, folder, ok)
+			}
+		})
+	}
+}
+
+func TestKeptName(t *testing.T) {
+	if got := keptName("Show S01", true, "0123456789abcdef"); got != "Show S01.old-01234567" {
+		t.Errorf("Unexpected folder name %q", got)
+	}
+	if got := keptName("movie.mkv", false, "0123456789abcdef"); got != "movie.old-01234567.mkv" {
+		t.Errorf("Unexpected file name %q", got)
+	}
+}
+
+func TestKnownFiles(t *testing.T) {
+	oldFiles := []TorrentFile{
+		{Index: 0, Name: "Show S01E01-02/e01.mkv"},
+		{Index: 1, Name: "Show S01E01-02/e02.mkv"},
+	}
+	newFiles := []TorrentFile{
+		{Index: 0, Name: "Show S01E01-03/e01.mkv"},
+		{Index: 1, Name: "Show S01E01-03/e02.mkv"},
+		{Index: 2, Name: "Show S01E01-03/e03.mkv"},
+	}
+	if got := knownFiles(oldFiles, newFiles); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("Expected the first two files to be known, got %v", got)
+	}
+	if got := knownFiles(nil, newFiles); len(got) != 0 {
+		t.Errorf("Expected no known files without a previous file list, got %v", got)
+	}
+}
+
+func TestCurrentRecord(t *testing.T) {
+	useTestRepository(t)
+	url := "https://kinozal.tv/details.php?id=1"
+	if err := database.Repo.AddRecord(models.Torrent{Title: "T", Name: "T", Hash: "aaa", Url: url}); err != nil {
+		t.Fatalf("AddRecord() failed: %v", err)
+	}
+	started, _ := database.Repo.GetRecordByUrl(url)
+	_ = database.Repo.SetUpdateStrategy(url, database.UpdateKeepBoth)
+
+	// A running watcher picks up the strategy changed after it started
+	if record := currentRecord(started); record.UpdateStrategy != database.UpdateKeepBoth {
+		t.Errorf("Expected the changed update strategy, got %q", record.UpdateStrategy)
+	}
+	gone := database.Torrent{ID: started.ID + 1, Url: "https://kinozal.tv/details.php?id=2"}
+	if record := currentRecord(gone); !reflect.DeepEqual(record, gone) {
+		t.Errorf("Expected the copy of a torrent that can not be read, got %+v", record)
+	}
+}
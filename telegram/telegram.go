@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"kinozaltv_monitor/config"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/events"
+	logger "kinozaltv_monitor/logging"
 	"kinozaltv_monitor/models"
 	"net/http"
 	"text/template"
@@ -25,6 +28,7 @@ const messageTorrentUpdated = `<b>Обновлен торрент</b>
 <b>Ссылка:</b> {{ .Url }}`
 
 var globalConfig = config.GlobalConfig
+var log = logger.New("telegram")
 
 // BaseChat is a base chat interface
 type BaseChat struct {
@@ -70,8 +74,8 @@ func SendCommand(token string, m BaseChat) error {
 	return nil
 }
 
-// SendTorrentAction sends a message about added torrent
-func SendTorrentAction(action, token string, torrentInfo models.Torrent) error {
+// SendTorrentAction sends a message about an added or updated torrent to chatID
+func SendTorrentAction(action, token, chatID string, torrentInfo models.Torrent) error {
 	var tpl bytes.Buffer
 
 	switch action {
@@ -95,7 +99,58 @@ func SendTorrentAction(action, token string, torrentInfo models.Torrent) error {
 		}
 	}
 
-	m := NewBaseChat(globalConfig.TelegramChatId, tpl.String())
+	m := NewBaseChat(chatID, tpl.String())
 
 	return SendCommand(token, m)
 }
+
+// RunNotifier sends a message for every added or updated torrent received from sub
+func RunNotifier(sub *events.Subscription) {
+	for e := range sub.C {
+		var action string
+		switch e.Type {
+		case events.TorrentAdded:
+			action = "added"
+		case events.TorrentUpdated:
+			action = "updated"
+		default:
+			continue
+		}
+
+		torrentInfo := models.Torrent{Title: e.Title, Hash: e.Hash, Url: e.Url}
+		for _, chatID := range chatIDs(e.Url) {
+			if err := SendTorrentAction(action, globalConfig.TelegramToken, chatID, torrentInfo); err != nil {
+				log.Error("send_telegram_notification", err.Error(), map[string]string{"torrent_url": e.Url, "chat_id": chatID})
+			}
+		}
+	}
+}
+
+// chatIDs returns the Telegram chats to notify about url: the chat of every user
+// watching it, or the configured chat for watchers without their own
+func chatIDs(url string) []string {
+	watchers, err := database.Repo.GetWatchers(url)
+	if err != nil {
+		log.Error("get_watchers", err.Error(), map[string]string{"torrent_url": url})
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	add := func(chatID string) {
+		if chatID != "" && !seen[chatID] {
+			seen[chatID] = true
+			result = append(result, chatID)
+		}
+	}
+	for _, watcher := range watchers {
+		if watcher.TelegramChatID != "" {
+			add(watcher.TelegramChatID)
+		} else {
+			add(globalConfig.TelegramChatId)
+		}
+	}
+	if len(watchers) == 0 {
+		add(globalConfig.TelegramChatId)
+	}
+	return result
+}
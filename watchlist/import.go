@@ -0,0 +1,145 @@
+package watchlist
+
+import (
+	"errors"
+	"fmt"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+)
+
+// Import actions reported per entry
+const (
+	ActionAdd     = "add"
+	ActionUpdate  = "update"
+	ActionSkip    = "skip"
+	ActionInvalid = "invalid"
+)
+
+// Conflict policies for entries whose url is already watched
+const (
+	ConflictSkip   = "skip"
+	ConflictUpdate = "update"
+)
+
+// ErrUnsupportedConflict is returned for an unknown conflict policy
+var ErrUnsupportedConflict = errors.New("unsupported conflict policy")
+
+// Options controls how an import is applied. With a UserID the entries are
+// imported into that user's watch list.
+type Options struct {
+	DryRun   bool
+	Conflict string
+	UserID   int
+}
+
+// Result is the outcome of importing a single entry
+type Result struct {
+	Url    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	JobID  string `json:"job_id,omitempty"`
+}
+
+// Report is the outcome of an import
+type Report struct {
+	DryRun   bool           `json:"dry_run"`
+	Conflict string         `json:"conflict"`
+	Results  []Result       `json:"results"`
+	Summary  map[string]int `json:"summary"`
+}
+
+// Import validates entries, resolves conflicts by url and, unless it is a dry run,
+// updates existing records and passes missing torrents to queue, which returns the add job ID
+func Import(repo database.TorrentRepository, entries []Entry, opts Options, queue func(common.TorrentData) string) (Report, error) {
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictSkip
+	}
+	if opts.Conflict != ConflictSkip && opts.Conflict != ConflictUpdate {
+		return Report{}, fmt.Errorf("%w: %s", ErrUnsupportedConflict, opts.Conflict)
+	}
+
+	report := Report{
+		DryRun:   opts.DryRun,
+		Conflict: opts.Conflict,
+		Results:  make([]Result, 0, len(entries)),
+		Summary:  map[string]int{ActionAdd: 0, ActionUpdate: 0, ActionSkip: 0, ActionInvalid: 0},
+	}
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		entry.Url = common.CanonicalTorrentUrl(entry.Url)
+		entry.Tags = common.NormalizeTags(entry.Tags)
+		result := Result{Url: entry.Url, Title: entry.Title}
+
+		switch {
+		case entry.Url == "":
+			result.Action, result.Reason = ActionInvalid, "url is empty"
+		case !common.IsSupportedTrackerUrl(entry.Url):
+			result.Action, result.Reason = ActionInvalid, "unsupported tracker url"
+		case entry.WatchEvery < 0:
+			result.Action, result.Reason = ActionInvalid, "watch_every must not be negative"
+		case seen[entry.Url]:
+			result.Action, result.Reason = ActionSkip, "duplicate entry in import"
+		}
+		if result.Action != "" {
+			report.add(result)
+			continue
+		}
+		seen[entry.Url] = true
+
+		_, err := repo.GetRecordByUrl(entry.Url)
+		watching := true
+		if err == nil && opts.UserID != 0 {
+			if watching, err = repo.IsWatching(opts.UserID, entry.Url); err != nil {
+				return report, err
+			}
+		}
+		switch {
+		case err == nil && !watching:
+			result.Action, result.Reason = ActionAdd, "already tracked by another user"
+			if !opts.DryRun {
+				if err := repo.AddWatcher(opts.UserID, entry.Url); err != nil {
+					return report, err
+				}
+			}
+		case err == nil && opts.Conflict == ConflictSkip:
+			result.Action, result.Reason = ActionSkip, "already watched"
+		case err == nil:
+			result.Action = ActionUpdate
+			if !opts.DryRun {
+				if err := applyUpdate(repo, entry); err != nil {
+					return report, err
+				}
+			}
+		case err == database.ErrNotFound:
+			result.Action = ActionAdd
+			if !opts.DryRun {
+				result.JobID = queue(common.TorrentData{Url: entry.Url, DownloadPath: entry.DownloadPath, WatchEvery: entry.WatchEvery, Tags: entry.Tags, UserID: opts.UserID})
+			}
+		default:
+			return report, err
+		}
+		report.add(result)
+	}
+
+	return report, nil
+}
+
+func (r *Report) add(result Result) {
+	r.Results = append(r.Results, result)
+	r.Summary[result.Action]++
+}
+
+// applyUpdate copies the watch schedule, download path and tags of entry to the existing record
+func applyUpdate(repo database.TorrentRepository, entry Entry) error {
+	if err := repo.SetWatchFlag(entry.Url, entry.WatchEvery); err != nil {
+		return err
+	}
+	if entry.DownloadPath != "" {
+		if err := repo.SetDownloadPath(entry.Url, entry.DownloadPath); err != nil {
+			return err
+		}
+	}
+	return repo.SetTags(entry.Url, entry.Tags)
+}
@@ -0,0 +1,230 @@
+package watchlist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported serialization formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatOPML = "opml"
+)
+
+// Entry is a single watched torrent in an export file
+type Entry struct {
+	Title        string   `json:"title"`
+	Url          string   `json:"url"`
+	Hash         string   `json:"hash"`
+	WatchEvery   int      `json:"watch_every"`
+	DownloadPath string   `json:"download_path"`
+	Tags         []string `json:"tags"`
+}
+
+// Document is the JSON export file
+type Document struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Torrents   []Entry   `json:"torrents"`
+}
+
+// csvHeader is the column order of CSV exports
+var csvHeader = []string{"title", "url", "hash", "watch_every", "download_path", "tags"}
+
+// opmlDocument is an OPML 2.0 file with one outline per torrent
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated,omitempty"`
+	Outline []opmlOutline `xml:"body>outline"`
+}
+
+// opmlOutline keeps tags in the standard comma separated category attribute
+type opmlOutline struct {
+	Type         string `xml:"type,attr"`
+	Text         string `xml:"text,attr"`
+	Url          string `xml:"url,attr"`
+	Hash         string `xml:"hash,attr,omitempty"`
+	WatchEvery   int    `xml:"watchEvery,attr"`
+	DownloadPath string `xml:"downloadPath,attr,omitempty"`
+	Category     string `xml:"category,attr,omitempty"`
+}
+
+// FromRecords converts database records to export entries. External torrents are left
+// out, their .torrent file or magnet link is not kept to add them again on import.
+func FromRecords(records []database.Torrent) []Entry {
+	entries := make([]Entry, 0, len(records))
+	for _, record := range records {
+		if common.IsExternalTorrentUrl(record.Url) {
+			continue
+		}
+		entries = append(entries, Entry{
+			Title:        record.Title,
+			Url:          record.Url,
+			Hash:         record.Hash,
+			WatchEvery:   record.WatchEvery,
+			DownloadPath: record.DownloadPath,
+			Tags:         common.NormalizeTags(record.Tags),
+		})
+	}
+	return entries
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatOPML:
+		return "text/x-opml; charset=utf-8"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// FormatFromContentType guesses the format of an uploaded file, defaulting to JSON
+func FormatFromContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return FormatCSV
+	case strings.Contains(contentType, "opml"), strings.Contains(contentType, "xml"):
+		return FormatOPML
+	default:
+		return FormatJSON
+	}
+}
+
+// Encode writes entries to w in the given format
+func Encode(w io.Writer, format string, entries []Entry) error {
+	switch format {
+	case FormatJSON, "":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(Document{Version: 1, ExportedAt: time.Now().UTC(), Torrents: entries})
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			record := []string{e.Title, e.Url, e.Hash, strconv.Itoa(e.WatchEvery), e.DownloadPath, strings.Join(e.Tags, ",")}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatOPML:
+		doc := opmlDocument{Version: "2.0", Title: "Kinozal Monitor watch list", Created: time.Now().UTC().Format(time.RFC1123Z)}
+		for _, e := range entries {
+			doc.Outline = append(doc.Outline, opmlOutline{
+				Type:         "torrent",
+				Text:         e.Title,
+				Url:          e.Url,
+				Hash:         e.Hash,
+				WatchEvery:   e.WatchEvery,
+				DownloadPath: e.DownloadPath,
+				Category:     strings.Join(e.Tags, ","),
+			})
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// Decode reads entries in the given format from r
+func Decode(r io.Reader, format string) ([]Entry, error) {
+	switch format {
+	case FormatJSON, "":
+		var doc Document
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON watch list: %w", err)
+		}
+		return doc.Torrents, nil
+	case FormatCSV:
+		return decodeCSV(r)
+	case FormatOPML:
+		var doc opmlDocument
+		if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid OPML watch list: %w", err)
+		}
+		entries := make([]Entry, 0, len(doc.Outline))
+		for _, o := range doc.Outline {
+			entries = append(entries, Entry{
+				Title:        o.Text,
+				Url:          o.Url,
+				Hash:         o.Hash,
+				WatchEvery:   o.WatchEvery,
+				DownloadPath: o.DownloadPath,
+				Tags:         common.NormalizeTags(strings.Split(o.Category, ",")),
+			})
+		}
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// decodeCSV reads a CSV file with a header row, columns may come in any order
+func decodeCSV(r io.Reader) ([]Entry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV watch list: %w", err)
+	}
+	if len(records) == 0 {
+		return []Entry{}, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, fmt.Errorf("invalid CSV watch list: url column is missing")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	entries := make([]Entry, 0, len(records)-1)
+	for line, record := range records[1:] {
+		entry := Entry{
+			Title:        field(record, "title"),
+			Url:          field(record, "url"),
+			Hash:         field(record, "hash"),
+			DownloadPath: field(record, "download_path"),
+			Tags:         common.NormalizeTags(strings.Split(field(record, "tags"), ",")),
+		}
+		if watchEvery := field(record, "watch_every"); watchEvery != "" {
+			entry.WatchEvery, err = strconv.Atoi(watchEvery)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV watch list: line %d: watch_every is not a number", line+2)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
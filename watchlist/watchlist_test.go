@@ -0,0 +1,161 @@
+package watchlist
+
+import (
+	"bytes"
+	"kinozaltv_monitor/common"
+	"kinozaltv_monitor/database"
+	"kinozaltv_monitor/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testEntries = []Entry{
+	{Title: "Series, season 1", Url: "https://kinozal.tv/details.php?id=1", Hash: "aaa", WatchEvery: 60, DownloadPath: "/downloads/series", Tags: []string{"series", "kinozal-monitor"}},
+	{Title: "Movie", Url: "https://rutracker.org/forum/viewtopic.php?t=2", Hash: "bbb", Tags: []string{}},
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatCSV, FormatOPML} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, format, testEntries); err != nil {
+				t.Fatalf("Encode() failed: %v", err)
+			}
+			decoded, err := Decode(&buf, format)
+			if err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, testEntries) {
+				t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", testEntries, decoded)
+			}
+		})
+	}
+}
+
+func TestFromRecords_SkipsExternalTorrents(t *testing.T) {
+	records := []database.Torrent{
+		{Title: "Series", Url: "https://kinozal.tv/details.php?id=1", Hash: "aaa"},
+		{Title: "Uploaded", Url: common.ExternalTorrentUrl("ccc"), Hash: "ccc"},
+	}
+	entries := FromRecords(records)
+	if len(entries) != 1 || entries[0].Url != records[0].Url {
+		t.Errorf("Expected only the tracker topic, got %+v", entries)
+	}
+}
+
+func TestDecodeCSV_MissingUrlColumn(t *testing.T) {
+	if _, err := Decode(bytes.NewBufferString("title,hash\nA,aaa\n"), FormatCSV); err == nil {
+		t.Error("Expected error for CSV without url column")
+	}
+}
+
+func newTestRepository(t *testing.T) database.TorrentRepository {
+	repo, err := database.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestImport(t *testing.T) {
+	entries := []Entry{
+		{Url: "http://www.kinozal.tv/details.php?id=1", WatchEvery: 30, Tags: []string{"series"}},
+		{Url: "https://kinozal.tv/details.php?id=2", DownloadPath: "/downloads"},
+		{Url: "https://kinozal.tv/details.php?id=2"},
+		{Url: "https://example.com/?id=3"},
+		{Url: ""},
+	}
+
+	t.Run("Dry run changes nothing", func(t *testing.T) {
+		repo := newTestRepository(t)
+		if err := repo.AddRecord(models.Torrent{Title: "One", Hash: "aaa", Url: "https://kinozal.tv/details.php?id=1"}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+
+		var queued []common.TorrentData
+		report, err := Import(repo, entries, Options{DryRun: true, Conflict: ConflictUpdate}, func(data common.TorrentData) string {
+			queued = append(queued, data)
+			return "job"
+		})
+		if err != nil {
+			t.Fatalf("Import() failed: %v", err)
+		}
+
+		expected := map[string]int{ActionAdd: 1, ActionUpdate: 1, ActionSkip: 1, ActionInvalid: 2}
+		if !reflect.DeepEqual(report.Summary, expected) {
+			t.Errorf("Expected summary %v, got %v", expected, report.Summary)
+		}
+		if len(queued) != 0 {
+			t.Errorf("Dry run must not queue torrents, got %v", queued)
+		}
+		record, _ := repo.GetRecordByUrl("https://kinozal.tv/details.php?id=1")
+		if record.WatchEvery != 0 {
+			t.Errorf("Dry run must not update records, got %+v", record)
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		repo := newTestRepository(t)
+		if err := repo.AddRecord(models.Torrent{Title: "One", Hash: "aaa", Url: "https://kinozal.tv/details.php?id=1"}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+
+		var queued []common.TorrentData
+		report, err := Import(repo, entries, Options{Conflict: ConflictUpdate}, func(data common.TorrentData) string {
+			queued = append(queued, data)
+			return "job"
+		})
+		if err != nil {
+			t.Fatalf("Import() failed: %v", err)
+		}
+
+		if len(queued) != 1 || queued[0].Url != "https://kinozal.tv/details.php?id=2" || queued[0].DownloadPath != "/downloads" {
+			t.Errorf("Unexpected queued torrents: %+v", queued)
+		}
+		if report.Results[1].JobID != "job" {
+			t.Errorf("Expected job ID in the result of a queued entry, got %+v", report.Results[1])
+		}
+		record, _ := repo.GetRecordByUrl("https://kinozal.tv/details.php?id=1")
+		if record.WatchEvery != 30 || !reflect.DeepEqual(record.Tags, []string{"series"}) {
+			t.Errorf("Expected existing record to be updated, got %+v", record)
+		}
+	})
+
+	t.Run("Into a user's watch list", func(t *testing.T) {
+		repo := newTestRepository(t)
+		user, err := repo.CreateUser(database.User{Username: "alice", PasswordHash: "hash", Role: database.RoleUser})
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		if err := repo.AddRecord(models.Torrent{Title: "One", Hash: "aaa", Url: "https://kinozal.tv/details.php?id=1"}); err != nil {
+			t.Fatalf("AddRecord() failed: %v", err)
+		}
+
+		var queued []common.TorrentData
+		report, err := Import(repo, entries, Options{UserID: user.ID}, func(data common.TorrentData) string {
+			queued = append(queued, data)
+			return "job"
+		})
+		if err != nil {
+			t.Fatalf("Import() failed: %v", err)
+		}
+
+		if report.Results[0].Action != ActionAdd || report.Results[0].JobID != "" {
+			t.Errorf("Expected a torrent tracked by another user to be shared, got %+v", report.Results[0])
+		}
+		if watching, _ := repo.IsWatching(user.ID, "https://kinozal.tv/details.php?id=1"); !watching {
+			t.Error("Expected the shared torrent on the user's watch list")
+		}
+		if len(queued) != 1 || queued[0].UserID != user.ID {
+			t.Errorf("Expected the new torrent to be queued for the user, got %+v", queued)
+		}
+	})
+
+	t.Run("Unsupported conflict policy", func(t *testing.T) {
+		if _, err := Import(newTestRepository(t), entries, Options{Conflict: "merge"}, nil); err == nil {
+			t.Error("Expected error for unsupported conflict policy")
+		}
+	})
+}